@@ -3,14 +3,15 @@ module example/hello
 go 1.24.2
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/rs/zerolog v1.34.0
+	github.com/samber/lo v1.49.1
 	resty.dev/v3 v3.0.0-beta.2
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/samber/lo v1.49.1 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
@@ -0,0 +1,309 @@
+package hyapi
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+	"resty.dev/v3"
+)
+
+// DownloadWithClient downloads a package file to destPath using the provided client,
+// verifying the downloaded size and MD5 checksum against the package file information.
+// A compressed file is decompressed while downloading, so the MD5 and DecompressedSize refer to the decompressed content.
+// The content is written to a temporary ".part" file next to destPath, which is only renamed
+// to destPath once the download is verified. A transfer failing on a network error or a temporary status code is
+// retried DefaultRetries times, use a Downloader with another Retry policy to change it.
+func DownloadWithClient(client *resty.Client, file GamePackageFile, destPath string) error {
+	return (&Downloader{Client: client, Retry: NewRetryPolicy(DefaultRetries, DefaultRetryDelay)}).Download(file, destPath)
+}
+
+// DownloadWithProgress is DownloadWithClient calling onProgress as the file is transferred, with the bytes read so far
+// and the compressed size of the file.
+func DownloadWithProgress(client *resty.Client, file GamePackageFile, destPath string, onProgress ProgressFunc) error {
+	progress := &DownloadProgress{OnFile: onProgress, total: file.Size}
+	return (&Downloader{Client: client, Progress: progress, Retry: NewRetryPolicy(DefaultRetries, DefaultRetryDelay)}).Download(file, destPath)
+}
+
+// download downloads like DownloadWithClient, retrying a failed transfer as the downloader's policy says.
+func (d *Downloader) download(file GamePackageFile, destPath string) error {
+	return ExecuteWithRetry(d.Retry, func(int) error {
+		return d.downloadAttempt(file, destPath)
+	})
+}
+
+// downloadAttempt transfers a package file once, reporting the bytes transferred to the downloader's progress when
+// not nil and waiting at its gate before reading every chunk of the body. The bytes of a failed transfer are taken
+// back from the progress, as they are transferred again when retried.
+func (d *Downloader) downloadAttempt(file GamePackageFile, destPath string) error {
+	resp, err := d.Client.R().
+		SetDoNotParseResponse(true). // Stream the body to disk instead of buffering it in memory
+		Get(file.URL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", file.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return &StatusError{URL: file.URL, Code: resp.StatusCode()}
+	}
+
+	// Create parent directories if they don't exist
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directories for %s: %w", destPath, err)
+	}
+
+	partPath := destPath + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", partPath, err)
+	}
+
+	// Count the bytes actually transferred, which is the compressed size
+	body := &countingReader{r: d.Reader(resp.Body), onRead: d.Progress.onRead(file)}
+	content, err := decompressor(file.Compression, body)
+	if err != nil {
+		out.Close()
+		os.Remove(partPath)
+		d.Progress.unread(body.n)
+		return fmt.Errorf("failed to download %s: %w", file.URL, err)
+	}
+	defer content.Close()
+
+	// Hash and count the (decompressed) body while writing it, so the file never has to be read back
+	hMD5 := md5.New()
+	hashed := &countingWriter{w: hMD5}
+	if file.Compression != "" {
+		hashed.limit = file.DecompressedSize // A stream decompressing past its size fails without being written to the end
+	}
+	_, err = io.Copy(out, io.TeeReader(content, hashed))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil && body.err == nil {
+		err = &permanentError{err} // The body was transferred, the stream itself is corrupt
+	}
+	if err == nil {
+		err = verifyDownload(file, body.n, hashed.n, hMD5.Sum(nil))
+	}
+	if err != nil {
+		os.Remove(partPath) // Don't leave a corrupt partial file behind
+		d.Progress.unread(body.n)
+		return fmt.Errorf("failed to download %s: %w", file.URL, err)
+	}
+
+	err = os.Rename(partPath, destPath)
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", partPath, destPath, err)
+	}
+
+	log.Debug().
+		Str("url", file.URL).
+		Str("file", destPath).
+		Int64("size", body.n).
+		Msg("Downloaded")
+	return nil
+}
+
+// Download initializes a Resty client and downloads a package file to destPath.
+func Download(file GamePackageFile, destPath string) error {
+	// Initialize Resty client
+	client := resty.New()
+	defer client.Close()
+
+	// Delegate to DownloadWithClient
+	return DownloadWithClient(client, file, destPath)
+}
+
+// PackageDownload pairs a package file with the path it is downloaded to.
+type PackageDownload struct {
+	File     GamePackageFile
+	DestPath string
+}
+
+// DownloadAllWithClient downloads every package file to its destination path using the provided client.
+// Each unique URL is fetched once, retried like DownloadWithClient: the first destination of a URL is downloaded, the others are hard linked to it,
+// or copied where links aren't possible, and each destination is then verified against its own package file.
+func DownloadAllWithClient(client *resty.Client, downloads []PackageDownload) error {
+	return DownloadAllWithProgress(client, downloads, nil)
+}
+
+// DownloadAllWithProgress is DownloadAllWithClient reporting the bytes transferred to progress, created by
+// NewDownloadProgress or NewTerminalProgress for the same downloads. Reused downloads transfer nothing.
+func DownloadAllWithProgress(client *resty.Client, downloads []PackageDownload, progress *DownloadProgress) error {
+	return (&Downloader{Client: client, Progress: progress, Retry: NewRetryPolicy(DefaultRetries, DefaultRetryDelay)}).DownloadAll(downloads)
+}
+
+// downloadOnce downloads a package file unless its URL is already in downloaded, mapping URLs to the path they
+// were first downloaded to, in which case that path is reused with copyDownload.
+func (d *Downloader) downloadOnce(download PackageDownload, downloaded map[string]string) error {
+	file, destPath := download.File, download.DestPath
+	cachedPath, ok := downloaded[file.URL]
+	if !ok {
+		if err := d.download(file, destPath); err != nil {
+			return err
+		}
+		downloaded[file.URL] = destPath
+		return nil
+	}
+	if err := copyDownload(file, cachedPath, destPath); err != nil {
+		return err
+	}
+	log.Debug().
+		Str("url", file.URL).
+		Str("file", destPath).
+		Str("source", cachedPath).
+		Msg("Reused download")
+	return nil
+}
+
+// copyDownload links, or copies, the already downloaded srcPath to destPath through a temporary ".part" file,
+// which is only renamed to destPath once its content is verified against the package file.
+func copyDownload(file GamePackageFile, srcPath, destPath string) error {
+	err := os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directories for %s: %w", destPath, err)
+	}
+
+	partPath := destPath + ".part"
+	os.Remove(partPath) // A link can't replace an existing file
+	if err := os.Link(srcPath, partPath); err != nil {
+		err = copyFile(srcPath, partPath)
+		if err != nil {
+			os.Remove(partPath)
+			return fmt.Errorf("failed to copy %s to %s: %w", srcPath, partPath, err)
+		}
+	}
+	if err := verifyLocalFile(file, partPath); err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("failed to verify %s: %w", destPath, err)
+	}
+
+	err = os.Rename(partPath, destPath)
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", partPath, destPath, err)
+	}
+	return nil
+}
+
+// copyFile copies the content of srcPath to a new file at destPath.
+func copyFile(srcPath, destPath string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// verifyLocalFile checks the size and MD5 checksum of a downloaded file against the package file information.
+// A compressed file is stored decompressed, so its size is checked against DecompressedSize when known.
+func verifyLocalFile(file GamePackageFile, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hMD5 := md5.New()
+	size, err := io.Copy(hMD5, f)
+	if err != nil {
+		return err
+	}
+	expectedSize := file.Size
+	if file.Compression != "" {
+		expectedSize = file.DecompressedSize
+	}
+	if expectedSize != 0 && size != expectedSize {
+		return fmt.Errorf("size mismatch: expected %d, got %d", expectedSize, size)
+	}
+	if actualMD5 := hex.EncodeToString(hMD5.Sum(nil)); actualMD5 != file.MD5 {
+		return fmt.Errorf("md5 mismatch: expected %s, got %s", file.MD5, actualMD5)
+	}
+	return nil
+}
+
+// verifyDownload checks the downloaded size, decompressed size and MD5 checksum against the package file information.
+// The decompressed size is only checked for compressed files, as it otherwise describes the extracted package.
+func verifyDownload(file GamePackageFile, size int64, decompressedSize int64, md5Hash []byte) error {
+	if size != file.Size {
+		return fmt.Errorf("size mismatch: expected %d, got %d", file.Size, size)
+	}
+	if file.Compression != "" && file.DecompressedSize != 0 && decompressedSize != file.DecompressedSize {
+		return fmt.Errorf("decompressed size mismatch: expected %d, got %d", file.DecompressedSize, decompressedSize)
+	}
+	if actualMD5 := hex.EncodeToString(md5Hash); actualMD5 != file.MD5 {
+		return fmt.Errorf("md5 mismatch: expected %s, got %s", file.MD5, actualMD5)
+	}
+	return nil
+}
+
+// decompressor wraps r in a reader decompressing the given compression, "" returns r unchanged.
+func decompressor(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "":
+		return io.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// countingReader counts the bytes read through it, calling onRead when set with the bytes of each read and the count.
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	err    error // First error reading r other than io.EOF
+	onRead func(read, done int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if err != nil && err != io.EOF && cr.err == nil {
+		cr.err = err
+	}
+	if n > 0 && cr.onRead != nil {
+		cr.onRead(int64(n), cr.n)
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written through it to w. With a limit, the write going past it fails instead,
+// so a decompressed stream larger than expected is caught while it streams.
+type countingWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64 // Maximum number of bytes, 0 when unlimited
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.limit > 0 && cw.n+int64(len(p)) > cw.limit {
+		return 0, fmt.Errorf("decompressed size mismatch: expected %d, got more", cw.limit)
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,329 @@
+package hyapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"resty.dev/v3"
+)
+
+// newDryRunClient returns a Resty client that answers every request with the given content.
+func newDryRunClient(t *testing.T, content []byte, statusCode int) *resty.Client {
+	t.Helper()
+	mockResponseFile := filepath.Join(t.TempDir(), "response.bin")
+	if err := os.WriteFile(mockResponseFile, content, 0644); err != nil {
+		t.Fatalf("Failed to write mock response file: %v", err)
+	}
+
+	client := resty.New()
+	client.SetTransport(&DryRunTransport{
+		MockResponseFile: mockResponseFile,
+		StatusCode:       statusCode,
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDownloadWithClient(t *testing.T) {
+	content := bytes.Repeat([]byte("game data "), 1000)
+	md5Hash := md5.Sum(content)
+	file := GamePackageFile{
+		URL:  "https://example.invalid/game.zip",
+		MD5:  hex.EncodeToString(md5Hash[:]),
+		Size: int64(len(content)),
+	}
+	destPath := filepath.Join(t.TempDir(), "nested", "game.zip")
+
+	err := DownloadWithClient(newDryRunClient(t, content, http.StatusOK), file, destPath)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	downloaded, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Downloaded content differs from served content")
+	}
+	if _, err := os.Stat(destPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be gone, got %v", err)
+	}
+}
+
+func TestDownloadWithClientHashMismatch(t *testing.T) {
+	content := []byte("corrupted data")
+	file := GamePackageFile{
+		URL:  "https://example.invalid/game.zip",
+		MD5:  "00000000000000000000000000000000",
+		Size: int64(len(content)),
+	}
+	destPath := filepath.Join(t.TempDir(), "game.zip")
+
+	err := DownloadWithClient(newDryRunClient(t, content, http.StatusOK), file, destPath)
+	if err == nil {
+		t.Fatalf("Expected an md5 mismatch error")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written, got %v", err)
+	}
+	if _, err := os.Stat(destPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be removed, got %v", err)
+	}
+}
+
+func TestDownloadWithClientHTTPError(t *testing.T) {
+	file := GamePackageFile{URL: "https://example.invalid/missing.zip"}
+	destPath := filepath.Join(t.TempDir(), "missing.zip")
+
+	client := newDryRunClient(t, []byte("not found"), http.StatusNotFound)
+	transport := &countingTransport{next: client.Transport()}
+	client.SetTransport(transport)
+	err := DownloadWithClient(client, file, destPath)
+	if err == nil {
+		t.Fatalf("Expected an error for a 404 response")
+	}
+	if requests := transport.requests.Load(); requests != 1 {
+		t.Errorf("Expected a 404 response not to be retried, got %d requests", requests)
+	}
+}
+
+// flakyTransport fails the first requests sent through it, alternately with a 503 response and a body dropped
+// mid-transfer, before passing the next ones on.
+type flakyTransport struct {
+	next     http.RoundTripper
+	failures int32
+	requests atomic.Int32
+}
+
+func (ft *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request := ft.requests.Add(1)
+	if request > ft.failures {
+		return ft.next.RoundTrip(r)
+	}
+	if request%2 == 1 {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Header: make(http.Header)}, nil
+	}
+	resp, err := ft.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(io.MultiReader(io.LimitReader(resp.Body, 100), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	return resp, nil
+}
+
+func TestDownloaderRetry(t *testing.T) {
+	content := bytes.Repeat([]byte("retried game data "), 1000)
+	md5Hash := md5.Sum(content)
+	file := GamePackageFile{URL: "https://example.invalid/game.pck", MD5: hex.EncodeToString(md5Hash[:]), Size: int64(len(content))}
+	destPath := filepath.Join(t.TempDir(), "game.pck")
+
+	client := newDryRunClient(t, content, http.StatusOK)
+	transport := &flakyTransport{next: client.Transport(), failures: 2}
+	client.SetTransport(transport)
+	progress := NewDownloadProgress([]PackageDownload{{File: file, DestPath: destPath}}, nil, nil)
+	downloader := &Downloader{Client: client, Progress: progress, Retry: NewRetryPolicy(2, time.Millisecond)}
+	if err := downloader.Download(file, destPath); err != nil {
+		t.Fatalf("Expected the download to succeed once retried, got %v", err)
+	}
+	if requests := transport.requests.Load(); requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if downloaded, err := os.ReadFile(destPath); err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected the served content, got %d bytes (err: %v)", len(downloaded), err)
+	}
+	// The bytes of the dropped transfer are not counted twice.
+	if done := progress.Done(); done != file.Size {
+		t.Errorf("Expected %d bytes done, got %d", file.Size, done)
+	}
+
+	// Without retries the first failure is returned.
+	transport.requests.Store(0)
+	downloader = &Downloader{Client: client}
+	var statusErr *StatusError
+	if err := downloader.Download(file, destPath); !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the 503 status error, got %v", err)
+	}
+}
+
+func TestDownloadWithClientDecompress(t *testing.T) {
+	content := bytes.Repeat([]byte("decompressed game data "), 1000)
+	md5Hash := md5.Sum(content)
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(content)
+	gw.Close()
+	encoder, _ := zstd.NewWriter(nil)
+	zstded := encoder.EncodeAll(content, nil)
+	encoder.Close()
+
+	for compression, compressed := range map[string][]byte{"gzip": gzipped.Bytes(), "zstd": zstded} {
+		t.Run(compression, func(t *testing.T) {
+			// The MD5 and decompressed size describe the decompressed content, the size the transferred one.
+			file := GamePackageFile{
+				URL:              "https://example.invalid/game.pck",
+				MD5:              hex.EncodeToString(md5Hash[:]),
+				Size:             int64(len(compressed)),
+				DecompressedSize: int64(len(content)),
+				Compression:      compression,
+			}
+			destPath := filepath.Join(t.TempDir(), "game.pck")
+
+			err := DownloadWithClient(newDryRunClient(t, compressed, http.StatusOK), file, destPath)
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			downloaded, err := os.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed to read downloaded file: %v", err)
+			}
+			if !bytes.Equal(downloaded, content) {
+				t.Errorf("Downloaded file is not the decompressed content")
+			}
+
+			file.DecompressedSize++
+			err = DownloadWithClient(newDryRunClient(t, compressed, http.StatusOK), file, filepath.Join(t.TempDir(), "game.pck"))
+			if err == nil {
+				t.Errorf("Expected a decompressed size mismatch error")
+			}
+		})
+	}
+}
+
+func TestDownloadWithClientDecompressedSize(t *testing.T) {
+	content := bytes.Repeat([]byte("decompressed game data "), 1000)
+	md5Hash := md5.Sum(content)
+	gzipped := func(content []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(content)
+		gw.Close()
+		return buf.Bytes()
+	}
+	full := gzipped(content)
+
+	cases := []struct {
+		name             string
+		compressed       []byte
+		decompressedSize int64
+		errContains      string // Empty when the download must succeed
+	}{
+		{"matching", full, int64(len(content)), ""},
+		{"truncated stream", full[:len(full)/2], int64(len(content)), "unexpected EOF"},
+		{"truncated content", gzipped(content[:len(content)/2]), int64(len(content)), "decompressed size mismatch"},
+		{"larger than recorded", full, int64(len(content)) / 2, "decompressed size mismatch"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := GamePackageFile{
+				URL:              "https://example.invalid/game.pck",
+				MD5:              hex.EncodeToString(md5Hash[:]),
+				Size:             int64(len(tc.compressed)),
+				DecompressedSize: tc.decompressedSize,
+				Compression:      "gzip",
+			}
+			destPath := filepath.Join(t.TempDir(), "game.pck")
+
+			err := DownloadWithClient(newDryRunClient(t, tc.compressed, http.StatusOK), file, destPath)
+			if tc.errContains == "" {
+				if err != nil {
+					t.Fatalf("Download failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+				t.Errorf("Expected an error containing %q, got %v", tc.errContains, err)
+			}
+			for _, path := range []string{destPath, destPath + ".part"} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Expected no %s after a failed download", filepath.Base(path))
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadWithClientUnsupportedCompression(t *testing.T) {
+	file := GamePackageFile{URL: "https://example.invalid/game.pck", Compression: "lzma"}
+	destPath := filepath.Join(t.TempDir(), "game.pck")
+
+	err := DownloadWithClient(newDryRunClient(t, []byte("data"), http.StatusOK), file, destPath)
+	if err == nil {
+		t.Fatalf("Expected an error for an unsupported compression")
+	}
+	if _, err := os.Stat(destPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial file to be removed, got %v", err)
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	next     http.RoundTripper
+	requests atomic.Int32
+}
+
+func (ct *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ct.requests.Add(1)
+	return ct.next.RoundTrip(r)
+}
+
+func TestDownloadAllWithClientSharedURL(t *testing.T) {
+	content := bytes.Repeat([]byte("shared audio bank "), 1000)
+	md5Hash := md5.Sum(content)
+	file := GamePackageFile{
+		URL:  "https://example.invalid/audio.pck",
+		MD5:  hex.EncodeToString(md5Hash[:]),
+		Size: int64(len(content)),
+	}
+	dir := t.TempDir()
+	downloads := []PackageDownload{
+		{File: file, DestPath: filepath.Join(dir, "en-us", "audio.pck")},
+		{File: file, DestPath: filepath.Join(dir, "ja-jp", "audio.pck")},
+	}
+
+	client := newDryRunClient(t, content, http.StatusOK)
+	transport := &countingTransport{next: client.Transport()}
+	client.SetTransport(transport)
+
+	if err := DownloadAllWithClient(client, downloads); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if requests := transport.requests.Load(); requests != 1 {
+		t.Errorf("Expected the shared URL to be downloaded once, got %d requests", requests)
+	}
+	for _, download := range downloads {
+		downloaded, err := os.ReadFile(download.DestPath)
+		if err != nil {
+			t.Fatalf("Failed to read downloaded file: %v", err)
+		}
+		if !bytes.Equal(downloaded, content) {
+			t.Errorf("%s: content differs from served content", download.DestPath)
+		}
+	}
+
+	// A second entry expecting other content for the same URL fails its own verification.
+	other := file
+	other.MD5 = "00000000000000000000000000000000"
+	destPath := filepath.Join(dir, "other", "audio.pck")
+	err := DownloadAllWithClient(client, []PackageDownload{downloads[0], {File: other, DestPath: destPath}})
+	if err == nil {
+		t.Fatalf("Expected an md5 mismatch error")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written, got %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 package hyapi
 
+import "resty.dev/v3"
+
 type GamePackages struct {
 	GamePackages []GamePackage `json:"game_packages"` // Array of GamePackage
 }
@@ -38,9 +40,20 @@ type GamePackageFile struct {
 	MD5              string  `json:"md5"`                      // MD5 checksum (always lowercase)
 	Size             int64   `json:"size,string"`              // Compressed package size (can be a string)
 	DecompressedSize int64   `json:"decompressed_size,string"` // Decompressed size (can be a string)
+	Compression      string  `json:"compression,omitempty"`    // Transport compression of the content the MD5 refers to ("", "gzip" or "zstd")
 }
 
 func getGamePackages() []GamePackage {
+	// Initialize Resty client
+	client := resty.New()
+	defer client.Close()
+
+	// Delegate to getGamePackagesWithClient
+	return getGamePackagesWithClient(client)
+}
+
+// getGamePackagesWithClient fetches the game packages from the API using the provided client
+func getGamePackagesWithClient(client *resty.Client) []GamePackage {
 	// Default values
 	hostname := "sg-hyp-api.hoyoverse.com"
 	api := "getGamePackages"
@@ -49,7 +62,7 @@ func getGamePackages() []GamePackage {
 	nestedKey := "game_packages"
 
 	// Fetch game packages from the API
-	result := callAPI[[]GamePackage](hostname, api, launcherID, language, nestedKey)
+	result := callAPIWithClient[[]GamePackage](client, hostname, api, launcherID, language, nestedKey)
 
 	return result
 }
@@ -0,0 +1,96 @@
+package hyapi
+
+import (
+	"io"
+	"sync"
+
+	"resty.dev/v3"
+)
+
+// PauseGate holds back the goroutines waiting at it while it is paused. The zero value is an open gate.
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // Closed by Resume, nil while the gate is open
+}
+
+// Pause closes the gate, so the next Wait blocks until Resume is called.
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+// Resume opens the gate and wakes up every goroutine waiting at it.
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+// Paused tells whether the gate is closed.
+func (g *PauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// Wait blocks while the gate is paused.
+func (g *PauseGate) Wait() {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume != nil {
+		<-resume
+	}
+}
+
+// Reader returns r waiting at the gate before every Read, so a transfer paused midway stops at its next chunk
+// and continues from there once resumed.
+func (g *PauseGate) Reader(r io.Reader) io.Reader {
+	return &gatedReader{r: r, gate: g}
+}
+
+// gatedReader waits at gate before reading from r.
+type gatedReader struct {
+	r    io.Reader
+	gate *PauseGate
+}
+
+func (gr *gatedReader) Read(p []byte) (int, error) {
+	gr.gate.Wait()
+	return gr.r.Read(p)
+}
+
+// Downloader downloads package files like DownloadAllWithProgress and can be paused from another goroutine:
+// while paused no new file is started, and the files being transferred stop at their next chunk, keeping their
+// connection and what was written so far. Resume continues every transfer from where it stopped.
+// A Downloader must not be copied once used.
+type Downloader struct {
+	Client   *resty.Client
+	Progress *DownloadProgress // Reports the bytes transferred when not nil
+	Retry    RetryPolicy       // Retries a failed transfer, the zero value makes a single attempt
+	PauseGate
+}
+
+// Download downloads a package file to destPath like DownloadWithClient.
+func (d *Downloader) Download(file GamePackageFile, destPath string) error {
+	d.Wait()
+	return d.download(file, destPath)
+}
+
+// DownloadAll downloads every package file to its destination path like DownloadAllWithClient.
+func (d *Downloader) DownloadAll(downloads []PackageDownload) error {
+	downloaded := make(map[string]string) // URL -> path it was first downloaded to
+	for _, download := range downloads {
+		d.Wait() // Don't start another file while paused
+		if err := d.downloadOnce(download, downloaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package hyapi
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloaderPauseResume(t *testing.T) {
+	content := bytes.Repeat([]byte("paused data "), 20_000)
+	md5Hash := md5.Sum(content)
+	file := func(url string) GamePackageFile {
+		return GamePackageFile{URL: url, MD5: hex.EncodeToString(md5Hash[:]), Size: int64(len(content))}
+	}
+	dir := t.TempDir()
+	downloads := []PackageDownload{
+		{File: file("https://example.invalid/a.pck"), DestPath: filepath.Join(dir, "a.pck")},
+		{File: file("https://example.invalid/b.pck"), DestPath: filepath.Join(dir, "b.pck")},
+	}
+
+	// Pause from the progress callback once the first file is partly transferred
+	var done atomic.Int64
+	var pauseOnce sync.Once
+	paused := make(chan struct{})
+	downloader := &Downloader{Client: newDryRunClient(t, content, http.StatusOK)}
+	downloader.Progress = NewDownloadProgress(downloads, nil, func(d, _ int64) {
+		done.Store(d)
+		if d < int64(len(content)) {
+			pauseOnce.Do(func() {
+				downloader.Pause()
+				close(paused)
+			})
+		}
+	})
+
+	result := make(chan error, 1)
+	go func() { result <- downloader.DownloadAll(downloads) }()
+	select {
+	case <-paused:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download never started")
+	}
+
+	// Nothing is transferred while paused, and the second file isn't started
+	stalled := done.Load()
+	time.Sleep(50 * time.Millisecond)
+	if d := done.Load(); d != stalled || d >= int64(len(content)) {
+		t.Errorf("Expected the transfer to stop at %d bytes, got %d", stalled, d)
+	}
+	select {
+	case err := <-result:
+		t.Fatalf("Expected the download to wait while paused, got %v", err)
+	default:
+	}
+	if _, err := os.Stat(downloads[1].DestPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the second file not to be started while paused, got %v", err)
+	}
+
+	downloader.Resume()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download didn't complete once resumed")
+	}
+	for _, download := range downloads {
+		downloaded, err := os.ReadFile(download.DestPath)
+		if err != nil || !bytes.Equal(downloaded, content) {
+			t.Errorf("%s: expected the served content, got %d bytes (err: %v)", download.DestPath, len(downloaded), err)
+		}
+	}
+	if d := done.Load(); d != 2*int64(len(content)) {
+		t.Errorf("Expected %d bytes transferred, got %d", 2*len(content), d)
+	}
+}
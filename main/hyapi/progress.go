@@ -0,0 +1,114 @@
+package hyapi
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ProgressFunc is called as a package file downloads, with the bytes transferred so far and the expected total,
+// the compressed size for a compressed file.
+type ProgressFunc func(file GamePackageFile, done, total int64)
+
+// DownloadProgress reports the progress of several downloads, per file and across all of them.
+// The aggregate counter is updated atomically, so downloads may run concurrently; the callbacks are called from
+// the downloading goroutines and must be safe for that.
+type DownloadProgress struct {
+	OnFile      ProgressFunc            // Called with the progress of the file being read, may be nil
+	OnAggregate func(done, total int64) // Called with the progress across every file, may be nil
+
+	total int64        // Expected bytes of every unique URL
+	done  atomic.Int64 // Bytes transferred so far across every file
+}
+
+// NewDownloadProgress returns the progress of downloading the given package files, counting each unique URL once
+// like DownloadAllWithClient fetches it.
+func NewDownloadProgress(downloads []PackageDownload, onFile ProgressFunc, onAggregate func(done, total int64)) *DownloadProgress {
+	p := &DownloadProgress{OnFile: onFile, OnAggregate: onAggregate}
+	seen := make(map[string]bool)
+	for _, download := range downloads {
+		if !seen[download.File.URL] {
+			seen[download.File.URL] = true
+			p.total += download.File.Size
+		}
+	}
+	return p
+}
+
+// Total returns the expected bytes of every unique URL.
+func (p *DownloadProgress) Total() int64 {
+	return p.total
+}
+
+// Done returns the bytes transferred so far across every file.
+func (p *DownloadProgress) Done() int64 {
+	return p.done.Load()
+}
+
+// onRead returns the callback of the counting reader of file, nil when p is nil.
+func (p *DownloadProgress) onRead(file GamePackageFile) func(read, done int64) {
+	if p == nil {
+		return nil
+	}
+	return func(read, done int64) {
+		if p.OnFile != nil {
+			p.OnFile(file, done, file.Size)
+		}
+		aggregate := p.done.Add(read)
+		if p.OnAggregate != nil {
+			p.OnAggregate(aggregate, p.total)
+		}
+	}
+}
+
+// unread takes back n bytes of a failed transfer from the aggregate, nothing when p is nil.
+func (p *DownloadProgress) unread(n int64) {
+	if p == nil || n == 0 {
+		return
+	}
+	aggregate := p.done.Add(-n)
+	if p.OnAggregate != nil {
+		p.OnAggregate(aggregate, p.total)
+	}
+}
+
+// ProgressBar renders done out of total as a bar of width characters followed by the percentage, e.g.
+// "[=======>            ]  38%". An unknown total renders an empty bar.
+func ProgressBar(done, total int64, width int) string {
+	var percent int64
+	if total > 0 {
+		percent = min(max(done*100/total, 0), 100)
+	}
+	filled := int(percent) * width / 100
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		if filled > 0 {
+			bar = bar[:filled-1] + ">"
+		}
+		bar += strings.Repeat(" ", width-filled)
+	}
+	return fmt.Sprintf("[%s] %3d%%", bar, percent)
+}
+
+// NewTerminalProgress returns the progress of downloads rendered to w as a single line, rewritten in place,
+// with a bar for the file being read and one for every file.
+func NewTerminalProgress(w io.Writer, downloads []PackageDownload) *DownloadProgress {
+	var mu sync.Mutex // Concurrent downloads would interleave their lines
+	var fileBar string
+	return NewDownloadProgress(downloads,
+		func(file GamePackageFile, done, total int64) {
+			mu.Lock()
+			fileBar = ProgressBar(done, total, 20)
+			mu.Unlock()
+		},
+		func(done, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(w, "\rfile %s  total %s", fileBar, ProgressBar(done, total, 30))
+			if done >= total {
+				fmt.Fprintln(w)
+			}
+		})
+}
@@ -0,0 +1,95 @@
+package hyapi
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadAllWithProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("progress data "), 10_000)
+	md5Hash := md5.Sum(content)
+	file := func(url string) GamePackageFile {
+		return GamePackageFile{URL: url, MD5: hex.EncodeToString(md5Hash[:]), Size: int64(len(content))}
+	}
+	dir := t.TempDir()
+	downloads := []PackageDownload{
+		{File: file("https://example.invalid/a.pck"), DestPath: filepath.Join(dir, "a.pck")},
+		{File: file("https://example.invalid/b.pck"), DestPath: filepath.Join(dir, "b.pck")},
+		{File: file("https://example.invalid/a.pck"), DestPath: filepath.Join(dir, "copy", "a.pck")}, // Reused, not transferred
+	}
+
+	fileDone := make(map[string][]int64)
+	var aggregateDone []int64
+	var aggregateTotal int64
+	progress := NewDownloadProgress(downloads,
+		func(file GamePackageFile, done, total int64) {
+			if total != file.Size {
+				t.Errorf("%s: expected a total of %d, got %d", file.URL, file.Size, total)
+			}
+			fileDone[file.URL] = append(fileDone[file.URL], done)
+		},
+		func(done, total int64) {
+			aggregateDone = append(aggregateDone, done)
+			aggregateTotal = total
+		})
+
+	if err := DownloadAllWithProgress(newDryRunClient(t, content, http.StatusOK), downloads, progress); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	increasing := func(name string, values []int64, last int64) {
+		t.Helper()
+		if len(values) == 0 {
+			t.Fatalf("%s: no progress reported", name)
+		}
+		for i := 1; i < len(values); i++ {
+			if values[i] <= values[i-1] {
+				t.Errorf("%s: progress went from %d to %d", name, values[i-1], values[i])
+			}
+		}
+		if values[len(values)-1] != last {
+			t.Errorf("%s: expected progress to end at %d, got %d", name, last, values[len(values)-1])
+		}
+	}
+	if len(fileDone) != 2 {
+		t.Errorf("Expected progress of the 2 unique URLs, got %d", len(fileDone))
+	}
+	for url, done := range fileDone {
+		increasing(url, done, int64(len(content)))
+	}
+	total := 2 * int64(len(content))
+	increasing("aggregate", aggregateDone, total)
+	if aggregateTotal != total || progress.Total() != total || progress.Done() != total {
+		t.Errorf("Expected an aggregate total of %d, got %d, %d and %d done", total, aggregateTotal, progress.Total(), progress.Done())
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		done, total int64
+		expected    string
+	}{
+		{0, 100, "[          ]   0%"},
+		{38, 100, "[==>       ]  38%"},
+		{100, 100, "[==========] 100%"},
+		{150, 100, "[==========] 100%"},
+		{10, 0, "[          ]   0%"},
+	}
+	for _, test := range tests {
+		if got := ProgressBar(test.done, test.total, 10); got != test.expected {
+			t.Errorf("ProgressBar(%d, %d): expected %q, got %q", test.done, test.total, test.expected, got)
+		}
+	}
+
+	var out strings.Builder
+	progress := NewTerminalProgress(&out, []PackageDownload{{File: GamePackageFile{URL: "u", Size: 10}}})
+	progress.onRead(GamePackageFile{URL: "u", Size: 10})(10, 10)
+	if !strings.HasSuffix(out.String(), "100%\n") {
+		t.Errorf("Expected a completed line, got %q", out.String())
+	}
+}
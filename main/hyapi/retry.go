@@ -0,0 +1,112 @@
+package hyapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultRetryMaxDelay caps the delay between two attempts of a request, however many times it failed.
+const defaultRetryMaxDelay = 30 * time.Second
+
+// Default number of retries and delay before the first one of DownloadWithClient and DownloadAllWithClient.
+const (
+	DefaultRetries    = 3
+	DefaultRetryDelay = time.Second
+)
+
+// defaultRetryableStatus are the status codes of responses worth retrying: timeouts, rate limits and server errors
+// that are usually temporary.
+var defaultRetryableStatus = []int{408, 429, 500, 502, 503, 504}
+
+// RetryPolicy decides how often and how late a failed request is tried again. The zero value makes a single attempt.
+type RetryPolicy struct {
+	Attempts        int           // Number of attempts, the first included, at least 1
+	BaseDelay       time.Duration // Delay before the second attempt, doubled before each next one
+	MaxDelay        time.Duration // Maximum delay between two attempts, unlimited when 0
+	Jitter          float64       // Fraction of each delay drawn at random, from 0 to 1, so clients don't retry in step
+	RetryableStatus []int         // Status codes of the responses retried, network errors are always retried
+}
+
+// NewRetryPolicy returns the policy retrying a failed request retries times, waiting delay before the first retry.
+func NewRetryPolicy(retries int, delay time.Duration) RetryPolicy {
+	return RetryPolicy{
+		Attempts:        retries + 1,
+		BaseDelay:       delay,
+		MaxDelay:        defaultRetryMaxDelay,
+		Jitter:          0.2,
+		RetryableStatus: defaultRetryableStatus,
+	}
+}
+
+// StatusError is the error of a request answered with a status code other than 200 OK, the status code telling
+// whether it is retried.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to fetch %s: status code %d", e.URL, e.Code)
+}
+
+// permanentError marks an error that is never retried, whatever it wraps, e.g. the unexpected EOF of a compressed
+// stream that was fully transferred but is truncated, which would fail alike on every attempt.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retryable tells whether an attempt failing with err is worth retrying: a response with a retryable status code,
+// or a network error, the connection dropping mid-body included. Any other error, e.g. a response that isn't a
+// manifest or a file failing its checksum, would fail alike on every attempt.
+func (p RetryPolicy) retryable(err error) bool {
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(p.RetryableStatus, statusErr.Code)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// delay returns how long to wait after the failed attempt number attempt, counted from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay << min(attempt-1, 30) // Capped shift, so the delay never overflows to a negative one
+	if delay < 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		jitter := time.Duration(p.Jitter * float64(delay))
+		delay += -jitter + rand.N(2*jitter+1)
+	}
+	return max(delay, 0)
+}
+
+// ExecuteWithRetry calls op until it succeeds, fails with an error that isn't retryable, or policy.Attempts attempts
+// were made, sleeping between attempts as the policy says. op is given the attempt number, counted from 1, and must
+// clean up after a failed attempt, e.g. remove a partially written file. The error of the last attempt is returned.
+func ExecuteWithRetry(policy RetryPolicy, op func(attempt int) error) error {
+	attempts := max(policy.Attempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if err == nil || attempt >= attempts || !policy.retryable(err) {
+			return err
+		}
+		delay := policy.delay(attempt)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("Request failed, retrying")
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,71 @@
+package hyapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// errTemporary is a network error that goes away when retried.
+var errTemporary = fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+
+func TestExecuteWithRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, BaseDelay: time.Millisecond, RetryableStatus: defaultRetryableStatus}
+
+	// Failing twice, then succeeding.
+	calls := 0
+	err := ExecuteWithRetry(policy, func(attempt int) error {
+		calls++
+		if attempt != calls {
+			t.Errorf("Expected attempt %d, got %d", calls, attempt)
+		}
+		if calls < 3 {
+			return errTemporary
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the 3rd attempt, got %v after %d attempts", err, calls)
+	}
+
+	// Giving up after the last attempt, with its error.
+	calls = 0
+	err = ExecuteWithRetry(policy, func(int) error {
+		calls++
+		return &StatusError{URL: "http://example.test/", Code: http.StatusServiceUnavailable}
+	})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || calls != 4 {
+		t.Errorf("Expected the status error after 4 attempts, got %v after %d attempts", err, calls)
+	}
+
+	// Errors that would fail alike are never retried.
+	for _, permanent := range []error{&StatusError{Code: http.StatusNotFound}, errors.New("not a manifest")} {
+		calls = 0
+		err = ExecuteWithRetry(policy, func(int) error {
+			calls++
+			return permanent
+		})
+		if err != permanent || calls != 1 {
+			t.Errorf("Expected %v without retrying, got %v after %d attempts", permanent, err, calls)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 100: 5 * time.Second} {
+		if delay := policy.delay(attempt); delay != expected {
+			t.Errorf("Attempt %d: expected %s, got %s", attempt, expected, delay)
+		}
+	}
+	policy.Jitter = 0.5
+	for range 100 {
+		if delay := policy.delay(2); delay < time.Second || delay > 3*time.Second {
+			t.Fatalf("Expected 2s give or take half, got %s", delay)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package hyapi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+
+	"resty.dev/v3"
+)
+
+// PackageKind is the kind of package a file listed by PackageURLs belongs to.
+type PackageKind string
+
+const (
+	KindMain  PackageKind = "main"  // Game files of the complete installation package
+	KindAudio PackageKind = "audio" // Audio files of the complete installation package
+	KindPatch PackageKind = "patch" // Game and audio files of the incremental update packages
+)
+
+// URLFilter selects the files listed by PackageURLs.
+type URLFilter struct {
+	Kinds       []PackageKind // Kinds of package listed, every kind when empty
+	Language    string        // Only the audio files of this language when set, e.g. "en-us", files without language are kept
+	PreDownload bool          // List the pre-download version instead of the current one
+}
+
+// PackageURL is a file to download, with the game and package it belongs to.
+type PackageURL struct {
+	Game    string // Game business, e.g. "hk4e_global"
+	Version string // Version of the complete installation package or incremental update
+	Kind    PackageKind
+	File    GamePackageFile
+}
+
+// PackageURLs returns the files of every game package matching the filter, in the order of the API response:
+// per game the complete installation package, game files before audio files, then the incremental updates.
+// This decouples discovering the files from downloading them, e.g. with an external downloader.
+func PackageURLs(packages []GamePackage, filter URLFilter) []PackageURL {
+	var urls []PackageURL
+	add := func(game, version string, kind PackageKind, files []GamePackageFile) {
+		if len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, kind) {
+			return
+		}
+		for _, file := range files {
+			if filter.Language != "" && file.Language != nil && *file.Language != filter.Language {
+				continue
+			}
+			urls = append(urls, PackageURL{Game: game, Version: version, Kind: kind, File: file})
+		}
+	}
+	for _, pkg := range packages {
+		version := pkg.Main
+		if filter.PreDownload {
+			version = pkg.PreDownload
+		}
+		game := pkg.GameId.GameBiz
+		if major := version.Major; major != nil {
+			add(game, major.Version, KindMain, major.GamePackages)
+			add(game, major.Version, KindAudio, major.AudioPackages)
+		}
+		for _, patch := range version.Patches {
+			add(game, patch.Version, KindPatch, patch.GamePackages)
+			add(game, patch.Version, KindPatch, patch.AudioPackages)
+		}
+	}
+	return urls
+}
+
+// WriteURLs writes one line per file to w, its URL, size and MD5 separated by tabs.
+func WriteURLs(w io.Writer, urls []PackageURL) error {
+	bw := bufio.NewWriter(w)
+	for _, url := range urls {
+		fmt.Fprintf(bw, "%s\t%d\t%s\n", url.File.URL, url.File.Size, url.File.MD5) // A failed write is kept by bw
+	}
+	return bw.Flush()
+}
+
+// ListURLsWithClient fetches the game packages using the provided client and writes the files matching the filter
+// to w with WriteURLs.
+func ListURLsWithClient(client *resty.Client, w io.Writer, filter URLFilter) error {
+	return WriteURLs(w, PackageURLs(getGamePackagesWithClient(client), filter))
+}
+
+// ListURLs initializes a Resty client and writes the files matching the filter to w, see ListURLsWithClient.
+func ListURLs(w io.Writer, filter URLFilter) error {
+	// Initialize Resty client
+	client := resty.New()
+	defer client.Close()
+
+	// Delegate to ListURLsWithClient
+	return ListURLsWithClient(client, w, filter)
+}
@@ -0,0 +1,81 @@
+package hyapi
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"resty.dev/v3"
+)
+
+func TestListURLsWithClient(t *testing.T) {
+	client := resty.New()
+	client.SetTransport(&DryRunTransport{
+		MockResponseFile: "../../res/demoapi/getGamePackages.json",
+		StatusCode:       http.StatusOK,
+	})
+	defer client.Close()
+
+	list := func(filter URLFilter) []string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := ListURLsWithClient(client, &out, filter); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	}
+
+	// Every file of every game, the complete packages and the updates with their audio of every language.
+	if lines := list(URLFilter{}); len(lines) != 71 {
+		t.Errorf("Expected 71 files, got %d", len(lines))
+	}
+
+	expected := []string{
+		"https://autopatchos.zenlesszonezero.com/package_download/op/client_app/os/download/20250302131501_XPL3MbP0GgnuJoHq/audio_zip_En.zip\t1545364599\tf98c8e2f36b02fff0bd9ecccdddbf149",
+		"https://autopatchos.starrails.com/client/download/20250213222029_yggGSkDyveoATToc/PC/English.7z\t8230597408\t6590989fd52a71cb8a7c50836174935f",
+		"https://autopatchhk.yuanshen.com/client_app/download/pc_zip/20250314110016_HcIQuDGRmsbByeAE/Audio_English(US)_5.5.0.zip\t18412580831\te14821e9236844f1fae27396f30f094a",
+	}
+	lines := list(URLFilter{Kinds: []PackageKind{KindAudio}, Language: "en-us"})
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the English audio files\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	// The 5 updates have a game file each and an English audio file each.
+	lines = list(URLFilter{Kinds: []PackageKind{KindPatch}, Language: "en-us"})
+	if len(lines) != 10 {
+		t.Errorf("Expected 10 update files, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if fields := strings.Split(line, "\t"); len(fields) != 3 || !strings.Contains(fields[0], "_hdiff_") {
+			t.Errorf("Expected an update URL, size and MD5, got %q", line)
+		}
+	}
+}
+
+func TestPackageURLsPreDownload(t *testing.T) {
+	language := "en-us"
+	packages := []GamePackage{{
+		GameId: GameId{GameBiz: "test_global"},
+		Main: GamePackageVersion{Major: &GamePackageResource{
+			Version:      "1.0.0",
+			GamePackages: []GamePackageFile{{URL: "https://example.invalid/game_1.0.0.zip"}},
+		}},
+		PreDownload: GamePackageVersion{Major: &GamePackageResource{
+			Version:       "1.1.0",
+			GamePackages:  []GamePackageFile{{URL: "https://example.invalid/game_1.1.0.zip"}},
+			AudioPackages: []GamePackageFile{{URL: "https://example.invalid/audio_1.1.0.zip", Language: &language}},
+		}},
+	}}
+
+	urls := PackageURLs(packages, URLFilter{PreDownload: true})
+	if len(urls) != 2 {
+		t.Fatalf("Expected 2 pre-download files, got %d", len(urls))
+	}
+	if urls[0].Kind != KindMain || urls[0].Version != "1.1.0" || urls[1].Kind != KindAudio || urls[1].Game != "test_global" {
+		t.Errorf("Expected the main then audio files of 1.1.0, got %+v", urls)
+	}
+	if urls := PackageURLs(packages, URLFilter{PreDownload: true, Language: "ja-jp"}); len(urls) != 1 {
+		t.Errorf("Expected the audio file of another language to be skipped, got %d files", len(urls))
+	}
+}
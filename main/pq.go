@@ -2,8 +2,12 @@ package main
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -14,39 +18,52 @@ import (
 type Item[T any] struct {
 	Value    T
 	Priority int // Higher value means higher priority
+	Retries  int // Number of times the item was requeued after a failure
 	index    int // Index in the heap (for heap.Interface)
 }
 
 // UnboundedPriorityQueue implements heap.Interface and holds Items.
-type UnboundedPriorityQueue[T any] []*Item[T]
+// Items of equal priority come out first in, first out, numbered in push order by the queue itself, so queues
+// don't contend on a shared counter.
+type UnboundedPriorityQueue[T any] struct {
+	items   []*Item[T]
+	seqs    []uint64 // Push order of each item, kept by the queue so an item doesn't carry the order of another queue
+	nextSeq uint64   // Push order of the next item
+}
 
-func (pq UnboundedPriorityQueue[T]) Len() int { return len(pq) }
+func (pq UnboundedPriorityQueue[T]) Len() int { return len(pq.items) }
 
 // We want higher priority to have a lower index in the heap for removal.
+// Equal priorities are ordered by push order, so they come out first in, first out.
 func (pq UnboundedPriorityQueue[T]) Less(i, j int) bool {
-	return pq[i].Priority > pq[j].Priority
+	if pq.items[i].Priority != pq.items[j].Priority {
+		return pq.items[i].Priority > pq.items[j].Priority
+	}
+	return pq.seqs[i] < pq.seqs[j]
 }
 
 func (pq UnboundedPriorityQueue[T]) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.seqs[i], pq.seqs[j] = pq.seqs[j], pq.seqs[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 func (pq *UnboundedPriorityQueue[T]) Push(x any) {
-	n := len(*pq)
 	item := x.(*Item[T])
-	item.index = n
-	*pq = append(*pq, item)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
+	pq.seqs = append(pq.seqs, pq.nextSeq) // A requeued item goes after those of its priority already queued
+	pq.nextSeq++
 }
 
 func (pq *UnboundedPriorityQueue[T]) Pop() any {
-	old := *pq
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil  // avoid memory leaks
-	item.index = -1 // for safety
-	*pq = old[0 : n-1]
+	n := len(pq.items)
+	item := pq.items[n-1]
+	pq.items[n-1] = nil // avoid memory leaks
+	item.index = -1     // for safety
+	pq.items = pq.items[:n-1]
+	pq.seqs = pq.seqs[:n-1]
 	return item
 }
 
@@ -66,6 +83,28 @@ type BlockingPriorityQueue[T any] struct {
 	mu     sync.Mutex                // Mutex for thread-safe access to the queue
 	co     *sync.Cond                // Condition variable for signaling when the queue is not empty
 	closed bool                      // Indicates if the queue is closed
+	paused bool                      // Indicates if Pop is currently held back
+
+	capacity int        // Maximum number of queued items Push waits for room below, 0 when unbounded
+	notFull  *sync.Cond // Condition variable for signaling when a bounded queue has room, nil when unbounded
+
+	trackInFlight bool // Whether popped items must be settled with Done or Requeue
+	inFlight      int  // Number of popped items not settled yet, only counted when trackInFlight is set
+
+	onLength *lengthListener // Listener registered with OnLengthChange, nil when none
+}
+
+// lengthListener is a callback registered with OnLengthChange, run by its own notifier goroutine.
+type lengthListener struct {
+	fn       func(newLen int)
+	wake     chan struct{} // Wakes the notifier, buffered by one so rapid changes coalesce
+	stop     chan struct{} // Closed once the listener is unregistered or replaced, which stops the notifier
+	stopOnce sync.Once
+}
+
+// close stops the notifier of the listener, it may be called more than once.
+func (l *lengthListener) close() {
+	l.stopOnce.Do(func() { close(l.stop) })
 }
 
 // NewBlockingPriorityQueue initializes a new BlockingPriorityQueue.
@@ -76,6 +115,39 @@ func NewBlockingPriorityQueue[T any]() *BlockingPriorityQueue[T] {
 	return bpq
 }
 
+// NewBoundedPriorityQueue initializes a BlockingPriorityQueue holding at most capacity items: Push and PushBatch
+// wait for Pop to make room, so a fast producer can't grow it without bound. Closing the queue wakes them up with
+// an error. Requeue never waits, as the item is part of the queue already. It panics when capacity is not positive.
+func NewBoundedPriorityQueue[T any](capacity int) *BlockingPriorityQueue[T] {
+	if capacity <= 0 {
+		panic("NewBoundedPriorityQueue: capacity must be positive")
+	}
+	bpq := NewBlockingPriorityQueue[T]()
+	bpq.capacity = capacity
+	bpq.notFull = sync.NewCond(&bpq.mu)
+	return bpq
+}
+
+// waitNotFull waits until a bounded queue has room for one more item or is closed, it must be called with the lock
+// held. It returns immediately for an unbounded queue.
+func (pqw *BlockingPriorityQueue[T]) waitNotFull() {
+	for pqw.notFull != nil && pqw.pq.Len() >= pqw.capacity && !pqw.closed {
+		pqw.notFull.Wait() // Release the lock and wait for an item to be popped
+	}
+}
+
+// signalNotFull wakes up the producers waiting for room after n items were popped, it must be called with the
+// lock held.
+func (pqw *BlockingPriorityQueue[T]) signalNotFull(n int) {
+	switch {
+	case pqw.notFull == nil:
+	case n == 1:
+		pqw.notFull.Signal() // A single free slot can only satisfy one waiting producer
+	default:
+		pqw.notFull.Broadcast() // Several free slots can satisfy several waiting producers
+	}
+}
+
 // Len returns the length of the priority queue in a thread-safe manner.
 func (pqw *BlockingPriorityQueue[T]) Len() int {
 	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
@@ -83,17 +155,66 @@ func (pqw *BlockingPriorityQueue[T]) Len() int {
 	return pqw.pq.Len()
 }
 
+// unsettled returns the number of items queued plus those in flight, read together so an item moving between the
+// two is counted once.
+func (pqw *BlockingPriorityQueue[T]) unsettled() int {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+	return pqw.pq.Len() + pqw.inFlight
+}
+
 // Push adds an item to the priority queue in a thread-safe manner.
 func (pqw *BlockingPriorityQueue[T]) Push(x *Item[T]) error {
 	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
 	defer pqw.mu.Unlock() // Release the lock when the function exits
 
+	pqw.waitNotFull() // A bounded queue waits for room first
 	if pqw.closed {
 		return fmt.Errorf("queue is closed")
 	}
 
 	defer pqw.co.Signal() // Signal one waiting goroutine that an item has been added
 	heap.Push(&pqw.pq, x) // Add the item to the underlying priority queue
+	pqw.notifyLength()
+	return nil
+}
+
+// PushBatch adds several items to the priority queue under a single lock acquisition.
+// Waiting goroutines are woken only as needed: Signal for one item, Broadcast for more.
+// A bounded queue adds the items one by one as room is made, so a closed queue may have taken only some of them.
+func (pqw *BlockingPriorityQueue[T]) PushBatch(items ...*Item[T]) error {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.closed {
+		return fmt.Errorf("queue is closed")
+	}
+
+	if pqw.notFull != nil {
+		for i, x := range items {
+			pqw.waitNotFull()
+			if pqw.closed {
+				return fmt.Errorf("queue is closed, %d of %d items added", i, len(items))
+			}
+			heap.Push(&pqw.pq, x) // Add the item to the underlying priority queue
+			pqw.co.Signal()       // Wake a consumer now, it may be the one making room for the next item
+			pqw.notifyLength()
+		}
+		return nil
+	}
+
+	switch len(items) {
+	case 0:
+		return nil // Nothing added, nobody to wake up
+	case 1:
+		defer pqw.co.Signal() // A single item can only satisfy one waiting goroutine
+	default:
+		defer pqw.co.Broadcast() // Several items can satisfy several waiting goroutines
+	}
+	for _, x := range items {
+		heap.Push(&pqw.pq, x) // Add the item to the underlying priority queue
+	}
+	pqw.notifyLength()
 	return nil
 }
 
@@ -102,8 +223,9 @@ func (pqw *BlockingPriorityQueue[T]) Pop() (*Item[T], error) {
 	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
 	defer pqw.mu.Unlock() // Release the lock when the function exits
 
-	// Wait until the queue is not empty or closed
-	for pqw.pq.Len() <= 0 && !pqw.closed {
+	// Wait until the queue is not empty or closed, and not paused unless there is nothing left to hand out
+	// A closed queue still waits for items in flight, as they may be requeued
+	for (pqw.pq.Len() <= 0 && (!pqw.closed || pqw.inFlight > 0)) || (pqw.paused && pqw.pq.Len() > 0) {
 		pqw.co.Wait() // Release the lock and wait for a signal
 	}
 
@@ -112,8 +234,234 @@ func (pqw *BlockingPriorityQueue[T]) Pop() (*Item[T], error) {
 		return nil, fmt.Errorf("queue is closed")
 	}
 
+	if pqw.trackInFlight {
+		pqw.inFlight++
+	}
 	// Remove and return the highest-priority item
-	return heap.Pop(&pqw.pq).(*Item[T]), nil
+	item := heap.Pop(&pqw.pq).(*Item[T])
+	pqw.signalNotFull(1)
+	pqw.notifyLength()
+	return item, nil
+}
+
+// PopBytes removes and returns the highest-priority items whose combined size, as reported by sizeOf,
+// does not exceed maxBytes. At least one item is returned even if it alone is larger than maxBytes.
+// It blocks only while the queue is empty, like Pop.
+func (pqw *BlockingPriorityQueue[T]) PopBytes(maxBytes int64, sizeOf func(*Item[T]) int64) ([]*Item[T], error) {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	// Wait until the queue is not empty or closed, and not paused unless there is nothing left to hand out
+	// A closed queue still waits for items in flight, as they may be requeued
+	for (pqw.pq.Len() <= 0 && (!pqw.closed || pqw.inFlight > 0)) || (pqw.paused && pqw.pq.Len() > 0) {
+		pqw.co.Wait() // Release the lock and wait for a signal
+	}
+
+	if pqw.closed && pqw.pq.Len() == 0 {
+		log.Debug().Msg("BlockingPriorityQueue PopBytes() closed")
+		return nil, fmt.Errorf("queue is closed")
+	}
+
+	// Always take the highest-priority item, then keep taking while the next one still fits the budget
+	item := heap.Pop(&pqw.pq).(*Item[T])
+	batch := []*Item[T]{item}
+	total := sizeOf(item)
+	for pqw.pq.Len() > 0 {
+		next := pqw.pq.items[0] // The top of the heap is the next item Pop would return
+		size := sizeOf(next)
+		if total+size > maxBytes {
+			break
+		}
+		batch = append(batch, heap.Pop(&pqw.pq).(*Item[T]))
+		total += size
+	}
+	if pqw.trackInFlight {
+		pqw.inFlight += len(batch)
+	}
+	pqw.signalNotFull(len(batch))
+	pqw.notifyLength()
+	return batch, nil
+}
+
+// TryPop removes and returns the highest-priority item like Pop, but never waits: it returns (nil, false) at once
+// when the queue is empty, closed or paused.
+func (pqw *BlockingPriorityQueue[T]) TryPop() (*Item[T], bool) {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.pq.Len() == 0 || pqw.closed || pqw.paused {
+		return nil, false
+	}
+
+	if pqw.trackInFlight {
+		pqw.inFlight++
+	}
+	item := heap.Pop(&pqw.pq).(*Item[T])
+	pqw.signalNotFull(1)
+	pqw.notifyLength()
+	return item, true
+}
+
+// Drain removes and returns every item left in the queue at once, highest priority first, without waiting nor
+// closing the queue, which can still be used afterwards. Like Peek it doesn't care whether the queue is closed or
+// paused. When TrackInFlight was called, the drained items are in flight like popped ones.
+func (pqw *BlockingPriorityQueue[T]) Drain() []*Item[T] {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	items := make([]*Item[T], 0, pqw.pq.Len())
+	for pqw.pq.Len() > 0 {
+		items = append(items, heap.Pop(&pqw.pq).(*Item[T]))
+	}
+	if len(items) == 0 {
+		return items
+	}
+	if pqw.trackInFlight {
+		pqw.inFlight += len(items)
+	}
+	pqw.signalNotFull(len(items))
+	pqw.notifyLength()
+	return items
+}
+
+// TrackInFlight makes the queue count popped items until they are settled with Done or Requeue.
+// A closed queue then only reports being closed once every item in flight is settled, so requeued items are never lost.
+// It must be called before any item is popped.
+func (pqw *BlockingPriorityQueue[T]) TrackInFlight() {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	pqw.trackInFlight = true
+}
+
+// Done settles a popped item that was processed, it is a no-op unless TrackInFlight was called.
+func (pqw *BlockingPriorityQueue[T]) Done(_ *Item[T]) {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.trackInFlight {
+		pqw.inFlight--
+		if pqw.inFlight == 0 && pqw.closed {
+			pqw.co.Broadcast() // Wake up the goroutines waiting for the last items in flight
+			pqw.notifyLength() // Lets the length notifier see the queue is done
+		}
+	}
+}
+
+// Requeue puts a popped item back with a new priority. When TrackInFlight was called, it settles the item
+// and is accepted even after Close, as the item is part of the queue already; otherwise a closed queue rejects it.
+func (pqw *BlockingPriorityQueue[T]) Requeue(item *Item[T], newPriority int) error {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.trackInFlight {
+		pqw.inFlight--
+	} else if pqw.closed {
+		return fmt.Errorf("queue is closed")
+	}
+
+	defer pqw.co.Signal() // Signal one waiting goroutine that an item has been added
+	item.Priority = newPriority
+	heap.Push(&pqw.pq, item)
+	pqw.notifyLength()
+	return nil
+}
+
+// Peek returns the highest-priority item without removing it, and whether there was one. It never blocks, an empty
+// queue returns (nil, false) at once. Like Len it doesn't care whether the queue is closed or paused, so a closed
+// queue still shows the items left to pop.
+func (pqw *BlockingPriorityQueue[T]) Peek() (*Item[T], bool) {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.pq.Len() == 0 {
+		return nil, false
+	}
+	return pqw.pq.items[0], true // The root of the heap has the highest priority
+}
+
+// Reprioritize changes the priority of an item still in the queue, returning false without changing it when the item
+// is not in the queue, e.g. it was popped already. It is the thread-safe counterpart of UnboundedPriorityQueue.update.
+func (pqw *BlockingPriorityQueue[T]) Reprioritize(item *Item[T], priority int) bool {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	// The index of an item that was never pushed is zero too, so it must also be found at its index.
+	if item.index < 0 || item.index >= pqw.pq.Len() || pqw.pq.items[item.index] != item {
+		return false
+	}
+	pqw.pq.update(item, item.Value, priority)
+	return true
+}
+
+// TopK returns up to k highest-priority items, in the order Pop would return them, without removing them.
+// The result is a point-in-time snapshot: the items may be popped or updated by others right after it returns.
+func (pqw *BlockingPriorityQueue[T]) TopK(k int) []*Item[T] {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	return pqw.pq.topK(k)
+}
+
+// Snapshot returns every item in the queue at one point in time, in heap order rather than priority order, e.g. to
+// list the queue for monitoring. The slice is a copy, so sorting it leaves the heap alone, but the items are shared:
+// changing their Priority corrupts the queue, use Reprioritize instead.
+func (pqw *BlockingPriorityQueue[T]) Snapshot() []*Item[T] {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	return slices.Clone(pqw.pq.items)
+}
+
+// topK returns up to k highest-priority items in order without modifying the heap or the items' indexes.
+// It pops k times from a small candidate heap of positions, seeded with the root and extended with the
+// children of every popped position, so it costs O(k log k) instead of sorting the whole queue.
+func (pq UnboundedPriorityQueue[T]) topK(k int) []*Item[T] {
+	k = min(k, pq.Len())
+	if k <= 0 {
+		return nil
+	}
+
+	result := make([]*Item[T], 0, k)
+	candidates := &heapPositions[T]{pq: pq, positions: []int{0}}
+	for len(result) < k {
+		i := heap.Pop(candidates).(int)
+		result = append(result, pq.items[i])
+		// Children of a heap position are its only items that can come next after it.
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < pq.Len() {
+				heap.Push(candidates, child)
+			}
+		}
+	}
+	return result
+}
+
+// heapPositions is a heap of positions into an UnboundedPriorityQueue, ordered by the priority of their items.
+type heapPositions[T any] struct {
+	pq        UnboundedPriorityQueue[T]
+	positions []int
+}
+
+func (h heapPositions[T]) Len() int { return len(h.positions) }
+
+func (h heapPositions[T]) Less(i, j int) bool {
+	return h.pq.Less(h.positions[i], h.positions[j])
+}
+
+func (h heapPositions[T]) Swap(i, j int) {
+	h.positions[i], h.positions[j] = h.positions[j], h.positions[i]
+}
+
+func (h *heapPositions[T]) Push(x any) {
+	h.positions = append(h.positions, x.(int))
+}
+
+func (h *heapPositions[T]) Pop() any {
+	n := len(h.positions)
+	x := h.positions[n-1]
+	h.positions = h.positions[:n-1]
+	return x
 }
 
 // Close marks the queue as closed and signals all waiting goroutines.
@@ -124,39 +472,200 @@ func (pqw *BlockingPriorityQueue[T]) Close() {
 	if !pqw.closed {
 		pqw.closed = true
 		pqw.co.Broadcast() // Wake up all waiting goroutines
+		if pqw.notFull != nil {
+			pqw.notFull.Broadcast() // Wake up the producers waiting for room, they fail as the queue is closed
+		}
+		pqw.notifyLength() // Lets the length notifier stop if the queue is drained already
 		log.Debug().Msg("BlockingPriorityQueue closed")
 	}
 }
 
+// OnLengthChange registers fn to be called with the new length after Push, PushBatch, Pop, PopBytes or Requeue
+// change it, e.g. to spawn or retire workers based on the backlog. fn runs on a notifier goroutine without the lock
+// held, so it may call back into the queue. Changes coming faster than fn returns are debounced: fn only sees the
+// latest length, never the same length twice in a row. Calling it again replaces fn.
+// The notifier goroutine lives as long as the registration: it stops once the returned stop is called, fn is
+// replaced, or the queue is closed and drained. A call of fn already under way when stop is called still completes.
+func (pqw *BlockingPriorityQueue[T]) OnLengthChange(fn func(newLen int)) (stop func()) {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.onLength != nil {
+		pqw.onLength.close() // Replaced, its notifier stops
+	}
+	listener := &lengthListener{fn: fn, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	pqw.onLength = listener
+	if !pqw.drained() {
+		go pqw.lengthNotifier(listener, pqw.pq.Len())
+	}
+	return func() {
+		pqw.mu.Lock()
+		defer pqw.mu.Unlock()
+
+		if pqw.onLength == listener {
+			pqw.onLength = nil
+		}
+		listener.close()
+	}
+}
+
+// drained reports whether the queue is closed with no item left to pop or in flight, so its length never changes
+// again. It must be called with the lock held.
+func (pqw *BlockingPriorityQueue[T]) drained() bool {
+	return pqw.closed && pqw.pq.Len() == 0 && pqw.inFlight == 0
+}
+
+// notifyLength wakes the length notifier, if any. It must be called with the lock held and never blocks:
+// when a wake-up is pending already, the notifier reads the latest length anyway.
+func (pqw *BlockingPriorityQueue[T]) notifyLength() {
+	if pqw.onLength == nil {
+		return
+	}
+	select {
+	case pqw.onLength.wake <- struct{}{}:
+	default:
+	}
+}
+
+// lengthNotifier calls the callback of listener outside the lock whenever the length differs from the last one
+// reported, until the listener is stopped or the queue is drained.
+func (pqw *BlockingPriorityQueue[T]) lengthNotifier(listener *lengthListener, last int) {
+	for {
+		select {
+		case <-listener.wake:
+		case <-listener.stop:
+			return
+		}
+
+		pqw.mu.Lock()
+		length, done := pqw.pq.Len(), pqw.drained()
+		pqw.mu.Unlock()
+
+		if length != last {
+			last = length
+			select {
+			case <-listener.stop:
+				return // Stopped meanwhile
+			default:
+				listener.fn(length)
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// Pause holds back Pop until Resume is called. Items can still be pushed while paused.
+// A paused queue that is closed keeps its remaining items until it is resumed.
+func (pqw *BlockingPriorityQueue[T]) Pause() {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	pqw.paused = true
+}
+
+// Resume lets Pop hand out items again and wakes up all waiting goroutines.
+func (pqw *BlockingPriorityQueue[T]) Resume() {
+	pqw.mu.Lock()         // Acquire the lock to ensure thread-safe access
+	defer pqw.mu.Unlock() // Release the lock when the function exits
+
+	if pqw.paused {
+		pqw.paused = false
+		pqw.co.Broadcast() // Wake up all waiting goroutines
+	}
+}
+
+// Channel returns a channel receiving the items popped from the queue, highest priority first.
+// A goroutine pops items until the queue is closed and drained, then closes the channel.
+// It is lighter than ChannelizedPriorityQueue for read-only consumption, items are still pushed with Push.
+// Only one bridge should be created per queue: several would compete for the same items, and none
+// would be stopped before the queue is closed.
+func (pqw *BlockingPriorityQueue[T]) Channel() <-chan *Item[T] {
+	out := make(chan *Item[T]) // Unbuffered, so no popped item waits outside the queue
+	go func() {
+		defer close(out)
+		for {
+			item, err := pqw.Pop() // Pop the highest-priority item, blocking while the queue is empty
+			if err != nil {        // Closed and drained
+				log.Debug().Msg("BlockingPriorityQueue channel closed")
+				return
+			}
+			out <- item
+		}
+	}()
+	return out
+}
+
 // https://github.com/golang-design/chann/blob/main/chann.go
 
 // ChannelizedPriorityQueue wraps a BlockingPriorityQueue and provides in and out channels
 // for interacting with the queue using a producer-consumer model.
 type ChannelizedPriorityQueue[T any] struct {
 	in  chan *Item[T]             // Buffered channel for incoming items
-	out chan *Item[T]             // Unbuffered channel for outgoing items
+	out chan *Item[T]             // Channel for outgoing items, unbuffered by default
 	bpq *BlockingPriorityQueue[T] // Internal thread-safe priority queue
+
+	maxRetries int            // Number of requeues allowed per item, only used by a retrying queue
+	deadLetter func(*Item[T]) // Receives the items requeued more than maxRetries times
+
+	closeIn   sync.Once     // Closes the in channel once, from Close or DrainContext
+	queueDone chan struct{} // Closed once every item sent to the in channel is in the internal queue
+	outClosed chan struct{} // Closed along with the out channel
+	popped    atomic.Int64  // Items taken from the internal queue
+	sent      atomic.Int64  // Items handed to the out channel
 }
 
 // NewChannelizedPriorityQueue initializes a new ChannelizedPriorityQueue.
 func NewChannelizedPriorityQueue[T any]() *ChannelizedPriorityQueue[T] {
+	return NewChannelizedPriorityQueueWithBuffers[T](16, 0) // Buffered in channel with size 16, unbuffered out channel
+}
+
+// NewChannelizedPriorityQueueWithBuffers initializes a ChannelizedPriorityQueue whose in and out channels buffer
+// inBuf and outBuf items, to cut the goroutine hand-offs of high-throughput runs. Items in the out buffer already
+// left the internal queue, so they are handed out in the order they were buffered: a larger outBuf weakens the
+// priority order, and those items can't be paused nor reprioritized.
+func NewChannelizedPriorityQueueWithBuffers[T any](inBuf, outBuf int) *ChannelizedPriorityQueue[T] {
 	cpq := &ChannelizedPriorityQueue[T]{
-		in:  make(chan *Item[T], 16), // Buffered channel with size 16
-		out: make(chan *Item[T]),     // Unbuffered channel
+		in:  make(chan *Item[T], inBuf),
+		out: make(chan *Item[T], outBuf),
 		bpq: NewBlockingPriorityQueue[T](),
 	}
+	cpq.start()
+	return cpq
+}
+
+// NewRetryingChannelizedPriorityQueue initializes a ChannelizedPriorityQueue whose consumers can Requeue failed items.
+// Every item received from the out channel must be settled with either Done or Requeue, and the out channel
+// is only closed once every item is settled. An item requeued more than maxRetries times goes to deadLetter instead.
+func NewRetryingChannelizedPriorityQueue[T any](maxRetries int, deadLetter func(*Item[T])) *ChannelizedPriorityQueue[T] {
+	cpq := &ChannelizedPriorityQueue[T]{
+		in:         make(chan *Item[T], 16), // Buffered channel with size 16
+		out:        make(chan *Item[T]),     // Unbuffered channel
+		bpq:        NewBlockingPriorityQueue[T](),
+		maxRetries: maxRetries,
+		deadLetter: deadLetter,
+	}
+	cpq.bpq.TrackInFlight()
+	cpq.start()
+	return cpq
+}
+
+// start launches the goroutines moving items between the channels and the internal queue.
+func (cpq *ChannelizedPriorityQueue[T]) start() {
+	cpq.queueDone = make(chan struct{})
+	cpq.outClosed = make(chan struct{})
 
 	// Start a goroutine to transfer items from the in channel to the internal queue
 	go cpq.transferToQueue()
 
 	// Start a goroutine to transfer items from the internal queue to the out channel
 	go cpq.transferToOut()
-
-	return cpq
 }
 
 // transferToQueue continuously reads from the in channel and pushes items to the internal queue.
 func (cpq *ChannelizedPriorityQueue[T]) transferToQueue() {
+	defer close(cpq.queueDone)
 	for item := range cpq.in {
 		cpq.bpq.Push(item) // Push the item to the internal priority queue
 	}
@@ -172,10 +681,13 @@ func (cpq *ChannelizedPriorityQueue[T]) transferToOut() {
 		item, err := cpq.bpq.Pop() // Pop the highest-priority item from the internal queue
 		if err != nil {            // Closed
 			close(cpq.out)
+			close(cpq.outClosed)
 			log.Debug().Msg("ChannelizedPriorityQueue out channel closed")
 			return
 		}
+		cpq.popped.Add(1)
 		cpq.out <- item // Send the item to the out channel
+		cpq.sent.Add(1)
 	}
 }
 
@@ -189,12 +701,124 @@ func (cpq *ChannelizedPriorityQueue[T]) Out() <-chan *Item[T] {
 	return cpq.out
 }
 
+// Len returns the number of items waiting in the internal queue, e.g. for a queue depth gauge.
+// It is a point-in-time value, items keep moving in and out while it returns.
+func (cpq *ChannelizedPriorityQueue[T]) Len() int {
+	return cpq.bpq.Len()
+}
+
+// Pending is Len also counting the items sent to the in channel but not in the internal queue yet, and those waiting
+// in the buffer of the out channel. Like Len it is approximate under concurrency, an item being moved between a
+// channel and the queue may be missed or counted twice. Both keep working after Close.
+func (cpq *ChannelizedPriorityQueue[T]) Pending() int {
+	return len(cpq.in) + cpq.bpq.Len() + len(cpq.out)
+}
+
+// Pause stops dispatching new items to the out channel until Resume is called.
+// An item that was already taken from the internal queue is still delivered, so at most one item slips through.
+func (cpq *ChannelizedPriorityQueue[T]) Pause() {
+	cpq.bpq.Pause()
+}
+
+// Resume continues dispatching items to the out channel in priority order.
+func (cpq *ChannelizedPriorityQueue[T]) Resume() {
+	cpq.bpq.Resume()
+}
+
+// Done settles an item received from the out channel of a retrying queue once it was processed.
+func (cpq *ChannelizedPriorityQueue[T]) Done(item *Item[T]) {
+	cpq.bpq.Done(item)
+}
+
+// Requeue puts an item received from the out channel back into the internal queue with a new priority,
+// usually lowered so other items get a chance first. Past maxRetries requeues the item is handed to the dead-letter callback instead.
+// Without a dead-letter callback such an item is dropped, settled like with Done, and an error is returned.
+func (cpq *ChannelizedPriorityQueue[T]) Requeue(item *Item[T], newPriority int) error {
+	item.Retries++
+	if cpq.bpq.trackInFlight && item.Retries > cpq.maxRetries {
+		if cpq.deadLetter == nil {
+			cpq.bpq.Done(item)
+			return fmt.Errorf("item dropped after %d retries", cpq.maxRetries)
+		}
+		cpq.deadLetter(item)
+		cpq.bpq.Done(item) // Settled only after the callback, so the out channel outlives it
+		return nil
+	}
+	return cpq.bpq.Requeue(item, newPriority)
+}
+
+// Reprioritize changes the priority of an item waiting in the internal queue, e.g. to bump a download the user asked
+// for to the front, and reports whether it did. It is best effort: an item still in the in channel, or taken off the
+// internal queue and waiting to be received from the out channel, can't be reprioritized and false is returned, so
+// an item sent to the in channel right before may be missed.
+func (cpq *ChannelizedPriorityQueue[T]) Reprioritize(item *Item[T], priority int) bool {
+	return cpq.bpq.Reprioritize(item, priority)
+}
+
 // Close closes the in channel immediately and delays the closing of the out channel
 // until all remaining items have been processed.
+// Closing it again, or after DrainContext, has no effect.
 func (cpq *ChannelizedPriorityQueue[T]) Close() {
-	// Close the in channel to stop accepting new items, transferToQueue will call bpq.Close()
-	close(cpq.in)
-	log.Debug().Msg("ChannelizedPriorityQueue in channel closed")
+	cpq.closeIn.Do(func() {
+		// Close the in channel to stop accepting new items, transferToQueue will call bpq.Close()
+		close(cpq.in)
+		log.Debug().Msg("ChannelizedPriorityQueue in channel closed")
+	})
 
 	// The out channel will be closed in transferToOut
 }
+
+// DrainError is returned by DrainContext when its context is done before the queue is drained.
+type DrainError struct {
+	Remaining int   // Items not received from the out channel yet, plus on a retrying queue those not settled yet
+	Err       error // Error of the context
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("drain stopped with %d items remaining: %v", e.Remaining, e.Err)
+}
+
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+// DrainContext stops accepting new items like Close, then waits until the consumers of the out channel received
+// every item left, and on a retrying queue settled them with Done or Requeue, which includes the retries.
+// When ctx is done first, it returns a *DrainError with the number of items remaining, wrapping the context error;
+// like Pending that number is a point-in-time value, and without retries an item being handed out right then may be
+// counted as remaining. The items stay queued and the consumers keep receiving them, so a later DrainContext call resumes the wait.
+func (cpq *ChannelizedPriorityQueue[T]) DrainContext(ctx context.Context) error {
+	cpq.Close()
+	select {
+	case <-cpq.outClosed:
+	case <-ctx.Done():
+		return cpq.drainError(ctx)
+	}
+	// The out channel is closed once its buffer holds the last items, which are received after that
+	for len(cpq.out) > 0 {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			return cpq.drainError(ctx)
+		}
+	}
+	return nil
+}
+
+// drainError counts the items remaining when the context of DrainContext is done, nil when there are none left.
+func (cpq *ChannelizedPriorityQueue[T]) drainError(ctx context.Context) error {
+	// Every item sent to the closed in channel reaches the internal queue promptly, as pushing never blocks
+	<-cpq.queueDone
+	var remaining int
+	if cpq.bpq.trackInFlight {
+		// Received items stay in flight until settled, a requeued one being back in the internal queue
+		remaining = cpq.bpq.unsettled()
+	} else {
+		// An item taken from the internal queue is remaining until handed to the out channel, and in its buffer
+		remaining = cpq.bpq.Len() + int(cpq.popped.Load()-cpq.sent.Load()) + len(cpq.out)
+	}
+	if remaining == 0 {
+		return nil
+	}
+	return &DrainError{Remaining: remaining, Err: ctx.Err()}
+}
@@ -2,7 +2,16 @@ package main
 
 import (
 	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
 )
 
 // TestUnboundedPriorityQueue tests the priority queue with various input orders.
@@ -52,7 +61,7 @@ func TestUnboundedPriorityQueue(t *testing.T) {
 		{Value: "Item1", Priority: 1},
 	}
 
-	pq1 := make(UnboundedPriorityQueue[string], 0)
+	pq1 := UnboundedPriorityQueue[string]{}
 	pushItems(&pq1, items1)
 	validateOrder(&pq1, expectedOrder1)
 
@@ -72,7 +81,7 @@ func TestUnboundedPriorityQueue(t *testing.T) {
 
 	expectedOrder2 := expectedOrder1 // Same as Test Case 1 since pop order depends on priority
 
-	pq2 := make(UnboundedPriorityQueue[string], 0)
+	pq2 := UnboundedPriorityQueue[string]{}
 	pushItems(&pq2, items2)
 	validateOrder(&pq2, expectedOrder2)
 
@@ -92,7 +101,1164 @@ func TestUnboundedPriorityQueue(t *testing.T) {
 
 	expectedOrder3 := expectedOrder1 // Same as Test Case 1 since pop order depends on priority
 
-	pq3 := make(UnboundedPriorityQueue[string], 0)
+	pq3 := UnboundedPriorityQueue[string]{}
 	pushItems(&pq3, items3)
 	validateOrder(&pq3, expectedOrder3)
 }
+
+func TestPriorityQueueFIFO(t *testing.T) {
+	// Many items of the same priority, interleaved with a higher one, come out in push order within each priority.
+	const count = 1000
+	bpq := NewBlockingPriorityQueue[int]()
+	for i := range count {
+		bpq.Push(&Item[int]{Value: i, Priority: i % 2})
+	}
+	var odd, even []int
+	for range count {
+		item, err := bpq.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Priority == 1 {
+			if len(even) > 0 {
+				t.Fatalf("Expected every priority 1 item before priority 0 ones, got %d after %d", item.Value, even[len(even)-1])
+			}
+			odd = append(odd, item.Value)
+		} else {
+			even = append(even, item.Value)
+		}
+	}
+	for i, values := range [][]int{even, odd} {
+		if !slices.IsSorted(values) || len(values) != count/2 {
+			t.Errorf("Expected the %d items of priority %d in push order, got %v", count/2, i, values)
+		}
+	}
+
+	// A requeued item goes after the items of its new priority already queued.
+	bpq.Push(&Item[int]{Value: 1})
+	bpq.Push(&Item[int]{Value: 2})
+	first, _ := bpq.Pop()
+	bpq.Push(&Item[int]{Value: 3})
+	bpq.Requeue(first, 0)
+	for _, expected := range []int{2, 3, 1} {
+		if item, _ := bpq.Pop(); item.Value != expected {
+			t.Errorf("Expected %d, got %d", expected, item.Value)
+		}
+	}
+
+	// Each queue numbers its own items, pushing the same items to another queue in another order leaves its order alone.
+	a, b := &Item[int]{Value: 1}, &Item[int]{Value: 2}
+	var pqA, pqB UnboundedPriorityQueue[int]
+	heap.Push(&pqA, a)
+	heap.Push(&pqA, b)
+	heap.Push(&pqB, b)
+	heap.Push(&pqB, a)
+	for _, tc := range []struct {
+		pq       *UnboundedPriorityQueue[int]
+		expected []int
+	}{{&pqA, []int{1, 2}}, {&pqB, []int{2, 1}}} {
+		var got []int
+		for tc.pq.Len() > 0 {
+			got = append(got, heap.Pop(tc.pq).(*Item[int]).Value)
+		}
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("Expected %v, got %v", tc.expected, got)
+		}
+	}
+}
+
+func TestChannelizedPriorityQueuePauseResume(t *testing.T) {
+	cpq := NewChannelizedPriorityQueue[int]()
+	cpq.Pause()
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+	cpq.Close()
+
+	// While paused at most one item, already taken off the internal queue, may be dispatched.
+	slipped := 0
+	timeout := time.After(100 * time.Millisecond)
+waitPaused:
+	for {
+		select {
+		case <-cpq.Out():
+			slipped++
+		case <-timeout:
+			break waitPaused
+		}
+	}
+	if slipped > 1 {
+		t.Fatalf("Expected at most 1 item while paused, got %d", slipped)
+	}
+
+	cpq.Resume()
+	received := slipped
+	for range cpq.Out() {
+		received++
+	}
+	if received != 5 {
+		t.Errorf("Expected 5 items after resume, got %d", received)
+	}
+}
+
+func TestChannelizedPriorityQueueLen(t *testing.T) {
+	cpq := NewChannelizedPriorityQueue[int]()
+	if cpq.Len() != 0 || cpq.Pending() != 0 {
+		t.Fatalf("Expected an empty queue, got Len %d and Pending %d", cpq.Len(), cpq.Pending())
+	}
+	cpq.Pause()
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+	if pending := cpq.Pending(); pending < 4 || pending > 5 {
+		t.Errorf("Expected about 5 pending items, got %d", pending) // One may be between the channel and the queue
+	}
+	for deadline := time.Now().Add(5 * time.Second); cpq.Len() < 5; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out, the items did not reach the internal queue")
+		}
+	}
+	if cpq.Pending() != 5 {
+		t.Errorf("Expected 5 pending items, got %d", cpq.Pending())
+	}
+
+	// Both still work once closed, down to an empty queue.
+	cpq.Close()
+	if cpq.Len() != 5 || cpq.Pending() != 5 {
+		t.Errorf("Expected 5 items after close, got Len %d and Pending %d", cpq.Len(), cpq.Pending())
+	}
+	cpq.Resume()
+	for range cpq.Out() {
+	}
+	if cpq.Len() != 0 || cpq.Pending() != 0 {
+		t.Errorf("Expected an empty queue after draining, got Len %d and Pending %d", cpq.Len(), cpq.Pending())
+	}
+}
+
+func TestChannelizedPriorityQueueWithBuffers(t *testing.T) {
+	cpq := NewChannelizedPriorityQueueWithBuffers[int](4, 8)
+	if cap(cpq.in) != 4 || cap(cpq.out) != 8 {
+		t.Fatalf("Expected buffers of 4 and 8, got %d and %d", cap(cpq.in), cap(cpq.out))
+	}
+	go func() {
+		for i := range 50 {
+			cpq.In() <- &Item[int]{Value: i, Priority: i}
+		}
+		cpq.Close()
+	}()
+
+	// The out channel is still closed once the items are received, the buffered ones included.
+	received := make(map[int]bool)
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case item, ok := <-cpq.Out():
+			if !ok {
+				done = true
+				break
+			}
+			received[item.Value] = true
+		case <-timeout:
+			t.Fatalf("Timed out, received %d items and the out channel was not closed", len(received))
+		}
+	}
+	if len(received) != 50 {
+		t.Errorf("Expected 50 items, got %d", len(received))
+	}
+
+	// Items waiting in the out buffer are remaining when a drain is cancelled, even once the out channel is closed.
+	cpq = NewChannelizedPriorityQueueWithBuffers[int](16, 8)
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+	for deadline := time.Now().Add(5 * time.Second); cpq.sent.Load() < 5; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out, the items did not reach the out buffer")
+		}
+	}
+	if cpq.Pending() != 5 {
+		t.Errorf("Expected the 5 buffered items to be pending, got %d", cpq.Pending())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var drainErr *DrainError
+	if err := cpq.DrainContext(ctx); !errors.As(err, &drainErr) || drainErr.Remaining != 5 {
+		t.Errorf("Expected a drain error with 5 remaining, got %v", err)
+	}
+	<-cpq.Out()
+	<-cpq.Out()
+	<-cpq.outClosed
+	if err := cpq.DrainContext(ctx); !errors.As(err, &drainErr) || drainErr.Remaining != 3 {
+		t.Errorf("Expected a drain error with 3 remaining, got %v", err)
+	}
+	for range cpq.Out() {
+	}
+	if err := cpq.DrainContext(ctx); err != nil {
+		t.Errorf("Expected nothing remaining once every item was received, got %v", err)
+	}
+}
+
+func TestChannelizedPriorityQueueDrainContext(t *testing.T) {
+	cpq := NewChannelizedPriorityQueue[int]()
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+
+	var drained []int
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for item := range cpq.Out() {
+			drained = append(drained, item.Value)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cpq.DrainContext(ctx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	<-consumed
+	if !slices.Equal(drained, []int{4, 3, 2, 1, 0}) {
+		t.Errorf("Expected every item in priority order, got %v", drained)
+	}
+	cpq.Close() // Already closed by the drain
+}
+
+func TestChannelizedPriorityQueueDrainContextCancelled(t *testing.T) {
+	cpq := NewChannelizedPriorityQueue[int]()
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+
+	// The consumer is stuck on the first item, so the drain times out with the others remaining.
+	release := make(chan struct{})
+	consumed := make(chan struct{})
+	var drained []int
+	go func() {
+		defer close(consumed)
+		for item := range cpq.Out() {
+			drained = append(drained, item.Value)
+			if len(drained) == 1 {
+				<-release
+			}
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); cpq.sent.Load() < 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out, the first item was not received")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var drainErr *DrainError
+	err := cpq.DrainContext(ctx)
+	if !errors.As(err, &drainErr) || drainErr.Remaining != 4 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a drain error with 4 remaining wrapping %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// A later drain resumes the wait for the items left.
+	close(release)
+	resumeCtx, resumeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer resumeCancel()
+	if err := cpq.DrainContext(resumeCtx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	<-consumed
+	if !slices.Equal(drained, []int{4, 3, 2, 1, 0}) {
+		t.Errorf("Expected every item once, got %v", drained)
+	}
+}
+
+func TestChannelizedPriorityQueueDrainContextRetrying(t *testing.T) {
+	cpq := NewRetryingChannelizedPriorityQueue[string](3, nil)
+	cpq.Pause()
+	cpq.In() <- &Item[string]{Value: "retried", Priority: 3}
+	cpq.In() <- &Item[string]{Value: "done", Priority: 2}
+	cpq.In() <- &Item[string]{Value: "stuck", Priority: 1}
+	for deadline := time.Now().Add(5 * time.Second); cpq.bpq.Len() < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out, the items did not reach the internal queue")
+		}
+	}
+	cpq.Resume()
+
+	// The first item is requeued behind the others, then the consumer is stuck on the last one.
+	stuck := make(chan struct{})
+	release := make(chan struct{})
+	consumed := make(chan struct{})
+	var drained []string
+	go func() {
+		defer close(consumed)
+		for item := range cpq.Out() {
+			drained = append(drained, item.Value)
+			switch {
+			case item.Value == "retried" && item.Retries == 0:
+				if err := cpq.Requeue(item, 0); err != nil {
+					t.Errorf("Expected the item to be requeued, got %v", err)
+				}
+				continue
+			case item.Value == "stuck":
+				close(stuck)
+				<-release
+			}
+			cpq.Done(item)
+		}
+	}()
+
+	// Cancelled mid-drain, the requeued item and the stuck one remain: requeues keep items from being lost in the count.
+	ctx, cancel := context.WithCancel(context.Background())
+	drainDone := make(chan error, 1)
+	go func() { drainDone <- cpq.DrainContext(ctx) }()
+	<-stuck
+	cancel()
+	var drainErr *DrainError
+	if err := <-drainDone; !errors.As(err, &drainErr) || drainErr.Remaining != 2 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a drain error with 2 remaining wrapping %v, got %v", context.Canceled, err)
+	}
+
+	close(release)
+	resumeCtx, resumeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer resumeCancel()
+	if err := cpq.DrainContext(resumeCtx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	<-consumed
+	if !slices.Equal(drained, []string{"retried", "done", "stuck", "retried"}) {
+		t.Errorf("Expected the requeued item last, got %v", drained)
+	}
+}
+
+func TestChannelizedPriorityQueueReprioritize(t *testing.T) {
+	cpq := NewChannelizedPriorityQueue[string]()
+	cpq.Pause()
+	low, mid, high := &Item[string]{Value: "low", Priority: 1}, &Item[string]{Value: "mid", Priority: 2}, &Item[string]{Value: "high", Priority: 3}
+	for _, item := range []*Item[string]{low, mid, high} {
+		cpq.In() <- item
+	}
+	cpq.Close()
+	for deadline := time.Now().Add(5 * time.Second); cpq.bpq.Len() < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out, the items did not reach the internal queue")
+		}
+	}
+
+	// Still in the heap, the low item is bumped to the front.
+	if !cpq.Reprioritize(low, 10) {
+		t.Error("Expected to reprioritize an item in the heap")
+	}
+	if cpq.Reprioritize(&Item[string]{Value: "never pushed"}, 10) {
+		t.Error("Expected not to reprioritize an item that was never pushed")
+	}
+	cpq.Resume()
+	if item := <-cpq.Out(); item != low || item.Priority != 10 {
+		t.Errorf("Expected the bumped low item first, got %s with priority %d", item.Value, item.Priority)
+	}
+
+	// Already popped, the high item keeps its priority.
+	if item := <-cpq.Out(); item != high {
+		t.Errorf("Expected the high item, got %s", item.Value)
+	}
+	if cpq.Reprioritize(high, 20) || high.Priority != 3 {
+		t.Errorf("Expected not to reprioritize a popped item, got priority %d", high.Priority)
+	}
+	for range cpq.Out() {
+	}
+}
+
+func TestBlockingPriorityQueuePopBytes(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[int64]()
+	// Value is the size in bytes, priorities make the pop order 100, 30, 50, 10, 500, 20.
+	sizes := []int64{100, 30, 50, 10, 500, 20}
+	for i, size := range sizes {
+		bpq.Push(&Item[int64]{Value: size, Priority: len(sizes) - i})
+	}
+	sizeOf := func(item *Item[int64]) int64 { return item.Value }
+
+	expectedBatches := [][]int64{
+		{100},        // 100 + 30 exceeds 120
+		{30, 50, 10}, // 90, adding 500 exceeds 120
+		{500},        // Larger than the budget alone, still returned
+		{20},         // Remaining item
+	}
+	for _, expected := range expectedBatches {
+		batch, err := bpq.PopBytes(120, sizeOf)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var got []int64
+		for _, item := range batch {
+			got = append(got, item.Value)
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("Expected batch %v, got %v", expected, got)
+		}
+	}
+
+	bpq.Close()
+	if _, err := bpq.PopBytes(120, sizeOf); err == nil {
+		t.Errorf("Expected an error from a closed and empty queue")
+	}
+}
+
+func TestBlockingPriorityQueueConcurrentPop(t *testing.T) {
+	const poppers = 32
+	const batches = 100
+	const batchSize = 50
+
+	bpq := NewBlockingPriorityQueue[int]()
+	received := make(chan int, batches*batchSize)
+	var wg sync.WaitGroup
+	for range poppers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, err := bpq.Pop()
+				if err != nil { // Closed and drained
+					return
+				}
+				received <- item.Value
+			}
+		}()
+	}
+
+	// Mix batch and single pushes so both Broadcast and Signal paths wake the poppers.
+	for b := range batches {
+		items := make([]*Item[int], batchSize)
+		for i := range items {
+			items[i] = &Item[int]{Value: b*batchSize + i, Priority: i}
+		}
+		if b%2 == 0 {
+			if err := bpq.PushBatch(items...); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		} else {
+			for _, item := range items {
+				if err := bpq.Push(item); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		}
+	}
+	bpq.Close()
+
+	// Every popper must return once the queue is closed and drained.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the poppers to exit")
+	}
+	close(received)
+
+	seen := make(map[int]int)
+	for value := range received {
+		seen[value]++
+	}
+	if len(seen) != batches*batchSize {
+		t.Errorf("Expected %d distinct items, got %d", batches*batchSize, len(seen))
+	}
+	for value, count := range seen {
+		if count != 1 {
+			t.Errorf("Item %d delivered %d times", value, count)
+		}
+	}
+	if err := bpq.PushBatch(&Item[int]{}); err == nil {
+		t.Error("Expected an error pushing to a closed queue")
+	}
+}
+
+func TestBlockingPriorityQueueTopK(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[int]()
+	// Priorities in a scrambled order, with duplicates.
+	for i := range 1000 {
+		priority := (i * 7919) % 500
+		bpq.Push(&Item[int]{Value: i, Priority: priority})
+	}
+
+	top := bpq.TopK(25)
+	if len(top) != 25 {
+		t.Fatalf("Expected 25 items, got %d", len(top))
+	}
+	if bpq.Len() != 1000 {
+		t.Fatalf("TopK modified the queue, length is %d", bpq.Len())
+	}
+
+	// The snapshot must match the priorities Pop hands out, in order.
+	for i, item := range top {
+		popped, err := bpq.Pop()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if item.Priority != popped.Priority {
+			t.Errorf("Item %d: expected priority %d, got %d", i, popped.Priority, item.Priority)
+		}
+	}
+
+	if got := bpq.TopK(5000); len(got) != bpq.Len() {
+		t.Errorf("Expected k to be capped at the queue length %d, got %d", bpq.Len(), len(got))
+	}
+	if got := bpq.TopK(0); len(got) != 0 {
+		t.Errorf("Expected no items for k=0, got %d", len(got))
+	}
+}
+
+func TestBlockingPriorityQueueSnapshot(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[int]()
+	if snapshot := bpq.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("Expected an empty snapshot, got %d items", len(snapshot))
+	}
+	for i := range 50 {
+		bpq.Push(&Item[int]{Value: i, Priority: (i * 7) % 13})
+	}
+
+	// Sorting the copy must not disturb the heap.
+	snapshot := bpq.Snapshot()
+	if len(snapshot) != 50 || bpq.Len() != 50 {
+		t.Fatalf("Expected 50 items in the snapshot and the queue, got %d and %d", len(snapshot), bpq.Len())
+	}
+	slices.SortFunc(snapshot, func(a, b *Item[int]) int { return a.Priority - b.Priority })
+	prev := 13
+	for range 50 {
+		item, err := bpq.Pop()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if item.Priority > prev {
+			t.Fatalf("Popped priority %d after %d, the heap was corrupted", item.Priority, prev)
+		}
+		prev = item.Priority
+	}
+	if len(snapshot) != 50 {
+		t.Errorf("Expected popping to leave the snapshot alone, got %d items", len(snapshot))
+	}
+}
+
+func TestBlockingPriorityQueuePeek(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[string]()
+	if item, ok := bpq.Peek(); ok || item != nil {
+		t.Errorf("Expected nothing to peek at in an empty queue, got %v", item)
+	}
+
+	bpq.Push(&Item[string]{Value: "low", Priority: 1})
+	bpq.Push(&Item[string]{Value: "high", Priority: 3})
+	bpq.Push(&Item[string]{Value: "mid", Priority: 2})
+	for _, expected := range []string{"high", "mid"} {
+		item, ok := bpq.Peek()
+		if !ok || item.Value != expected {
+			t.Fatalf("Expected to peek at %s, got %v", expected, item)
+		}
+		if popped, _ := bpq.Pop(); popped != item {
+			t.Errorf("Expected Pop to return the peeked %s, got %s", item.Value, popped.Value)
+		}
+	}
+
+	// A closed queue still shows the item left, then nothing once it was popped.
+	bpq.Close()
+	if item, ok := bpq.Peek(); !ok || item.Value != "low" || bpq.Len() != 1 {
+		t.Errorf("Expected to peek at low in the closed queue, got %v", item)
+	}
+	bpq.Pop()
+	if _, ok := bpq.Peek(); ok {
+		t.Error("Expected nothing to peek at once the closed queue is empty")
+	}
+}
+
+func TestBoundedPriorityQueue(t *testing.T) {
+	bpq := NewBoundedPriorityQueue[int](2)
+	bpq.Push(&Item[int]{Value: 1, Priority: 1})
+	bpq.Push(&Item[int]{Value: 2, Priority: 2})
+
+	// A full queue holds back Push until Pop makes room.
+	pushed := make(chan error)
+	go func() {
+		pushed <- bpq.Push(&Item[int]{Value: 3, Priority: 3})
+	}()
+	select {
+	case err := <-pushed:
+		t.Fatalf("Expected Push to wait for room in the full queue, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if item, _ := bpq.Pop(); item.Value != 2 {
+		t.Errorf("Expected to pop 2, got %d", item.Value)
+	}
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Errorf("Expected Push to succeed once there was room, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Push to get room")
+	}
+	if n := bpq.Len(); n != 2 {
+		t.Errorf("Expected the queue to stay at its capacity of 2, got %d", n)
+	}
+
+	// A batch is added as room is made, by consumers woken for each item.
+	batchPushed := make(chan error)
+	go func() {
+		batchPushed <- bpq.PushBatch(&Item[int]{Value: 4, Priority: 4}, &Item[int]{Value: 5, Priority: 5})
+	}()
+	for range 2 {
+		bpq.Pop()
+	}
+	select {
+	case err := <-batchPushed:
+		if err != nil {
+			t.Errorf("Expected PushBatch to succeed once there was room, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for PushBatch to get room")
+	}
+
+	// Closing the queue wakes up the waiting producers with an error.
+	go func() {
+		pushed <- bpq.Push(&Item[int]{Value: 6, Priority: 6})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	bpq.Close()
+	select {
+	case err := <-pushed:
+		if err == nil {
+			t.Error("Expected Push to fail once the queue was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Close to wake up Push")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a capacity of 0 to panic")
+		}
+	}()
+	NewBoundedPriorityQueue[int](0)
+}
+
+func TestBlockingPriorityQueueDrain(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[string]()
+	if items := bpq.Drain(); len(items) != 0 {
+		t.Errorf("Expected nothing to drain from an empty queue, got %v", items)
+	}
+
+	for i, value := range []string{"low", "high", "mid", "mid2"} {
+		bpq.Push(&Item[string]{Value: value, Priority: []int{1, 3, 2, 2}[i]})
+	}
+	var values []string
+	for _, item := range bpq.Drain() {
+		values = append(values, item.Value)
+	}
+	if expected := []string{"high", "mid", "mid2", "low"}; !slices.Equal(values, expected) {
+		t.Errorf("Expected %v in priority order, got %v", expected, values)
+	}
+	if n := bpq.Len(); n != 0 {
+		t.Errorf("Expected the drained queue to be empty, got %d items", n)
+	}
+
+	// The queue is still open: a waiting Pop gets the next pushed item.
+	popped := make(chan *Item[string])
+	go func() {
+		item, _ := bpq.Pop()
+		popped <- item
+	}()
+	if err := bpq.Push(&Item[string]{Value: "after"}); err != nil {
+		t.Fatalf("Expected Push to work after Drain, got %v", err)
+	}
+	select {
+	case item := <-popped:
+		if item.Value != "after" {
+			t.Errorf("Expected to pop the item pushed after Drain, got %s", item.Value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Pop after Drain")
+	}
+}
+
+func TestBlockingPriorityQueueTryPop(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[int]()
+	if item, ok := bpq.TryPop(); ok || item != nil {
+		t.Errorf("Expected nothing to pop from an empty queue, got %v", item)
+	}
+
+	// Producers and consumers polling with TryPop must neither deadlock nor lose or duplicate items.
+	const producers, consumers, perProducer = 4, 4, 1000
+	var produced sync.WaitGroup
+	for p := range producers {
+		produced.Add(1)
+		go func() {
+			defer produced.Done()
+			for i := range perProducer {
+				bpq.Push(&Item[int]{Value: p*perProducer + i, Priority: i})
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	popped := make(chan []int, consumers)
+	for range consumers {
+		go func() {
+			var values []int
+			for {
+				if item, ok := bpq.TryPop(); ok {
+					values = append(values, item.Value)
+					continue
+				}
+				select {
+				case <-stop:
+					popped <- values
+					return
+				default:
+				}
+			}
+		}()
+	}
+
+	var all []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		produced.Wait()
+		for bpq.Len() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(stop)
+		for range consumers {
+			all = append(all, <-popped...)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("TryPop deadlocked")
+	}
+	slices.Sort(all)
+	if len(all) != producers*perProducer || all[0] != 0 || all[len(all)-1] != producers*perProducer-1 ||
+		len(slices.Compact(all)) != producers*perProducer {
+		t.Errorf("Expected every pushed item to be popped exactly once, got %d items", len(all))
+	}
+
+	// A closed queue refuses to hand out its remaining items without waiting.
+	bpq.Push(&Item[int]{Value: 1})
+	bpq.Close()
+	if item, ok := bpq.TryPop(); ok || item != nil {
+		t.Errorf("Expected nothing to pop from a closed queue, got %v", item)
+	}
+}
+
+func TestBlockingPriorityQueueOnLengthChange(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[int]()
+	lengths := make(chan int)
+	bpq.OnLengthChange(func(newLen int) {
+		// The callback must run without the lock held, so it can call back into the queue.
+		if !bpq.mu.TryLock() {
+			t.Error("Expected the callback not to be called while holding the mutex")
+		} else {
+			bpq.mu.Unlock()
+		}
+		if n := bpq.Len(); n != newLen {
+			t.Errorf("Expected Len to match the reported length %d, got %d", newLen, n)
+		}
+		lengths <- newLen
+	})
+	expectLength := func(expected int) {
+		t.Helper()
+		select {
+		case n := <-lengths:
+			if n != expected {
+				t.Errorf("Expected the callback to report %d, got %d", expected, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the callback to report %d", expected)
+		}
+	}
+
+	// Each change is awaited before the next one, so none of them is debounced.
+	bpq.Push(&Item[int]{Value: 1, Priority: 1})
+	expectLength(1)
+	bpq.PushBatch(&Item[int]{Value: 2, Priority: 2}, &Item[int]{Value: 3, Priority: 3})
+	expectLength(3)
+	bpq.Pop()
+	expectLength(2)
+	item, _ := bpq.Pop()
+	expectLength(1)
+	bpq.Requeue(item, 5)
+	expectLength(2)
+	bpq.PopBytes(100, func(*Item[int]) int64 { return 1 })
+	expectLength(0)
+
+	// Changes made while the callback is busy are coalesced into a single call with the latest length.
+	bpq.Push(&Item[int]{Value: 4, Priority: 4})
+	for i := range 10 {
+		bpq.Push(&Item[int]{Value: i, Priority: i})
+	}
+	var reported []int
+	timeout := time.After(5 * time.Second)
+	for len(reported) == 0 || reported[len(reported)-1] != 11 {
+		select {
+		case n := <-lengths:
+			reported = append(reported, n)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the callback to report 11, got %v", reported)
+		}
+	}
+	if len(reported) > 2 {
+		t.Errorf("Expected rapid pushes to be debounced into at most 2 calls, got %v", reported)
+	}
+
+	// The notifier reports the drained queue, then stops.
+	bpq.Close()
+	for bpq.Len() > 0 {
+		bpq.Pop()
+	}
+	select {
+	case n := <-lengths:
+		for n != 0 {
+			n = <-lengths
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the callback to report the drained queue")
+	}
+}
+
+func TestBlockingPriorityQueueOnLengthChangeStop(t *testing.T) {
+	// waitGoroutines waits for the number of goroutines to come back to n, as the notifiers stop asynchronously.
+	waitGoroutines := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > n {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d goroutines once the notifiers stopped, got %d", n, runtime.NumGoroutine())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	baseline := runtime.NumGoroutine()
+
+	bpq := NewBlockingPriorityQueue[int]()
+	first := make(chan int, 10)
+	stopFirst := bpq.OnLengthChange(func(newLen int) { first <- newLen })
+	bpq.Push(&Item[int]{Value: 1, Priority: 1})
+	if n := <-first; n != 1 {
+		t.Errorf("Expected the first callback to report 1, got %d", n)
+	}
+
+	// A stopped registration is no longer called, and its notifier is gone although the queue is still open.
+	stopFirst()
+	waitGoroutines(baseline)
+	bpq.Push(&Item[int]{Value: 2, Priority: 2})
+
+	// A later registration gets a notifier of its own.
+	second := make(chan int, 10)
+	stopSecond := bpq.OnLengthChange(func(newLen int) { second <- newLen })
+	bpq.Pop()
+	select {
+	case n := <-second:
+		if n != 1 {
+			t.Errorf("Expected the second callback to report 1, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the second callback")
+	}
+
+	// Replacing the callback stops the notifier of the previous one.
+	third := make(chan int, 10)
+	bpq.OnLengthChange(func(newLen int) { third <- newLen })
+	stopSecond() // No-op, it was replaced already
+	bpq.Pop()
+	select {
+	case n := <-third:
+		if n != 0 {
+			t.Errorf("Expected the third callback to report 0, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the third callback")
+	}
+	if len(first) > 0 || len(second) > 0 {
+		t.Errorf("Expected stopped callbacks not to be called, got %d and %d calls", len(first), len(second))
+	}
+
+	// The last notifier stops once the queue is closed and drained, and registering then starts none.
+	bpq.Close()
+	waitGoroutines(baseline)
+	bpq.OnLengthChange(func(int) { t.Error("Expected no call on a drained queue") })()
+	waitGoroutines(baseline)
+}
+
+func TestChannelizedPriorityQueueRequeue(t *testing.T) {
+	var deadLetters []int
+	cpq := NewRetryingChannelizedPriorityQueue[int](3, func(item *Item[int]) {
+		deadLetters = append(deadLetters, item.Value)
+	})
+	for i := range 5 {
+		cpq.In() <- &Item[int]{Value: i, Priority: 10}
+	}
+	cpq.Close()
+
+	// Every item fails twice then succeeds, requeued with a lowered priority each time.
+	attempts := make(map[int]int)
+	succeeded := make(map[int]int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for item := range cpq.Out() {
+			attempts[item.Value]++
+			if attempts[item.Value] <= 2 {
+				if err := cpq.Requeue(item, item.Priority-1); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				continue
+			}
+			succeeded[item.Value]++
+			if item.Retries != 2 {
+				t.Errorf("Item %d: expected 2 retries, got %d", item.Value, item.Retries)
+			}
+			cpq.Done(item)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out, the out channel was not closed")
+	}
+
+	for i := range 5 {
+		if attempts[i] != 3 || succeeded[i] != 1 {
+			t.Errorf("Item %d: expected 3 attempts and 1 success, got %d and %d", i, attempts[i], succeeded[i])
+		}
+	}
+	if len(deadLetters) != 0 {
+		t.Errorf("Expected no dead letters, got %v", deadLetters)
+	}
+}
+
+func TestChannelizedPriorityQueueDeadLetter(t *testing.T) {
+	var mu sync.Mutex
+	var deadLetters []*Item[int]
+	cpq := NewRetryingChannelizedPriorityQueue[int](2, func(item *Item[int]) {
+		mu.Lock()
+		defer mu.Unlock()
+		deadLetters = append(deadLetters, item)
+	})
+	for i := range 3 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+	cpq.Close()
+
+	// A consumer that always fails must not loop forever.
+	attempts := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for item := range cpq.Out() {
+			attempts++
+			cpq.Requeue(item, item.Priority-1)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out, failing items kept looping")
+	}
+
+	if attempts != 9 {
+		t.Errorf("Expected 9 attempts (1 + 2 retries per item), got %d", attempts)
+	}
+	if len(deadLetters) != 3 {
+		t.Fatalf("Expected 3 dead letters, got %d", len(deadLetters))
+	}
+	for _, item := range deadLetters {
+		if item.Retries != 3 {
+			t.Errorf("Item %d: expected 3 retries, got %d", item.Value, item.Retries)
+		}
+	}
+}
+
+func TestChannelizedPriorityQueueMaxRetriesWithoutDeadLetter(t *testing.T) {
+	cpq := NewRetryingChannelizedPriorityQueue[int](2, nil)
+	for i := range 3 {
+		cpq.In() <- &Item[int]{Value: i, Priority: i}
+	}
+	cpq.Close()
+
+	// Without a dead-letter callback, failing items are dropped after the last retry instead of looping.
+	attempts, dropped := 0, 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for item := range cpq.Out() {
+			attempts++
+			if err := cpq.Requeue(item, item.Priority-1); err != nil {
+				dropped++
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out, failing items kept looping")
+	}
+
+	if attempts != 9 || dropped != 3 {
+		t.Errorf("Expected 9 attempts and 3 dropped items, got %d and %d", attempts, dropped)
+	}
+}
+
+func TestBlockingPriorityQueueChannel(t *testing.T) {
+	bpq := NewBlockingPriorityQueue[string]()
+	if err := bpq.PushBatch(
+		&Item[string]{Value: "low", Priority: 1},
+		&Item[string]{Value: "high", Priority: 3},
+		&Item[string]{Value: "mid", Priority: 2},
+	); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ch := bpq.Channel()
+
+	var got []string
+	for range 3 {
+		got = append(got, (<-ch).Value)
+	}
+	if expected := []string{"high", "mid", "low"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// An empty but open queue keeps the channel open.
+	select {
+	case item, ok := <-ch:
+		t.Fatalf("Unexpected receive from an open queue: %v, %v", item, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Items pushed before Close are still delivered, then the channel is closed.
+	if err := bpq.Push(&Item[string]{Value: "last", Priority: 0}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bpq.Close()
+	got = nil
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case item, ok := <-ch:
+			if !ok {
+				if !slices.Equal(got, []string{"last"}) {
+					t.Errorf("Expected the remaining item to be drained before closing, got %v", got)
+				}
+				return
+			}
+			got = append(got, item.Value)
+		case <-timeout:
+			t.Fatal("Timed out waiting for the channel to close")
+		}
+	}
+}
+
+// quietQueueLogs keeps the debug lines logged when a queue closes out of a benchmark's measurement.
+func quietQueueLogs(b *testing.B) {
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	b.Cleanup(func() { zerolog.SetGlobalLevel(previous) })
+}
+
+// benchmarkItems returns n items with pseudo-random priorities, so the heap does real work.
+func benchmarkItems(n int) []*Item[int] {
+	items := make([]*Item[int], n)
+	for i := range items {
+		items[i] = &Item[int]{Value: i, Priority: (i * 7919) % n}
+	}
+	return items
+}
+
+// BenchmarkUnboundedPriorityQueue measures the bare heap, without locking, as the baseline of the other queues.
+func BenchmarkUnboundedPriorityQueue(b *testing.B) {
+	const itemsPerOp = 1024
+	items := benchmarkItems(itemsPerOp)
+	b.ReportAllocs()
+	for b.Loop() {
+		pq := UnboundedPriorityQueue[int]{items: make([]*Item[int], 0, itemsPerOp), seqs: make([]uint64, 0, itemsPerOp)}
+		for _, item := range items {
+			heap.Push(&pq, item)
+		}
+		for pq.Len() > 0 {
+			heap.Pop(&pq)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*itemsPerOp), "ns/item")
+}
+
+// BenchmarkBlockingPriorityQueue measures Push/Pop throughput with producers and consumers running concurrently,
+// from a single pair up to goroutine counts where they mostly contend for the mutex.
+func BenchmarkBlockingPriorityQueue(b *testing.B) {
+	const itemsPerOp = 4096
+	quietQueueLogs(b)
+	items := benchmarkItems(itemsPerOp)
+
+	for _, n := range []int{1, 4, 16, 256} {
+		b.Run(fmt.Sprintf("producers=%d/consumers=%d", n, n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				bpq := NewBlockingPriorityQueue[int]()
+				var consumers sync.WaitGroup
+				for range n {
+					consumers.Add(1)
+					go func() {
+						defer consumers.Done()
+						for {
+							if _, err := bpq.Pop(); err != nil { // Closed and drained
+								return
+							}
+						}
+					}()
+				}
+				var producers sync.WaitGroup
+				for p := range n {
+					producers.Add(1)
+					go func() {
+						defer producers.Done()
+						for i := p; i < itemsPerOp; i += n {
+							bpq.Push(items[i])
+						}
+					}()
+				}
+				producers.Wait()
+				bpq.Close()
+				consumers.Wait()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*itemsPerOp), "ns/item")
+		})
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			bpq := NewBlockingPriorityQueue[int]()
+			bpq.PushBatch(items...)
+			bpq.Close()
+			for {
+				if _, err := bpq.Pop(); err != nil {
+					break
+				}
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*itemsPerOp), "ns/item")
+	})
+}
+
+// BenchmarkChannelizedPriorityQueueLatency measures the end-to-end latency of a single item sent to the in channel
+// until it is received from the out channel, through both transfer goroutines.
+func BenchmarkChannelizedPriorityQueueLatency(b *testing.B) {
+	quietQueueLogs(b)
+	cpq := NewChannelizedPriorityQueue[int]()
+	b.Cleanup(func() {
+		cpq.Close()
+		for range cpq.Out() {
+		}
+	})
+	item := &Item[int]{Value: 1, Priority: 1}
+	b.ReportAllocs()
+	for b.Loop() {
+		cpq.In() <- item
+		<-cpq.Out()
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
 
+	"example/hello/hyapi"
 	"github.com/rs/zerolog/log"
 )
 
@@ -63,6 +65,11 @@ func (fim *FileInfoMap) UpdateMD5(path string, md5Hash string) {
 //	updateMD5 func(string, string):
 //	  A callback function that is called with the file path and its MD5 hash (as a hexadecimal string).
 func FileWorker(paths <-chan string, updateSize func(string, int64), updateMD5 func(string, string)) {
+	fileWorker(paths, updateSize, updateMD5, &hyapi.PauseGate{})
+}
+
+// fileWorker is FileWorker reading every file through gate, so a paused gate stops the hashing at the next chunk.
+func fileWorker(paths <-chan string, updateSize func(string, int64), updateMD5 func(string, string), gate *hyapi.PauseGate) {
 	for path := range paths { // FileWorker consumes paths from here
 		func() {
 			file, err := os.Open(path)
@@ -81,7 +88,7 @@ func FileWorker(paths <-chan string, updateSize func(string, int64), updateMD5 f
 			updateSize(path, size)
 
 			hash := md5.New()
-			if _, err := io.Copy(hash, file); err != nil {
+			if _, err := io.Copy(hash, gate.Reader(file)); err != nil {
 				log.Warn().Str("path", path).Err(err).Msg("Error calculating MD5")
 				return
 			}
@@ -94,14 +101,21 @@ func FileWorker(paths <-chan string, updateSize func(string, int64), updateMD5 f
 
 // Launch the file walker goroutine
 func FileWalker(root string, paths chan<- string, addFile func(string)) {
+	walkFiles(root, func(path string, _ int64) {
+		addFile(path) // Store initial info
+		paths <- path // FileWalker sends paths to workers
+	})
+}
+
+// walkFiles calls fn with the path and size of every file under root.
+func walkFiles(root string, fn func(path string, size int64)) {
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Panic().Str("path", path).Err(err).Msg("Error accessing path")
 			return nil // Continue walking despite the error
 		}
 		if !info.IsDir() {
-			addFile(path) // Store initial info
-			paths <- path // FileWalker sends paths to workers
+			fn(path, info.Size())
 		}
 		return nil
 	})
@@ -110,43 +124,88 @@ func FileWalker(root string, paths chan<- string, addFile func(string)) {
 	}
 }
 
-func FileTest() {
-	// Log file discovery
-	addFile := func(path string) {
-		log.Info().Str("file", path).Msg("Discovered file")
+// PriorityFunc decides the priority of a file in the pipeline, higher values are processed first.
+type PriorityFunc func(path string, size int64) int
+
+// PriorityByPathLength processes files with longer paths first.
+func PriorityByPathLength(path string, _ int64) int {
+	return len(path)
+}
+
+// PriorityBySmallestSize processes smaller files first, so many files complete early.
+// Sizes past the range of int, 2 GiB on 32-bit platforms, share the lowest priority instead of wrapping around.
+func PriorityBySmallestSize(_ string, size int64) int {
+	return -int(min(size, math.MaxInt))
+}
+
+// FilePipeline walks a directory and feeds its files to a pool of FileWorkers
+// through a ChannelizedPriorityQueue, in the order decided by Priority.
+type FilePipeline struct {
+	Root       string               // Directory to walk
+	NumWorkers int                  // Number of FileWorkers, one when not positive
+	Priority   PriorityFunc         // Priority of each file, PriorityByPathLength when nil
+	AddFile    func(string)         // Called for every discovered file, may be nil
+	UpdateSize func(string, int64)  // Called with the size of every processed file, may be nil
+	UpdateMD5  func(string, string) // Called with the MD5 hash of every processed file, may be nil
+
+	gate hyapi.PauseGate                   // Closed while paused, holding back the workers between chunks
+	mu   sync.Mutex                        // Guards cpq
+	cpq  *ChannelizedPriorityQueue[string] // Queue of the running pipeline, nil when not running
+}
+
+// Pause stops dispatching files to the workers and holds back the files being hashed at their next chunk,
+// until Resume is called. It may be called before or while the pipeline runs.
+func (p *FilePipeline) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gate.Pause()
+	if p.cpq != nil {
+		p.cpq.Pause()
 	}
+}
 
-	// Log size updates
-	updateSize := func(path string, size int64) {
-		log.Info().Str("file", path).Int64("size", size).Msg("Updated file size")
+// Resume continues hashing the files where they were paused, and dispatching the remaining ones.
+func (p *FilePipeline) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gate.Resume()
+	if p.cpq != nil {
+		p.cpq.Resume()
 	}
+}
 
-	// Log MD5 updates
-	updateMD5 := func(path string, md5 string) {
-		log.Info().Str("file", path).Str("md5", md5).Msg("Updated file MD5")
+// walk sends an Item for every file under the pipeline root, prioritized by the pipeline's PriorityFunc.
+func (p *FilePipeline) walk(items chan<- *Item[string]) {
+	priority := p.Priority
+	if priority == nil {
+		priority = PriorityByPathLength
 	}
+	walkFiles(p.Root, func(path string, size int64) {
+		if p.AddFile != nil {
+			p.AddFile(path) // Store initial info
+		}
+		items <- &Item[string]{Value: path, Priority: priority(path, size)}
+	})
+}
 
+// Run walks the root directory and processes every file, returning once all workers are done.
+func (p *FilePipeline) Run() {
 	// Using ChannelizedPriorityQueue as a middleman between two channels here to illustrate
 	// how ChannelizedPriorityQueue will be sent items and and receive items from
 
-	pathsFromProducer := make(chan string)
-	// Start FileWalker
-	go func() {
-		FileWalker(".", pathsFromProducer, addFile)
-		close(pathsFromProducer) // Close the channel after FileWalker is done
-		log.Debug().Msg("pathsFromProducer closed")
-	}()
-
 	// Create a ChannelizedPriorityQueue to act as a middleman
 	cpq := NewChannelizedPriorityQueue[string]()
+	p.mu.Lock()
+	p.cpq = cpq
+	if p.gate.Paused() {
+		cpq.Pause()
+	}
+	p.mu.Unlock()
 
-	// Goroutine to transfer paths from the FileWalker to the ChannelizedPriorityQueue
+	// Start the walker, sending prioritized items straight to the ChannelizedPriorityQueue
 	go func() {
-		for path := range pathsFromProducer {
-			item := &Item[string]{Value: path, Priority: len(path)} // Demo priority
-			cpq.In() <- item
-		}
-		cpq.Close() // when pathsFromProducer is closed, close ChannelizedPriorityQueue also
+		p.walk(cpq.In())
+		cpq.Close() // when the walker is done, close ChannelizedPriorityQueue also
 		log.Debug().Msg("ChannelizedPriorityQueue closed")
 	}()
 
@@ -161,15 +220,54 @@ func FileTest() {
 
 	}()
 
-	numWorkers := 4 // Number of workers
+	// Callbacks left nil are no-ops
+	updateSize, updateMD5 := p.UpdateSize, p.UpdateMD5
+	if updateSize == nil {
+		updateSize = func(string, int64) {}
+	}
+	if updateMD5 == nil {
+		updateMD5 = func(string, string) {}
+	}
+
 	var wg sync.WaitGroup
-	// Launch worker goroutines
-	for range numWorkers {
+	// Launch worker goroutines, at least one so the queue is always drained
+	for range max(p.NumWorkers, 1) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			FileWorker(pathsToWorker, updateSize, updateMD5)
+			fileWorker(pathsToWorker, updateSize, updateMD5, &p.gate)
 		}()
 	}
 	wg.Wait() // Wait for all workers to finish
+
+	p.mu.Lock()
+	p.cpq = nil
+	p.mu.Unlock()
+}
+
+func FileTest() {
+	// Log file discovery
+	addFile := func(path string) {
+		log.Info().Str("file", path).Msg("Discovered file")
+	}
+
+	// Log size updates
+	updateSize := func(path string, size int64) {
+		log.Info().Str("file", path).Int64("size", size).Msg("Updated file size")
+	}
+
+	// Log MD5 updates
+	updateMD5 := func(path string, md5 string) {
+		log.Info().Str("file", path).Str("md5", md5).Msg("Updated file MD5")
+	}
+
+	pipeline := &FilePipeline{
+		Root:       ".",
+		NumWorkers: 4,                    // Number of workers
+		Priority:   PriorityByPathLength, // Demo priority
+		AddFile:    addFile,
+		UpdateSize: updateSize,
+		UpdateMD5:  updateMD5,
+	}
+	pipeline.Run()
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeFiles creates files of the given sizes under root.
+func writeFiles(t *testing.T, root string, sizes map[string]int) {
+	t.Helper()
+	for name, size := range sizes {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create parent of %s: %v", name, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestFilePipelinePriority(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{
+		"big":                 300,
+		"medium/nested/file":  200,
+		"small/file":          10,
+		"tiny/very/long/path": 1,
+	})
+
+	popOrder := func(priority PriorityFunc) []string {
+		pipeline := &FilePipeline{Root: root, Priority: priority, AddFile: func(string) {}}
+		items := make(chan *Item[string], 10)
+		pipeline.walk(items)
+		close(items)
+
+		bpq := NewBlockingPriorityQueue[string]()
+		for item := range items {
+			bpq.Push(item)
+		}
+		bpq.Close()
+
+		var order []string
+		for {
+			item, err := bpq.Pop()
+			if err != nil {
+				return order
+			}
+			relPath, _ := filepath.Rel(root, item.Value)
+			order = append(order, filepath.ToSlash(relPath))
+		}
+	}
+
+	byLength := popOrder(PriorityByPathLength)
+	expected := []string{"tiny/very/long/path", "medium/nested/file", "small/file", "big"}
+	if !slices.Equal(byLength, expected) {
+		t.Errorf("By path length: expected %v, got %v", expected, byLength)
+	}
+
+	bySize := popOrder(PriorityBySmallestSize)
+	expected = []string{"tiny/very/long/path", "small/file", "medium/nested/file", "big"}
+	if !slices.Equal(bySize, expected) {
+		t.Errorf("By size: expected %v, got %v", expected, bySize)
+	}
+
+	// Sizes past the range of int share the lowest priority instead of wrapping around to a high one.
+	if huge := PriorityBySmallestSize("", math.MaxInt64); huge != -math.MaxInt || huge > PriorityBySmallestSize("", 3<<30) {
+		t.Errorf("Expected the largest size to get the lowest priority, got %d", huge)
+	}
+}
+
+func TestFilePipelineNilCallbacks(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{"a": 1, "b/c": 2})
+
+	// Only the root is set, the callbacks are no-ops and a single worker runs.
+	done := make(chan struct{})
+	go func() {
+		(&FilePipeline{Root: root}).Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pipeline without workers nor callbacks didn't complete")
+	}
+}
+
+func TestFilePipelineRun(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{"a": 1, "b/c": 2, "b/d/e": 3})
+
+	var mu sync.Mutex
+	sizes := make(map[string]int64)
+	md5s := make(map[string]string)
+	pipeline := &FilePipeline{
+		Root:       root,
+		NumWorkers: 2,
+		Priority:   PriorityBySmallestSize,
+		AddFile:    func(string) {},
+		UpdateSize: func(path string, size int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			sizes[path] = size
+		},
+		UpdateMD5: func(path string, md5 string) {
+			mu.Lock()
+			defer mu.Unlock()
+			md5s[path] = md5
+		},
+	}
+	pipeline.Run()
+
+	if len(sizes) != 3 || len(md5s) != 3 {
+		t.Errorf("Expected 3 processed files, got %d sizes and %d hashes", len(sizes), len(md5s))
+	}
+	if size := sizes[filepath.Join(root, "b", "d", "e")]; size != 3 {
+		t.Errorf("Expected size 3, got %d", size)
+	}
+}
+
+func TestFilePipelinePauseResume(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]int{"a": 1, "b/c": 2, "b/d/e": 3})
+
+	var hashed atomic.Int32
+	pipeline := &FilePipeline{
+		Root:       root,
+		NumWorkers: 2,
+		AddFile:    func(string) {},
+		UpdateSize: func(string, int64) {},
+		UpdateMD5:  func(string, string) { hashed.Add(1) },
+	}
+	pipeline.Pause()
+	done := make(chan struct{})
+	go func() {
+		pipeline.Run()
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := hashed.Load(); n != 0 {
+		t.Errorf("Expected no file hashed while paused, got %d", n)
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected the pipeline to wait while paused")
+	default:
+	}
+
+	pipeline.Resume()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pipeline didn't complete once resumed")
+	}
+	if n := hashed.Load(); n != 3 {
+		t.Errorf("Expected 3 hashed files, got %d", n)
+	}
+}
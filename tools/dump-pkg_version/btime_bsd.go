@@ -0,0 +1,20 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// btimeSupported reports whether birth times can be recorded on this platform.
+const btimeSupported = true
+
+// birthTime returns the birth time of a file in Unix nanoseconds from its platform-specific stat data.
+func birthTime(_ string, info fs.FileInfo) (btime int64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return stat.Birthtimespec.Nano(), true
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// btimeSupported reports whether birth times can be recorded on this platform.
+const btimeSupported = true
+
+// birthTime returns the birth time of a file in Unix nanoseconds, read with statx as stat doesn't report it.
+// Not every filesystem records it, ok is false for those.
+func birthTime(path string, _ fs.FileInfo) (btime int64, ok bool) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx); err != nil {
+		return 0, false
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return 0, false
+	}
+	return stx.Btime.Sec*1e9 + int64(stx.Btime.Nsec), true
+}
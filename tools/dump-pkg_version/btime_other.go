@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+import "io/fs"
+
+// btimeSupported reports whether birth times can be recorded on this platform.
+const btimeSupported = false
+
+// birthTime always reports that no birth time is available, as this platform doesn't expose one.
+func birthTime(_ string, _ fs.FileInfo) (btime int64, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpBirthTime(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "a"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	before := time.Now().Add(-time.Minute)
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, BirthTime: true})
+	})
+	entries := readManifest(t, outputFile)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	// Even a supporting platform depends on the filesystem recording birth times.
+	stat, err := os.Lstat(filepath.Join(inputDir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, recorded := birthTime(filepath.Join(inputDir, "a"), stat)
+	switch {
+	case !btimeSupported && recorded:
+		t.Errorf("Expected no birth time on an unsupported platform")
+	case recorded && entries[0].BirthTime.Before(before):
+		t.Errorf("Expected a recent birth time, got %v", entries[0].BirthTime)
+	case !recorded && !entries[0].BirthTime.IsZero():
+		t.Errorf("Expected btime to be omitted, got %v", entries[0].BirthTime)
+	}
+
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+	if btime := readManifest(t, outputFile)[0].BirthTime; !btime.IsZero() {
+		t.Errorf("Expected btime to only be recorded with --btime, got %v", btime)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// btimeSupported reports whether birth times can be recorded on this platform.
+const btimeSupported = true
+
+// birthTime returns the creation time of a file in Unix nanoseconds from its platform-specific stat data.
+func birthTime(_ string, info fs.FileInfo) (btime int64, ok bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0, false
+	}
+	return data.CreationTime.Nanoseconds(), true
+}
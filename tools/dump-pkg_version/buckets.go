@@ -0,0 +1,135 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// compareResultSampleLimit is the number of paths kept per kind of result by reportResults.
+const compareResultSampleLimit = 100
+
+// CompareResultBuckets accumulates compare results per kind: every result is counted, but only the first paths of
+// each kind are kept as a sample, so memory stays bounded however many files differ. It is safe for concurrent use.
+type CompareResultBuckets struct {
+	sampleLimit int
+	counts      [crCount]atomic.Int64
+	unknown     atomic.Int64 // Results outside the known kinds, counted but never sampled
+
+	mu      sync.Mutex
+	samples [crCount][]string
+
+	hashMu sync.Mutex
+	hashes map[string]*HashCount // Match and mismatch counts per hash name
+}
+
+// HashCount is the number of files a hash algorithm matched and didn't match, across every file it was compared for.
+type HashCount struct {
+	Match    int64
+	Mismatch int64
+}
+
+// NewCompareResultBuckets returns empty buckets keeping up to sampleLimit paths per kind of result.
+func NewCompareResultBuckets(sampleLimit int) *CompareResultBuckets {
+	return &CompareResultBuckets{sampleLimit: max(sampleLimit, 0)}
+}
+
+// Add counts a result, and keeps its path if the sample of its kind isn't full yet.
+func (b *CompareResultBuckets) Add(res FileCompareResult) {
+	if len(res.Hashes) > 0 {
+		b.addHashes(res.Hashes)
+	}
+	if res.Result < 0 || res.Result >= crCount {
+		b.unknown.Add(1)
+		return
+	}
+	// The count doubles as a ticket, only the first sampleLimit results of a kind ever take the lock.
+	if b.counts[res.Result].Add(1) > int64(b.sampleLimit) {
+		return
+	}
+	b.mu.Lock()
+	b.samples[res.Result] = append(b.samples[res.Result], res.FilePath)
+	b.mu.Unlock()
+}
+
+// addHashes counts whether each hash compared for a file matched.
+func (b *CompareResultBuckets) addHashes(hashes map[string]bool) {
+	b.hashMu.Lock()
+	defer b.hashMu.Unlock()
+	if b.hashes == nil {
+		b.hashes = make(map[string]*HashCount)
+	}
+	for name, match := range hashes {
+		count := b.hashes[name]
+		if count == nil {
+			count = &HashCount{}
+			b.hashes[name] = count
+		}
+		if match {
+			count.Match++
+		} else {
+			count.Mismatch++
+		}
+	}
+}
+
+// HashCounts returns how many files matched and didn't match per hash name, e.g. to tell that xxh64 always matches
+// while md5 differs, hinting at wrongly recorded hashes rather than changed files. Only compared hashes are included.
+func (b *CompareResultBuckets) HashCounts() map[string]HashCount {
+	b.hashMu.Lock()
+	defer b.hashMu.Unlock()
+	counts := make(map[string]HashCount, len(b.hashes))
+	for name, count := range b.hashes {
+		counts[name] = *count
+	}
+	return counts
+}
+
+// Count returns the number of results of a kind.
+func (b *CompareResultBuckets) Count(cr CompareResult) int64 {
+	if cr < 0 || cr >= crCount {
+		return 0
+	}
+	return b.counts[cr].Load()
+}
+
+// Total returns the number of results added, including those of unknown kinds.
+func (b *CompareResultBuckets) Total() int64 {
+	total := b.unknown.Load()
+	for i := range b.counts {
+		total += b.counts[i].Load()
+	}
+	return total
+}
+
+// Mismatches returns the number of results that don't match the manifest, including those of unknown kinds.
+func (b *CompareResultBuckets) Mismatches() int64 {
+	mismatches := b.unknown.Load()
+	for cr := range crCount {
+		if !cr.IsMatch() {
+			mismatches += b.counts[cr].Load()
+		}
+	}
+	return mismatches
+}
+
+// Counts returns the number of results of every kind that occurred.
+func (b *CompareResultBuckets) Counts() map[CompareResult]int {
+	counts := make(map[CompareResult]int)
+	for cr := range crCount {
+		if count := b.counts[cr].Load(); count > 0 {
+			counts[cr] = int(count)
+		}
+	}
+	return counts
+}
+
+// Samples returns a copy of the paths kept for a kind of result, in the order they were added.
+func (b *CompareResultBuckets) Samples(cr CompareResult) []string {
+	if cr < 0 || cr >= crCount {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.samples[cr])
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareResultBucketsConcurrent(t *testing.T) {
+	const workers, perWorker, limit = 8, 1000, 10
+	buckets := NewCompareResultBuckets(limit)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				result := CR_Md5Dif
+				if i%4 == 0 {
+					result = CR_Same
+				}
+				buckets.Add(FileCompareResult{FilePath: fmt.Sprintf("w%d/file%d", w, i), Result: result})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := buckets.Count(CR_Md5Dif); got != workers*perWorker*3/4 {
+		t.Errorf("Expected %d md5 differences, got %d", workers*perWorker*3/4, got)
+	}
+	if got := buckets.Count(CR_Same); got != workers*perWorker/4 {
+		t.Errorf("Expected %d same files, got %d", workers*perWorker/4, got)
+	}
+	if got := buckets.Total(); got != workers*perWorker {
+		t.Errorf("Expected %d results in total, got %d", workers*perWorker, got)
+	}
+	for _, cr := range []CompareResult{CR_Md5Dif, CR_Same} {
+		if got := len(buckets.Samples(cr)); got != limit {
+			t.Errorf("Expected %d samples of %s, got %d", limit, cr, got)
+		}
+	}
+	if got := buckets.Samples(CR_NotExist); len(got) != 0 {
+		t.Errorf("Expected no samples of not_exist, got %v", got)
+	}
+}
+
+func TestReportResultsManyMismatches(t *testing.T) {
+	const missing = 5000
+	pkgMap := make(map[string]FileInfo, missing)
+	for i := range missing {
+		filePath := fmt.Sprintf("dir%d/missing%d", i%10, i)
+		pkgMap[filePath] = FileInfo{FilePath: filePath, Size: 1}
+	}
+
+	var buckets *CompareResultBuckets
+	runWithTimeout(t, 20*time.Second, func() {
+		buckets = reportResults(verifyContent(4, t.TempDir(), pkgMap, compareOptions{}))
+	})
+
+	if got := buckets.Count(CR_NotExist); got != missing {
+		t.Errorf("Expected %d missing files, got %d", missing, got)
+	}
+	// Memory is bounded by the sample limit, not by the number of mismatches.
+	samples := buckets.Samples(CR_NotExist)
+	if len(samples) != compareResultSampleLimit {
+		t.Errorf("Expected %d samples, got %d", compareResultSampleLimit, len(samples))
+	}
+	for _, sample := range samples {
+		if _, ok := pkgMap[sample]; !ok {
+			t.Errorf("Sample %s is not a manifest entry", sample)
+		}
+	}
+}
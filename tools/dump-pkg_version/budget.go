@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ByteSize is a number of bytes, written on the command line either plainly or with a decimal (KB, MB, GB, TB) or
+// binary (KiB, MiB, GiB, TiB) unit, e.g. "500MB" or "10GiB".
+type ByteSize int64
+
+// UnmarshalText parses a size like "10GiB", letting go-arg populate it from the command line.
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	number := strings.TrimSpace(string(text))
+	unit := int64(1)
+	for _, suffix := range []struct {
+		name string
+		unit int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	} {
+		if trimmed, ok := strings.CutSuffix(number, suffix.name); ok {
+			number, unit = strings.TrimSpace(trimmed), suffix.unit
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/unit {
+		return fmt.Errorf("invalid size %q, expected a number of bytes like 500MB or 10GiB", text)
+	}
+	*s = ByteSize(n * unit)
+	return nil
+}
+
+// readBudget caps the bytes read across the workers of a run. Each file claims its size before it is read, and once
+// a file doesn't fit the budget is exhausted, so no file is read anymore and the run winds down instead of reading
+// the smaller files left. A nil budget is unlimited.
+type readBudget struct {
+	limit     int64
+	claimed   atomic.Int64
+	exhausted atomic.Bool
+}
+
+// newReadBudget returns a budget of limit bytes, or nil when limit is not positive.
+func newReadBudget(limit ByteSize) *readBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &readBudget{limit: int64(limit)}
+}
+
+// Claim reserves size bytes to read a file, and reports whether the budget allowed it.
+func (b *readBudget) Claim(size int64) bool {
+	if b == nil {
+		return true
+	}
+	if b.exhausted.Load() {
+		return false
+	}
+	if b.claimed.Add(size) > b.limit {
+		b.claimed.Add(-size)
+		b.exhausted.Store(true)
+		return false
+	}
+	return true
+}
+
+// Exhausted reports whether a file was refused, after which none is read.
+func (b *readBudget) Exhausted() bool {
+	return b != nil && b.exhausted.Load()
+}
+
+// Claimed returns the bytes claimed by the files read.
+func (b *readBudget) Claimed() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.claimed.Load()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteSizeUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected ByteSize
+	}{
+		{"1234", 1234},
+		{"0", 0},
+		{"500MB", 500_000_000},
+		{"10GiB", 10 << 30},
+		{"2 KiB", 2048},
+		{"7B", 7},
+	}
+	for _, test := range tests {
+		var size ByteSize
+		if err := size.UnmarshalText([]byte(test.text)); err != nil || size != test.expected {
+			t.Errorf("%q: expected %d, got %d (%v)", test.text, test.expected, size, err)
+		}
+	}
+	for _, text := range []string{"", "MB", "-1", "1.5GB", "10PB", "99999999999TB"} {
+		var size ByteSize
+		if err := size.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: expected an error, got %d", text, size)
+		}
+	}
+}
+
+// budgetTree writes 10 files of 1000 bytes, for a budget of 3500 bytes to stop after 3 of them.
+func budgetTree(t *testing.T) (string, map[string]string) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 10 {
+		files[fmt.Sprintf("file%d", i)] = strings.Repeat(fmt.Sprint(i), 1000)
+	}
+	writeTree(t, inputDir, files)
+	return inputDir, files
+}
+
+func TestVerifyReadBudget(t *testing.T) {
+	inputDir, files := budgetTree(t)
+	budget := newReadBudget(3500)
+	pkgMap := make(map[string]FileInfo)
+	for name, content := range files {
+		info, err := hashReader(strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.FilePath = name
+		pkgMap[name] = info
+	}
+
+	var buckets *CompareResultBuckets
+	runWithTimeout(t, 10*time.Second, func() {
+		buckets = reportResults(verifyContent(2, inputDir, pkgMap, compareOptions{ReadBudget: budget}))
+	})
+	// The fourth file would overrun the budget, so it and all the others are left unchecked.
+	expected := map[CompareResult]int{CR_Same: 3, CR_Unchecked: 7}
+	if counts := buckets.Counts(); !maps.Equal(counts, expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+	if !budget.Exhausted() || budget.Claimed() != 3000 {
+		t.Errorf("Expected an exhausted budget with 3000 bytes read, got %v and %d", budget.Exhausted(), budget.Claimed())
+	}
+}
+
+func TestDumpReadBudget(t *testing.T) {
+	inputDir, _ := budgetTree(t)
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	var summary DumpSummary
+	runWithTimeout(t, 10*time.Second, func() {
+		summary = subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, ReadBudget: 3500})
+	})
+	if summary.Files != 3 || summary.Bytes != 3000 {
+		t.Errorf("Expected 3 files of 3000 bytes, got %d of %d", summary.Files, summary.Bytes)
+	}
+	if entries := readManifest(t, manifest); len(entries) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(entries))
+	}
+}
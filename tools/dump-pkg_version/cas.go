@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// casBlobPath returns the path of the blob with the given hex hash in a content-addressed store, below two levels
+// of directories named after its first two pairs of digits, e.g. "ab/cd/abcd0123...".
+func casBlobPath(hexHash string) string {
+	if len(hexHash) < 4 {
+		return hexHash
+	}
+	return path.Join(hexHash[:2], hexHash[2:4], hexHash)
+}
+
+// casCandidates returns the store paths the blob of file may be at, by md5 then xxh64, nil when it has no hash.
+func casCandidates(file FileInfo) []string {
+	var candidates []string
+	for _, sum := range [][]byte{file.Md5Hash, file.Xxh64Hash} {
+		if len(sum) > 0 {
+			candidates = append(candidates, casBlobPath(hex.EncodeToString(sum)))
+		}
+	}
+	return candidates
+}
+
+// locateCASBlob returns the first candidate path of file existing under casRoot, or the first candidate when none
+// exists, so the missing blob is reported by its preferred hash. ok is false when file has no hash to look it up by.
+func locateCASBlob(casRoot string, file FileInfo) (blobPath string, ok bool) {
+	candidates := casCandidates(file)
+	if len(candidates) == 0 {
+		return "", false
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Lstat(filepath.Join(casRoot, filepath.FromSlash(candidate))); err == nil {
+			return candidate, true
+		}
+	}
+	return candidates[0], true
+}
+
+// verifyCAS compares the entries of pkgMap against the blobs of a content-addressed store instead of the files at
+// their remote names. Each blob is found by the hashes of its entry and compared once, however many entries share it,
+// and results are reported by blob path. Directories and links, which have no blob, are skipped.
+func verifyCAS(threads int, casRoot string, pkgMap map[string]FileInfo, opts compareOptions) <-chan FileCompareResult {
+	blobs := make(map[string]FileInfo)
+	for _, file := range pkgMap {
+		if file.IsDir || file.IsSymlink {
+			log.Debug().Str("file", file.FilePath).Msg("Skipped entry without blob")
+			continue
+		}
+		blobPath, ok := locateCASBlob(casRoot, file)
+		if !ok {
+			log.Warn().Str("file", file.FilePath).Msg("Entry has no hash to find its blob by")
+			continue
+		}
+		log.Trace().Str("file", file.FilePath).Str("blob", blobPath).Msg("Located blob")
+		file.FilePath = blobPath
+		blobs[blobPath] = file
+	}
+
+	workQueue := make(chan FileInfo, len(blobs)) // Work queue
+	for _, blob := range blobs {
+		workQueue <- blob
+	}
+	close(workQueue)
+	return compareFiles(threads, casRoot, workQueue, opts)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/hex"
+	"maps"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyCAS(t *testing.T) {
+	hashes := func(content string) FileInfo {
+		info, err := hashReader(strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	present, byXxh64, corrupt, missing := hashes("present"), hashes("by xxh64"), hashes("corrupt"), hashes("missing")
+
+	casRoot := t.TempDir()
+	writeTree(t, casRoot, map[string]string{
+		casBlobPath(hex.EncodeToString(present.Md5Hash)):   "present",
+		casBlobPath(hex.EncodeToString(byXxh64.Xxh64Hash)): "by xxh64",
+		casBlobPath(hex.EncodeToString(corrupt.Md5Hash)):   "CORRUPT",
+	})
+	pkgMap := make(map[string]FileInfo)
+	for name, info := range map[string]FileInfo{
+		"a/present": present, "b/duplicate": present, "by-xxh64": byXxh64, "corrupt": corrupt, "missing": missing,
+		"dir": {IsDir: true},
+	} {
+		info.FilePath = name
+		pkgMap[name] = info
+	}
+
+	var buckets *CompareResultBuckets
+	runWithTimeout(t, 10*time.Second, func() {
+		buckets = reportResults(verifyCAS(2, casRoot, pkgMap, compareOptions{}))
+	})
+	// The duplicate shares the blob of a/present, which is only compared once.
+	expected := map[CompareResult]int{CR_Same: 2, CR_Md5Dif: 1, CR_NotExist: 1}
+	if counts := buckets.Counts(); !maps.Equal(counts, expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+	missingBlob := casBlobPath(hex.EncodeToString(missing.Md5Hash))
+	if samples := buckets.Samples(CR_NotExist); len(samples) != 1 || samples[0] != missingBlob {
+		t.Errorf("Expected the missing blob to be reported as %s, got %v", missingBlob, samples)
+	}
+	if blob := casBlobPath("abcdef"); blob != path.Join("ab", "cd", "abcdef") {
+		t.Errorf("Expected ab/cd/abcdef, got %s", blob)
+	}
+}
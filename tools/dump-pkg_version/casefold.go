@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// foldPkgMap rekeys a manifest by lowercased path for case-insensitive lookups.
+// Entries whose paths differ only by case collide; the lexically smallest path is kept and
+// the paths of the dropped entries are returned so they can be reported.
+func foldPkgMap(pkgMap map[string]FileInfo) (map[string]FileInfo, []string) {
+	folded := make(map[string]FileInfo, len(pkgMap))
+	var collisions []string
+	for _, file := range pkgMap {
+		key := strings.ToLower(file.FilePath)
+		existing, ok := folded[key]
+		if !ok {
+			folded[key] = file
+			continue
+		}
+		// Keep the result independent of map iteration order.
+		if file.FilePath < existing.FilePath {
+			folded[key], file = file, existing
+		}
+		collisions = append(collisions, file.FilePath)
+		log.Warn().
+			Str("file", file.FilePath).
+			Str("kept", folded[key].FilePath).
+			Msg("Manifest entries differ only by case, ignoring one")
+	}
+	return folded, collisions
+}
+
+// resolveCaseInsensitive walks basedir and rewrites the path of every manifest entry in the folded map
+// to the on-disk spelling of its path, so that it can be opened on a case-sensitive filesystem.
+// The returned map is keyed by the resolved path, entries without an on-disk match keep their manifest path.
+func resolveCaseInsensitive(basedir string, folded map[string]FileInfo, skipDirs []string) (map[string]FileInfo, error) {
+	resolved := make(map[string]FileInfo, len(folded))
+	err := filepath.WalkDir(basedir, skipDirWalk(basedir, skipDirs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(basedir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		key := strings.ToLower(relPath)
+		if file, ok := folded[key]; ok {
+			file.FilePath = relPath
+			resolved[relPath] = file
+			delete(folded, key) // Only the first on-disk spelling is used
+		}
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries left over don't exist on disk in any spelling.
+	for _, file := range folded {
+		resolved[file.FilePath] = file
+	}
+	return resolved, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveCaseInsensitive(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"data/foo.pak": "foo"})
+	file, err := processFile(root, filepath.Join(root, "data", "foo.pak"))
+	if err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	file.FilePath = "Data/Foo.pak" // Spelled differently in the manifest
+
+	folded, collisions := foldPkgMap(map[string]FileInfo{
+		file.FilePath: file,
+		"Missing.txt": {FilePath: "Missing.txt", Size: 1},
+	})
+	if len(collisions) != 0 {
+		t.Errorf("Expected no collisions, got %v", collisions)
+	}
+	resolved, err := resolveCaseInsensitive(root, folded, nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+
+	got := make(map[string]CompareResult)
+	for res := range verifyContent(1, root, resolved, compareOptions{}) {
+		got[res.FilePath] = res.Result
+	}
+	if got["data/foo.pak"] != CR_Same {
+		t.Errorf("Expected data/foo.pak to match Data/Foo.pak, got %v", got)
+	}
+	if result, ok := got["Missing.txt"]; !ok || result != CR_NotExist {
+		t.Errorf("Expected Missing.txt to not exist, got %v", got)
+	}
+}
+
+func TestFoldPkgMapCollision(t *testing.T) {
+	folded, collisions := foldPkgMap(map[string]FileInfo{
+		"Data/A.txt": {FilePath: "Data/A.txt"},
+		"data/a.txt": {FilePath: "data/a.txt"},
+		"b.txt":      {FilePath: "b.txt"},
+	})
+
+	if !slices.Equal(collisions, []string{"data/a.txt"}) {
+		t.Errorf("Expected data/a.txt to collide, got %v", collisions)
+	}
+	if len(folded) != 2 || folded["data/a.txt"].FilePath != "Data/A.txt" {
+		t.Errorf("Expected Data/A.txt to be kept, got %v", folded)
+	}
+}
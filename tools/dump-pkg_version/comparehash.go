@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// compareHashSample is the number of file entries checkCompareHashes looks at, enough to catch a manifest dumped
+// without a hash without going through every entry of a large one.
+const compareHashSample = 1000
+
+// checkCompareHashes checks that a sample of the file entries of pkgMap record every hash named by --compare-hash,
+// so verify fails at load time instead of comparing against nothing for every file. The error lists the hashes the
+// sampled entries record. Directories and links are skipped, as they have no content hash.
+func checkCompareHashes(pkgMap map[string]FileInfoOutput, names []string) error {
+	available := make(map[string]struct{})
+	sampled := 0
+	for _, entry := range pkgMap {
+		if entry.IsDir || entry.IsSymlink {
+			continue
+		}
+		hashes := entryHashes(entry)
+		delete(hashes, "dir") // Only directory entries have a dir hash
+		for name := range hashes {
+			available[name] = struct{}{}
+		}
+		for _, name := range names {
+			if _, ok := hashes[name]; !ok {
+				return fmt.Errorf("hash %s is not recorded in the manifest, e.g. by %s, available hashes are %s",
+					name, entry.FilePath, listHashNames(available))
+			}
+		}
+		if sampled++; sampled >= compareHashSample {
+			break
+		}
+	}
+	return nil
+}
+
+// listHashNames returns the sorted names of hashes separated by commas, or "no hash" when there is none.
+func listHashNames(hashes map[string]struct{}) string {
+	if len(hashes) == 0 {
+		return "no hash"
+	}
+	return strings.Join(slices.Sorted(maps.Keys(hashes)), ", ")
+}
+
+// selectCompareHashes keeps in fileInfo only the hashes of entry named by --compare-hash, the additional ones
+// included, so compareFile compares exactly those.
+func selectCompareHashes(fileInfo *FileInfo, entry FileInfoOutput, names []string) {
+	fileInfo.Md5Hash, fileInfo.Xxh64Hash, fileInfo.Hashes = nil, nil, nil
+	for _, name := range names {
+		switch name {
+		case hasherMD5:
+			fileInfo.Md5Hash = decodeHex(entry.Md5Hash)
+		case hasherXXH64:
+			fileInfo.Xxh64Hash = decodeHex(entry.Xxh64Hash)
+		default:
+			if sum, ok := entry.Hashes[name]; ok {
+				if fileInfo.Hashes == nil {
+					fileInfo.Hashes = make(map[string][]byte)
+				}
+				fileInfo.Hashes[name] = decodeHex(sum)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareHashes(t *testing.T) {
+	registerTestHasher()
+
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a.txt": "alpha", "dir/b.txt": "bravo"})
+	dump := func(hashes ...string) map[string]FileInfoOutput {
+		t.Helper()
+		manifest := filepath.Join(t.TempDir(), "package.jsonl")
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2, Hashes: hashes}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+		})
+		pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkgMap
+	}
+
+	// A hash the manifest was never dumped with fails, naming the ones it has.
+	plain := dump()
+	for _, name := range []string{"sha256", "test-crc32"} {
+		err := checkCompareHashes(plain, []string{"md5", name})
+		if err == nil || !strings.Contains(err.Error(), name) || !strings.Contains(err.Error(), "md5, xxh64") {
+			t.Errorf("Expected an error naming %s and the available md5, xxh64, got %v", name, err)
+		}
+	}
+	if err := checkCompareHashes(plain, []string{"xxh64"}); err != nil {
+		t.Errorf("Expected xxh64 to be recorded, got %v", err)
+	}
+
+	// A recorded additional hash is the only one compared once selected.
+	withCRC := dump("test-crc32")
+	if err := checkCompareHashes(withCRC, []string{"test-crc32"}); err != nil {
+		t.Fatalf("Expected test-crc32 to be recorded, got %v", err)
+	}
+	compare := func(name string) CompareResult {
+		t.Helper()
+		var fileInfo FileInfo
+		selectCompareHashes(&fileInfo, withCRC[name], []string{"test-crc32"})
+		if fileInfo.Md5Hash != nil || fileInfo.Xxh64Hash != nil || len(fileInfo.Hashes) != 1 {
+			t.Errorf("Expected only test-crc32 to be selected, got %+v", fileInfo)
+		}
+		fileInfo.FilePath, fileInfo.Size = name, withCRC[name].Size
+		result, _ := compareFile(inputDir, fileInfo, compareOptions{})
+		return result
+	}
+	if result := compare("a.txt"); result != CR_Same {
+		t.Errorf("Expected the unchanged file to be the same, got %s", result)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "a.txt"), []byte("ALPHA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := compare("a.txt"); result != CR_HashDif {
+		t.Errorf("Expected the changed file to differ by hash, got %s", result)
+	}
+}
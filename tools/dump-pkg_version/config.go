@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog"
+)
+
+// Config holds the settings shared by the subcommands, so they can be kept in a versioned file instead of being
+// repeated on every command line. They are resolved from the defaults, then the --config file, then the flags given
+// on the command line, each overriding the previous ones, and the subcommands are given the resolved settings.
+type Config struct {
+	Workers  int      `json:"workers" toml:"workers"`   // Number of worker goroutines for hashing
+	Hashes   []string `json:"hashes" toml:"hashes"`     // Registered algorithms recorded by dump besides md5 and xxh64
+	TextExts []string `json:"textExts" toml:"textExts"` // Extensions of the text files --normalize-eol applies to, the default ones when empty
+	LogLevel string   `json:"logLevel" toml:"logLevel"` // Minimum level of the logged messages, e.g. info
+}
+
+// defaultConfig returns the settings used when neither the config file nor a flag sets them.
+func defaultConfig() Config {
+	return Config{Workers: 2, LogLevel: "debug"}
+}
+
+// loadConfigFile overrides the settings of config set in the file at path, a TOML file when its extension is .toml
+// and a JSON file otherwise. Unknown settings are an error, so a typo doesn't go unnoticed.
+func loadConfigFile(config *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		meta, err := toml.Decode(string(data), config)
+		if err != nil {
+			return fmt.Errorf("failed to decode config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("failed to decode config file %s: unknown setting %s", path, undecoded[0])
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("failed to decode config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// resolveConfig returns the settings for args: the defaults, overridden by the --config file when given, overridden
+// by the flags set on the command line. A flag left unset is nil or empty.
+func resolveConfig(args *Args) (Config, error) {
+	config := defaultConfig()
+	if args.ConfigFile != "" {
+		if err := loadConfigFile(&config, args.ConfigFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if args.Threads != nil {
+		config.Workers = *args.Threads // An explicit -w 0 is kept, and clamped like any other count
+	}
+	if args.LogLevel != "" {
+		config.LogLevel = args.LogLevel
+	}
+	if args.Dump != nil {
+		config.Hashes = overrideList(config.Hashes, args.Dump.Hashes)
+		config.TextExts = overrideList(config.TextExts, args.Dump.TextExts)
+	}
+	if args.Verify != nil {
+		config.TextExts = overrideList(config.TextExts, args.Verify.TextExts)
+	}
+
+	if _, err := zerolog.ParseLevel(config.LogLevel); err != nil {
+		return Config{}, fmt.Errorf("invalid log level: %w", err)
+	}
+	return config, nil
+}
+
+// overrideList returns flag when it was given, and current otherwise.
+func overrideList(current, flag []string) []string {
+	if len(flag) > 0 {
+		return flag
+	}
+	return current
+}
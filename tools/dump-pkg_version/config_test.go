@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexflint/go-arg"
+)
+
+// parseArgs parses a command line like main does.
+func parseArgs(t *testing.T, cmdline ...string) *Args {
+	t.Helper()
+	var args Args
+	parser, err := arg.NewParser(arg.Config{}, &args)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	if err := parser.Parse(cmdline); err != nil {
+		t.Fatalf("Failed to parse %v: %v", cmdline, err)
+	}
+	return &args
+}
+
+func TestResolveConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(jsonFile, []byte(`{"workers": 8, "hashes": ["md5"], "logLevel": "warn"}`), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	tomlFile := filepath.Join(dir, "config.toml")
+	toml := "# Shared settings\nworkers = 8 # per machine\nhashes = [\n  \"md5\", # always\n]\nlogLevel = 'warn'\n"
+	if err := os.WriteFile(tomlFile, []byte(toml), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cmdline  []string
+		expected Config
+	}{
+		{"default", []string{"dump", "in"}, Config{Workers: 2, LogLevel: "debug"}},
+		{"json file", []string{"--config", jsonFile, "dump", "in"}, Config{Workers: 8, Hashes: []string{"md5"}, LogLevel: "warn"}},
+		{"toml file", []string{"--config", tomlFile, "dump", "in"}, Config{Workers: 8, Hashes: []string{"md5"}, LogLevel: "warn"}},
+		{"flag over file", []string{"--config", tomlFile, "-w", "4", "--log-level", "info", "dump", "in", "--hash", "xxh64"},
+			Config{Workers: 4, Hashes: []string{"xxh64"}, LogLevel: "info"}},
+		{"flag over default", []string{"-w", "3", "verify", "in", "--text-ext", ".md"}, Config{Workers: 3, TextExts: []string{".md"}, LogLevel: "debug"}},
+		{"zero flag over file", []string{"--config", jsonFile, "-w", "0", "dump", "in"}, Config{Workers: 0, Hashes: []string{"md5"}, LogLevel: "warn"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := parseArgs(t, test.cmdline...)
+			config, err := resolveConfig(args)
+			if err != nil {
+				t.Fatalf("Failed to resolve config: %v", err)
+			}
+			if !reflect.DeepEqual(config, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, config)
+			}
+		})
+	}
+}
+
+func TestResolveConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown.json": `{"worker": 8}`,
+		"level.json":   `{"logLevel": "loud"}`,
+		"table.toml":   "[dump]\nworkers = 8\n",
+		"twice.toml":   "workers = 8\nworkers = 4\n",
+		"value.toml":   "workers = eight\n",
+		"type.toml":    "hashes = \"md5\"\n",
+	} {
+		configFile := filepath.Join(dir, name)
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if _, err := resolveConfig(&Args{ConfigFile: configFile}); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
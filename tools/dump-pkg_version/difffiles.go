@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zeebo/xxh3"
+)
+
+// DiffKind tells how an entry differs between two manifests.
+type DiffKind int
+
+const (
+	DK_Added DiffKind = iota
+	DK_Removed
+	DK_Changed
+)
+
+// ManifestDiff describes one entry that differs between two manifests.
+type ManifestDiff struct {
+	FilePath    string // Relative path of the file, the manifest key
+	Kind        DiffKind
+	SizeChanged bool // Only meaningful for DK_Changed
+	HashChanged bool // Only meaningful for DK_Changed, true when any hash differs
+}
+
+// DiffOptions controls which differences are reported by diffManifests.
+type DiffOptions struct {
+	IgnoreSize bool // Suppress entries that differ only in size
+	IgnoreHash bool // Suppress entries that differ only in hashes
+}
+
+func subcommandDiffFiles(_ Config, diffFilesCmd *DiffFilesCmd) {
+	// Create a local copy of diffFilesCmd to avoid unintended modifications.
+	_diffFilesCmd := *diffFilesCmd
+
+	// Identical manifests are by far the most common case, files with the same bytes are settled before parsing either.
+	// A file that can't be read is reported by the parsing below.
+	if identical, err := filesIdentical(_diffFilesCmd.OldPkgFile, _diffFilesCmd.NewPkgFile); err == nil && identical {
+		log.Info().Int("differences", 0).Msg("Manifests are identical")
+		return
+	}
+
+	oldMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(_diffFilesCmd.OldPkgFile, oldMap); err != nil {
+		log.Panic().Err(err).Msg("Error reading old pkg file")
+	}
+	newMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(_diffFilesCmd.NewPkgFile, newMap); err != nil {
+		log.Panic().Err(err).Msg("Error reading new pkg file")
+	}
+
+	// Manifests with the same entries written in another order are settled by a digest of each, without a detailed diff.
+	if manifestsIdentical(oldMap, newMap) {
+		log.Info().Int("differences", 0).Msg("Manifests are identical")
+		return
+	}
+
+	diffs := diffManifests(oldMap, newMap, DiffOptions{
+		IgnoreSize: _diffFilesCmd.IgnoreSize,
+		IgnoreHash: _diffFilesCmd.IgnoreHash,
+	})
+	for _, diff := range diffs {
+		baseLog := log.With().Str("file", diff.FilePath).Logger()
+		switch diff.Kind {
+		case DK_Added:
+			baseLog.Info().Msg("File added")
+		case DK_Removed:
+			baseLog.Info().Msg("File removed")
+		case DK_Changed:
+			baseLog.Info().
+				Bool("size_changed", diff.SizeChanged).
+				Bool("hash_changed", diff.HashChanged).
+				Msg("File changed")
+		}
+	}
+	log.Info().Int("differences", len(diffs)).Msg("Diff finished")
+}
+
+// diffManifests compares two manifests keyed by remoteName and returns the differing entries sorted by path.
+// Entries recorded with different hash sets are compared on the hashes they have in common, a single warning tells
+// how many were.
+func diffManifests(oldMap, newMap map[string]FileInfoOutput, opts DiffOptions) []ManifestDiff {
+	var diffs []ManifestDiff
+	var reducedCount, sizeOnlyCount int
+	for filePath, oldEntry := range oldMap {
+		newEntry, ok := newMap[filePath]
+		if !ok {
+			diffs = append(diffs, ManifestDiff{FilePath: filePath, Kind: DK_Removed})
+			continue
+		}
+		sizeChanged := oldEntry.Size != newEntry.Size
+		// Only the hashes recorded in both entries can be compared, entries without any in common compare on size.
+		hashChanged, compared, reduced := compareCommonHashes(oldEntry, newEntry)
+		if reduced {
+			reducedCount++
+			if compared == 0 {
+				sizeOnlyCount++
+			}
+		}
+		// Keep the entry only if it differs in at least one dimension that isn't ignored
+		if (sizeChanged && !opts.IgnoreSize) || (hashChanged && !opts.IgnoreHash) {
+			diffs = append(diffs, ManifestDiff{
+				FilePath:    filePath,
+				Kind:        DK_Changed,
+				SizeChanged: sizeChanged,
+				HashChanged: hashChanged,
+			})
+		}
+	}
+	for filePath := range newMap {
+		if _, ok := oldMap[filePath]; !ok {
+			diffs = append(diffs, ManifestDiff{FilePath: filePath, Kind: DK_Added})
+		}
+	}
+
+	if reducedCount > 0 {
+		log.Warn().
+			Int("entries", reducedCount).
+			Int("size_only", sizeOnlyCount).
+			Msg("Some entries were recorded with different hashes and were only compared on the ones both have, with less confidence")
+	}
+
+	slices.SortFunc(diffs, func(a, b ManifestDiff) int {
+		return strings.Compare(a.FilePath, b.FilePath)
+	})
+	return diffs
+}
+
+// entryHashes returns the hashes recorded in entry by algorithm name, md5 and xxh64 included.
+func entryHashes(entry FileInfoOutput) map[string]string {
+	hashes := make(map[string]string, 2+len(entry.Hashes))
+	maps.Copy(hashes, entry.Hashes)
+	if entry.Md5Hash != "" {
+		hashes["md5"] = entry.Md5Hash
+	}
+	if entry.Xxh64Hash != "" {
+		hashes["xxh64"] = entry.Xxh64Hash
+	}
+	if entry.DirHash != "" {
+		hashes["dir"] = entry.DirHash
+	}
+	return hashes
+}
+
+// compareCommonHashes compares the hashes recorded in both entries. It returns whether any of them differs, how many
+// were compared, and whether the entries were recorded with different hash sets so some could not be compared.
+// The xxh64 hashes of entries dumped with different --xxh-seed values never match, so they are not in common.
+func compareCommonHashes(a, b FileInfoOutput) (changed bool, compared int, reduced bool) {
+	aHashes, bHashes := entryHashes(a), entryHashes(b)
+	if a.XxhSeed != b.XxhSeed {
+		delete(aHashes, hasherXXH64)
+		delete(bHashes, hasherXXH64)
+		reduced = true
+	}
+	for name, aHash := range aHashes {
+		bHash, ok := bHashes[name]
+		if !ok {
+			reduced = true
+			continue
+		}
+		compared++
+		changed = changed || aHash != bHash
+	}
+	return changed, compared, reduced || compared < len(bHashes)
+}
+
+// filesIdentical reports whether the files at a and b have the same content. Their sizes are compared first, and only
+// files of the same size are read, as a stream, to compare their digests.
+func filesIdentical(a, b string) (bool, error) {
+	aStat, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bStat, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if aStat.Size() != bStat.Size() {
+		return false, nil
+	}
+	aDigest, err := fileDigest(a)
+	if err != nil {
+		return false, err
+	}
+	bDigest, err := fileDigest(b)
+	if err != nil {
+		return false, err
+	}
+	return aDigest == bDigest, nil
+}
+
+// fileDigest returns the xxh3 digest of the content of the file at path.
+func fileDigest(path string) (xxh3.Uint128, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return xxh3.Uint128{}, err
+	}
+	defer f.Close()
+	h := xxh3.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return xxh3.Uint128{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return h.Sum128(), nil
+}
+
+// manifestsIdentical reports whether two manifests hold the same entries, as far as diffManifests is concerned.
+// It compares the entry counts, then the manifest digests, so it never reports identical manifests as different
+// and only reports different ones as identical on a 128-bit hash collision.
+func manifestsIdentical(oldMap, newMap map[string]FileInfoOutput) bool {
+	return len(oldMap) == len(newMap) && manifestDigest(oldMap) == manifestDigest(newMap)
+}
+
+// manifestDigest hashes the fields compared by diffManifests (path, size and hashes, additional ones in name order,
+// directory hashes included)
+// of every entry, in path order so the digest doesn't depend on the order the manifest was written in.
+func manifestDigest(pkgMap map[string]FileInfoOutput) xxh3.Uint128 {
+	filePaths := slices.Sorted(maps.Keys(pkgMap))
+
+	h := xxh3.New()
+	var buf []byte
+	for _, filePath := range filePaths {
+		entry := pkgMap[filePath]
+		// Every string is length-prefixed, so no two different entries encode to the same bytes.
+		buf = buf[:0]
+		fields := []string{entry.FilePath, entry.Md5Hash, entry.Xxh64Hash, entry.DirHash}
+		for _, name := range slices.Sorted(maps.Keys(entry.Hashes)) {
+			fields = append(fields, name, entry.Hashes[name])
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(fields)))
+		for _, field := range fields {
+			buf = binary.AppendUvarint(buf, uint64(len(field)))
+			buf = append(buf, field...)
+		}
+		buf = binary.AppendVarint(buf, entry.Size)
+		h.Write(buf)
+	}
+	return h.Sum128()
+}
+
+// DiffDirs compares two live directory trees by content, without writing manifests.
+// Both trees are walked concurrently and their files hashed by a single pool of threads workers.
+// Returned paths are relative to the roots, use forward slashes and are sorted: added files only exist in b,
+// removed files only exist in a and changed files exist in both with a different size or hash.
+func DiffDirs(a, b string, threads int) (added, removed, changed []string, err error) {
+	// Each file carries the index of its tree, so one pool can hash both.
+	type treeFile struct {
+		tree int
+		path string
+	}
+	type treeResult struct {
+		tree int
+		info FileInfo
+	}
+	roots := []string{a, b}
+
+	var errOnce sync.Once
+	setErr := func(e error) {
+		errOnce.Do(func() { err = e })
+	}
+
+	paths := make(chan treeFile, 10_000)
+	results := RunPool(paths, max(threads, 1), func(file treeFile) (treeResult, error) {
+		info, err := processFile(roots[file.tree], file.path)
+		if err != nil {
+			setErr(err)
+			return treeResult{}, err
+		}
+		return treeResult{tree: file.tree, info: info}, nil
+	})
+
+	var walkWg sync.WaitGroup
+	for tree, root := range roots {
+		walkWg.Add(1)
+		go func() {
+			defer walkWg.Done()
+			walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					paths <- treeFile{tree: tree, path: path}
+				}
+				return nil
+			})
+			if walkErr != nil {
+				setErr(walkErr)
+			}
+		}()
+	}
+	go func() {
+		walkWg.Wait()
+		close(paths)
+	}()
+
+	// Collect both trees in the manifest format, so they can be compared like two manifests.
+	trees := []map[string]FileInfoOutput{make(map[string]FileInfoOutput), make(map[string]FileInfoOutput)}
+	for result := range results {
+		out := toFileInfoOutput(result.info, entryFormat{})
+		trees[result.tree][out.FilePath] = out
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, diff := range diffManifests(trees[0], trees[1], DiffOptions{}) {
+		switch diff.Kind {
+		case DK_Added:
+			added = append(added, diff.FilePath)
+		case DK_Removed:
+			removed = append(removed, diff.FilePath)
+		case DK_Changed:
+			changed = append(changed, diff.FilePath)
+		}
+	}
+	return added, removed, changed, nil
+}
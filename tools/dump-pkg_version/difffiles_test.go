@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeManifest writes the given entries as a JSONL manifest and returns its path.
+func writeManifest(t *testing.T, entries ...FileInfoOutput) string {
+	t.Helper()
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+	results := make(chan FileInfo, len(entries))
+	for _, entry := range entries {
+		results <- FileInfo{
+			FilePath:  entry.FilePath,
+			Md5Hash:   decodeHex(entry.Md5Hash),
+			Xxh64Hash: decodeHex(entry.Xxh64Hash),
+			Size:      entry.Size,
+		}
+	}
+	close(results)
+	pkgOutWriter(outputFile, results, entryFormat{}, false)
+	return outputFile
+}
+
+func TestDiffManifestsIgnore(t *testing.T) {
+	oldPkg := writeManifest(t,
+		FileInfoOutput{FilePath: "same", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+		FileInfoOutput{FilePath: "size", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+		FileInfoOutput{FilePath: "hash", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+		FileInfoOutput{FilePath: "both", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+		FileInfoOutput{FilePath: "removed", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+	)
+	newPkg := writeManifest(t,
+		FileInfoOutput{FilePath: "same", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+		FileInfoOutput{FilePath: "size", Md5Hash: "00", Xxh64Hash: "00", Size: 2},
+		FileInfoOutput{FilePath: "hash", Md5Hash: "00", Xxh64Hash: "11", Size: 1},
+		FileInfoOutput{FilePath: "both", Md5Hash: "11", Xxh64Hash: "00", Size: 2},
+		FileInfoOutput{FilePath: "added", Md5Hash: "00", Xxh64Hash: "00", Size: 1},
+	)
+	oldMap := make(map[string]FileInfoOutput)
+	newMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(oldPkg, oldMap); err != nil {
+		t.Fatal(err)
+	}
+	if err := readPkgFile(newPkg, newMap); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		opts     DiffOptions
+		expected []string
+	}{
+		{DiffOptions{}, []string{"added", "both", "hash", "removed", "size"}},
+		{DiffOptions{IgnoreSize: true}, []string{"added", "both", "hash", "removed"}},
+		{DiffOptions{IgnoreHash: true}, []string{"added", "both", "removed", "size"}},
+		{DiffOptions{IgnoreSize: true, IgnoreHash: true}, []string{"added", "removed"}},
+	}
+	for _, tc := range testCases {
+		var got []string
+		for _, diff := range diffManifests(oldMap, newMap, tc.opts) {
+			got = append(got, diff.FilePath)
+		}
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("%+v: expected %v, got %v", tc.opts, tc.expected, got)
+		}
+	}
+}
+
+func TestDiffManifestsDifferentHashSets(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "alpha", "dir/b": "bravo", "dir/c": "charlie"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+	bothMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(manifest, bothMap); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same tree with md5 only, as if dumped by a tool that doesn't know xxh64.
+	md5Map := make(map[string]FileInfoOutput)
+	for filePath, entry := range bothMap {
+		entry.Xxh64Hash = ""
+		md5Map[filePath] = entry
+	}
+	if diffs := diffManifests(bothMap, md5Map, DiffOptions{}); len(diffs) != 0 {
+		t.Errorf("Expected no differences on the common md5, got %+v", diffs)
+	}
+
+	// A content change is still seen on the common hash, and size is all that's left without one.
+	changed := md5Map["dir/b"]
+	changed.Md5Hash = bothMap["a"].Md5Hash
+	md5Map["dir/b"] = changed
+	noHashMap := maps.Clone(md5Map)
+	sizeOnly := noHashMap["dir/c"]
+	sizeOnly.Md5Hash = ""
+	noHashMap["dir/c"] = sizeOnly
+	noHashMap["a"] = FileInfoOutput{FilePath: "a", Size: bothMap["a"].Size + 1}
+	got := diffManifests(bothMap, noHashMap, DiffOptions{})
+	expected := []ManifestDiff{
+		{FilePath: "a", Kind: DK_Changed, SizeChanged: true},
+		{FilePath: "dir/b", Kind: DK_Changed, HashChanged: true},
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestDiffDirs(t *testing.T) {
+	a := t.TempDir()
+	b := t.TempDir()
+	writeTree(t, a, map[string]string{
+		"same":          "same",
+		"dir/same":      "same",
+		"dir/changed":   "old",
+		"resized":       "old",
+		"dir/removed":   "removed",
+		"other/removed": "removed",
+	})
+	writeTree(t, b, map[string]string{
+		"same":        "same",
+		"dir/same":    "same",
+		"dir/changed": "new",
+		"resized":     "older",
+		"dir/added":   "added",
+	})
+
+	added, removed, changed, err := DiffDirs(a, b, 4)
+	if err != nil {
+		t.Fatalf("DiffDirs failed: %v", err)
+	}
+	if expected := []string{"dir/added"}; !slices.Equal(added, expected) {
+		t.Errorf("Expected added %v, got %v", expected, added)
+	}
+	if expected := []string{"dir/removed", "other/removed"}; !slices.Equal(removed, expected) {
+		t.Errorf("Expected removed %v, got %v", expected, removed)
+	}
+	if expected := []string{"dir/changed", "resized"}; !slices.Equal(changed, expected) {
+		t.Errorf("Expected changed %v, got %v", expected, changed)
+	}
+
+	if _, _, _, err := DiffDirs(a, filepath.Join(b, "missing"), 4); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
+
+func TestManifestsIdentical(t *testing.T) {
+	var entries []FileInfoOutput
+	for i := range 1000 {
+		entries = append(entries, FileInfoOutput{FilePath: fmt.Sprintf("dir%d/file%d", i%10, i), Md5Hash: fmt.Sprintf("%032x", i), Xxh64Hash: fmt.Sprintf("%016x", i), Size: int64(i)})
+	}
+	toMap := func(entries []FileInfoOutput) map[string]FileInfoOutput {
+		m := make(map[string]FileInfoOutput)
+		for _, entry := range entries {
+			m[entry.FilePath] = entry
+		}
+		return m
+	}
+	oldMap := toMap(entries)
+
+	// The same entries written in another order are identical.
+	reversed := slices.Clone(entries)
+	slices.Reverse(reversed)
+	if !manifestsIdentical(oldMap, toMap(reversed)) {
+		t.Errorf("Expected manifests with the same entries to be identical")
+	}
+
+	// A single byte differs in one hash.
+	changed := slices.Clone(entries)
+	changed[500].Md5Hash = changed[500].Md5Hash[:31] + "f"
+	newMap := toMap(changed)
+	if manifestsIdentical(oldMap, newMap) {
+		t.Errorf("Expected manifests differing by one byte to differ")
+	}
+	if diffs := diffManifests(oldMap, newMap, DiffOptions{}); len(diffs) != 1 || diffs[0].FilePath != changed[500].FilePath {
+		t.Errorf("Expected the detailed diff to report %s, got %+v", changed[500].FilePath, diffs)
+	}
+
+	// Moving bytes between fields changes the digest.
+	shifted := map[string]FileInfoOutput{"ab": {FilePath: "ab", Md5Hash: "c"}}
+	unshifted := map[string]FileInfoOutput{"a": {FilePath: "a", Md5Hash: "bc"}}
+	if manifestsIdentical(shifted, unshifted) {
+		t.Errorf("Expected entries with different field boundaries to differ")
+	}
+
+	// Directory entries only differing in their rolled-up hash differ, as diffManifests compares it.
+	oldDir := map[string]FileInfoOutput{"dir": {FilePath: "dir", IsDir: true, DirHash: fmt.Sprintf("%032x", 1)}}
+	newDir := map[string]FileInfoOutput{"dir": {FilePath: "dir", IsDir: true, DirHash: fmt.Sprintf("%032x", 2)}}
+	if manifestsIdentical(oldDir, newDir) {
+		t.Errorf("Expected directory entries with different dir hashes to differ")
+	}
+	if diffs := diffManifests(oldDir, newDir, DiffOptions{}); len(diffs) != 1 || !diffs[0].HashChanged {
+		t.Errorf("Expected the detailed diff to report the changed dir hash, got %+v", diffs)
+	}
+}
+
+func TestFilesIdentical(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// Not a manifest at all: identical files are settled without being parsed.
+	old := write("old.jsonl", "not json\n")
+	same := write("same.jsonl", "not json\n")
+	oneByte := write("byte.jsonl", "not jsoN\n")
+	longer := write("longer.jsonl", "not json\n\n")
+
+	for path, expected := range map[string]bool{same: true, oneByte: false, longer: false} {
+		if identical, err := filesIdentical(old, path); err != nil || identical != expected {
+			t.Errorf("%s: expected identical %t, got %t (err: %v)", filepath.Base(path), expected, identical, err)
+		}
+	}
+	if _, err := filesIdentical(old, filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDiffFiles(Config{}, &DiffFilesCmd{OldPkgFile: old, NewPkgFile: same})
+	})
+}
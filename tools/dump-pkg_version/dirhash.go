@@ -0,0 +1,78 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+)
+
+// dirChild is a direct child of a directory, as it enters the hash of the directory.
+type dirChild struct {
+	name   string // Base name, with a trailing slash for a subdirectory
+	digest string // Hex hash of a file or subdirectory, or the target of a link
+}
+
+// dirHashEntries returns a directory entry for every directory holding files, the root excluded, whose DirHash is an
+// XXH3 of the sorted (name, hash) pairs of its children: its files by xxh64, its links by target and its
+// subdirectories by their own DirHash. A change to a file thus changes the hashes of its ancestors only. Directories
+// without any file below them have no entry.
+func dirHashEntries(files []FileInfo) []FileInfo {
+	children := make(map[string][]dirChild)
+	dirs := make(map[string]struct{})
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		digest := hex.EncodeToString(file.Xxh64Hash)
+		if file.IsSymlink {
+			digest = "->" + file.LinkTarget
+		}
+		dir := path.Dir(file.FilePath)
+		children[dir] = append(children[dir], dirChild{path.Base(file.FilePath), digest})
+		// Every ancestor is known, so each is hashed even when it only holds subdirectories.
+		for ancestor := dir; ancestor != "."; ancestor = path.Dir(ancestor) {
+			dirs[ancestor] = struct{}{}
+		}
+	}
+
+	// The deepest directories are hashed first, so every subdirectory is done before its parent.
+	sorted := slices.SortedFunc(maps.Keys(dirs), func(a, b string) int {
+		return cmp.Or(cmp.Compare(strings.Count(b, "/"), strings.Count(a, "/")), cmp.Compare(a, b))
+	})
+	var entries []FileInfo
+	for _, dir := range sorted {
+		slices.SortFunc(children[dir], func(a, b dirChild) int { return cmp.Compare(a.name, b.name) })
+		h := xxh3.New()
+		for _, child := range children[dir] {
+			h.WriteString(child.name + "\x00" + child.digest + "\n")
+		}
+		entry := FileInfo{FilePath: dir, IsDir: true, DirHash: h.Sum(nil)}
+		entries = append(entries, entry)
+		parent := path.Dir(dir)
+		children[parent] = append(children[parent], dirChild{path.Base(dir) + "/", hex.EncodeToString(entry.DirHash)})
+	}
+	return entries
+}
+
+// withDirHashes passes the entries of results on, then the entries of dirHashEntries once results is closed.
+func withDirHashes(results <-chan FileInfo) <-chan FileInfo {
+	out := make(chan FileInfo, cap(results))
+	go func() {
+		defer close(out)
+		var files []FileInfo
+		for result := range results {
+			files = append(files, FileInfo{FilePath: result.FilePath, Xxh64Hash: result.Xxh64Hash,
+				IsDir: result.IsDir, IsSymlink: result.IsSymlink, LinkTarget: result.LinkTarget})
+			out <- result
+		}
+		for _, entry := range dirHashEntries(files) {
+			out <- entry
+		}
+	}()
+	return out
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDumpDirHashes(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"a/b/c.txt": "c", "a/b/d.txt": "d", "a/e.txt": "e", "f/g.txt": "g", "f/h/i.txt": "i", "top.txt": "top",
+	})
+	dump := func() map[string]FileInfoOutput {
+		manifest := filepath.Join(t.TempDir(), "package.jsonl")
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, DirHashes: true})
+		})
+		// The manifest is read like verify and difffiles do.
+		pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+		if err != nil {
+			t.Fatalf("Failed to read manifest: %v", err)
+		}
+		return pkgMap
+	}
+
+	before := dump()
+	var dirs []string
+	for name, entry := range before {
+		if entry.IsDir {
+			dirs = append(dirs, name)
+		}
+	}
+	if slices.Sort(dirs); !slices.Equal(dirs, []string{"a", "a/b", "f", "f/h"}) {
+		t.Fatalf("Expected entries for a, a/b, f and f/h, got %v", dirs)
+	}
+	if a := before["a"]; a.DirHash == "" || a.Size != 0 || a.Md5Hash != "" || a.Xxh64Hash != "" {
+		t.Errorf("Expected a to only have a dir hash, got %+v", a)
+	}
+	if again := dump(); again["a/b"].DirHash != before["a/b"].DirHash {
+		t.Errorf("Expected the same hashes for the same tree, got %s then %s", before["a/b"].DirHash, again["a/b"].DirHash)
+	}
+
+	// Only the ancestors of the changed file change, not even its siblings' directories.
+	writeTree(t, inputDir, map[string]string{"a/b/c.txt": "C"})
+	after := dump()
+	for dir, changed := range map[string]bool{"a/b": true, "a": true, "f": false, "f/h": false} {
+		if (after[dir].DirHash != before[dir].DirHash) != changed {
+			t.Errorf("%s: expected changed %v, got %s then %s", dir, changed, before[dir].DirHash, after[dir].DirHash)
+		}
+	}
+
+	// Diffing the manifests tells the changed directories along with the file.
+	var changed []string
+	for _, diff := range diffManifests(before, after, DiffOptions{}) {
+		changed = append(changed, diff.FilePath)
+	}
+	if slices.Sort(changed); !slices.Equal(changed, []string{"a", "a/b", "a/b/c.txt"}) {
+		t.Errorf("Expected a, a/b and a/b/c.txt to differ, got %v", changed)
+	}
+}
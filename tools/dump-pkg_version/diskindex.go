@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// diskIndexRunSize is the number of manifest entries sorted in memory at once while building a disk index.
+// It is a variable so tests can exercise merging many runs.
+var diskIndexRunSize = 256 * 1024
+
+// diskIndex is a manifest kept in a temporary file instead of a map. Entries are sorted by parent directory
+// then name, and deduplicated so that, like readPkgFiles, later pkg files override earlier ones.
+type diskIndex struct {
+	dir   string // Temporary directory holding the index files
+	path  string // Sorted, deduplicated manifest, in the pkg file format
+	count int    // Number of entries in the index
+}
+
+// diskIndexRecord is a manifest entry tagged with its position among all the entries read, as written to sorted runs.
+type diskIndexRecord struct {
+	Seq   int64          `json:"seq"`
+	Entry FileInfoOutput `json:"entry"`
+}
+
+// diskIndexKey orders entries by parent directory then name, so the entries of a directory are contiguous.
+// The separator sorts before any path character, keeping "a/b" directly after "a" entries and never in between.
+func diskIndexKey(filePath string) string {
+	return path.Dir(filePath) + "\x00" + path.Base(filePath)
+}
+
+// compareDiskIndexRecords orders records by key, then by the order they were read in.
+func compareDiskIndexRecords(a, b diskIndexRecord) int {
+	return cmp.Or(cmp.Compare(diskIndexKey(a.Entry.FilePath), diskIndexKey(b.Entry.FilePath)), cmp.Compare(a.Seq, b.Seq))
+}
+
+// buildDiskIndex reads the same pkg files as readPkgFiles into a new disk index, using an external merge sort
+// so that at most diskIndexRunSize entries are held in memory. The index must be closed to remove its files.
+func buildDiskIndex(inputDir string, pkgFiles []string, checkInputDirForPkg bool) (*diskIndex, error) {
+	if checkInputDirForPkg {
+		found, err := pkgFilesInDir(inputDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan input directory for pkg files: %w", err)
+		}
+		// Same order as readPkgFiles: the pkg files of the input directory are read first.
+		pkgFiles = append(found, pkgFiles...)
+	}
+
+	dir, err := os.MkdirTemp("", "dump-pkg_version-index-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk index directory: %w", err)
+	}
+	index := &diskIndex{dir: dir, path: filepath.Join(dir, "index.jsonl")}
+
+	// Sort fixed-size runs of entries in memory and write each one to its own file.
+	var runs []string
+	var batch []diskIndexRecord
+	var seq int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		slices.SortFunc(batch, compareDiskIndexRecords)
+		runPath := filepath.Join(dir, fmt.Sprintf("run%d.jsonl", len(runs)))
+		if err := writeDiskIndexRun(runPath, batch); err != nil {
+			return err
+		}
+		runs = append(runs, runPath)
+		batch = batch[:0]
+		return nil
+	}
+	for _, pkgFile := range pkgFiles {
+		err := eachPkgLine(pkgFile, func(entry FileInfoOutput) error {
+			batch = append(batch, diskIndexRecord{Seq: seq, Entry: entry})
+			seq++
+			if len(batch) >= diskIndexRunSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			index.Close()
+			return nil, fmt.Errorf("error processing pkg file %s: %w", pkgFile, err)
+		}
+	}
+	if err := flush(); err != nil {
+		index.Close()
+		return nil, err
+	}
+	batch = nil
+
+	if err := index.merge(runs); err != nil {
+		index.Close()
+		return nil, err
+	}
+	for _, run := range runs {
+		os.Remove(run) // The merged index replaces the runs
+	}
+	return index, nil
+}
+
+// writeDiskIndexRun writes sorted records to a run file, one JSON line per record.
+func writeDiskIndexRun(runPath string, records []diskIndexRecord) error {
+	out, err := os.Create(runPath)
+	if err != nil {
+		return fmt.Errorf("failed to create disk index run %s: %w", runPath, err)
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to write disk index run %s: %w", runPath, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write disk index run %s: %w", runPath, err)
+	}
+	return out.Close()
+}
+
+// merge merges the sorted runs into the index file, keeping only the last record read for every path.
+func (index *diskIndex) merge(runs []string) error {
+	out, err := os.Create(index.path)
+	if err != nil {
+		return fmt.Errorf("failed to create disk index %s: %w", index.path, err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+
+	err = mergeDiskIndexRuns(runs, func(entry FileInfoOutput) error {
+		index.count++
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("failed to write disk index %s: %w", index.path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write disk index %s: %w", index.path, err)
+	}
+	return out.Close()
+}
+
+// mergeDiskIndexRuns merges the sorted runs, calling emit with every entry in disk index order and only the last
+// record read for every path.
+func mergeDiskIndexRuns(runs []string, emit func(FileInfoOutput) error) error {
+	readers := &diskIndexRunHeap{}
+	for _, run := range runs {
+		reader, err := openDiskIndexRun(run)
+		if err != nil {
+			return err
+		}
+		defer reader.file.Close()
+		if ok, err := reader.next(); err != nil {
+			return err
+		} else if ok {
+			readers.runs = append(readers.runs, reader)
+		}
+	}
+	heap.Init(readers)
+
+	// Records of the same path come out consecutively, oldest first, so the last one seen wins.
+	var pending *FileInfoOutput
+	for readers.Len() > 0 {
+		reader := readers.runs[0]
+		entry := reader.current.Entry
+		if pending != nil && pending.FilePath != entry.FilePath {
+			if err := emit(*pending); err != nil {
+				return err
+			}
+		}
+		pending = &entry
+
+		ok, err := reader.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(readers, 0)
+		} else {
+			heap.Pop(readers)
+		}
+	}
+	if pending != nil {
+		return emit(*pending)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the index.
+func (index *diskIndex) Len() int {
+	return index.count
+}
+
+// Each calls fn with every entry of the index, in index order, reading them from disk.
+func (index *diskIndex) Each(fn func(FileInfoOutput) error) error {
+	return eachPkgLine(index.path, fn)
+}
+
+// Close removes the files of the index.
+func (index *diskIndex) Close() error {
+	return os.RemoveAll(index.dir)
+}
+
+// diskIndexRunReader reads the records of a run file one at a time.
+type diskIndexRunReader struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	current diskIndexRecord // Last record read
+}
+
+func openDiskIndexRun(runPath string) (*diskIndexRunReader, error) {
+	file, err := os.Open(runPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk index run %s: %w", runPath, err)
+	}
+	return &diskIndexRunReader{file: file, scanner: bufio.NewScanner(file)}, nil
+}
+
+// next reads the following record into current, returning false at the end of the run.
+func (reader *diskIndexRunReader) next() (bool, error) {
+	if !reader.scanner.Scan() {
+		if err := reader.scanner.Err(); err != nil {
+			return false, fmt.Errorf("failed to read disk index run %s: %w", reader.file.Name(), err)
+		}
+		return false, nil
+	}
+	reader.current = diskIndexRecord{}
+	if err := json.Unmarshal(reader.scanner.Bytes(), &reader.current); err != nil {
+		return false, fmt.Errorf("failed to parse disk index run %s: %w", reader.file.Name(), err)
+	}
+	return true, nil
+}
+
+// diskIndexRunHeap orders run readers by their current record, for the k-way merge.
+type diskIndexRunHeap struct {
+	runs []*diskIndexRunReader
+}
+
+func (h diskIndexRunHeap) Len() int { return len(h.runs) }
+
+func (h diskIndexRunHeap) Less(i, j int) bool {
+	return compareDiskIndexRecords(h.runs[i].current, h.runs[j].current) < 0
+}
+
+func (h diskIndexRunHeap) Swap(i, j int) {
+	h.runs[i], h.runs[j] = h.runs[j], h.runs[i]
+}
+
+func (h *diskIndexRunHeap) Push(x any) {
+	h.runs = append(h.runs, x.(*diskIndexRunReader))
+}
+
+func (h *diskIndexRunHeap) Pop() any {
+	n := len(h.runs)
+	x := h.runs[n-1]
+	h.runs = h.runs[:n-1]
+	return x
+}
+
+// verifyDiskIndex compares the entries of a disk index against the files on disk. Entries are streamed
+// from the index, so only those in flight are held in memory. The returned channel is closed once every entry has been compared.
+func verifyDiskIndex(threads int, basedir string, index *diskIndex, statOnly bool, toFileInfo func(FileInfoOutput) FileInfo, opts compareOptions) <-chan FileCompareResult {
+	if statOnly {
+		// The index keeps the entries of a directory together, so each group is complete once the directory changes.
+		groups := make(chan []FileInfo, threads)
+		go func() {
+			defer close(groups)
+			var group []FileInfo
+			err := index.Each(func(entry FileInfoOutput) error {
+				file := toFileInfo(entry)
+				if len(group) > 0 && path.Dir(group[0].FilePath) != path.Dir(file.FilePath) {
+					groups <- group
+					group = nil
+				}
+				group = append(group, file)
+				return nil
+			})
+			if err != nil {
+				log.Panic().Err(err).Msg("Failed to read disk index")
+			}
+			if len(group) > 0 {
+				groups <- group
+			}
+		}()
+		return compareDirGroups(threads, basedir, groups)
+	}
+
+	workQueue := make(chan FileInfo, threads) // Work queue, kept small so the index is read as files are compared
+	go func() {
+		defer close(workQueue)
+		err := index.Each(func(entry FileInfoOutput) error {
+			workQueue <- toFileInfo(entry)
+			return nil
+		})
+		if err != nil {
+			log.Panic().Err(err).Msg("Failed to read disk index")
+		}
+	}()
+	return compareFiles(threads, basedir, workQueue, opts)
+}
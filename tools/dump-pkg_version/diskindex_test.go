@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// withDiskIndexRunSize lowers the run size for the duration of a test, so small manifests span many runs.
+func withDiskIndexRunSize(t testing.TB, size int) {
+	previous := diskIndexRunSize
+	diskIndexRunSize = size
+	t.Cleanup(func() { diskIndexRunSize = previous })
+}
+
+func TestDiskIndexMatchesPkgMap(t *testing.T) {
+	withDiskIndexRunSize(t, 3)
+
+	oldPkg := writeManifest(t,
+		FileInfoOutput{FilePath: "a/b/c", Md5Hash: "01", Xxh64Hash: "01", Size: 1},
+		FileInfoOutput{FilePath: "a/x", Md5Hash: "02", Xxh64Hash: "02", Size: 2},
+		FileInfoOutput{FilePath: "root", Md5Hash: "03", Xxh64Hash: "03", Size: 3},
+		FileInfoOutput{FilePath: "a/b/c", Md5Hash: "04", Xxh64Hash: "04", Size: 4}, // Overrides an earlier line
+		FileInfoOutput{FilePath: "a/z", Md5Hash: "05", Xxh64Hash: "05", Size: 5},
+	)
+	newPkg := writeManifest(t,
+		FileInfoOutput{FilePath: "a/x", Md5Hash: "06", Xxh64Hash: "06", Size: 6}, // Overrides the old pkg file
+		FileInfoOutput{FilePath: "a/b/d", Md5Hash: "07", Xxh64Hash: "07", Size: 7},
+		FileInfoOutput{FilePath: "a/y", Md5Hash: "08", Xxh64Hash: "08", Size: 8},
+	)
+
+	expected, err := readPkgFiles("", []string{oldPkg, newPkg}, false)
+	if err != nil {
+		t.Fatalf("Failed to read pkg files: %v", err)
+	}
+	index, err := buildDiskIndex("", []string{oldPkg, newPkg}, false)
+	if err != nil {
+		t.Fatalf("Failed to build disk index: %v", err)
+	}
+	defer index.Close()
+
+	got := make(map[string]FileInfoOutput)
+	var dirs []string
+	err = index.Each(func(entry FileInfoOutput) error {
+		if _, ok := got[entry.FilePath]; ok {
+			t.Errorf("%s appears twice in the index", entry.FilePath)
+		}
+		got[entry.FilePath] = entry
+		if dir := path.Dir(entry.FilePath); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read disk index: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if index.Len() != len(expected) {
+		t.Errorf("Expected %d entries, got %d", len(expected), index.Len())
+	}
+	// Every directory must form a single contiguous group.
+	if len(dirs) != 3 {
+		t.Errorf("Expected 3 contiguous directories, got %v", dirs)
+	}
+}
+
+func TestVerifyDiskIndex(t *testing.T) {
+	withDiskIndexRunSize(t, 4)
+
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 20 {
+		files[fmt.Sprintf("dir%d/sub/file%d", i%3, i)] = fmt.Sprintf("content %d", i)
+		files[fmt.Sprintf("dir%d/file%d", i%3, i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, inputDir, files)
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+
+	// One file changes content with the same size, another changes size, a third goes missing.
+	writeTree(t, inputDir, map[string]string{"dir0/file0": "CONTENT 0", "dir1/sub/file1": "longer content 1"})
+	if err := os.Remove(filepath.Join(inputDir, "dir2", "file2")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	index, err := buildDiskIndex(inputDir, []string{manifest}, false)
+	if err != nil {
+		t.Fatalf("Failed to build disk index: %v", err)
+	}
+	defer index.Close()
+	toFileInfo := func(v FileInfoOutput) FileInfo {
+		return FileInfo{FilePath: v.FilePath, Md5Hash: decodeHex(v.Md5Hash), Xxh64Hash: decodeHex(v.Xxh64Hash), Size: v.Size}
+	}
+
+	var counts map[CompareResult]int
+	runWithTimeout(t, 10*time.Second, func() {
+		counts = reportResults(verifyDiskIndex(2, inputDir, index, false, toFileInfo, compareOptions{})).Counts()
+	})
+	expected := map[CompareResult]int{CR_Same: 37, CR_Md5Dif: 1, CR_SizeLarger: 1, CR_NotExist: 1}
+	if !maps.Equal(counts, expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+
+	runWithTimeout(t, 10*time.Second, func() {
+		counts = reportResults(verifyDiskIndex(2, inputDir, index, true, toFileInfo, compareOptions{})).Counts()
+	})
+	expected = map[CompareResult]int{CR_Same: 38, CR_SizeLarger: 1, CR_NotExist: 1}
+	if !maps.Equal(counts, expected) {
+		t.Errorf("Expected %v with --stat-only, got %v", expected, counts)
+	}
+}
+
+// BenchmarkManifestMemory compares the heap kept alive by the in-memory map and by a disk index
+// for a synthetic manifest, reported as the heap-MB metric.
+func BenchmarkManifestMemory(b *testing.B) {
+	const entries = 500_000
+	manifest := filepath.Join(b.TempDir(), "package.jsonl")
+	results := make(chan FileInfo, 1024)
+	go func() {
+		defer close(results)
+		hash := make([]byte, 16)
+		for i := range entries {
+			results <- FileInfo{FilePath: fmt.Sprintf("dir%d/sub%d/file%d.bin", i%100, i%7, i), Md5Hash: hash, Xxh64Hash: hash[:8], Size: int64(i)}
+		}
+	}()
+	pkgOutWriter(manifest, results, entryFormat{}, false)
+
+	heapInUse := func() float64 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.HeapInuse) / (1 << 20)
+	}
+
+	b.Run("map", func(b *testing.B) {
+		for b.Loop() {
+			before := heapInUse()
+			pkgMap, err := readPkgFiles("", []string{manifest}, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(heapInUse()-before, "heap-MB")
+			runtime.KeepAlive(pkgMap)
+		}
+	})
+	b.Run("disk-index", func(b *testing.B) {
+		withDiskIndexRunSize(b, 64*1024)
+		for b.Loop() {
+			before := heapInUse()
+			index, err := buildDiskIndex("", []string{manifest}, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(heapInUse()-before, "heap-MB")
+			index.Close()
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"example/hello/hyapi"
+	"resty.dev/v3"
+)
+
+// mirrorDownloader fetches the content of manifest entries for mirror --download, each from its remote name below
+// a base URL.
+type mirrorDownloader struct {
+	client  *resty.Client
+	policy  hyapi.RetryPolicy
+	baseURL string
+	sparse  bool // Whether the files recorded as sparse are written with holes for their blocks of zeros
+}
+
+// fileURL returns the URL of the content of the entry at filePath, each segment of its remote name escaped.
+func (d *mirrorDownloader) fileURL(filePath string) (string, error) {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return url.JoinPath(d.baseURL, segments...)
+}
+
+// download fetches the content of file into destPath, retrying as the policy says. The content is written to
+// destPath+".part" and checked against the recorded size and hashes while it is written, and only renamed to
+// destPath once it matches, so destPath never holds a partial or corrupted file.
+func (d *mirrorDownloader) download(file FileInfoOutput, destPath string) error {
+	fileURL, err := d.fileURL(file.FilePath)
+	if err != nil {
+		return fmt.Errorf("invalid URL for %s: %w", file.FilePath, err)
+	}
+	partPath := destPath + ".part"
+	err = hyapi.ExecuteWithRetry(d.policy, func(int) error {
+		return d.fetch(fileURL, partPath, file)
+	})
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, destPath)
+}
+
+// fetch downloads fileURL into path once, hashing the content with a HashingWriter while it is written, so it is
+// never read back, then checks it against file. A previous attempt at path is overwritten. A sparse file is written
+// by copySparse, which seeks over its blocks of zeros, the HashingWriter then hashing the content as it is read.
+func (d *mirrorDownloader) fetch(fileURL string, path string, file FileInfoOutput) error {
+	resp, err := d.client.R().
+		SetDoNotParseResponse(true). // Stream the body to disk instead of buffering it in memory
+		Get(fileURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode() != 200 {
+		return &hyapi.StatusError{URL: fileURL, Code: resp.StatusCode()}
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	sparse := d.sparse && file.Sparse
+	var w io.Writer = out
+	if sparse {
+		w = io.Discard // copySparse writes to out
+	}
+	hw := getHashingWriter(w)
+	defer putHashingWriter(hw)
+	hw.SetSeed(file.XxhSeed) // Pooled writers keep the seed of their last file
+	for name := range file.Hashes {
+		if err := hw.AddHasher(name); err != nil {
+			return fmt.Errorf("cannot check %s: %w", file.FilePath, err)
+		}
+	}
+	if sparse {
+		_, err = copySparse(out, io.TeeReader(resp.Body, hw))
+	} else {
+		_, err = io.Copy(hw, resp.Body)
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+	return checkDownloaded(file, hw.Result())
+}
+
+// checkDownloaded compares the size and hashes of downloaded content against the manifest entry it was fetched for.
+// Only the hashes recorded in the entry are compared.
+func checkDownloaded(file FileInfoOutput, got FileInfo) error {
+	if got.Size != file.Size {
+		return fmt.Errorf("downloaded %s has %d bytes, expected %d", file.FilePath, got.Size, file.Size)
+	}
+	expected := entryHashes(file)
+	delete(expected, "dir") // Only recorded for directories
+	actual := map[string][]byte{hasherMD5: got.Md5Hash, hasherXXH64: got.Xxh64Hash}
+	maps.Copy(actual, got.Hashes)
+	for _, name := range slices.Sorted(maps.Keys(expected)) {
+		if hash, actualHash := expected[name], hex.EncodeToString(actual[name]); !strings.EqualFold(actualHash, hash) {
+			return fmt.Errorf("downloaded %s has %s hash %s, expected %s", file.FilePath, name, actualHash, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example/hello/hyapi"
+)
+
+func TestMirrorDownload(t *testing.T) {
+	inputDir := t.TempDir()
+	files := map[string]string{
+		"root.bin":           "root content",
+		"dir/sub/file.bin":   strings.Repeat("file content ", 1000),
+		"dir/50% off #1.txt": "escaped name",
+	}
+	writeTree(t, inputDir, files)
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, XxhSeed: 42})
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(inputDir)))
+	defer server.Close()
+
+	outputDir, sidecarDir := t.TempDir(), t.TempDir()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(Config{Workers: 2}, &MirrorCmd{OutputDir: outputDir, PkgFiles: []string{manifest}, SidecarDir: sidecarDir, Download: server.URL})
+	})
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(name)))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: expected the served content, got %q (err: %v)", name, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(name)) + ".part"); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no partial file left, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(sidecarDir, filepath.FromSlash(name)) + ".json"); err != nil {
+			t.Errorf("%s: expected a sidecar, got %v", name, err)
+		}
+	}
+}
+
+func TestMirrorDownloaderCheck(t *testing.T) {
+	const content = "expected content"
+	info, err := hashReader(strings.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := toFileInfoOutput(info, entryFormat{})
+	entry.FilePath = "file.bin"
+
+	// The first request fails with a temporary status, then the content is served, corrupted or not.
+	var requests atomic.Int64
+	var served atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(served.Load().(string)))
+	}))
+	defer server.Close()
+	client := newPkgClient(0)
+	defer client.Close()
+	downloader := &mirrorDownloader{client: client, policy: hyapi.NewRetryPolicy(1, time.Millisecond), baseURL: server.URL}
+
+	dir := t.TempDir()
+	served.Store(content)
+	if err := downloader.download(entry, filepath.Join(dir, "good.bin")); err != nil {
+		t.Fatalf("Expected the download to succeed once retried, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "good.bin")); err != nil || string(data) != content {
+		t.Errorf("Expected the served content, got %q (err: %v)", data, err)
+	}
+
+	// Content of the right size but another hash is rejected, and nothing is left behind.
+	requests.Store(0)
+	served.Store(strings.ToUpper(content))
+	err = downloader.download(entry, filepath.Join(dir, "bad.bin"))
+	if err == nil || !strings.Contains(err.Error(), "md5 hash") {
+		t.Errorf("Expected a hash mismatch error, got %v", err)
+	}
+	for _, name := range []string{"bad.bin", "bad.bin.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s after a mismatch, got %v", name, err)
+		}
+	}
+}
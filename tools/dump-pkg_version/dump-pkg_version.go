@@ -1,15 +1,18 @@
 package main
 
 import (
-	"crypto/md5"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/zeebo/xxh3"
 )
 
 // FileInfo holds metadata about a file.
@@ -19,47 +22,226 @@ import (
 // struct field names follow Go naming conventions (CamelCase)
 // but are mapped to the requested JSON field names using `json:"..."` tags.
 type FileInfo struct {
-	FilePath  string // Relative path of the file from the input directory
-	Md5Hash   []byte // MD5 hash as a byte slice
-	Xxh64Hash []byte // XXH64 hash as a byte slice
-	Size      int64  // Size of the file in bytes
+	FilePath    string            // Relative path of the file from the input directory
+	Md5Hash     []byte            // MD5 hash as a byte slice
+	Xxh64Hash   []byte            // XXH64 hash as a byte slice
+	Size        int64             // Size of the file in bytes
+	Uid         *uint32           // Owner user id, nil when not recorded
+	Gid         *uint32           // Owner group id, nil when not recorded
+	Fingerprint []byte            // Head/tail fingerprint as a byte slice, nil when not recorded
+	Sparse      bool              // Whether the file has holes
+	IsDir       bool              // Whether the entry is a directory, which has no content
+	Mode        uint32            // Permission bits, only recorded for directories
+	ModTime     time.Time         // Modification time, zero when not recorded
+	Xattrs      map[string][]byte // Extended attributes, nil when not recorded
+	IsSymlink   bool              // Whether the entry is a symbolic link recorded as a link, hashes and size are those of its target string
+	LinkTarget  string            // Target of a symbolic link recorded as a link, "" otherwise
+	Hashes      map[string][]byte // Hashes of the registered algorithms selected with --hash, by name, nil when none
+	BirthTime   time.Time         // Birth time, zero when not recorded
+	HeaderHash  []byte            // Hash of the first HeaderBytes bytes, nil when not recorded
+	HeaderBytes int64             // Number of bytes covered by HeaderHash
+	DirHash     []byte            // Hash rolling up everything below a directory entry, nil when not recorded
 }
 
 // FileInfoOutput is a struct specifically for the JSON output format.
 // It contains the same information as FileInfo, but the hash values are stored as strings
 // to be directly included in the JSON output.
 type FileInfoOutput struct {
-	FilePath  string `json:"remoteName"` // Path of the file, relative to the input directory
-	Md5Hash   string `json:"md5"`        // MD5 hash of the file as a hexadecimal string
-	Xxh64Hash string `json:"hash"`       // XXH64 hash of the file as a hexadecimal string
-	Size      int64  `json:"fileSize"`   // Size of the file in bytes
+	Version     int               `json:"v,omitempty"`           // Version of the format of the line, first so readers can branch on it, 1 when absent (optional)
+	FilePath    string            `json:"remoteName"`            // Path of the file, relative to the input directory
+	Md5Hash     string            `json:"md5"`                   // MD5 hash of the file as a hexadecimal string
+	Xxh64Hash   string            `json:"hash"`                  // XXH64 hash of the file as a hexadecimal string
+	Size        int64             `json:"fileSize"`              // Size of the file in bytes
+	Uid         *uint32           `json:"uid,omitempty"`         // Owner user id (Unix only, optional)
+	Gid         *uint32           `json:"gid,omitempty"`         // Owner group id (Unix only, optional)
+	Fingerprint string            `json:"fingerprint,omitempty"` // Head/tail fingerprint as a hexadecimal string (optional)
+	Sparse      bool              `json:"sparse,omitempty"`      // Whether the file has holes (Unix only, optional)
+	IsDir       bool              `json:"dir,omitempty"`         // Whether the entry is a directory, with no size nor hashes (optional)
+	Mode        uint32            `json:"mode,omitempty"`        // Permission bits of a directory entry (optional)
+	ModTime     ManifestTime      `json:"mtime,omitzero"`        // Modification time in Unix nanoseconds or as an RFC 3339 string (optional)
+	Xattrs      map[string]string `json:"xattrs,omitempty"`      // Extended attributes, base64-encoded values by name (optional)
+	IsSymlink   bool              `json:"symlink,omitempty"`     // Whether the entry is a symbolic link, hashed as its target string (optional)
+	LinkTarget  string            `json:"target,omitempty"`      // Target of a symbolic link entry, verbatim (optional)
+	Hashes      map[string]string `json:"hashes,omitempty"`      // Hashes of additional registered algorithms, hexadecimal strings by name (optional)
+	BirthTime   ManifestTime      `json:"btime,omitzero"`        // Birth time like mtime, where the platform records it (optional)
+	HeaderHash  string            `json:"headerHash,omitempty"`  // XXH64 of the first headerBytes bytes of the file as a hexadecimal string (optional)
+	HeaderBytes int64             `json:"headerBytes,omitempty"` // Number of bytes covered by headerHash (optional)
+	DirHash     string            `json:"dirHash,omitempty"`     // XXH3 of the names and hashes below a directory entry as a hexadecimal string, see --dir-hashes (optional)
+	XxhSeed     uint64            `json:"xxhSeed,omitempty"`     // Seed the xxh64 hash was computed with, see --xxh-seed, unseeded when absent (optional)
+
+	aliases bool // Whether the entry is written with the keys of the newer schema too, see MarshalJSON
 }
 
 // Args is the main struct that defines the top-level commands and global options.
 type Args struct {
-	Threads int        `arg:"-w,--workers" default:"2" help:"Number of worker goroutines for hashing"`
-	Dump    *DumpCmd   `arg:"subcommand:dump"`
-	Verify  *VerifyCmd `arg:"subcommand:verify"`
-	Mirror  *MirrorCmd `arg:"subcommand:mirror"`
+	Threads    *int          `arg:"-w,--workers" help:"Number of worker goroutines for hashing, 2 by default"`
+	ConfigFile string        `arg:"--config" help:"JSON or .toml file of settings, overridden by the flags given on the command line"`
+	LogLevel   string        `arg:"--log-level" help:"Minimum level of the logged messages: trace, debug, info, warn or error, debug by default"`
+	Dump       *DumpCmd      `arg:"subcommand:dump"`
+	Verify     *VerifyCmd    `arg:"subcommand:verify"`
+	Mirror     *MirrorCmd    `arg:"subcommand:mirror"`
+	Schema     *SchemaCmd    `arg:"subcommand:schema"`
+	DiffFiles  *DiffFilesCmd `arg:"subcommand:difffiles"`
+	Prune      *PruneCmd     `arg:"subcommand:prune"`
+	SelfTest   *SelfTestCmd  `arg:"subcommand:selftest"`
+	List       *ListCmd      `arg:"subcommand:list"`
+	Gen        *GenCmd       `arg:"subcommand:gen"`
+	Split      *SplitCmd     `arg:"subcommand:split"`
+	Stat       *StatCmd      `arg:"subcommand:stat"`
 }
 
 // DumpCmd defines the arguments for the "dump" subcommand.
 type DumpCmd struct {
-	InputDir   string `arg:"positional,required" help:"Input directory to scan"`
-	OutputFile string `arg:"-o,--output" default:"package.jsonl" help:"Output file (default: package.jsonl)"`
+	InputDir       string   `arg:"positional,required" help:"Input directory to scan"`
+	MoreInputDirs  []string `arg:"positional" help:"More input directories to scan into the same manifest"`
+	PrefixWithRoot bool     `arg:"--prefix-with-root" help:"Prepend the name of its input directory to every entry, so files at the same relative path in several input directories don't collide"`
+	OutputFile     string   `arg:"-o,--output" default:"package.jsonl" help:"Output file (default: package.jsonl)"`
+	Shard          *Shard   `arg:"--shard" help:"Only process files of shard N/M, selected by the XXH64 of their relative path"`
+	Owner          bool     `arg:"--owner" help:"Record the uid/gid of each file (Unix only)"`
+	Fingerprint    bool     `arg:"--fingerprint" help:"Also record a fast head/tail fingerprint of each file"`
+	Sparse         bool     `arg:"--sparse" help:"Record which files are sparse (Unix only)"`
+	ShardByDir     bool     `arg:"--shard-by-dir" help:"Write one manifest per top-level directory, named after the output file with the directory appended"`
+	IncludeDirs    bool     `arg:"--include-dirs" help:"Also record directory entries, so mirror can recreate empty directories"`
+	ModTime        bool     `arg:"--mtime" help:"Record the modification time of each file, used by verify --smart"`
+	Xattrs         bool     `arg:"--xattrs" help:"Record the extended attributes of each file (Linux/BSD/macOS only)"`
+	SymlinkAsLink  bool     `arg:"--symlink-as-link" help:"Record symbolic links as links to their target instead of hashing the file they point to"`
+	PinWorkers     bool     `arg:"--pin-workers" help:"Advanced tuning: cap the workers to GOMAXPROCS and lock each one to an OS thread, for more stable cache behavior on NUMA machines (Unix only)"`
+	VerifyAfter    bool     `arg:"--verify-after" help:"Dump to a temporary manifest, verify it against the input directory, and only then replace the output file"`
+	SkipLocked     bool     `arg:"--skip-locked" default:"true" help:"Skip files locked by another process instead of failing, use --skip-locked=false to fail (Windows only)"`
+	LineBuffered   bool     `arg:"--line-buffered" help:"Write every entry as soon as it is hashed, for tailing the manifest live; costs a system call per entry, which slows down dumps of many small files"`
+	Hashes         []string `arg:"--hash" help:"Also record the hash of this registered algorithm in the hashes field, md5 and xxh64 are always recorded; an unknown name is an error"`
+	NormalizeEOL   bool     `arg:"--normalize-eol" help:"Hash text files with CRLF collapsed to LF, recording the normalized size; such hashes only match a verify run with --normalize-eol"`
+	TextExts       []string `arg:"--text-ext" help:"Extension of the text files --normalize-eol applies to, .txt .json .xml and other common ones by default"`
+	BirthTime      bool     `arg:"--btime" help:"Record the birth (creation) time of files where the platform and filesystem provide it"`
+	Writers        int      `arg:"--writers" help:"Write the manifest through this many writers, each to its own file named after the output file, e.g. package-w1of4.jsonl"`
+	ConcatWriters  bool     `arg:"--concat-writers" help:"Concatenate the files of --writers into the output file at the end, and remove them"`
+	HeaderBytes    int64    `arg:"--header-bytes" help:"Also record the hash of the first N bytes of each file, for verify --header-only"`
+	TimeFormat     string   `arg:"--time-format" default:"unixnano" help:"Write the recorded times as compact Unix nanoseconds (unixnano) or readable RFC 3339 strings (rfc3339), both are read back"`
+	Sort           bool     `arg:"--sort" help:"Write the entries sorted by directory then name instead of in the order they are hashed, buffering them until the end"`
+	SortSpill      int      `arg:"--sort-spill" help:"With --sort, hold at most this many entries in memory, spilling sorted runs to temporary files merged at the end; 0 keeps every entry in memory"`
+	MaxInflight    int      `arg:"--max-inflight" help:"Number of hashed entries buffered for the writer, one per worker by default; a lower value bounds memory when the writer is slow"`
+	Schema         string   `arg:"--schema" default:"pkg" help:"Keys of the manifest entries: pkg for the pkg_version ones, or both to also write them under the newer schema keys (path, xxh64, size) for consumers of either, which makes manifests slightly larger"`
+	DirHashes      bool     `arg:"--dir-hashes" help:"Also record an entry per directory holding files, whose dirHash rolls up the hashes of everything below it, so difffiles of two such manifests tells the changed directories at a glance"`
+	PathsFrom      string   `arg:"--paths-from" help:"Hash exactly the files listed in this file, relative to the input directory, in the listed order instead of walking it; one per line, or NUL-separated like list --print0. Listed paths missing on disk are errors"`
+	XxhSeed        uint64   `arg:"--xxh-seed" help:"Seed the xxh64 hashes with this number, e.g. one per project so manifests of different projects never match by accident; recorded in every entry for verify"`
+	EmbedVersion   bool     `arg:"--embed-version" help:"Write the version of the manifest format in every entry as v, so future readers can adapt line by line; readers take a line without it as version 1"`
+	Md5sumOut      string   `arg:"--md5sum-out" help:"Also write the md5 of every file to this file in the md5sum format, to check the input directory with md5sum -c"`
+	ReadBudget     ByteSize `arg:"--read-budget" help:"Stop reading files once this many bytes were read, e.g. 50GB on metered storage; the files left are not recorded"`
 }
 
 // VerifyCmd defines the arguments for the "verify" subcommand.
 type VerifyCmd struct {
-	InputDir            string   `arg:"positional,required" help:"Input directory to scan"`
+	InputDir            string        `arg:"positional,required" help:"Input directory to scan"`
+	PkgFiles            []string      `arg:"-f,--pkg-file" help:"List of additional package files to use"`
+	CheckInputDirForPkg bool          `arg:"-c,--check-input" help:"Look for pkg files in input directory"`
+	StatOnly            bool          `arg:"--stat-only" help:"Compare sizes from directory listings only, never opening files"`
+	Owner               bool          `arg:"--owner" help:"Report files whose uid/gid differ from the recorded ones (Unix only)"`
+	Fingerprint         bool          `arg:"--fingerprint" help:"Check recorded head/tail fingerprints before hashing whole files"`
+	CaseInsensitive     bool          `arg:"--case-insensitive" help:"Match manifest paths to on-disk paths ignoring case"`
+	DiskIndex           bool          `arg:"--disk-index" help:"Sort the manifest into a temporary on-disk index instead of loading it in memory, for very large manifests"`
+	Smart               bool          `arg:"--smart" help:"Trust files whose size and recorded mtime match, hashing only the others"`
+	PkgURLs             []string      `arg:"--pkg-url" help:"URL of an additional package file to fetch over HTTP"`
+	HTTPTimeout         time.Duration `arg:"--http-timeout" default:"1m" help:"Timeout of each request fetching a package file"`
+	Retries             int           `arg:"--retries" default:"3" help:"Number of retries of a failed request fetching a package file, on network errors and temporary status codes"`
+	RetryDelay          time.Duration `arg:"--retry-delay" default:"1s" help:"Delay before the first retry of a failed request, doubled on each next one up to 30s, with some jitter"`
+	ModifiedSince       *time.Time    `arg:"--modified-since" help:"Only hash files modified at or after this RFC 3339 time, e.g. 2024-05-01T00:00:00Z, older files are assumed unchanged"`
+	RequireFresh        string        `arg:"--require-fresh" help:"Check that no file of the input directory is newer than the local package files, and either warn or fail when one is"`
+	NormalizeEOL        bool          `arg:"--normalize-eol" help:"Hash text files with CRLF collapsed to LF, for manifests dumped with --normalize-eol; raw and normalized hashes never match"`
+	TextExts            []string      `arg:"--text-ext" help:"Extension of the text files --normalize-eol applies to, .txt .json .xml and other common ones by default"`
+	HeaderOnly          bool          `arg:"--header-only" help:"Only compare sizes and the recorded hashes of the first bytes of files, never reading further; corruption past the header goes unnoticed"`
+	ResumeFile          string        `arg:"--resume-file" help:"When interrupted by SIGINT, write the remote names of the entries not verified yet to this file, to complete them with --only"`
+	Only                string        `arg:"--only" help:"Only verify the entries whose remote names are listed in this file one per line, e.g. a --resume-file"`
+	CASRoot             string        `arg:"--cas-root" help:"Verify the blobs of a content-addressed store in this directory, found by their md5 or xxh64 as ab/cd/abcd..., instead of the files at their remote names"`
+	SkipDirs            []string      `arg:"--skip-dir" help:"Neither verify nor walk the directories matching this pattern, e.g. saves or */logs for user data; a name without a slash only matches a top-level directory"`
+	ReadBudget          ByteSize      `arg:"--read-budget" help:"Stop reading files once this many bytes were read, e.g. 50GB on metered storage; the files left are reported unchecked"`
+	MaxReport           int           `arg:"--max-report" help:"Only log the first N differences of each kind, then how many more there were; 0 logs them all"`
+	JSONOut             string        `arg:"--json-out" help:"Write every result to this file as JSON lines with its path and result, whatever --max-report logs"`
+	XxhSeed             *uint64       `arg:"--xxh-seed" help:"Fail unless the xxh64 hashes of the manifest were dumped with this seed; entries are always verified with the seed they record"`
+	MissingOnly         bool          `arg:"--missing-only" help:"Only check the entries of the manifest exist, never reading nor sizing files; the fastest way to list what's missing"`
+	CompareHashes       []string      `arg:"--compare-hash" help:"Only compare the hash of this algorithm, md5, xxh64 or one recorded with dump --hash, instead of the recorded md5 and xxh64; fails when the manifest doesn't record it"`
+}
+
+// DiffFilesCmd defines the arguments for the "difffiles" subcommand, which compares two manifests.
+type DiffFilesCmd struct {
+	OldPkgFile string `arg:"positional,required" help:"Old package file"`
+	NewPkgFile string `arg:"positional,required" help:"New package file"`
+	IgnoreSize bool   `arg:"--ignore-size" help:"Don't report entries that differ only in size"`
+	IgnoreHash bool   `arg:"--ignore-hash" help:"Don't report entries that differ only in hashes"`
+}
+
+// PruneCmd defines the arguments for the "prune" subcommand, which deletes files not listed in the manifest.
+type PruneCmd struct {
+	InputDir            string   `arg:"positional,required" help:"Input directory to prune"`
 	PkgFiles            []string `arg:"-f,--pkg-file" help:"List of additional package files to use"`
 	CheckInputDirForPkg bool     `arg:"-c,--check-input" help:"Look for pkg files in input directory"`
+	Apply               bool     `arg:"--apply" help:"Actually delete the files, the default is a dry run"`
+}
+
+// ListCmd defines the arguments for the "list" subcommand, which prints the remote names listed in manifests.
+type ListCmd struct {
+	PkgFiles []string `arg:"positional,required" help:"Package files to list"`
+	Print0   bool     `arg:"-0,--print0" help:"Separate names with NUL bytes instead of newlines, for xargs -0"`
+}
+
+// GenCmd defines the arguments for the "gen" subcommand, which creates a synthetic tree for tests and benchmarks.
+type GenCmd struct {
+	OutputDir string  `arg:"positional,required" help:"Directory to create the tree in"`
+	Files     int     `arg:"-n,--files" default:"100" help:"Number of files to create"`
+	MinSize   int64   `arg:"--min-size" default:"0" help:"Smallest file size in bytes"`
+	MaxSize   int64   `arg:"--max-size" default:"1048576" help:"Largest file size in bytes"`
+	SizeDist  string  `arg:"--size-dist" default:"log" help:"Distribution of file sizes between the bounds: uniform or log (mostly small files)"`
+	Depth     int     `arg:"--depth" default:"3" help:"Maximum nesting depth of the directories"`
+	Seed      *uint64 `arg:"--seed" help:"Seed of the pseudo-random layout and content, a random one is picked and logged when omitted"`
+}
+
+// SplitCmd defines the arguments for the "split" subcommand, which splits a manifest into parts of similar total size.
+type SplitCmd struct {
+	PkgFile    string `arg:"positional,required" help:"Package file to split"`
+	Parts      int    `arg:"-n,--parts" default:"2" help:"Number of parts to split the entries into"`
+	OutputFile string `arg:"-o,--output" help:"Name the parts after this file instead of the package file, e.g. package-part1of2.jsonl"`
 }
 
+// StatCmd defines the arguments for the "stat" subcommand, which counts the files of a tree and their total size.
+type StatCmd struct {
+	InputDir string `arg:"positional,required" help:"Input directory to count"`
+}
+
+// SchemaCmd defines the arguments for the "schema" subcommand, which prints the manifest JSON Schema.
+type SchemaCmd struct{}
+
+// SelfTestCmd defines the arguments for the "selftest" subcommand, which checks hashing against known values.
+type SelfTestCmd struct{}
+
 // MirrorCmd defines the arguments for the "mirror" subcommand.
 type MirrorCmd struct {
-	OutputDir string   `arg:"positional,required" help:"Output directory to create files to"`
-	PkgFiles  []string `arg:"-f,--pkg-file" help:"List of additional package files to use"`
+	OutputDir   string        `arg:"positional" help:"Output directory to create files to, unless --zip is given"`
+	PkgFiles    []string      `arg:"-f,--pkg-file" help:"List of additional package files to use"`
+	Owner       bool          `arg:"--owner" help:"Restore the recorded uid/gid on created files (needs sufficient privileges)"`
+	Xattrs      bool          `arg:"--xattrs" help:"Restore the recorded extended attributes on created files"`
+	SidecarExt  *string       `arg:"--sidecar-ext" default:".json" help:"Extension appended to file paths to name their sidecar JSON, empty to write no sidecar"`
+	SidecarDir  string        `arg:"--sidecar-dir" help:"Write the sidecars in this directory, mirroring the tree, instead of next to the files"`
+	Download    string        `arg:"--download" help:"Base URL to download the content of every file from, at <url>/<remoteName>; each file is checked against its recorded size and hashes while it is written"`
+	HTTPTimeout time.Duration `arg:"--http-timeout" default:"1m" help:"Timeout of each request downloading a file"`
+	Retries     int           `arg:"--retries" default:"3" help:"Number of retries of a failed download, on network errors and temporary status codes"`
+	RetryDelay  time.Duration `arg:"--retry-delay" default:"1s" help:"Delay before the first retry of a failed download, doubled on each next one up to 30s, with some jitter"`
+	Sparse      bool          `arg:"--sparse" help:"Write the downloaded files recorded as sparse with holes for their blocks of zeros, on filesystems supporting it"`
+	Zip         string        `arg:"--zip" help:"Write the mirrored entries into this zip archive instead of an output directory, named by their remote name; needs --download"`
+}
+
+// maxThreadsPerCPU bounds the number of workers relative to the available CPUs.
+const maxThreadsPerCPU = 16
+
+// clampWorkers keeps Workers between 1 and maxThreadsPerCPU workers per CPU, logging a warning when it is adjusted.
+func (config *Config) clampWorkers() {
+	maxThreads := runtime.NumCPU() * maxThreadsPerCPU
+	threads := min(max(config.Workers, 1), maxThreads)
+	if threads != config.Workers {
+		log.Warn().
+			Int("requested", config.Workers).
+			Int("using", threads).
+			Msg("Adjusted number of workers")
+		config.Workers = threads
+	}
 }
 
 func main() {
@@ -74,36 +256,176 @@ func main() {
 	// Zerolog setup: Configure the logging library to output to the console.
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
+	// Settings come from the defaults, the config file and the flags, the subcommands are given the resolved ones.
+	config, err := resolveConfig(&args)
+	if err != nil {
+		log.Panic().Err(err).Msg("Invalid configuration")
+	}
+	level, _ := zerolog.ParseLevel(config.LogLevel) // Already validated
+	zerolog.SetGlobalLevel(level)
+
+	// The generic xxh3 code is several times slower, which is worth knowing when dumps are unexpectedly slow.
+	if accelerated, impl := xxh3Acceleration(); accelerated {
+		log.Debug().Str("xxh3", impl).Msg("Using accelerated xxh3")
+	} else {
+		log.Warn().Str("xxh3", impl).Msg("Accelerated xxh3 is not available on this CPU, hashing will be slower")
+	}
+
+	// A non-positive worker count would start no workers and deadlock the pipelines.
+	config.clampWorkers()
+
 	switch {
 	case args.Dump != nil:
-		subcommandDump(&args, args.Dump)
+		summary := subcommandDump(config, args.Dump)
+		log.Info().
+			Int("files", summary.Files).
+			Int64("bytes", summary.Bytes).
+			Int("errors", summary.Errors).
+			Dur("duration", summary.Duration).
+			Msg("Dump finished")
 	case args.Verify != nil:
-		subcommandVerify(&args, args.Verify)
+		subcommandVerify(config, args.Verify)
 	case args.Mirror != nil:
-		subcommandMirror(&args, args.Mirror)
+		subcommandMirror(config, args.Mirror)
+	case args.DiffFiles != nil:
+		subcommandDiffFiles(config, args.DiffFiles)
+	case args.Prune != nil:
+		subcommandPrune(config, args.Prune)
+	case args.Schema != nil:
+		subcommandSchema(config, args.Schema)
+	case args.SelfTest != nil:
+		subcommandSelfTest(config, args.SelfTest)
+	case args.List != nil:
+		subcommandList(config, args.List)
+	case args.Gen != nil:
+		subcommandGen(config, args.Gen)
+	case args.Split != nil:
+		subcommandSplit(config, args.Split)
+	case args.Stat != nil:
+		subcommandStat(config, args.Stat)
 	}
 }
 
-// fileWorker reads file paths from the paths channel, processes each file, and sends the FileInfo to the results channel.
-func fileWorker(paths <-chan string, inputDir string, results chan<- FileInfo) {
-	for path := range paths { // Continuously read file paths from the 'paths' channel until it's closed.
-		info, err := processFile(inputDir, path) // Process the file to calculate hashes and size.
+// fileWorker processes a single file path received by a worker and returns its FileInfo, with the hashes and text
+// extensions of config.
+func fileWorker(dumpCmd *DumpCmd, config Config, path string) (FileInfo, error) {
+	if dumpCmd.IncludeDirs || dumpCmd.SymlinkAsLink {
+		stat, err := os.Lstat(path)
 		if err != nil {
-			log.Panic().Err(err).Str("file", path).Msg("Error processing file") // If there's an error processing the file, log a fatal error and exit.
-			continue
+			log.Panic().Err(err).Str("file", path).Msg("Error retrieving file metadata")
+			return FileInfo{}, err
+		}
+		// Directories are only sent by the walker when they are included, and have no content to hash.
+		if stat.IsDir() && dumpCmd.IncludeDirs {
+			info, err := processDir(dumpCmd.InputDir, path, stat)
+			if err != nil {
+				log.Panic().Err(err).Str("dir", path).Msg("Error processing directory")
+			}
+			return info, err
+		}
+		// Symbolic links are recorded by their target instead of the content they point to.
+		if stat.Mode()&fs.ModeSymlink != 0 && dumpCmd.SymlinkAsLink {
+			info, err := processSymlink(dumpCmd.InputDir, path, dumpCmd.XxhSeed)
+			if err != nil {
+				log.Panic().Err(err).Str("file", path).Msg("Error reading symbolic link")
+			}
+			return info, err
 		}
-		results <- info // Send the processed FileInfo struct to the 'results' channel.
 	}
+	// The mtime is taken before hashing, so a file modified while being hashed looks changed to verify --smart.
+	var modTime time.Time
+	if dumpCmd.ModTime {
+		stat, err := os.Stat(path)
+		if err != nil {
+			log.Panic().Err(err).Str("file", path).Msg("Error retrieving file metadata")
+			return FileInfo{}, err
+		}
+		modTime = stat.ModTime()
+	}
+	normalizeEOL := dumpCmd.NormalizeEOL && isTextFile(path, config.TextExts)
+	info, err := processFileWithHashes(dumpCmd.InputDir, path, config.Hashes, normalizeEOL, dumpCmd.XxhSeed) // Process the file to calculate hashes and size.
+	if err != nil && dumpCmd.SkipLocked && isLockedFileError(err) {
+		log.Warn().Err(err).Str("file", path).Msg("Skipped locked file")
+		return FileInfo{}, errSkippedLocked // The pool drops the file, and the dump goes on
+	}
+	if err != nil {
+		log.Panic().Err(err).Str("file", path).Msg("Error processing file") // If there's an error processing the file, log a fatal error and exit.
+		return FileInfo{}, err
+	}
+	info.ModTime = modTime
+	if dumpCmd.Owner || dumpCmd.Sparse || dumpCmd.BirthTime {
+		stat, err := os.Lstat(path)
+		if err != nil {
+			log.Panic().Err(err).Str("file", path).Msg("Error retrieving file metadata")
+			return FileInfo{}, err
+		}
+		if uid, gid, ok := ownerFromFileInfo(stat); ok && dumpCmd.Owner {
+			info.Uid, info.Gid = &uid, &gid
+		}
+		if dumpCmd.BirthTime {
+			if btime, ok := birthTime(path, stat); ok { // Left zero, and omitted, where the filesystem doesn't record it
+				info.BirthTime = time.Unix(0, btime)
+			}
+		}
+		info.Sparse = dumpCmd.Sparse && isSparse(stat)
+	}
+	if dumpCmd.Xattrs {
+		info.Xattrs, err = readXattrs(path)
+		if err != nil {
+			log.Panic().Err(err).Str("file", path).Msg("Error reading extended attributes")
+			return FileInfo{}, err
+		}
+	}
+	if dumpCmd.Fingerprint {
+		info.Fingerprint, err = fingerprintFile(path)
+		if err != nil {
+			log.Panic().Err(err).Str("file", path).Msg("Error computing file fingerprint")
+			return FileInfo{}, err
+		}
+	}
+	if dumpCmd.HeaderBytes > 0 {
+		info.HeaderHash, err = headerHashFile(path, dumpCmd.HeaderBytes)
+		if err != nil {
+			log.Panic().Err(err).Str("file", path).Msg("Error computing header hash")
+			return FileInfo{}, err
+		}
+		info.HeaderBytes = dumpCmd.HeaderBytes
+	}
+	return info, nil // Hand the processed FileInfo struct to the pool, which sends it to the 'results' channel.
+}
+
+// errSkippedLocked is returned for the files left out of a dump because another process has them locked.
+var errSkippedLocked = errors.New("file is locked by another process")
+
+// fingerprintFile opens a file and computes its head/tail fingerprint.
+func fingerprintFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return computeFingerprint(f, stat.Size())
 }
 
 // fileWalker recursively walks the input directory and sends the path of each file to the paths channel.
-func fileWalker(inputDir string, paths chan<- string) {
+// Directories below the input directory are sent too when includeDirs is set.
+// Files for which accept returns false are skipped, a nil accept keeps every file.
+func fileWalker(inputDir string, paths chan<- string, includeDirs bool, accept func(path string) bool) {
 	err := filepath.WalkDir(inputDir, func(path string, d os.DirEntry, err error) error { // WalkDir walks the file tree rooted at inputDir, calling the anonymous function for each file and directory.
 		if err != nil {
 			log.Panic().Err(err).Str("path", path).Msg("Error walking file") // If there's an error accessing a path, log a fatal error and return the error to stop walking.
 			return nil
 		}
-		if !d.IsDir() { // Check if the current entry is a file (not a directory).
+		if !d.IsDir() || (includeDirs && path != inputDir) { // Check if the current entry is a file (not a directory), or a directory to record.
+			if accept != nil && !accept(path) {
+				log.Trace().Str("file", path).Msg("Skipped")
+				return nil
+			}
 			paths <- path // Send the file path to the 'paths' channel for processing by workers.
 			log.Debug().Str("file", path).Msg("Discovered")
 		}
@@ -116,20 +438,81 @@ func fileWalker(inputDir string, paths chan<- string) {
 
 // processFileReader computes the MD5 and XXH64 hashes and size from any io.Reader.
 func processFileReader(reader io.Reader) (md5Hash []byte, xxh64Hash []byte, size int64, err error) {
-	hMD5 := md5.New()    // Create a new MD5 hash object.
-	hXXH64 := xxh3.New() // Create a new XXH3 hash object.
-
-	// Use io.MultiWriter to write data simultaneously to both hash objects while reading.
-	size, err = io.Copy(io.MultiWriter(hMD5, hXXH64), reader)
+	result, err := hashReader(reader, nil)
 	if err != nil {
 		return nil, nil, 0, err // If there's an error, return empty values and the error.
 	}
+	return result.Md5Hash, result.Xxh64Hash, result.Size, nil
+}
 
-	return hMD5.Sum(nil), hXXH64.Sum(nil), size, nil
+// hashReader computes the MD5 and XXH64 hashes and size from any io.Reader, along with the hashes of the
+// registered algorithms named by hashNames. FilePath is left empty.
+func hashReader(reader io.Reader, hashNames []string) (FileInfo, error) {
+	return hashReaderSeed(reader, hashNames, 0)
+}
+
+// hashReaderSeed is hashReader with the XXH64 hash seeded with xxhSeed, 0 being the unseeded hash.
+func hashReaderSeed(reader io.Reader, hashNames []string, xxhSeed uint64) (FileInfo, error) {
+	hw := getHashingWriter(io.Discard) // Hash everything read, without keeping the content.
+	defer putHashingWriter(hw)         // Return the hashers to the pool, on the error path too.
+	hw.SetSeed(xxhSeed)                // Pooled writers keep the seed of their last file
+	for _, name := range hashNames {
+		if err := hw.AddHasher(name); err != nil {
+			return FileInfo{}, err
+		}
+	}
+
+	// Copy the data through the HashingWriter, which updates every hash while reading.
+	_, err := io.Copy(hw, reader)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return hw.Result(), nil
+}
+
+// processDir returns the FileInfo of a directory entry: its relative path and permission bits, without size nor hashes.
+func processDir(baseDir string, path string, stat fs.FileInfo) (FileInfo, error) {
+	relPath, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		FilePath: filepath.ToSlash(relPath),
+		IsDir:    true,
+		Mode:     uint32(stat.Mode().Perm()),
+	}, nil
+}
+
+// processSymlink returns the FileInfo of a symbolic link recorded as a link: its target, with the hashes and size
+// of the target string so that the entry still carries the usual fields. The link itself is never followed.
+// The xxh64 hash is seeded with xxhSeed like those of files.
+func processSymlink(baseDir string, path string, xxhSeed uint64) (FileInfo, error) {
+	relPath, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := hashReaderSeed(strings.NewReader(target), nil, xxhSeed)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info.FilePath = filepath.ToSlash(relPath)
+	info.IsSymlink, info.LinkTarget = true, target
+	return info, nil
 }
 
 // processFile reads the file and computes the MD5 and XXH64 hashes and file size.
 func processFile(baseDir string, path string) (FileInfo, error) {
+	return processFileWithHashes(baseDir, path, nil, false, 0)
+}
+
+// processFileWithHashes is processFile also computing the hashes of the registered algorithms named by hashNames.
+// With normalizeEOL, the hashes and size are those of the content with CRLF collapsed to LF. The xxh64 hash is
+// seeded with xxhSeed.
+func processFileWithHashes(baseDir string, path string, hashNames []string, normalizeEOL bool, xxhSeed uint64) (FileInfo, error) {
 	relPath, err := filepath.Rel(baseDir, path) // Get the relative path of the file with respect to the base directory.
 	if err != nil {
 		return FileInfo{}, err // If there's an error getting the relative path, return an empty FileInfo and the error.
@@ -143,18 +526,18 @@ func processFile(baseDir string, path string) (FileInfo, error) {
 	}
 	defer f.Close() // Ensure the file is closed when this function returns.
 
-	// Compute hashes and size using processFileReader.
-	md5Hash, xxh64Hash, size, err := processFileReader(f)
+	// Compute hashes and size using hashReader.
+	var reader io.Reader = f
+	if normalizeEOL {
+		reader = &eolNormalizer{r: f}
+	}
+	info, err := hashReaderSeed(reader, hashNames, xxhSeed)
 	if err != nil {
 		return FileInfo{}, err // If there's an error during processing, return an empty FileInfo and the error.
 	}
 
 	// Return a FileInfo struct containing the calculated metadata.
 	log.Trace().Str("file", relPath).Msg("Done compare")
-	return FileInfo{
-		FilePath:  relPath,
-		Md5Hash:   md5Hash,   // MD5 hash as a byte array.
-		Xxh64Hash: xxh64Hash, // XXH64 hash as a byte array.
-		Size:      size,      // File size in bytes.
-	}, nil
+	info.FilePath = relPath
+	return info, nil
 }
@@ -1,24 +1,62 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
 )
 
-func subcommandDump(args *Args, dumpCmd *DumpCmd) {
-	// Create local copies of args and dumpCmd to avoid unintended modifications.
-	_args := *args
+// DumpSummary tells how a dump went: the files written to the manifest and their total size, the files left out of
+// it because they failed or were skipped, and how long the whole dump took.
+type DumpSummary struct {
+	Files    int
+	Bytes    int64
+	Errors   int
+	Duration time.Duration
+}
+
+// DumpFailure is the panic value raised again by subcommandDump when its pipeline failed, it carries the summary of
+// the partial manifest along with the original panic value.
+type DumpFailure struct {
+	Summary DumpSummary
+	Cause   any
+}
+
+func (f *DumpFailure) Error() string {
+	return fmt.Sprintf("dump failed: %v", f.Cause)
+}
+
+func subcommandDump(config Config, dumpCmd *DumpCmd) DumpSummary {
+	if dumpCmd.VerifyAfter {
+		return subcommandDumpVerified(config, dumpCmd) // Dumps through a temporary manifest, published only once verified.
+	}
+	start := time.Now()
+
+	// Create a local copy of dumpCmd to avoid unintended modifications.
 	_dumpCmd := *dumpCmd
 
 	// Ensure that the input directory path uses forward slashes consistently,
 	// regardless of the operating system's native path separator.
 	_dumpCmd.InputDir = filepath.ToSlash(_dumpCmd.InputDir)
+	_dumpCmd.MoreInputDirs = lo.Map(_dumpCmd.MoreInputDirs, func(path string, _ int) string {
+		return filepath.ToSlash(path)
+	})
 	// Ensure that the output file path also uses forward slashes consistently.
 	_dumpCmd.OutputFile = filepath.ToSlash(_dumpCmd.OutputFile)
 
@@ -27,73 +65,594 @@ func subcommandDump(args *Args, dumpCmd *DumpCmd) {
 		log.Panic().Msg("Input directory is required") // If no input directory is given, log a fatal error and exit.
 	}
 
-	// Channels for pipeline: Create channels to pass data between goroutines.
-	paths := make(chan string, 10_000) // Buffered channel to send file paths from the walker to the workers.
-	results := make(chan FileInfo, 8)  // Buffered channel to send processed file information from workers to the writer.
+	if _dumpCmd.Writers > 1 && _dumpCmd.ShardByDir {
+		log.Panic().Msg("Several writers and shard by dir both split the manifest and cannot be combined")
+	}
+	if _dumpCmd.Sort && (_dumpCmd.Writers > 1 || _dumpCmd.ShardByDir) {
+		log.Panic().Msg("Sorting writes a single manifest and cannot be combined with several writers or shard by dir")
+	}
+	if _dumpCmd.DirHashes && (_dumpCmd.IncludeDirs || _dumpCmd.Shard != nil || _dumpCmd.ReadBudget > 0) {
+		log.Panic().Msg("Dir hashes record every directory from all of its files and cannot be combined with include dirs, a shard or a read budget")
+	}
+	if _dumpCmd.PathsFrom != "" && len(_dumpCmd.MoreInputDirs) > 0 {
+		log.Panic().Msg("Paths from a list are relative to a single input directory and cannot be combined with more input directories")
+	}
+	// The listed paths replace the walk, they are read before anything starts.
+	var listedPaths []string
+	if _dumpCmd.PathsFrom != "" {
+		var err error
+		listedPaths, err = readNameList(_dumpCmd.PathsFrom)
+		if err != nil {
+			log.Panic().Err(err).Msg("Failed to read the paths to dump")
+		}
+		// A single worker hashes the files one after the other, so they are processed and written in the listed order.
+		config.Workers = 1
+	}
+	if _dumpCmd.SortSpill > 0 && !_dumpCmd.Sort {
+		log.Warn().Msg("Sort spill only applies to sorted dumps, it is ignored without --sort")
+	}
 
-	// Start file walker: Launch a goroutine to traverse the input directory and send file paths to the 'paths' channel.
-	go func() {
-		defer close(paths)                   // Ensure the 'paths' channel is closed when the file walker finishes. This signals to workers that no more paths will be sent.
-		fileWalker(_dumpCmd.InputDir, paths) // Call the fileWalker function with the input directory and the paths channel.
-	}()
+	// Every input directory is walked by its own pipeline, their results are merged into the single writer.
+	roots := append([]string{_dumpCmd.InputDir}, _dumpCmd.MoreInputDirs...)
+	prefixes := make([]string, len(roots))
+	if _dumpCmd.PrefixWithRoot {
+		for i, root := range roots {
+			prefixes[i] = path.Base(root)
+			if slices.Contains(prefixes[:i], prefixes[i]) {
+				log.Panic().Str("name", prefixes[i]).Msg("Several input directories have the same name, their entries would collide")
+			}
+		}
+	}
 
-	// Start workers: Launch a pool of worker goroutines to process the file paths received from the 'paths' channel.
-	var workWg sync.WaitGroup // WaitGroup to wait for all worker goroutines to finish.
-	workWg.Add(_args.Threads) // Add the number of workers to the WaitGroup counter.
-	for range _args.Threads { // Iterate a fixed number of times (equal to _args.HashWorkers).
-		go func() { // Launch an anonymous goroutine for each worker.
-			defer workWg.Done()                           // Decrement the WaitGroup counter when the worker goroutine finishes.
-			fileWorker(paths, _dumpCmd.InputDir, results) // Call the fileWorker function with the paths channel, input directory, and results channel.
-		}()
+	// Unknown algorithm names are rejected before anything is hashed.
+	hashNames, err := extraHashNames(config.Hashes)
+	if err != nil {
+		log.Panic().Err(err).Msg("Invalid hash algorithm")
+	}
+	config.Hashes = hashNames
+
+	// How entries are written is the same for every entry, it is given to the writer.
+	format := entryFormat{EmbedVersion: _dumpCmd.EmbedVersion, XxhSeed: _dumpCmd.XxhSeed}
+	switch _dumpCmd.Schema {
+	case "", "pkg":
+	case "both":
+		format.SchemaAliases = true
+	default:
+		log.Panic().Str("schema", _dumpCmd.Schema).Msg("Schema must be pkg or both")
+	}
+	if format.TimeFormat, err = parseTimeFormat(_dumpCmd.TimeFormat); err != nil {
+		log.Panic().Err(err).Msg("Invalid time format")
+	}
+
+	if _dumpCmd.Owner && !ownerSupported {
+		log.Warn().Msg("File ownership is not available on this platform, uid/gid will be omitted")
+	}
+	if _dumpCmd.BirthTime && !btimeSupported {
+		log.Warn().Msg("Birth times are not available on this platform, btime will be omitted")
+	}
+	if _dumpCmd.Xattrs && !xattrSupported {
+		log.Warn().Msg("Extended attributes are not available on this platform, they will be omitted")
+	}
+
+	// Pinning is an advanced tuning knob: with more pinned workers than GOMAXPROCS, the extra OS threads would only
+	// contend for the same cores, so the workers are capped first.
+	var workerSetup func() func()
+	if _dumpCmd.PinWorkers {
+		if !pinWorkersSupported {
+			log.Warn().Msg("Pinning workers is not available on this platform, they will not be pinned")
+		} else {
+			if maxProcs := runtime.GOMAXPROCS(0); config.Workers > maxProcs {
+				log.Info().Int("requested", config.Workers).Int("using", maxProcs).Msg("Capped workers to GOMAXPROCS")
+				config.Workers = maxProcs
+			}
+			workerSetup = pinWorker
+		}
+	}
+
+	// A panic in any goroutine of the pipeline is captured instead of killing the process, the pipeline then winds down
+	// so the entries already processed are written and the output closed, and the panic is raised again at the end.
+	var fault pipelineFault
+
+	// Counted by the workers as they go, every file sent by the walker is either a file or an error.
+	var files, errorCount atomic.Int64
+	var bytes atomic.Int64
+	// Regular files are only read while the budget lasts, those refused are left out like failed ones.
+	budget := newReadBudget(_dumpCmd.ReadBudget)
+	var unread atomic.Int64
+
+	// The workers are shared out between the input directories, so several of them don't multiply the load.
+	rootThreads := max(1, config.Workers/len(roots))
+	// Hashed entries wait for the writer in the output buffer of each pool, one per worker unless capped.
+	rootInflight := rootThreads
+	if _dumpCmd.MaxInflight > 0 {
+		rootInflight = max(1, _dumpCmd.MaxInflight/len(roots))
+	}
+	rootResults := make([]<-chan FileInfo, len(roots))
+	walkers := make([]func(), len(roots))
+	for i, root := range roots {
+		rootCmd := _dumpCmd
+		rootCmd.InputDir = root
+
+		// Restrict the walk to a single shard when requested, so several runs can split the tree between them.
+		var accept func(path string) bool
+		if rootCmd.Shard != nil {
+			shard := *rootCmd.Shard
+			accept = func(path string) bool {
+				relPath, err := filepath.Rel(rootCmd.InputDir, path)
+				return err == nil && shard.Contains(relPath)
+			}
+		}
+		walkAccept := func(path string) bool {
+			return !fault.Failed() && !budget.Exhausted() && (accept == nil || accept(path)) // Stop sending paths once something failed or the budget ran out
+		}
+
+		// Channels for pipeline: Create channels to pass data between goroutines.
+		paths := make(chan string, 10_000) // Buffered channel to send file paths from the walker to the workers.
+
+		// Start workers: Launch a pool of worker goroutines to process the file paths received from the 'paths' channel.
+		// The pool closes the 'results' channel once 'paths' is closed and every worker is done, which signals to the output writer that no more results will be sent.
+		rootResults[i] = RunPoolBuffered(paths, rootThreads, rootInflight, workerSetup, func(path string) (info FileInfo, err error) {
+			defer func() {
+				if err != nil {
+					errorCount.Add(1)
+				} else {
+					files.Add(1)
+					bytes.Add(info.Size)
+				}
+			}()
+			if fault.Failed() {
+				return FileInfo{}, errDumpAborted // Skip the remaining paths once something failed
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					fault.Set(r)
+					err = errDumpAborted // The pool drops the result of a failed file
+				}
+			}()
+			if budget != nil {
+				if stat, err := os.Lstat(path); err == nil && stat.Mode().IsRegular() && !budget.Claim(stat.Size()) {
+					unread.Add(1)
+					return FileInfo{}, errReadBudget
+				}
+			}
+			info, err = fileWorker(&rootCmd, config, path)
+			if err == nil && prefixes[i] != "" {
+				info.FilePath = prefixes[i] + "/" + info.FilePath
+			}
+			return info, err
+		})
+
+		// The file walker traverses the input directory and sends file paths to the 'paths' channel.
+		// Closing 'paths' early (no files found) is safe because workers are already ranging over it.
+		walkers[i] = func() {
+			defer close(paths)    // Ensure the 'paths' channel is closed when the file walker finishes. This signals to workers that no more paths will be sent.
+			defer fault.Capture() // Runs before close(paths), so workers see the failure before they stop.
+			if rootCmd.PathsFrom != "" {
+				pathsFromWalker(rootCmd.InputDir, listedPaths, paths, rootCmd.IncludeDirs, walkAccept, func() { errorCount.Add(1) })
+				return
+			}
+			fileWalker(rootCmd.InputDir, paths, rootCmd.IncludeDirs, walkAccept) // Call the fileWalker function with the input directory, the paths channel and the shard filter.
+		}
+	}
+	results := rootResults[0]
+	if len(rootResults) > 1 {
+		results = Merge(context.Background(), rootResults...) // Closed once every root is done
+	}
+	if _dumpCmd.DirHashes {
+		results = withDirHashes(results) // Directory entries follow once every file is hashed
+	}
+	if _dumpCmd.Md5sumOut != "" {
+		results = withMd5sumOut(_dumpCmd.Md5sumOut, results, _dumpCmd.NormalizeEOL, config.TextExts, &fault) // Written as the entries pass by
 	}
-	// Goroutine to close the results channel after all workers are done.
-	go func() {
-		workWg.Wait()  // Wait for all worker goroutines to finish processing files.
-		close(results) // Close the 'results' channel. This signals to the output writer that no more results will be sent.
-	}()
 
 	// Start output writer: Launch a goroutine to read processed file information from the 'results' channel and write it to the output file.
+	// The consumers are started before any producer so the pipeline drains correctly even when the walker finishes immediately (e.g. an empty input directory).
 	var writeWg sync.WaitGroup // WaitGroup to wait for the output writer goroutine to finish.
 	writeWg.Add(1)             // Add 1 to the WaitGroup counter for the output writer goroutine.
 	go func() {
-		defer writeWg.Done()                       // Decrement the WaitGroup counter when the output writer goroutine finishes.
-		pkgOutWriter(_dumpCmd.OutputFile, results) // Call the outputWriter function with the output file path and the results channel.
+		defer writeWg.Done() // Decrement the WaitGroup counter when the output writer goroutine finishes.
+		defer func() {
+			if r := recover(); r != nil {
+				fault.Set(r)
+				for range results { // Keep draining so the workers never block on a writer that's gone.
+				}
+			}
+		}()
+		if _dumpCmd.ShardByDir {
+			pkgOutWriterByDir(_dumpCmd.OutputFile, results, format, _dumpCmd.LineBuffered) // Route each entry to the manifest of its top-level directory.
+		} else if _dumpCmd.Sort {
+			pkgOutWriterSorted(_dumpCmd.OutputFile, results, format, _dumpCmd.LineBuffered, _dumpCmd.SortSpill) // Buffer every entry, or spill them to sorted runs.
+		} else if _dumpCmd.Writers > 1 {
+			pkgOutWriterSharded(_dumpCmd.OutputFile, results, format, _dumpCmd.Writers, _dumpCmd.LineBuffered, _dumpCmd.ConcatWriters) // Several writers, each with its own file.
+		} else {
+			pkgOutWriter(_dumpCmd.OutputFile, results, format, _dumpCmd.LineBuffered) // Call the outputWriter function with the output file path and the results channel.
+		}
 	}()
+
+	// Start file walkers last: Launch a goroutine per input directory to traverse it.
+	for _, walker := range walkers {
+		go walker()
+	}
+
 	writeWg.Wait() // Wait for the output writer goroutine to finish writing all the results to the file.
+
+	summary := DumpSummary{
+		Files:    int(files.Load()),
+		Bytes:    bytes.Load(),
+		Errors:   int(errorCount.Load()),
+		Duration: time.Since(start),
+	}
+	if budget.Exhausted() {
+		log.Warn().
+			Int64("budget", int64(_dumpCmd.ReadBudget)).
+			Int64("read", budget.Claimed()).
+			Int64("unread", unread.Load()).
+			Msg("Read budget exhausted, the manifest misses the files left unread")
+	}
+	if r, failed := fault.Get(); failed {
+		log.Error().Str("output", _dumpCmd.OutputFile).Msg("Dump failed, the manifest is partial and only lists the files processed before the failure")
+		panic(&DumpFailure{Summary: summary, Cause: r})
+	}
+	return summary
+}
+
+// errDumpAborted is returned for the files skipped or lost after the dump pipeline failed.
+var errDumpAborted = errors.New("dump aborted")
+
+// errReadBudget is returned for the files skipped once the read budget of the dump ran out.
+var errReadBudget = errors.New("read budget exhausted")
+
+// pipelineFault records the first panic raised by a goroutine of a pipeline.
+type pipelineFault struct {
+	once   sync.Once
+	failed atomic.Bool
+	value  any
 }
 
-// pkgOutWriter creates the output file and launches the pkgOutWorker goroutine.
-func pkgOutWriter(outputFile string, results <-chan FileInfo) {
-	outFile, err := os.Create(outputFile) // Create (or truncate) the output file.
+// Set records r as the pipeline failure, only the first call has an effect.
+func (f *pipelineFault) Set(r any) {
+	f.once.Do(func() {
+		f.value = r
+		f.failed.Store(true)
+	})
+}
+
+// Capture recovers a panic and records it, it must be called directly by defer.
+func (f *pipelineFault) Capture() {
+	if r := recover(); r != nil {
+		f.Set(r)
+	}
+}
+
+// Failed reports whether a failure was recorded.
+func (f *pipelineFault) Failed() bool {
+	return f.failed.Load()
+}
+
+// Get returns the recorded failure, if any.
+func (f *pipelineFault) Get() (any, bool) {
+	if !f.failed.Load() {
+		return nil, false
+	}
+	return f.value, true
+}
+
+// pkgOutWriter creates the output file and launches the pkgOutWorker goroutine, writing the entries as format tells.
+// A line-buffered output writes every entry as soon as it is formatted.
+func pkgOutWriter(outputFile string, results <-chan FileInfo, format entryFormat, lineBuffered bool) {
+	outFile, err := createPkgOutFile(outputFile, lineBuffered) // Create (or truncate) the output file.
 	if err != nil {
 		log.Panic().Err(err).Msg("Failed to create output file") // If there's an error creating the file, log a fatal error and exit.
 	}
-	defer outFile.Close() // Ensure the output file is closed when this function returns.
+	defer outFile.Close() // Ensure the buffered entries are written and the output file is closed when this function returns, even on panic.
 
-	pkgOutWorker(results, outFile) // Handle writing to the file.
+	pkgOutWorker(results, outFile.enc, format) // Handle writing to the file.
 }
 
-// pkgOutWorker reads FileInfo from the results channel, formats it as JSON, and writes it to the output file.
-func pkgOutWorker(results <-chan FileInfo, outFile *os.File) {
-	for result := range results { // Continuously read FileInfo structs from the 'results' channel until it's closed.
-		// Convert hash bytes to hex strings for JSON output.
-		out := FileInfoOutput{
-			FilePath:  filepath.ToSlash(result.FilePath),    // Assign the file path. Convert to forward slashes for cross-platform consistency.
-			Md5Hash:   hex.EncodeToString(result.Md5Hash),   // Convert the MD5 hash (byte array) to a hexadecimal string.
-			Xxh64Hash: hex.EncodeToString(result.Xxh64Hash), // Convert the XXH64 hash (byte array) to a hexadecimal string.
-			Size:      result.Size,                          // Assign the file size.
-		}
-		jsonBytes, err := json.Marshal(out) // Convert the FileInfoOutput struct to a JSON byte array.
+// pkgOutWriterByDir writes each result to a manifest named after its top-level directory, creating the files on demand.
+// Entries at the root of the input directory go to outputFile itself, which is always created.
+func pkgOutWriterByDir(outputFile string, results <-chan FileInfo, format entryFormat, lineBuffered bool) {
+	outFiles := make(map[string]*pkgOutFile) // Open manifests, keyed by top-level directory ("" for the root).
+	defer func() {
+		for _, outFile := range outFiles {
+			outFile.Close()
+		}
+	}()
+
+	openOutFile := func(dir string) *pkgOutFile {
+		if outFile, ok := outFiles[dir]; ok {
+			return outFile
+		}
+		outFile, err := createPkgOutFile(dirShardFileName(outputFile, dir), lineBuffered) // Create (or truncate) the manifest of this directory.
+		if err != nil {
+			log.Panic().Err(err).Str("dir", dir).Msg("Failed to create output file")
+		}
+		outFiles[dir] = outFile
+		return outFile
+	}
+	openOutFile("") // The default manifest exists even when every file is in a subdirectory.
+
+	for result := range results {
+		dir, _, found := strings.Cut(filepath.ToSlash(result.FilePath), "/")
+		if !found {
+			dir = "" // A file at the root has no directory component.
+		}
+		writePkgEntry(openOutFile(dir).enc, result, format)
+	}
+}
+
+// pkgOutWriterSharded writes the results through n writers taking entries from the same channel, each to its own
+// manifest, so they never contend on a file. Every manifest holds whole lines and is valid on its own. When concat
+// is set, the manifests are then appended to outputFile, in writer order, and removed.
+func pkgOutWriterSharded(outputFile string, results <-chan FileInfo, format entryFormat, n int, lineBuffered bool, concat bool) {
+	shardFiles := make([]string, n)
+	for i := range shardFiles {
+		shardFiles[i] = writerShardFileName(outputFile, i, n)
+	}
+
+	// A panic of one writer is raised again once the others are done, the others keep draining the results meanwhile.
+	var fault pipelineFault
+	var writeWg sync.WaitGroup
+	writeWg.Add(n)
+	for _, shardFile := range shardFiles {
+		go func() {
+			defer writeWg.Done()
+			defer fault.Capture()
+			pkgOutWriter(shardFile, results, format, lineBuffered)
+		}()
+	}
+	writeWg.Wait()
+	if r, failed := fault.Get(); failed {
+		panic(r)
+	}
+
+	if concat {
+		if err := concatFiles(outputFile, shardFiles); err != nil {
+			log.Panic().Err(err).Str("output", outputFile).Msg("Failed to concatenate writer manifests")
+		}
+	}
+}
+
+// pkgOutWriterSorted writes the results to outputFile in disk index order, by directory then name, instead of the
+// order they were hashed in. Every entry is sorted in memory, unless spill is positive: then each time spill entries
+// are buffered they are sorted into a temporary run file, and the runs are merged into outputFile at the end, so at
+// most spill entries are held in memory. Both ways write the same manifest.
+func pkgOutWriterSorted(outputFile string, results <-chan FileInfo, format entryFormat, lineBuffered bool, spill int) {
+	outFile, err := createPkgOutFile(outputFile, lineBuffered)
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to create output file")
+	}
+	defer outFile.Close()
+
+	var dir string // Temporary directory of the runs, created by the first spill
+	defer func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}()
+	var runs []string
+	var batch []diskIndexRecord
+	var seq int64
+	spillBatch := func() {
+		if dir == "" {
+			if dir, err = os.MkdirTemp("", "dump-pkg_version-sort-*"); err != nil {
+				log.Panic().Err(err).Msg("Failed to create sort directory")
+			}
+		}
+		slices.SortFunc(batch, compareDiskIndexRecords)
+		runPath := filepath.Join(dir, fmt.Sprintf("run%d.jsonl", len(runs)))
+		if err := writeDiskIndexRun(runPath, batch); err != nil {
+			log.Panic().Err(err).Msg("Failed to spill sorted entries")
+		}
+		runs = append(runs, runPath)
+		batch = batch[:0]
+	}
+	for result := range results {
+		batch = append(batch, diskIndexRecord{Seq: seq, Entry: toFileInfoOutput(result, format)})
+		seq++
+		if spill > 0 && len(batch) >= spill {
+			spillBatch()
+		}
+	}
+
+	if len(runs) == 0 {
+		// Like the merge, only the last entry of a path is kept.
+		slices.SortFunc(batch, compareDiskIndexRecords)
+		for i, record := range batch {
+			if i+1 < len(batch) && batch[i+1].Entry.FilePath == record.Entry.FilePath {
+				continue
+			}
+			writePkgOutput(outFile.enc, record.Entry)
+		}
+		return
+	}
+	if len(batch) > 0 {
+		spillBatch()
+	}
+	log.Debug().Int("runs", len(runs)).Msg("Merging sorted runs")
+	err = mergeDiskIndexRuns(runs, func(entry FileInfoOutput) error {
+		entry.aliases = format.SchemaAliases // Lost by the runs, as it is not a key of its own
+		writePkgOutput(outFile.enc, entry)
+		return nil
+	})
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to merge sorted entries")
+	}
+}
+
+// writerShardFileName returns the manifest name of the i-th of n writers, e.g. "package-w1of4.jsonl" for "package.jsonl".
+func writerShardFileName(outputFile string, i, n int) string {
+	return dirShardFileName(outputFile, fmt.Sprintf("w%dof%d", i+1, n))
+}
+
+// concatFiles creates (or truncates) outputFile with the content of every input file in order, then removes them.
+func concatFiles(outputFile string, inputFiles []string) error {
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, inputFile := range inputFiles {
+		in, err := os.Open(inputFile)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
 		if err != nil {
-			log.Panic().Err(err).Str("file", out.FilePath).Msg("Failed to marshal JSON") // If there's an error marshaling to JSON, log a fatal error and exit.
-			continue
+			return err
 		}
-		fmt.Fprintln(outFile, string(jsonBytes)) // Write the JSON string to the output file, adding a newline character.
-		log.Info().
-			Str("file", out.FilePath).
-			Str("md5", out.Md5Hash).
-			Str("xxh64", out.Xxh64Hash).
-			Int64("size", out.Size).
-			Msg("Written")
 	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	for _, inputFile := range inputFiles {
+		if err := os.Remove(inputFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirShardFileName returns the manifest name for a top-level directory, e.g. "package-Audio.jsonl" for "package.jsonl".
+func dirShardFileName(outputFile, dir string) string {
+	if dir == "" {
+		return outputFile
+	}
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + "-" + dir + ext
+}
+
+// pkgOutFile is an output manifest, written through a buffer by a single json.Encoder reused for every entry.
+type pkgOutFile struct {
+	file *os.File
+	buf  *bufio.Writer // nil when line-buffered
+	enc  *json.Encoder
+}
+
+// createPkgOutFile creates (or truncates) a manifest file. A line-buffered manifest has no buffer: the encoder writes
+// each entry to the file with a single write, so a reader tailing it sees every line as soon as it's complete, at the
+// cost of one system call per entry.
+func createPkgOutFile(path string, lineBuffered bool) (*pkgOutFile, error) {
+	if err := createOutputDir(path); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if lineBuffered {
+		return &pkgOutFile{file: file, enc: json.NewEncoder(file)}, nil
+	}
+	buf := bufio.NewWriter(file)
+	return &pkgOutFile{file: file, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+// createOutputDir creates the missing parent directories of an output file, so -o can point at a new folder.
+// A file in the current directory has none to create.
+func createOutputDir(path string) error {
+	dir := filepath.Dir(path)
+	if dir == "." {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return nil
+}
+
+// Close writes the buffered entries and closes the file.
+func (out *pkgOutFile) Close() error {
+	var err error
+	if out.buf != nil {
+		err = out.buf.Flush()
+	}
+	if closeErr := out.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Error().Err(err).Str("file", out.file.Name()).Msg("Failed to write output file")
+	}
+	return err
+}
+
+// pkgOutWorker reads FileInfo from the results channel, formats it as JSON, and writes it with the encoder.
+func pkgOutWorker(results <-chan FileInfo, enc *json.Encoder, format entryFormat) {
+	for result := range results { // Continuously read FileInfo structs from the 'results' channel until it's closed.
+		writePkgEntry(enc, result, format)
+	}
+}
+
+// writePkgEntry formats a single FileInfo as a JSON line and writes it with the encoder.
+func writePkgEntry(enc *json.Encoder, result FileInfo, format entryFormat) {
+	writePkgOutput(enc, toFileInfoOutput(result, format))
+}
+
+// writePkgOutput writes a single entry already in its manifest representation with the encoder.
+func writePkgOutput(enc *json.Encoder, out FileInfoOutput) {
+	err := enc.Encode(out) // Encode the FileInfoOutput struct as JSON, the encoder adds the newline character.
+	if err != nil {
+		log.Panic().Err(err).Str("file", out.FilePath).Msg("Failed to write JSON") // If there's an error marshaling or writing the JSON, log a fatal error and exit.
+	}
+	log.Info().
+		Str("file", out.FilePath).
+		Str("md5", out.Md5Hash).
+		Str("xxh64", out.Xxh64Hash).
+		Int64("size", out.Size).
+		Msg("Written")
+}
+
+// entryFormat holds the options of a dump telling how every entry is written, so FileInfo only carries what the
+// manifest records.
+type entryFormat struct {
+	TimeFormat    TimeFormat // How ModTime and BirthTime are written
+	SchemaAliases bool       // Whether every entry also carries the keys of the newer schema, set by --schema both
+	EmbedVersion  bool       // Whether every entry records the version of its format, set by --embed-version
+	XxhSeed       uint64     // Seed the xxh64 hashes were computed with, recorded in every entry having one
+}
+
+// toFileInfoOutput converts a FileInfo to its manifest representation, written as format tells.
+func toFileInfoOutput(result FileInfo, format entryFormat) FileInfoOutput {
+	// Convert hash bytes to hex strings for JSON output.
+	out := FileInfoOutput{
+		FilePath:    filepath.ToSlash(result.FilePath),                 // Assign the file path. Convert to forward slashes for cross-platform consistency.
+		Md5Hash:     hex.EncodeToString(result.Md5Hash),                // Convert the MD5 hash (byte array) to a hexadecimal string.
+		Xxh64Hash:   hex.EncodeToString(result.Xxh64Hash),              // Convert the XXH64 hash (byte array) to a hexadecimal string.
+		Size:        result.Size,                                       // Assign the file size.
+		Uid:         result.Uid,                                        // Assign the owner user id, if recorded.
+		Gid:         result.Gid,                                        // Assign the owner group id, if recorded.
+		Fingerprint: hex.EncodeToString(result.Fingerprint),            // Convert the fingerprint, empty when not recorded.
+		Sparse:      result.Sparse,                                     // Assign the sparse flag.
+		IsDir:       result.IsDir,                                      // Assign the directory marker.
+		Mode:        result.Mode,                                       // Assign the directory permission bits.
+		ModTime:     ManifestTime{result.ModTime, format.TimeFormat},   // Assign the modification time, if recorded.
+		Xattrs:      encodeXattrs(result.Xattrs),                       // Encode the extended attributes, nil when not recorded.
+		IsSymlink:   result.IsSymlink,                                  // Assign the symbolic link marker.
+		LinkTarget:  result.LinkTarget,                                 // Assign the link target, empty for other entries.
+		Hashes:      encodeHashes(result.Hashes),                       // Convert the additional hashes, nil when none.
+		BirthTime:   ManifestTime{result.BirthTime, format.TimeFormat}, // Assign the birth time, if recorded.
+		HeaderHash:  hex.EncodeToString(result.HeaderHash),             // Convert the header hash, empty when not recorded.
+		HeaderBytes: result.HeaderBytes,                                // Assign the number of header bytes hashed.
+		DirHash:     hex.EncodeToString(result.DirHash),                // Convert the directory hash, empty when not recorded.
+		aliases:     format.SchemaAliases,                              // Also write the keys of the newer schema, if requested.
+	}
+	if format.EmbedVersion {
+		out.Version = manifestVersion
+	}
+	if len(result.Xxh64Hash) > 0 {
+		out.XxhSeed = format.XxhSeed // 0 when unseeded, which is omitted
+	}
+	return out
+}
+
+// encodeHashes converts hashes by algorithm name to hexadecimal strings, nil when there are none.
+func encodeHashes(hashes map[string][]byte) map[string]string {
+	if len(hashes) == 0 {
+		return nil
+	}
+	encoded := make(map[string]string, len(hashes))
+	for name, sum := range hashes {
+		encoded[name] = hex.EncodeToString(sum)
+	}
+	return encoded
 }
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// runWithTimeout fails the test if fn doesn't return within the given duration.
+func runWithTimeout(t *testing.T, d time.Duration, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("Timed out after %s", d)
+	}
+}
+
+// readLines returns the non-empty lines of a file.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return lines
+}
+
+// readManifest parses every line of a manifest file.
+func readManifest(t *testing.T, path string) []FileInfoOutput {
+	t.Helper()
+	var entries []FileInfoOutput
+	for _, line := range readLines(t, path) {
+		var entry FileInfoOutput
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to parse manifest line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestSubcommandDumpEmptyDir(t *testing.T) {
+	inputDir := t.TempDir()
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	config := Config{Workers: 4}
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+
+	if lines := readLines(t, outputFile); len(lines) != 0 {
+		t.Errorf("Expected an empty manifest, got %d entries", len(lines))
+	}
+}
+
+func TestSubcommandDumpCreatesOutputDir(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a.txt": "alpha"})
+	outputDir := t.TempDir()
+
+	// A missing parent directory is created, several levels deep.
+	outputFile := filepath.Join(outputDir, "newdir", "sub", "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+	if entries := readManifest(t, outputFile); len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+
+	// A bare file name is written to the current directory.
+	t.Chdir(outputDir)
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: "package.jsonl"})
+	})
+	if entries := readManifest(t, filepath.Join(outputDir, "package.jsonl")); len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+}
+
+func TestSubcommandDumpShard(t *testing.T) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 40 {
+		files[fmt.Sprintf("dir%d/file%d.bin", i%4, i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, inputDir, files)
+
+	config := Config{Workers: 2}
+	seen := make(map[string]int)
+	for index := range uint64(3) {
+		outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+		shard := &Shard{Index: index, Count: 3}
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, Shard: shard})
+		})
+		for _, entry := range readManifest(t, outputFile) {
+			if !shard.Contains(entry.FilePath) {
+				t.Errorf("%s does not belong to shard %d/3", entry.FilePath, index)
+			}
+			seen[entry.FilePath]++
+		}
+	}
+
+	if len(seen) != len(files) {
+		t.Errorf("Expected shards to cover %d files, got %d", len(files), len(seen))
+	}
+	for file, count := range seen {
+		if count != 1 {
+			t.Errorf("%s appeared in %d shards", file, count)
+		}
+	}
+}
+
+func TestSubcommandDumpShardByDir(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"root.txt":             "root",
+		"Audio/a.pck":          "audio a",
+		"Audio/nested/b.pck":   "audio b",
+		"Video/intro.usm":      "video",
+		"Data/StreamingAssets": "data",
+	})
+	outputDir := t.TempDir()
+	outputFile := filepath.Join(outputDir, "package.jsonl")
+
+	config := Config{Workers: 2}
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, ShardByDir: true})
+	})
+
+	expected := map[string][]string{
+		"package.jsonl":       {"root.txt"},
+		"package-Audio.jsonl": {"Audio/a.pck", "Audio/nested/b.pck"},
+		"package-Video.jsonl": {"Video/intro.usm"},
+		"package-Data.jsonl":  {"Data/StreamingAssets"},
+	}
+	outputs, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatalf("Failed to list output directory: %v", err)
+	}
+	if len(outputs) != len(expected) {
+		t.Errorf("Expected %d manifests, got %d", len(expected), len(outputs))
+	}
+	for name, files := range expected {
+		var got []string
+		for _, entry := range readManifest(t, filepath.Join(outputDir, name)) {
+			got = append(got, entry.FilePath)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, files) {
+			t.Errorf("%s: expected %v, got %v", name, files, got)
+		}
+	}
+}
+
+func TestSubcommandDumpPartialOnPanic(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "a", "b": "b", "c": "c"})
+	// A dangling symlink is walked as a file but can't be opened, which makes its worker panic.
+	if err := os.Symlink(filepath.Join(inputDir, "missing"), filepath.Join(inputDir, "z-broken")); err != nil {
+		t.Skipf("Symlinks are not available: %v", err)
+	}
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	// A single worker processes the files in walk order, so every file before the broken one is done.
+	var recovered any
+	runWithTimeout(t, 10*time.Second, func() {
+		defer func() { recovered = recover() }()
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+
+	if recovered == nil {
+		t.Fatal("Expected the worker panic to be raised again")
+	}
+	var got []string
+	for _, entry := range readManifest(t, outputFile) {
+		got = append(got, entry.FilePath)
+	}
+	if expected := []string{"a", "b", "c"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected the partial manifest to list %v, got %v", expected, got)
+	}
+}
+
+func TestSubcommandDumpSummary(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "a", "b": "bb", "c": "ccc"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	var summary DumpSummary
+	runWithTimeout(t, 10*time.Second, func() {
+		summary = subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+	if summary.Files != 3 || summary.Bytes != 6 || summary.Errors != 0 || summary.Duration <= 0 {
+		t.Errorf("Expected 3 files of 6 bytes without errors, got %+v", summary)
+	}
+
+	// The broken symlink is walked last, so with a single worker it is the only file to fail.
+	if err := os.Symlink(filepath.Join(inputDir, "missing"), filepath.Join(inputDir, "z-broken")); err != nil {
+		t.Skipf("Symlinks are not available: %v", err)
+	}
+	var recovered any
+	runWithTimeout(t, 10*time.Second, func() {
+		defer func() { recovered = recover() }()
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+	failure, ok := recovered.(*DumpFailure)
+	if !ok {
+		t.Fatalf("Expected a *DumpFailure panic, got %v", recovered)
+	}
+	if summary := failure.Summary; summary.Files != 3 || summary.Bytes != 6 || summary.Errors != 1 {
+		t.Errorf("Expected 3 files of 6 bytes and 1 error, got %+v", summary)
+	}
+}
+
+func TestShardUnmarshalText(t *testing.T) {
+	var shard Shard
+	if err := shard.UnmarshalText([]byte("2/5")); err != nil || shard != (Shard{Index: 2, Count: 5}) {
+		t.Errorf("Expected 2/5, got %+v (err: %v)", shard, err)
+	}
+	for _, invalid := range []string{"5/5", "1/0", "abc", "1"} {
+		if err := shard.UnmarshalText([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestClampWorkers(t *testing.T) {
+	for _, workers := range []int{0, -3} {
+		config := Config{Workers: workers}
+		config.clampWorkers()
+		if config.Workers != 1 {
+			t.Errorf("Expected %d workers to be clamped to 1, got %d", workers, config.Workers)
+		}
+	}
+
+	config := Config{Workers: 1 << 20}
+	config.clampWorkers()
+	if config.Workers != runtime.NumCPU()*maxThreadsPerCPU {
+		t.Errorf("Expected workers to be capped to %d, got %d", runtime.NumCPU()*maxThreadsPerCPU, config.Workers)
+	}
+}
+
+func TestSubcommandDumpZeroThreads(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a.txt": "a", "b/c.txt": "c"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	config := Config{Workers: 0}
+	config.clampWorkers()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+
+	if entries := readManifest(t, outputFile); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d", len(entries))
+	}
+}
+
+func TestIncludeDirsRoundTrip(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"data/file.bin": "content"})
+	if err := os.MkdirAll(filepath.Join(inputDir, "empty", "nested"), 0750); err != nil {
+		t.Fatalf("Failed to create empty directory: %v", err)
+	}
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	config := Config{Workers: 2}
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, IncludeDirs: true})
+	})
+
+	dirs := make(map[string]FileInfoOutput)
+	for _, entry := range readManifest(t, outputFile) {
+		if entry.IsDir {
+			dirs[entry.FilePath] = entry
+		}
+	}
+	for _, dir := range []string{"data", "empty", "empty/nested"} {
+		entry, ok := dirs[dir]
+		if !ok {
+			t.Fatalf("Expected a directory entry for %s", dir)
+		}
+		if entry.Size != 0 || entry.Md5Hash != "" || entry.Xxh64Hash != "" {
+			t.Errorf("Directory entry %s has content: %+v", dir, entry)
+		}
+	}
+	if len(dirs) != 3 {
+		t.Errorf("Expected 3 directory entries, got %d", len(dirs))
+	}
+
+	// Verify accepts the directory entries, and mirror recreates the empty directories.
+	pkgMap, err := readPkgFiles(inputDir, []string{outputFile}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if res, _ := compareFile(inputDir, FileInfo{FilePath: "empty/nested", IsDir: true}, compareOptions{}); res != CR_Same {
+		t.Errorf("Expected the directory to verify, got %s", res)
+	}
+	if res, _ := compareFile(inputDir, FileInfo{FilePath: "data/file.bin", IsDir: true}, compareOptions{}); res != CR_NotDir {
+		t.Errorf("Expected a file to fail a directory entry, got %s", res)
+	}
+
+	outputDir := t.TempDir()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(config, &MirrorCmd{OutputDir: outputDir, PkgFiles: []string{outputFile}})
+	})
+	stat, err := os.Stat(filepath.Join(outputDir, "empty", "nested"))
+	if err != nil || !stat.IsDir() {
+		t.Fatalf("Expected mirror to recreate the empty directory (err: %v)", err)
+	}
+	if runtime.GOOS != "windows" && stat.Mode().Perm() != 0750 {
+		t.Errorf("Expected mode 0750, got %o", stat.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "data", "file.bin.json")); err != nil {
+		t.Errorf("Expected the file sidecar to be mirrored: %v", err)
+	}
+	if len(pkgMap) != 4 {
+		t.Errorf("Expected 4 manifest entries, got %d", len(pkgMap))
+	}
+}
+
+func TestReadPkgFileRejectsDirWithHash(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	if err := os.WriteFile(manifest, []byte(`{"remoteName":"dir","md5":"00","hash":"","fileSize":0,"dir":true}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if err := readPkgFile(manifest, make(map[string]FileInfoOutput)); err == nil {
+		t.Error("Expected an error for a directory entry with a hash")
+	}
+}
+
+func TestWritePkgEntryMatchesMarshal(t *testing.T) {
+	uid := uint32(1000)
+	results := []FileInfo{
+		{FilePath: "plain.bin", Md5Hash: []byte{0x01, 0x02}, Xxh64Hash: []byte{0x03}, Size: 2},
+		{FilePath: "dir/<html> & \"quotes\" .txt", Size: 0, Uid: &uid, Gid: &uid, ModTime: time.Unix(0, 1)},
+		{FilePath: "attrs", Xattrs: map[string][]byte{"user.b": {0xff}, "user.a": []byte("x")}},
+		{FilePath: "dir", IsDir: true, Mode: 0o750},
+	}
+
+	var expected []byte
+	for _, result := range results {
+		jsonBytes, err := json.Marshal(toFileInfoOutput(result, entryFormat{}))
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %v", result.FilePath, err)
+		}
+		expected = append(expected, jsonBytes...)
+		expected = append(expected, '\n')
+	}
+
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	ch := make(chan FileInfo, len(results))
+	for _, result := range results {
+		ch <- result
+	}
+	close(ch)
+	pkgOutWriter(manifest, ch, entryFormat{}, false)
+
+	got, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+// BenchmarkPkgOutWriter compares writing a large result set with json.Marshal per entry, as the writer used to,
+// and with the buffered encoder.
+func BenchmarkPkgOutWriter(b *testing.B) {
+	const entries = 100_000
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel) // Keep the per-entry log line out of the measurement
+	b.Cleanup(func() { zerolog.SetGlobalLevel(previous) })
+
+	hash := make([]byte, 16)
+	results := make([]FileInfo, entries)
+	for i := range results {
+		results[i] = FileInfo{FilePath: fmt.Sprintf("dir%d/sub%d/file%d.bin", i%100, i%7, i), Md5Hash: hash, Xxh64Hash: hash[:8], Size: int64(i)}
+	}
+	feed := func() <-chan FileInfo {
+		ch := make(chan FileInfo, 1024)
+		go func() {
+			defer close(ch)
+			for _, result := range results {
+				ch <- result
+			}
+		}()
+		return ch
+	}
+	manifest := filepath.Join(b.TempDir(), "package.jsonl")
+
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			outFile, err := os.Create(manifest)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for result := range feed() {
+				jsonBytes, err := json.Marshal(toFileInfoOutput(result, entryFormat{}))
+				if err != nil {
+					b.Fatal(err)
+				}
+				fmt.Fprintln(outFile, string(jsonBytes))
+			}
+			outFile.Close()
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			pkgOutWriter(manifest, feed(), entryFormat{}, false)
+		}
+	})
+}
+
+// BenchmarkDumpPinWorkers dumps a large single tree with and without --pin-workers, with as many workers
+// as the default hashing setup would use on a busy machine.
+func BenchmarkDumpPinWorkers(b *testing.B) {
+	const files, fileSize = 128, 1 << 20
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel) // Keep the per-entry log line out of the measurement
+	b.Cleanup(func() { zerolog.SetGlobalLevel(previous) })
+
+	inputDir := b.TempDir()
+	content := bytes.Repeat([]byte("dder"), fileSize/4)
+	for i := range files {
+		dir := filepath.Join(inputDir, fmt.Sprintf("dir%d", i%8))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.bin", i)), content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	manifest := filepath.Join(b.TempDir(), "package.jsonl")
+	threads := runtime.GOMAXPROCS(0) * 4
+
+	for _, pin := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pin=%v", pin), func(b *testing.B) {
+			b.SetBytes(files * fileSize)
+			for b.Loop() {
+				subcommandDump(Config{Workers: threads}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, PinWorkers: pin})
+			}
+		})
+	}
+}
+
+func TestPkgOutWriterLineBuffered(t *testing.T) {
+	const entries = 10
+	for _, lineBuffered := range []bool{true, false} {
+		manifest := filepath.Join(t.TempDir(), "package.jsonl")
+		results := make(chan FileInfo) // Unbuffered: once entry N+1 is received, entry N has been written
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pkgOutWriter(manifest, results, entryFormat{}, lineBuffered)
+		}()
+
+		for i := range entries + 1 {
+			results <- FileInfo{FilePath: fmt.Sprintf("file%d", i), Md5Hash: []byte{byte(i)}, Xxh64Hash: []byte{byte(i)}, Size: int64(i)}
+		}
+		// A concurrent reader, while the writer is still running.
+		got := len(readLines(t, manifest))
+		if lineBuffered && got < entries {
+			t.Errorf("Expected a reader to see at least %d lines with line buffering, got %d", entries, got)
+		}
+		if !lineBuffered && got != 0 {
+			t.Errorf("Expected a few small entries to stay buffered, got %d lines", got)
+		}
+
+		close(results)
+		<-done
+		if got := len(readLines(t, manifest)); got != entries+1 {
+			t.Errorf("Expected %d lines once closed, got %d", entries+1, got)
+		}
+	}
+}
+
+func TestSubcommandDumpMultipleRoots(t *testing.T) {
+	base := t.TempDir()
+	gameDir, dlcDir := filepath.Join(base, "game"), filepath.Join(base, "dlc")
+	writeTree(t, gameDir, map[string]string{"data/a.pak": "game a", "data/b.pak": "game b"})
+	writeTree(t, dlcDir, map[string]string{"data/a.pak": "dlc a"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	dump := func(prefix bool) map[string]FileInfoOutput {
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: gameDir, MoreInputDirs: []string{dlcDir}, OutputFile: outputFile, PrefixWithRoot: prefix})
+		})
+		entries := make(map[string]FileInfoOutput)
+		for _, entry := range readManifest(t, outputFile) {
+			if _, ok := entries[entry.FilePath]; ok && prefix {
+				t.Errorf("%s is listed twice", entry.FilePath)
+			}
+			entries[entry.FilePath] = entry
+		}
+		return entries
+	}
+
+	entries := dump(true)
+	expected := map[string]string{"game/data/a.pak": "game a", "game/data/b.pak": "game b", "dlc/data/a.pak": "dlc a"}
+	if len(entries) != len(expected) {
+		t.Errorf("Expected %d entries, got %v", len(expected), entries)
+	}
+	for name, content := range expected {
+		md5Hash, _, _, _ := processFileReader(strings.NewReader(content))
+		if entries[name].Md5Hash != hex.EncodeToString(md5Hash) {
+			t.Errorf("%s: expected the hash of %q, got %+v", name, content, entries[name])
+		}
+	}
+
+	// Without prefixes the paths are kept as-is, and the overlapping one is listed twice.
+	if entries := dump(false); len(entries) != 2 || len(readManifest(t, outputFile)) != 3 {
+		t.Errorf("Expected 3 unprefixed entries under 2 names, got %v", entries)
+	}
+}
+
+func TestSubcommandDumpWriters(t *testing.T) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 50 {
+		files[fmt.Sprintf("dir%d/file%d", i%5, i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, inputDir, files)
+	outDir := t.TempDir()
+
+	entrySet := func(entries []FileInfoOutput) []string {
+		var set []string
+		for _, entry := range entries {
+			set = append(set, entry.FilePath+" "+entry.Md5Hash)
+		}
+		slices.Sort(set)
+		return set
+	}
+	single := filepath.Join(outDir, "single.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: single})
+	})
+	expected := entrySet(readManifest(t, single))
+
+	// Every writer file is a valid manifest on its own, readManifest fails the test otherwise.
+	sharded := filepath.Join(outDir, "sharded.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: sharded, Writers: 3})
+	})
+	var entries []FileInfoOutput
+	for i := range 3 {
+		entries = append(entries, readManifest(t, writerShardFileName(sharded, i, 3))...)
+	}
+	if got := entrySet(entries); !slices.Equal(got, expected) {
+		t.Errorf("Expected the writer files to hold %v, got %v", expected, got)
+	}
+
+	concatenated := filepath.Join(outDir, "concatenated.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: concatenated, Writers: 3, ConcatWriters: true})
+	})
+	if got := entrySet(readManifest(t, concatenated)); !slices.Equal(got, expected) {
+		t.Errorf("Expected the concatenated manifest to hold %v, got %v", expected, got)
+	}
+	for i := range 3 {
+		if _, err := os.Stat(writerShardFileName(concatenated, i, 3)); !os.IsNotExist(err) {
+			t.Errorf("Expected writer file %d to be removed, got %v", i, err)
+		}
+	}
+}
+
+func TestSubcommandDumpSortSpill(t *testing.T) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 60 {
+		files[fmt.Sprintf("dir%d/sub%d/file%d", i%4, i%3, i)] = fmt.Sprintf("content %d", i)
+	}
+	files["top"] = "top"
+	writeTree(t, inputDir, files)
+	outDir := t.TempDir()
+
+	inMemory := filepath.Join(outDir, "memory.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: inMemory, Sort: true})
+	})
+	// Spilling every 7 entries merges 9 runs, with a lone inflight entry between the workers and the writer.
+	spilled := filepath.Join(outDir, "spilled.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: spilled, Sort: true, SortSpill: 7, MaxInflight: 1})
+	})
+
+	expected, err := os.ReadFile(inMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(spilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("Expected the spilled manifest to match the in-memory one:\n%s\ngot:\n%s", expected, got)
+	}
+
+	entries := readManifest(t, inMemory)
+	if len(entries) != len(files) {
+		t.Fatalf("Expected %d entries, got %d", len(files), len(entries))
+	}
+	if !slices.IsSortedFunc(entries, func(a, b FileInfoOutput) int {
+		return strings.Compare(diskIndexKey(a.FilePath), diskIndexKey(b.FilePath))
+	}) {
+		t.Errorf("Expected entries sorted by directory then name, got %v", entries)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultTextExtensions are the extensions of the files normalized by --normalize-eol when no --text-ext is given.
+var defaultTextExtensions = []string{".txt", ".json", ".jsonl", ".xml", ".csv", ".ini", ".cfg", ".yaml", ".yml", ".lua", ".md"}
+
+// isTextFile tells whether path has one of the text extensions, compared ignoring case, or one of the default
+// ones when exts is empty.
+func isTextFile(path string, exts []string) bool {
+	if len(exts) == 0 {
+		exts = defaultTextExtensions
+	}
+	ext := filepath.Ext(path)
+	return ext != "" && slices.ContainsFunc(exts, func(textExt string) bool {
+		return strings.EqualFold(ext, textExt)
+	})
+}
+
+// eolNormalizer reads from r with every CRLF collapsed to LF, so a text file hashes the same whatever the line
+// endings it was saved with. A lone CR is kept.
+type eolNormalizer struct {
+	r         io.Reader
+	pendingCR bool // A CR ended the previous read, it is dropped if the next byte read is a LF
+}
+
+func (n *eolNormalizer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	start := 0
+	if n.pendingCR {
+		p[0] = '\r'
+		start = 1
+		n.pendingCR = false
+	}
+	m, err := n.r.Read(p[start:])
+	buf := p[:start+m]
+
+	// Compact in place, the output is never longer than what was read.
+	out := 0
+	for i := 0; i < len(buf); i++ {
+		if buf[i] == '\r' {
+			if i+1 < len(buf) && buf[i+1] == '\n' {
+				continue
+			}
+			if i+1 == len(buf) && err == nil {
+				n.pendingCR = true // Held back until the next read tells whether a LF follows
+				continue
+			}
+		}
+		buf[out] = buf[i]
+		out++
+	}
+	return out, err
+}
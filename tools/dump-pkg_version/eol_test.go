@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestEOLNormalizer(t *testing.T) {
+	cases := map[string]string{
+		"a\r\nb\r\n":     "a\nb\n",
+		"a\rb\r":         "a\rb\r",
+		"\r\r\n\n":       "\r\n\n",
+		"no line ending": "no line ending",
+		"":               "",
+	}
+	for input, expected := range cases {
+		// One byte at a time, every CRLF is split across two reads.
+		got, err := io.ReadAll(&eolNormalizer{r: iotest.OneByteReader(strings.NewReader(input))})
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if string(got) != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestIsTextFile(t *testing.T) {
+	if !isTextFile("dir/config.JSON", nil) || isTextFile("dir/data.bin", nil) || isTextFile("README", nil) {
+		t.Errorf("Unexpected default text extensions")
+	}
+	if !isTextFile("dir/data.bin", []string{".bin"}) || isTextFile("dir/config.json", []string{".bin"}) {
+		t.Errorf("Expected the given extensions to replace the default ones")
+	}
+}
+
+func TestNormalizeEOL(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"text.txt": "line 1\r\nline 2\r\n", "data.bin": "bin\r\n"})
+
+	dump := func(normalize bool) map[string]FileInfoOutput {
+		outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, NormalizeEOL: normalize})
+		})
+		entries := make(map[string]FileInfoOutput)
+		for _, entry := range readManifest(t, outputFile) {
+			entries[entry.FilePath] = entry
+		}
+		return entries
+	}
+	raw, normalized := dump(false), dump(true)
+
+	lf, _, _, _ := processFileReader(strings.NewReader("line 1\nline 2\n"))
+	if normalized["text.txt"].Md5Hash != hex.EncodeToString(lf) || normalized["text.txt"].Size != 14 {
+		t.Errorf("Expected the text file to be hashed as LF, got %+v", normalized["text.txt"])
+	}
+	if raw["text.txt"].Md5Hash == normalized["text.txt"].Md5Hash || raw["text.txt"].Size != 16 {
+		t.Errorf("Expected the raw hash to differ from the normalized one, got %+v", raw["text.txt"])
+	}
+	if !reflect.DeepEqual(raw["data.bin"], normalized["data.bin"]) {
+		t.Errorf("Expected the binary file to never be normalized, got %+v and %+v", raw["data.bin"], normalized["data.bin"])
+	}
+
+	// The same text saved with LF endings matches the normalized entry only when verified normalized.
+	writeTree(t, inputDir, map[string]string{"text.txt": "line 1\nline 2\n"})
+	entry := normalized["text.txt"]
+	file := FileInfo{FilePath: entry.FilePath, Md5Hash: decodeHex(entry.Md5Hash), Xxh64Hash: decodeHex(entry.Xxh64Hash), Size: entry.Size}
+	if result, err := compareFile(inputDir, file, compareOptions{}); result != CR_Same {
+		t.Errorf("Expected a LF file to match raw too, as it has nothing to normalize, got %s (err: %v)", result, err)
+	}
+	normalizedOpts := compareOptions{NormalizeEOL: true}
+	if result, err := compareFile(inputDir, file, normalizedOpts); result != CR_Same {
+		t.Errorf("Expected a normalized LF file to match, got %s (err: %v)", result, err)
+	}
+	writeTree(t, inputDir, map[string]string{"text.txt": "line 1\r\nline 2\r\n"})
+	if result, err := compareFile(inputDir, file, normalizedOpts); result != CR_Same {
+		t.Errorf("Expected a normalized CRLF file to match, got %s (err: %v)", result, err)
+	}
+	if result, err := compareFile(inputDir, file, compareOptions{}); result != CR_SizeLarger {
+		t.Errorf("Expected a raw CRLF file not to match, got %s (err: %v)", result, err)
+	}
+
+	// A verified dump compares the files hashed the way it dumped them.
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: filepath.Join(t.TempDir(), "package.jsonl"), NormalizeEOL: true, VerifyAfter: true})
+	})
+}
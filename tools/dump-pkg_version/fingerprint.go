@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/zeebo/xxh3"
+)
+
+// fingerprintChunkSize is the number of bytes hashed at each end of a file for its fingerprint.
+const fingerprintChunkSize = 64 * 1024
+
+// computeFingerprint returns a cheap fingerprint of a file: the XXH64 of its size,
+// its first fingerprintChunkSize bytes and its last fingerprintChunkSize bytes.
+// Files up to twice the chunk size are hashed entirely.
+//
+// A differing fingerprint always means the file changed, but a matching fingerprint doesn't prove
+// the file is unchanged: modifications confined to the middle of a large file are not detected,
+// which is why a full hash is still needed to confirm a match.
+func computeFingerprint(r io.ReaderAt, size int64) ([]byte, error) {
+	h := xxh3.New()
+	binary.Write(h, binary.LittleEndian, size) // Writing to a hasher never fails
+
+	head := min(size, fingerprintChunkSize)
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, head)); err != nil {
+		return nil, err
+	}
+	tailStart := max(head, size-fingerprintChunkSize) // Never hash a byte twice
+	if _, err := io.Copy(h, io.NewSectionReader(r, tailStart, size-tailStart)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
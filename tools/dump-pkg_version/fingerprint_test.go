@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprintMissesMiddleChange(t *testing.T) {
+	root := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4*fingerprintChunkSize/16) // 4 chunks
+	path := filepath.Join(root, "big.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := processFile(root, path)
+	if err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	file.Fingerprint, err = fingerprintFile(path)
+	if err != nil {
+		t.Fatalf("Failed to fingerprint file: %v", err)
+	}
+	if result, _ := compareFile(root, file, compareOptions{}); result != CR_Same {
+		t.Fatalf("Expected CR_Same before modification, got %s", result)
+	}
+
+	// A change in the middle keeps the fingerprint, only the full hash catches it.
+	middle := bytes.Clone(content)
+	middle[len(middle)/2] ^= 0xff
+	if err := os.WriteFile(path, middle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := fingerprintFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fingerprint, file.Fingerprint) {
+		t.Errorf("Expected the fingerprint to miss a change in the middle")
+	}
+	if result, _ := compareFile(root, file, compareOptions{}); result != CR_Md5Dif {
+		t.Errorf("Expected the full hash to catch the change, got %s", result)
+	}
+
+	// A change in the head is caught by the fingerprint alone.
+	head := bytes.Clone(content)
+	head[0] ^= 0xff
+	if err := os.WriteFile(path, head, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := compareFile(root, file, compareOptions{}); result != CR_FingerprintDif {
+		t.Errorf("Expected CR_FingerprintDif for a change in the head, got %s", result)
+	}
+}
+
+func TestFingerprintSmallFile(t *testing.T) {
+	// Files shorter than two chunks are hashed entirely, so any change is detected.
+	a, err := computeFingerprint(bytes.NewReader([]byte("hello world")), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := computeFingerprint(bytes.NewReader([]byte("hello_world")), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Errorf("Expected different fingerprints for different small files")
+	}
+}
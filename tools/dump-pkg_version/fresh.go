@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// staleFiles walks inputDir and returns the relative paths of the files modified after the newest of pkgFiles,
+// which a manifest written before them can't describe. The pkg files themselves are skipped.
+func staleFiles(inputDir string, pkgFiles []string) ([]string, error) {
+	if len(pkgFiles) == 0 {
+		return nil, errors.New("no pkg file to compare against")
+	}
+	var manifestTime time.Time
+	manifests := make(map[string]bool)
+	for _, pkgFile := range pkgFiles {
+		stat, err := os.Stat(pkgFile)
+		if err != nil {
+			return nil, err
+		}
+		if stat.ModTime().After(manifestTime) {
+			manifestTime = stat.ModTime()
+		}
+		absPath, err := filepath.Abs(pkgFile)
+		if err != nil {
+			return nil, err
+		}
+		manifests[absPath] = true
+	}
+
+	var stale []string
+	err := filepath.WalkDir(inputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if manifests[absPath] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(manifestTime) {
+			relPath, err := filepath.Rel(inputDir, path)
+			if err != nil {
+				return err
+			}
+			stale = append(stale, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk input directory %s: %w", inputDir, err)
+	}
+	return stale, nil
+}
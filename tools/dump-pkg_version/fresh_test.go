@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRequireFresh(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"old": "old", "dir/old": "old", "dir/touched": "touched"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+
+	// The tree predates the manifest, then one file is touched after it was written.
+	written := time.Now().Add(-time.Hour)
+	for path, mtime := range map[string]time.Time{
+		manifest:                                  written,
+		filepath.Join(inputDir, "old"):            written.Add(-time.Minute),
+		filepath.Join(inputDir, "dir", "old"):     written.Add(-time.Minute),
+		filepath.Join(inputDir, "dir", "touched"): written.Add(time.Minute),
+	} {
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Failed to touch %s: %v", path, err)
+		}
+	}
+
+	stale, err := staleFiles(inputDir, []string{manifest})
+	if err != nil {
+		t.Fatalf("Failed to check freshness: %v", err)
+	}
+	if expected := []string{"dir/touched"}; !slices.Equal(stale, expected) {
+		t.Errorf("Expected %v to be stale, got %v", expected, stale)
+	}
+
+	verify := func(requireFresh string) (recovered any) {
+		runWithTimeout(t, 10*time.Second, func() {
+			defer func() { recovered = recover() }()
+			subcommandVerify(Config{Workers: 2}, &VerifyCmd{InputDir: inputDir, PkgFiles: []string{manifest}, RequireFresh: requireFresh})
+		})
+		return recovered
+	}
+	if r := verify("warn"); r != nil {
+		t.Errorf("Expected a stale manifest to only warn, got %v", r)
+	}
+	if r := verify("fail"); r == nil {
+		t.Errorf("Expected a stale manifest to fail")
+	}
+	if r := verify("sometimes"); r == nil {
+		t.Errorf("Expected an unknown behavior to be rejected")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// genFanout is the number of directory names to pick from at each level of a generated tree.
+const genFanout = 4
+
+// genOptions describes a synthetic tree. Every file is derived from Seed and its index alone, so the same options
+// always produce byte-identical trees, whatever the number of workers.
+type genOptions struct {
+	Files    int
+	MinSize  int64
+	MaxSize  int64
+	SizeDist string // "uniform" or "log"
+	Depth    int
+	Seed     uint64
+}
+
+func subcommandGen(config Config, genCmd *GenCmd) {
+	if genCmd.OutputDir == "" {
+		log.Panic().Msg("Output directory is required")
+	}
+
+	opts := genOptions{
+		Files:    genCmd.Files,
+		MinSize:  genCmd.MinSize,
+		MaxSize:  genCmd.MaxSize,
+		SizeDist: genCmd.SizeDist,
+		Depth:    genCmd.Depth,
+	}
+	if genCmd.Seed != nil {
+		opts.Seed = *genCmd.Seed
+	} else {
+		opts.Seed = rand.Uint64()
+	}
+	// The seed is always logged, so a run with a random one can be repeated.
+	log.Info().Uint64("seed", opts.Seed).Int("files", opts.Files).Msg("Generating tree")
+
+	if err := generateTree(genCmd.OutputDir, opts, config.Workers); err != nil {
+		log.Panic().Err(err).Msg("Failed to generate tree")
+	}
+	log.Info().Uint64("seed", opts.Seed).Str("output", genCmd.OutputDir).Msg("Tree generated")
+}
+
+// generateTree creates the files described by opts under outputDir, using a pool of threads workers.
+func generateTree(outputDir string, opts genOptions, threads int) error {
+	if opts.Files < 0 || opts.Depth < 0 || opts.MinSize < 0 || opts.MaxSize < opts.MinSize {
+		return fmt.Errorf("invalid options %+v", opts)
+	}
+	if opts.SizeDist != "uniform" && opts.SizeDist != "log" {
+		return fmt.Errorf("unknown size distribution %q", opts.SizeDist)
+	}
+
+	indexes := make(chan int, threads)
+	go func() {
+		defer close(indexes)
+		for i := range opts.Files {
+			indexes <- i
+		}
+	}()
+	var errOnce sync.Once
+	var firstErr error
+	generated := RunPool(indexes, max(threads, 1), func(i int) (struct{}, error) {
+		err := generateFile(outputDir, opts, i)
+		if err != nil {
+			errOnce.Do(func() { firstErr = err })
+		}
+		return struct{}{}, err
+	})
+	for range generated { // Wait for every file to be written.
+	}
+	return firstErr
+}
+
+// generateFile creates the file of the given index, its path, size and content all derived from the seed and index.
+func generateFile(outputDir string, opts genOptions, i int) error {
+	rng := rand.New(rand.NewPCG(opts.Seed, uint64(i)))
+
+	// Directories are picked among a few names per level, so files share directories at every depth.
+	dir := ""
+	for range rng.IntN(opts.Depth + 1) {
+		dir = path.Join(dir, fmt.Sprintf("dir%d", rng.IntN(genFanout)))
+	}
+	relPath := path.Join(dir, fmt.Sprintf("file%06d.bin", i))
+	size := genSize(rng, opts)
+
+	fullPath := filepath.Join(outputDir, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(fullPath)
+	if err != nil {
+		return err
+	}
+	// The content comes from its own stream, seeded with the seed and index too.
+	var chachaSeed [32]byte
+	binary.LittleEndian.PutUint64(chachaSeed[0:], opts.Seed)
+	binary.LittleEndian.PutUint64(chachaSeed[8:], uint64(i))
+	_, err = io.CopyN(out, rand.NewChaCha8(chachaSeed), size)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", fullPath, err)
+	}
+	log.Debug().Str("file", relPath).Int64("size", size).Msg("Generated")
+	return nil
+}
+
+// genSize picks a file size between the bounds of opts. The log distribution is uniform over the orders of magnitude,
+// so most files are small and a few are large, like a real game install.
+func genSize(rng *rand.Rand, opts genOptions) int64 {
+	if opts.MaxSize == opts.MinSize {
+		return opts.MinSize
+	}
+	if opts.SizeDist == "uniform" {
+		return opts.MinSize + rng.Int64N(opts.MaxSize-opts.MinSize+1)
+	}
+	// Shifted by one so a zero lower bound still has a logarithm.
+	lo, hi := math.Log(float64(opts.MinSize+1)), math.Log(float64(opts.MaxSize+1))
+	size := int64(math.Exp(lo+rng.Float64()*(hi-lo))) - 1
+	return min(max(size, opts.MinSize), opts.MaxSize)
+}
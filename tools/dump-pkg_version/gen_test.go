@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateTreeDeterministic(t *testing.T) {
+	opts := genOptions{Files: 50, MinSize: 0, MaxSize: 64 * 1024, SizeDist: "log", Depth: 3, Seed: 42}
+
+	// The same seed gives byte-identical trees, whatever the number of workers.
+	first, second := t.TempDir(), t.TempDir()
+	if err := generateTree(first, opts, 1); err != nil {
+		t.Fatalf("Failed to generate tree: %v", err)
+	}
+	if err := generateTree(second, opts, 4); err != nil {
+		t.Fatalf("Failed to generate tree: %v", err)
+	}
+	added, removed, changed, err := DiffDirs(first, second, 2)
+	if err != nil {
+		t.Fatalf("Failed to compare trees: %v", err)
+	}
+	if len(added)+len(removed)+len(changed) != 0 {
+		t.Errorf("Expected identical trees, got added %v, removed %v, changed %v", added, removed, changed)
+	}
+
+	count := 0
+	err = filepath.WalkDir(first, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+			if info, err := d.Info(); err != nil || info.Size() > opts.MaxSize {
+				t.Errorf("Unexpected file %s: %v, %v", path, info, err)
+			}
+		}
+		return err
+	})
+	if err != nil || count != opts.Files {
+		t.Errorf("Expected %d files, got %d (err: %v)", opts.Files, count, err)
+	}
+
+	// Another seed gives another tree.
+	opts.Seed = 43
+	other := t.TempDir()
+	if err := generateTree(other, opts, 2); err != nil {
+		t.Fatalf("Failed to generate tree: %v", err)
+	}
+	added, removed, changed, err = DiffDirs(first, other, 2)
+	if err != nil {
+		t.Fatalf("Failed to compare trees: %v", err)
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		t.Errorf("Expected different seeds to give different trees")
+	}
+}
+
+func TestGenerateTreeRejectsInvalidOptions(t *testing.T) {
+	for _, opts := range []genOptions{
+		{Files: 1, MinSize: 10, MaxSize: 5, SizeDist: "log"},
+		{Files: 1, MaxSize: 5, SizeDist: "normal"},
+		{Files: 1, MaxSize: 5, SizeDist: "uniform", Depth: -1},
+	} {
+		if err := generateTree(t.TempDir(), opts, 1); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
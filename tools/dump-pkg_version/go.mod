@@ -3,12 +3,24 @@ module example/tools/dump-pkg_version
 go 1.24.2
 
 require (
-	github.com/alexflint/go-arg v1.5.1 // indirect
+	example/hello v0.0.0-00010101000000-000000000000
+	github.com/BurntSushi/toml v1.5.0
+	github.com/alexflint/go-arg v1.5.1
+	github.com/klauspost/cpuid/v2 v2.0.9
+	github.com/rs/zerolog v1.34.0
+	github.com/samber/lo v1.49.1
+	github.com/zeebo/xxh3 v1.0.2
+	golang.org/x/sys v0.32.0
+	resty.dev/v3 v3.0.0-beta.2
+)
+
+require (
 	github.com/alexflint/go-scalar v1.2.0 // indirect
-	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
-	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.19 // indirect
-	github.com/rs/zerolog v1.34.0 // indirect
-	github.com/zeebo/xxh3 v1.0.2 // indirect
-	golang.org/x/sys v0.12.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
+
+replace example/hello => ../../main
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Names of the built-in hash algorithms, recorded in every manifest entry as its "md5" and "hash" fields.
+const (
+	hasherMD5   = "md5"
+	hasherXXH64 = "xxh64"
+)
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = map[string]func() hash.Hash{
+		hasherMD5:   md5.New,
+		hasherXXH64: func() hash.Hash { return xxh3.New() },
+	}
+)
+
+// RegisterHasher makes a hash algorithm available by name to dump --hash, which then records its hexadecimal
+// digest under that name in the "hashes" field of every entry. It panics when the name is empty, already
+// registered (the built-in md5 and xxh64 included) or the factory is nil. Selecting a name that was never
+// registered makes the dump fail with an error listing the registered algorithms.
+func RegisterHasher(name string, factory func() hash.Hash) {
+	if name == "" || factory == nil {
+		panic("RegisterHasher: empty name or nil factory")
+	}
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	if _, ok := hashers[name]; ok {
+		panic(fmt.Sprintf("RegisterHasher: %s is already registered", name))
+	}
+	hashers[name] = factory
+}
+
+// newHasher returns a new hash of the algorithm registered under name.
+func newHasher(name string) (hash.Hash, error) {
+	hashersMu.RLock()
+	factory, ok := hashers[name]
+	hashersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q, registered algorithms are %s", name, strings.Join(registeredHashers(), ", "))
+	}
+	return factory(), nil
+}
+
+// registeredHashers returns the sorted names of the registered hash algorithms.
+func registeredHashers() []string {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// extraHashNames checks every name is registered and returns them without duplicates, leaving out the built-in
+// algorithms as they are always recorded.
+func extraHashNames(names []string) ([]string, error) {
+	var extra []string
+	for _, name := range names {
+		if _, err := newHasher(name); err != nil {
+			return nil, err
+		}
+		if name != hasherMD5 && name != hasherXXH64 && !slices.Contains(extra, name) {
+			extra = append(extra, name)
+		}
+	}
+	return extra, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registerTestHasher registers a CRC-32 hasher once, as registering the same name twice panics.
+var registerTestHasher = sync.OnceFunc(func() {
+	RegisterHasher("test-crc32", func() hash.Hash { return crc32.NewIEEE() })
+})
+
+func TestRegisterHasher(t *testing.T) {
+	registerTestHasher()
+
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "hello", "dir/b": "world"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2, Hashes: []string{"test-crc32", "md5", "test-crc32"}}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+
+	entries := readManifest(t, outputFile)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		content := map[string]string{"a": "hello", "dir/b": "world"}[entry.FilePath]
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(content))
+		expected := map[string]string{"test-crc32": hex.EncodeToString(crc.Sum(nil))}
+		if len(entry.Hashes) != 1 || entry.Hashes["test-crc32"] != expected["test-crc32"] {
+			t.Errorf("%s: expected hashes %v, got %v", entry.FilePath, expected, entry.Hashes)
+		}
+		if entry.Md5Hash == "" || entry.Xxh64Hash == "" {
+			t.Errorf("%s: expected the built-in hashes to be recorded", entry.FilePath)
+		}
+	}
+}
+
+func TestUnknownHasher(t *testing.T) {
+	_, err := extraHashNames([]string{"md5", "no-such-hash"})
+	if err == nil || !strings.Contains(err.Error(), "no-such-hash") || !strings.Contains(err.Error(), "xxh64") {
+		t.Errorf("Expected an error naming the unknown and registered algorithms, got %v", err)
+	}
+}
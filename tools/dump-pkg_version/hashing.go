@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/md5"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+// HashingWriter is an io.Writer that forwards every byte to an underlying writer
+// while computing the MD5 and XXH64 hashes and the size of everything written.
+// It lets callers verify data while writing it, without reading it back afterwards.
+type HashingWriter struct {
+	w      io.Writer // Underlying writer, may be io.Discard to only hash
+	hMD5   hash.Hash
+	hXXH64 *xxh3.Hasher
+	seed   uint64               // Seed of hXXH64, kept across Reset
+	extra  map[string]hash.Hash // Registered hashes added with AddHasher, by name
+	size   int64
+}
+
+// NewHashingWriter returns a HashingWriter forwarding to w.
+func NewHashingWriter(w io.Writer) *HashingWriter {
+	return &HashingWriter{
+		w:      w,
+		hMD5:   md5.New(),
+		hXXH64: xxh3.New(),
+	}
+}
+
+// Reset clears the hashes and size and makes the writer forward to w, so it can be reused for another file.
+func (hw *HashingWriter) Reset(w io.Writer) {
+	hw.w = w
+	hw.hMD5.Reset()
+	hw.hXXH64.Reset()
+	hw.extra = nil // Added hashers only last for one file
+	hw.size = 0
+}
+
+// SetSeed makes the writer compute the XXH64 hash with seed, 0 being the unseeded hash. The seed is kept across
+// Reset, so a pooled writer only builds a new hasher when the seed changes.
+func (hw *HashingWriter) SetSeed(seed uint64) {
+	if seed != hw.seed {
+		hw.hXXH64 = xxh3.NewSeed(seed)
+		hw.seed = seed
+	}
+}
+
+// AddHasher makes the writer also compute the hash of the algorithm registered under name, until the next Reset.
+func (hw *HashingWriter) AddHasher(name string) error {
+	h, err := newHasher(name)
+	if err != nil {
+		return err
+	}
+	if hw.extra == nil {
+		hw.extra = make(map[string]hash.Hash)
+	}
+	hw.extra[name] = h
+	return nil
+}
+
+// hashingWriterPool recycles HashingWriters across files, avoiding new hasher allocations per file.
+var hashingWriterPool = sync.Pool{
+	New: func() any { return NewHashingWriter(io.Discard) },
+}
+
+// getHashingWriter takes a reset HashingWriter forwarding to w from the pool.
+func getHashingWriter(w io.Writer) *HashingWriter {
+	hw := hashingWriterPool.Get().(*HashingWriter)
+	hw.Reset(w)
+	return hw
+}
+
+// putHashingWriter returns a HashingWriter to the pool, it must not be used afterwards.
+func putHashingWriter(hw *HashingWriter) {
+	hw.Reset(io.Discard) // Don't keep the underlying writer alive while pooled
+	hashingWriterPool.Put(hw)
+}
+
+// Write writes p to the underlying writer, then hashes the bytes that were actually written.
+func (hw *HashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	// Hashers never return errors, only hash what reached the underlying writer.
+	hw.hMD5.Write(p[:n])
+	hw.hXXH64.Write(p[:n])
+	for _, h := range hw.extra {
+		h.Write(p[:n])
+	}
+	hw.size += int64(n)
+	return n, err
+}
+
+// Result returns the hashes and size of everything written so far, FilePath is left empty.
+func (hw *HashingWriter) Result() FileInfo {
+	var hashes map[string][]byte
+	if len(hw.extra) > 0 {
+		hashes = make(map[string][]byte, len(hw.extra))
+		for name, h := range hw.extra {
+			hashes[name] = h.Sum(nil)
+		}
+	}
+	return FileInfo{
+		Md5Hash:   hw.hMD5.Sum(nil),
+		Xxh64Hash: hw.hXXH64.Sum(nil),
+		Size:      hw.size,
+		Hashes:    hashes,
+	}
+}
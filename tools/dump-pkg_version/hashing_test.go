@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"testing"
+
+	"github.com/zeebo/xxh3"
+)
+
+func TestHashingWriter(t *testing.T) {
+	chunks := [][]byte{
+		[]byte("hello "),
+		{},
+		[]byte("streaming "),
+		bytes.Repeat([]byte("x"), 4096),
+		[]byte("world"),
+	}
+
+	var buf bytes.Buffer
+	hw := NewHashingWriter(&buf)
+	for _, chunk := range chunks {
+		n, err := hw.Write(chunk)
+		if err != nil || n != len(chunk) {
+			t.Fatalf("Write returned (%d, %v), want (%d, nil)", n, err, len(chunk))
+		}
+	}
+
+	expected := bytes.Join(chunks, nil)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Forwarded bytes differ from written bytes")
+	}
+
+	result := hw.Result()
+	expectedMd5 := md5.Sum(expected)
+	if !bytes.Equal(result.Md5Hash, expectedMd5[:]) {
+		t.Errorf("MD5 mismatch: got %x, want %x", result.Md5Hash, expectedMd5)
+	}
+	hXXH64 := xxh3.New()
+	hXXH64.Write(expected)
+	if expectedXxh64 := hXXH64.Sum(nil); !bytes.Equal(result.Xxh64Hash, expectedXxh64) {
+		t.Errorf("XXH64 mismatch: got %x, want %x", result.Xxh64Hash, expectedXxh64)
+	}
+	if result.Size != int64(len(expected)) {
+		t.Errorf("Size mismatch: got %d, want %d", result.Size, len(expected))
+	}
+}
+
+func TestProcessFileReaderPooled(t *testing.T) {
+	// Interleave inputs so every call reuses hashers that previously hashed something else.
+	inputs := [][]byte{[]byte("first file"), {}, bytes.Repeat([]byte("B"), 100_000), []byte("first file")}
+	for _, input := range inputs {
+		md5Hash, xxh64Hash, size, err := processFileReader(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("Failed to process reader: %v", err)
+		}
+
+		hw := NewHashingWriter(io.Discard)
+		hw.Write(input)
+		expected := hw.Result()
+		if !bytes.Equal(md5Hash, expected.Md5Hash) || !bytes.Equal(xxh64Hash, expected.Xxh64Hash) || size != expected.Size {
+			t.Errorf("Pooled result differs from a fresh one for %d bytes", len(input))
+		}
+	}
+}
+
+// BenchmarkProcessFileReaderSmallFiles measures per-file overhead when hashing many small files.
+func BenchmarkProcessFileReaderSmallFiles(b *testing.B) {
+	content := bytes.Repeat([]byte("small"), 200) // 1 KB
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, _, err := processFileReader(bytes.NewReader(content)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
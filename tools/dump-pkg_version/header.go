@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/zeebo/xxh3"
+)
+
+// computeHeaderHash returns the XXH64 of the first n bytes read from r, or of all of them when there are fewer.
+//
+// Comparing header hashes only tells that the headers match: for formats whose header identifies the version of
+// the file it is a fast smoke test, but any change or corruption past the header goes unnoticed.
+func computeHeaderHash(r io.Reader, n int64) ([]byte, error) {
+	h := xxh3.New()
+	if _, err := io.Copy(h, io.LimitReader(r, n)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// headerHashFile opens a file and computes the hash of its first n bytes.
+func headerHashFile(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return computeHeaderHash(f, n)
+}
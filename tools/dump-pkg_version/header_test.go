@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeaderOnly(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"asset.pak": "PAKv0002 body of the asset", "short": "PAK"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, HeaderBytes: 8})
+	})
+	pkgMap, err := readPkgFiles("", []string{manifest}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	toFileInfo := func(name string, headerOnly bool) FileInfo {
+		entry := pkgMap[name]
+		if entry.HeaderHash == "" || entry.HeaderBytes != 8 {
+			t.Fatalf("%s: expected a header hash of 8 bytes, got %+v", name, entry)
+		}
+		file := FileInfo{FilePath: entry.FilePath, Md5Hash: decodeHex(entry.Md5Hash), Xxh64Hash: decodeHex(entry.Xxh64Hash), Size: entry.Size}
+		if headerOnly {
+			file.HeaderHash, file.HeaderBytes = decodeHex(entry.HeaderHash), entry.HeaderBytes
+		}
+		return file
+	}
+	// A file shorter than the header is hashed whole.
+	if result, err := compareFile(inputDir, toFileInfo("short", true), compareOptions{}); result != CR_Same {
+		t.Errorf("Expected the short file to match, got %s (err: %v)", result, err)
+	}
+
+	// The body changes but not its size nor the header: only a full hash notices.
+	writeTree(t, inputDir, map[string]string{"asset.pak": "PAKv0002 BODY OF THE ASSET"})
+	if result, err := compareFile(inputDir, toFileInfo("asset.pak", true), compareOptions{}); result != CR_Same {
+		t.Errorf("Expected the header to match, got %s (err: %v)", result, err)
+	}
+	if result, err := compareFile(inputDir, toFileInfo("asset.pak", false), compareOptions{}); result != CR_Md5Dif {
+		t.Errorf("Expected the full hash to differ, got %s (err: %v)", result, err)
+	}
+
+	writeTree(t, inputDir, map[string]string{"asset.pak": "PAKv0003 body of the asset"})
+	if result, err := compareFile(inputDir, toFileInfo("asset.pak", true), compareOptions{}); result != CR_HeaderDif {
+		t.Errorf("Expected the header to differ, got %s (err: %v)", result, err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+func subcommandList(_ Config, listCmd *ListCmd) {
+	pkgFiles := lo.Map(listCmd.PkgFiles, func(path string, _ int) string {
+		return filepath.ToSlash(path)
+	})
+
+	if err := listPkgFiles(os.Stdout, pkgFiles, listCmd.Print0); err != nil {
+		log.Panic().Err(err).Msg("Failed to list pkg files")
+	}
+}
+
+// listPkgFiles writes the remote name of every entry of the pkg files to w, in manifest order.
+// Names are terminated by a newline, or by a NUL byte when print0 is set, in which case they are written
+// as-is even when they contain newlines.
+func listPkgFiles(w io.Writer, pkgFiles []string, print0 bool) error {
+	terminator := "\n"
+	if print0 {
+		terminator = "\x00"
+	}
+
+	out := bufio.NewWriter(w)
+	for _, pkgFile := range pkgFiles {
+		err := eachPkgLine(pkgFile, func(entry FileInfoOutput) error {
+			out.WriteString(entry.FilePath)
+			_, err := out.WriteString(terminator) // The bufio.Writer keeps the first write error, returned by every later call
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
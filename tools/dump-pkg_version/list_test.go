@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListPkgFilesPrint0(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't allow newlines in file names")
+	}
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"with space.txt": "a",
+		"with\nnewline":  "b",
+		"dir/plain.bin":  "c",
+	})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+
+	var out bytes.Buffer
+	if err := listPkgFiles(&out, []string{manifest}, true); err != nil {
+		t.Fatalf("Failed to list pkg file: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "\x00") {
+		t.Errorf("Expected every name to be NUL terminated, got %q", out.String())
+	}
+	names := strings.Split(strings.TrimSuffix(out.String(), "\x00"), "\x00")
+	slices.Sort(names)
+	expected := []string{"dir/plain.bin", "with\nnewline", "with space.txt"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected %q, got %q", expected, names)
+	}
+
+	out.Reset()
+	if err := listPkgFiles(&out, []string{manifest}, false); err != nil {
+		t.Fatalf("Failed to list pkg file: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 4 {
+		t.Errorf("Expected the newline inside a name to be kept as-is, got %d lines in %q", lines, out.String())
+	}
+}
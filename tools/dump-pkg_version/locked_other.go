@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// isLockedFileError always reports false, as other processes can't lock a file against reading on this platform.
+func isLockedFileError(_ error) bool {
+	return false
+}
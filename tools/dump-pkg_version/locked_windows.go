@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// isLockedFileError reports whether err means the file is opened or locked by another process, like pagefile.sys or
+// a file in use: opening it fails with a sharing violation, reading a locked range with a lock violation.
+func isLockedFileError(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
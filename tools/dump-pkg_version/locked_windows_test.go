@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile opens a file without sharing it, so any other open fails with a sharing violation until the test ends.
+func lockFile(t *testing.T, path string) {
+	t.Helper()
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := windows.CreateFile(name, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		t.Fatalf("Failed to lock %s: %v", path, err)
+	}
+	t.Cleanup(func() { windows.CloseHandle(handle) })
+}
+
+func TestDumpSkipLocked(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"free.txt": "free", "locked.txt": "locked"})
+	lockedPath := filepath.Join(inputDir, "locked.txt")
+	lockFile(t, lockedPath)
+
+	dumpCmd := &DumpCmd{InputDir: inputDir, SkipLocked: true}
+	if _, err := fileWorker(dumpCmd, Config{}, lockedPath); !errors.Is(err, errSkippedLocked) {
+		t.Errorf("Expected the locked file to be skipped, got %v", err)
+	}
+
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, SkipLocked: true})
+	})
+	entries := readManifest(t, outputFile)
+	if len(entries) != 1 || entries[0].FilePath != "free.txt" {
+		t.Errorf("Expected only the free file in the manifest, got %+v", entries)
+	}
+
+	// Without the flag a locked file is fatal, as before.
+	var recovered any
+	runWithTimeout(t, 10*time.Second, func() {
+		defer func() { recovered = recover() }()
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile})
+	})
+	if recovered == nil {
+		t.Error("Expected a locked file to fail the dump without --skip-locked")
+	}
+}
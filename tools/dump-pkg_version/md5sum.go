@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// md5sumLine formats the md5sum line of a manifest entry in the GNU coreutils format, "<hash> *<path>" for a file
+// hashed as is, or "<hash>  <path>" in text mode for one hashed with CRLF collapsed to LF, which text mode reading
+// does on Windows. A path with a backslash or a newline is escaped and the line starts with a backslash, as
+// md5sum does. textMode tells the file was hashed normalized. ok is false for an entry without a content md5, a
+// directory or a link.
+func md5sumLine(file FileInfo, textMode bool) (line string, ok bool) {
+	if file.IsDir || file.IsSymlink || len(file.Md5Hash) == 0 {
+		return "", false
+	}
+	mode := "*"
+	if textMode {
+		mode = " "
+	}
+	name, prefix := file.FilePath, ""
+	if strings.ContainsAny(name, "\\\n") {
+		name, prefix = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name), "\\"
+	}
+	return fmt.Sprintf("%s%s %s%s\n", prefix, hex.EncodeToString(file.Md5Hash), mode, name), true
+}
+
+// withMd5sumOut passes the entries of results on, writing the md5sum line of each to outputFile as it goes.
+// Text files are written in text mode when normalizeEOL is set, as they were hashed with dump --normalize-eol.
+// A failure to write is recorded in fault, and the entries are still passed on so the manifest is completed.
+func withMd5sumOut(outputFile string, results <-chan FileInfo, normalizeEOL bool, textExts []string, fault *pipelineFault) <-chan FileInfo {
+	out := make(chan FileInfo, cap(results))
+	go func() {
+		defer close(out)
+		defer func() {
+			if r := recover(); r != nil {
+				fault.Set(r)
+				for result := range results {
+					out <- result
+				}
+			}
+		}()
+
+		if err := createOutputDir(outputFile); err != nil {
+			log.Panic().Err(err).Str("file", outputFile).Msg("Failed to create md5sum file")
+		}
+		file, err := os.Create(outputFile)
+		if err != nil {
+			log.Panic().Err(err).Str("file", outputFile).Msg("Failed to create md5sum file")
+		}
+		defer file.Close()
+		w := bufio.NewWriter(file)
+		for result := range results {
+			if line, ok := md5sumLine(result, normalizeEOL && isTextFile(result.FilePath, textExts)); ok {
+				if _, err := w.WriteString(line); err != nil {
+					log.Panic().Err(err).Str("file", outputFile).Msg("Failed to write md5sum file")
+				}
+			}
+			out <- result
+		}
+		if err := w.Flush(); err != nil {
+			log.Panic().Err(err).Str("file", outputFile).Msg("Failed to write md5sum file")
+		}
+		if err := file.Close(); err != nil {
+			log.Panic().Err(err).Str("file", outputFile).Msg("Failed to close md5sum file")
+		}
+	}()
+	return out
+}
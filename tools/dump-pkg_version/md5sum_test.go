@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseMd5sumLine parses a line of an md5sum file the way md5sum -c does, undoing the escaping of the path.
+func parseMd5sumLine(t *testing.T, line string) (sum string, name string, binary bool) {
+	t.Helper()
+	escaped := strings.HasPrefix(line, "\\")
+	line = strings.TrimPrefix(line, "\\")
+	if len(line) < 35 || line[32] != ' ' || (line[33] != ' ' && line[33] != '*') {
+		t.Fatalf("Malformed md5sum line %q", line)
+	}
+	sum, name, binary = line[:32], line[34:], line[33] == '*'
+	if escaped {
+		name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+	}
+	return sum, name, binary
+}
+
+func TestDumpMd5sumOut(t *testing.T) {
+	inputDir := t.TempDir()
+	files := map[string]string{"a.bin": "alpha", "dir/with space.txt": "text\r\nfile\r\n", "empty": ""}
+	if runtime.GOOS != "windows" {
+		files["back\\slash"] = "escaped"
+	}
+	writeTree(t, inputDir, files)
+	if err := os.Symlink("a.bin", filepath.Join(inputDir, "link")); err != nil && runtime.GOOS != "windows" {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	md5sumFile := filepath.Join(t.TempDir(), "MD5SUMS")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: filepath.Join(t.TempDir(), "package.jsonl"),
+			Md5sumOut: md5sumFile, SymlinkAsLink: true, IncludeDirs: true})
+	})
+
+	// Every file is listed once in binary mode with the md5 of its content, the directory and the link are not.
+	lines := readLines(t, md5sumFile)
+	if len(lines) != len(files) {
+		t.Errorf("Expected %d lines, got %d: %q", len(files), len(lines), lines)
+	}
+	for _, line := range lines {
+		sum, name, binary := parseMd5sumLine(t, line)
+		content, ok := files[name]
+		if !ok {
+			t.Errorf("Unexpected file %q", name)
+			continue
+		}
+		if expected := md5.Sum([]byte(content)); sum != hex.EncodeToString(expected[:]) || !binary {
+			t.Errorf("%s: expected %x in binary mode, got %s (binary %v)", name, expected, sum, binary)
+		}
+	}
+
+	if md5sum, err := exec.LookPath("md5sum"); err == nil {
+		cmd := exec.Command(md5sum, "-c", "--quiet", md5sumFile)
+		cmd.Dir = inputDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("md5sum -c failed: %v\n%s", err, output)
+		}
+	}
+}
+
+func TestMd5sumLineTextMode(t *testing.T) {
+	file := FileInfo{FilePath: "notes.txt", Md5Hash: decodeHex("d41d8cd98f00b204e9800998ecf8427e")}
+	if line, ok := md5sumLine(file, true); !ok || line != "d41d8cd98f00b204e9800998ecf8427e  notes.txt\n" {
+		t.Errorf("Expected a text mode line, got %q", line)
+	}
+}
@@ -2,32 +2,54 @@ package main
 
 import (
 	"encoding/json"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sync"
+	"sync/atomic"
 
+	"example/hello/hyapi"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 )
 
-func subcommandMirror(args *Args, mirrorCmd *MirrorCmd) {
-	// Create local copies of args and mirrorCmd to avoid unintended modifications.
-	_args := *args
+func subcommandMirror(config Config, mirrorCmd *MirrorCmd) {
+	// Create a local copy of mirrorCmd to avoid unintended modifications.
 	_mirrorCmd := *mirrorCmd
 
 	// Ensure that the input directory path uses forward slashes consistently,
 	// regardless of the operating system's native path separator.
 	_mirrorCmd.OutputDir = filepath.ToSlash(_mirrorCmd.OutputDir)
+	_mirrorCmd.SidecarDir = filepath.ToSlash(_mirrorCmd.SidecarDir)
+	_mirrorCmd.Zip = filepath.ToSlash(_mirrorCmd.Zip)
 	// Ensure that the output file path also uses forward slashes consistently.
 	_mirrorCmd.PkgFiles = lo.Map(_mirrorCmd.PkgFiles, func(path string, _ int) string {
 		return filepath.ToSlash(path)
 	})
 
-	// Check if the required output directory flag was provided.
-	if _mirrorCmd.OutputDir == "" {
+	// Check if the required output directory flag was provided, a zip archive replaces it.
+	if _mirrorCmd.Zip != "" {
+		if _mirrorCmd.OutputDir != "" {
+			log.Panic().Msg("Either an output directory or zip is given, not both")
+		}
+		if _mirrorCmd.Download == "" {
+			log.Panic().Msg("The zip archive holds the content of the files, zip needs download")
+		}
+		if _mirrorCmd.Owner || _mirrorCmd.Xattrs || _mirrorCmd.Sparse || _mirrorCmd.SidecarDir != "" {
+			log.Panic().Msg("The zip archive holds no owners, extended attributes, holes or sidecars, zip doesn't take owner, xattrs, sparse or sidecar-dir")
+		}
+	} else if _mirrorCmd.OutputDir == "" {
 		log.Panic().Msg("Output directory is required") // If no output directory is given, log a fatal error and exit.
 	}
 
+	if _mirrorCmd.Sparse && _mirrorCmd.Download == "" {
+		log.Panic().Msg("Sparse files are only written when their content is downloaded, sparse needs download")
+	}
+
+	if _mirrorCmd.Xattrs && !xattrSupported {
+		log.Warn().Msg("Extended attributes are not available on this platform, they will not be restored")
+		_mirrorCmd.Xattrs = false
+	}
+
 	// Initialize the map for storing FileInfoOutput objects
 	pkgMap := make(map[string]FileInfoOutput)
 	// it is pretty fast to read already, doesn't need multi thread as map will require locking anyway.
@@ -39,19 +61,51 @@ func subcommandMirror(args *Args, mirrorCmd *MirrorCmd) {
 		}
 	}
 
+	// File contents are only written when downloaded, otherwise the mirror holds the tree and the sidecars.
+	var downloader *mirrorDownloader
+	if _mirrorCmd.Download != "" {
+		client := newPkgClient(_mirrorCmd.HTTPTimeout)
+		defer client.Close()
+		downloader = &mirrorDownloader{
+			client:  client,
+			policy:  hyapi.NewRetryPolicy(_mirrorCmd.Retries, _mirrorCmd.RetryDelay),
+			baseURL: _mirrorCmd.Download,
+			sparse:  _mirrorCmd.Sparse,
+		}
+	}
+
+	// With zip the entries are written into the archive instead of the output directory
+	var archive *zipMirror
+	if _mirrorCmd.Zip != "" {
+		var err error
+		archive, err = createZipMirror(_mirrorCmd.Zip)
+		if err != nil {
+			log.Panic().Err(err).Msg("Error creating zip archive")
+		}
+	}
+
 	workQueue := make(chan FileInfoOutput, len(pkgMap)) // Work queue
 
-	var workWg sync.WaitGroup
-	// Start a fixed number of worker goroutines
-	workWg.Add(_args.Threads)
-	for range _args.Threads {
-		go func() {
-			defer workWg.Done()
-			for file := range workQueue { // Workers pick tasks from the queue
-				mirrorFile(_mirrorCmd.OutputDir, file)
+	// Start a fixed number of worker goroutines, the pool closes its output once the work queue is drained.
+	var failed atomic.Int64
+	mirrored := RunPool(workQueue, config.Workers, func(file FileInfoOutput) (struct{}, error) {
+		var err error
+		if archive != nil {
+			err = archive.add(downloader, file)
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("file", file.FilePath).
+					Msg("Failed to add file to zip archive")
 			}
-		}()
-	}
+		} else {
+			err = mirrorFile(&_mirrorCmd, downloader, file)
+		}
+		if err != nil {
+			failed.Add(1) // Logged above or by mirrorFile
+		}
+		return struct{}{}, err
+	})
 
 	// Send work to the queue (no goroutine per file)
 	for _, file := range pkgMap {
@@ -59,12 +113,56 @@ func subcommandMirror(args *Args, mirrorCmd *MirrorCmd) {
 	}
 	pkgMap = nil // don't need the map anymore
 	close(workQueue)
-	workWg.Wait() // Wait for the comparator goroutines to finish writing all the results.
+	for range mirrored { // Wait for the mirror goroutines to finish writing all the files.
+	}
+	// Write the central directory, without which the archive can't be read, even when some entries failed
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			log.Panic().Err(err).Msg("Error finalizing zip archive")
+		}
+	}
+	if n := failed.Load(); n > 0 {
+		log.Panic().Int64("failed", n).Msg("Failed to mirror some entries")
+	}
 }
 
-func mirrorFile(baseDir string, file FileInfoOutput) error {
+// mirrorFile recreates a manifest entry in the output directory: its directory or link, the sidecar of a file, and
+// the content of a file when downloader is not nil.
+func mirrorFile(mirrorCmd *MirrorCmd, downloader *mirrorDownloader, file FileInfoOutput) error {
+	// Directory entries are recreated as they are, so empty directories survive the mirror
+	if file.IsDir {
+		return mirrorDir(mirrorCmd, file)
+	}
+	// Symbolic link entries are recreated as links to their recorded target
+	if file.IsSymlink {
+		return mirrorSymlink(mirrorCmd, file)
+	}
+
+	// Download the content first, so a sidecar is only written for a file that matches its entry
+	if downloader != nil {
+		dataPath := filepath.Join(mirrorCmd.OutputDir, file.FilePath)
+		err := os.MkdirAll(filepath.Dir(dataPath), 0755)
+		if err == nil {
+			err = downloader.download(file, dataPath)
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("file", file.FilePath).
+				Msg("Failed to download file")
+			return err
+		}
+		log.Debug().
+			Str("file", dataPath).
+			Msg("Downloaded file")
+	}
+
 	// Construct the output file path
-	outputPath := filepath.Join(baseDir, file.FilePath+".json")
+	outputPath, ok := sidecarPath(mirrorCmd, file.FilePath)
+	if !ok {
+		// Without sidecars only the directory of the file is created, the data tree stays clean
+		outputPath = filepath.Join(mirrorCmd.OutputDir, file.FilePath)
+	}
 
 	// Create parent directories if they don't exist
 	parentDir := filepath.Dir(outputPath)
@@ -76,6 +174,9 @@ func mirrorFile(baseDir string, file FileInfoOutput) error {
 			Msg("Failed to create directories")
 		return err
 	}
+	if !ok {
+		return nil
+	}
 
 	// Marshal the FileInfo object to JSON
 	data, err := json.Marshal(file)
@@ -98,9 +199,113 @@ func mirrorFile(baseDir string, file FileInfoOutput) error {
 		return err
 	}
 
+	// Restore the recorded owner when requested, entries without uid/gid keep the current owner
+	if mirrorCmd.Owner && file.Uid != nil && file.Gid != nil {
+		err = chownFile(outputPath, *file.Uid, *file.Gid)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("outputPath", outputPath).
+				Msg("Failed to change file owner")
+			return err
+		}
+	}
+
+	// Restore the recorded extended attributes when requested
+	if mirrorCmd.Xattrs && len(file.Xattrs) > 0 {
+		attrs, err := decodeXattrs(file.Xattrs)
+		if err == nil {
+			err = writeXattrs(outputPath, attrs)
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("outputPath", outputPath).
+				Msg("Failed to restore extended attributes")
+			return err
+		}
+	}
+
 	log.Debug().
 		Str("file", outputPath).
 		Msg("Wrote file")
 
 	return nil
 }
+
+// sidecarPath returns the path of the sidecar JSON of a file entry, or false when sidecars are disabled.
+// Sidecars are named after the file with the sidecar extension, next to it or in the same place under the sidecar directory.
+func sidecarPath(mirrorCmd *MirrorCmd, filePath string) (string, bool) {
+	ext := ".json"
+	if mirrorCmd.SidecarExt != nil {
+		ext = *mirrorCmd.SidecarExt
+	}
+	if ext == "" {
+		return "", false
+	}
+	root := mirrorCmd.OutputDir
+	if mirrorCmd.SidecarDir != "" {
+		root = mirrorCmd.SidecarDir
+	}
+	return filepath.Join(root, filePath+ext), true
+}
+
+// mirrorDir creates the directory of a directory entry, with its recorded permissions.
+func mirrorDir(mirrorCmd *MirrorCmd, file FileInfoOutput) error {
+	outputPath := filepath.Join(mirrorCmd.OutputDir, file.FilePath)
+
+	perm := fs.FileMode(file.Mode)
+	if perm == 0 {
+		perm = 0755 // Older or hand-written entries don't record a mode
+	}
+	err := os.MkdirAll(outputPath, perm)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("outputPath", outputPath).
+			Msg("Failed to create directory")
+		return err
+	}
+
+	log.Debug().
+		Str("dir", outputPath).
+		Msg("Created directory")
+
+	return nil
+}
+
+// mirrorSymlink recreates the symbolic link of a link entry, pointing at its recorded target.
+// An existing link at the same path is replaced.
+func mirrorSymlink(mirrorCmd *MirrorCmd, file FileInfoOutput) error {
+	outputPath := filepath.Join(mirrorCmd.OutputDir, file.FilePath)
+
+	parentDir := filepath.Dir(outputPath)
+	err := os.MkdirAll(parentDir, 0755)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("parentDir", parentDir).
+			Msg("Failed to create directories")
+		return err
+	}
+
+	if stat, err := os.Lstat(outputPath); err == nil && stat.Mode()&fs.ModeSymlink != 0 {
+		os.Remove(outputPath) // os.Symlink never overwrites
+	}
+	err = os.Symlink(file.LinkTarget, outputPath)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("outputPath", outputPath).
+			Str("target", file.LinkTarget).
+			Msg("Failed to create symbolic link")
+		return err
+	}
+
+	log.Debug().
+		Str("file", outputPath).
+		Str("target", file.LinkTarget).
+		Msg("Created symbolic link")
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorSidecars(t *testing.T) {
+	manifest := writeManifest(t,
+		FileInfoOutput{FilePath: "root.bin", Md5Hash: "01", Xxh64Hash: "02", Size: 1},
+		FileInfoOutput{FilePath: "dir/sub/file.bin", Md5Hash: "03", Xxh64Hash: "04", Size: 2},
+	)
+	mirror := func(mirrorCmd MirrorCmd) {
+		t.Helper()
+		mirrorCmd.PkgFiles = []string{manifest}
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandMirror(Config{Workers: 2}, &mirrorCmd)
+		})
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// A custom extension, next to the files.
+	outputDir := t.TempDir()
+	ext := ".meta"
+	mirror(MirrorCmd{OutputDir: outputDir, SidecarExt: &ext})
+	data, err := os.ReadFile(filepath.Join(outputDir, "dir", "sub", "file.bin.meta"))
+	if err != nil {
+		t.Fatalf("Expected the sidecar to use the custom extension: %v", err)
+	}
+	var entry FileInfoOutput
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Size != 2 {
+		t.Errorf("Unexpected sidecar content %s (err: %v)", data, err)
+	}
+
+	// Disabled sidecars only create the directories.
+	outputDir = t.TempDir()
+	noExt := ""
+	mirror(MirrorCmd{OutputDir: outputDir, SidecarExt: &noExt})
+	if !exists(filepath.Join(outputDir, "dir", "sub")) {
+		t.Error("Expected the directories to be created without sidecars")
+	}
+	for _, path := range []string{"root.bin", "root.bin.json", "dir/sub/file.bin", "dir/sub/file.bin.json"} {
+		if exists(filepath.Join(outputDir, filepath.FromSlash(path))) {
+			t.Errorf("Expected no %s without sidecars", path)
+		}
+	}
+
+	// Sidecars relocated to a parallel tree keep the data tree clean.
+	outputDir, sidecarDir := t.TempDir(), t.TempDir()
+	mirror(MirrorCmd{OutputDir: outputDir, SidecarDir: sidecarDir})
+	for _, path := range []string{"root.bin.json", "dir/sub/file.bin.json"} {
+		if !exists(filepath.Join(sidecarDir, filepath.FromSlash(path))) {
+			t.Errorf("Expected %s in the sidecar directory", path)
+		}
+		if exists(filepath.Join(outputDir, filepath.FromSlash(path))) {
+			t.Errorf("Expected no %s in the output directory", path)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ownerSupported reports whether file ownership can be recorded on this platform.
+const ownerSupported = false
+
+// ownerFromFileInfo always reports that no owner is available, as uid/gid don't exist on this platform.
+func ownerFromFileInfo(_ fs.FileInfo) (uid uint32, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// chownFile is not supported on this platform.
+func chownFile(_ string, _ uint32, _ uint32) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ownerSupported reports whether file ownership can be recorded on this platform.
+const ownerSupported = true
+
+// ownerFromFileInfo extracts the uid and gid from the platform-specific stat data of a file.
+func ownerFromFileInfo(info fs.FileInfo) (uid uint32, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// chownFile changes the owner of a file without following symbolic links.
+func chownFile(path string, uid uint32, gid uint32) error {
+	return os.Lchown(path, int(uid), int(gid))
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mockStatFileInfo overrides Sys to return fake stat data.
+type mockStatFileInfo struct {
+	fs.FileInfo
+	stat *syscall.Stat_t
+}
+
+func (m mockStatFileInfo) Sys() any { return m.stat }
+
+func TestOwnerFromFileInfo(t *testing.T) {
+	uid, gid, ok := ownerFromFileInfo(mockStatFileInfo{stat: &syscall.Stat_t{Uid: 1234, Gid: 5678}})
+	if !ok || uid != 1234 || gid != 5678 {
+		t.Errorf("Expected 1234:5678, got %d:%d (ok: %v)", uid, gid, ok)
+	}
+}
+
+func TestCompareFileOwner(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "abc"})
+	file, err := processFile(root, filepath.Join(root, "a.txt"))
+	if err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	file.Uid, file.Gid = &uid, &gid
+	if result, _ := compareFile(root, file, compareOptions{}); result != CR_Same {
+		t.Errorf("Expected CR_Same with the actual owner, got %d", result)
+	}
+
+	otherUid := uid + 1
+	file.Uid = &otherUid
+	if result, _ := compareFile(root, file, compareOptions{}); result != CR_OwnerDif {
+		t.Errorf("Expected CR_OwnerDif with a different uid, got %d", result)
+	}
+}
+
+func TestSubcommandDumpOwner(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a.txt": "a"})
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, Owner: true})
+	})
+
+	entries := readManifest(t, outputFile)
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Uid == nil || *entries[0].Uid != uint32(os.Getuid()) {
+		t.Errorf("Expected uid %d, got %v", os.Getuid(), entries[0].Uid)
+	}
+	if entries[0].Gid == nil || *entries[0].Gid != uint32(os.Getgid()) {
+		t.Errorf("Expected gid %d, got %v", os.Getgid(), entries[0].Gid)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// pathsFromWalker sends the files named by names, relative to inputDir, to the paths channel in the order of the list,
+// instead of walking inputDir. A listed path missing on disk, outside inputDir, or a directory without includeDirs is
+// logged as an error and counted with onError. Files for which accept returns false are skipped, like fileWalker.
+func pathsFromWalker(inputDir string, names []string, paths chan<- string, includeDirs bool, accept func(path string) bool, onError func()) {
+	for _, name := range names {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			log.Error().Str("file", name).Msg("Listed path is outside the input directory")
+			onError()
+			continue
+		}
+		path := filepath.Join(inputDir, filepath.FromSlash(name))
+		stat, err := os.Lstat(path)
+		if err != nil {
+			log.Error().Err(err).Str("file", name).Msg("Listed path is not on disk")
+			onError()
+			continue
+		}
+		if stat.IsDir() && !includeDirs {
+			log.Error().Str("file", name).Msg("Listed path is a directory")
+			onError()
+			continue
+		}
+		if accept != nil && !accept(path) {
+			log.Trace().Str("file", path).Msg("Skipped")
+			continue
+		}
+		paths <- path
+		log.Debug().Str("file", path).Msg("Listed")
+	}
+}
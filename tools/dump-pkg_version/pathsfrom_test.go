@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDumpPathsFrom(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"a.txt":        "alpha",
+		"b.txt":        "bravo",
+		"dir/c.txt":    "charlie",
+		"dir/d.txt":    "delta",
+		"unlisted.txt": "echo",
+	})
+
+	for name, list := range map[string]string{
+		"lines": "dir/d.txt\r\nmissing.txt\nb.txt\n\n../outside.txt\ndir/c.txt\n",
+		"nul":   "dir/d.txt\x00missing.txt\x00b.txt\x00../outside.txt\x00dir/c.txt\x00",
+	} {
+		t.Run(name, func(t *testing.T) {
+			listFile := filepath.Join(t.TempDir(), "paths.txt")
+			if err := os.WriteFile(listFile, []byte(list), 0644); err != nil {
+				t.Fatal(err)
+			}
+			manifest := filepath.Join(t.TempDir(), "package.jsonl")
+			var summary DumpSummary
+			runWithTimeout(t, 10*time.Second, func() {
+				summary = subcommandDump(Config{Workers: 4}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, PathsFrom: listFile})
+			})
+
+			// Exactly the listed files on disk, in the listed order.
+			var names []string
+			for _, entry := range readManifest(t, manifest) {
+				names = append(names, entry.FilePath)
+			}
+			expected := []string{"dir/d.txt", "b.txt", "dir/c.txt"}
+			if !slices.Equal(names, expected) {
+				t.Errorf("Expected %v, got %v", expected, names)
+			}
+			if summary.Files != 3 || summary.Errors != 2 {
+				t.Errorf("Expected 3 files and 2 errors for the missing and outside paths, got %+v", summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+// pinWorkersSupported reports whether hashing workers can be pinned on this platform.
+const pinWorkersSupported = false
+
+// pinWorker does nothing, as workers aren't pinned on this platform.
+func pinWorker() func() {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+)
+
+// pinWorkersSupported reports whether hashing workers can be pinned on this platform.
+const pinWorkersSupported = true
+
+// pinWorker locks the calling worker goroutine to its OS thread, so the scheduler never moves it and its hashing state
+// stays in the caches of the core that thread runs on. The returned function releases the thread.
+func pinWorker() func() {
+	runtime.LockOSThread()
+	return runtime.UnlockOSThread
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// RunPool starts a fixed number of worker goroutines that apply fn to every value received from inputs,
+// and returns a channel carrying the produced values.
+// The returned channel is closed once inputs is closed and every worker has finished.
+// Values for which fn returns an error are dropped; fn is responsible for logging or recording the failure.
+func RunPool[In, Out any](inputs <-chan In, workers int, fn func(In) (Out, error)) <-chan Out {
+	return RunPoolWithSetup(inputs, workers, nil, fn)
+}
+
+// RunPoolWithSetup is RunPool with per-worker state: each worker goroutine calls setup before taking its first input,
+// and the function setup returns, if not nil, once it is done. A nil setup is skipped.
+func RunPoolWithSetup[In, Out any](inputs <-chan In, workers int, setup func() func(), fn func(In) (Out, error)) <-chan Out {
+	return RunPoolBuffered(inputs, workers, workers, setup, fn) // Every worker can hand off one value without waiting on the consumer.
+}
+
+// RunPoolBuffered is RunPoolWithSetup with an output channel buffering up to buffer values, which bounds the
+// outputs held while the consumer is slower than the workers.
+func RunPoolBuffered[In, Out any](inputs <-chan In, workers, buffer int, setup func() func(), fn func(In) (Out, error)) <-chan Out {
+	outputs := make(chan Out, buffer)
+
+	var workWg sync.WaitGroup // WaitGroup to wait for all worker goroutines to finish.
+	workWg.Add(workers)       // Add the number of workers before any of them can call Done.
+	for range workers {
+		go func() {
+			defer workWg.Done()
+			if setup != nil {
+				if teardown := setup(); teardown != nil {
+					defer teardown()
+				}
+			}
+			for input := range inputs { // Workers pick tasks from the inputs channel until it's closed.
+				output, err := fn(input)
+				if err != nil {
+					continue
+				}
+				outputs <- output
+			}
+		}()
+	}
+
+	// Goroutine to close the outputs channel after all workers are done.
+	go func() {
+		workWg.Wait()
+		close(outputs)
+	}()
+
+	return outputs
+}
+
+// Merge fans the values received from every input into a single channel, in no particular order.
+// The returned channel is closed once every input is closed. After ctx is cancelled no more values are forwarded,
+// but the inputs are still drained until they close, so their producers never block on a merge that's gone.
+func Merge[T any](ctx context.Context, inputs ...<-chan T) <-chan T {
+	merged := make(chan T, len(inputs)) // Buffered so every input can hand off one value without waiting on the consumer.
+
+	var mergeWg sync.WaitGroup
+	mergeWg.Add(len(inputs))
+	for _, input := range inputs {
+		go func() {
+			defer mergeWg.Done()
+			for value := range input {
+				if ctx.Err() != nil {
+					continue // Cancelled, only drain
+				}
+				select {
+				case merged <- value:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// Goroutine to close the merged channel after all inputs are closed.
+	go func() {
+		mergeWg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
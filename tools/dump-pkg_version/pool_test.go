@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// feed returns a closed channel pre-filled with the given values.
+func feed[T any](values ...T) <-chan T {
+	ch := make(chan T, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+func TestRunPool(t *testing.T) {
+	outputs := RunPool(feed(1, 2, 3, 4, 5, 6, 7, 8), 3, func(n int) (int, error) {
+		return n * n, nil
+	})
+
+	var got []int
+	for out := range outputs {
+		got = append(got, out)
+	}
+	slices.Sort(got)
+
+	expected := []int{1, 4, 9, 16, 25, 36, 49, 64}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestRunPoolErrors(t *testing.T) {
+	errOdd := errors.New("odd")
+	outputs := RunPool(feed(1, 2, 3, 4, 5, 6), 2, func(n int) (int, error) {
+		if n%2 != 0 {
+			return 0, errOdd
+		}
+		return n, nil
+	})
+
+	var got []int
+	for out := range outputs {
+		got = append(got, out)
+	}
+	slices.Sort(got)
+
+	expected := []int{2, 4, 6}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestRunPoolEmpty(t *testing.T) {
+	outputs := RunPool(feed[int](), 4, func(n int) (int, error) {
+		return n, nil
+	})
+	for out := range outputs {
+		t.Errorf("Unexpected output %d", out)
+	}
+}
+
+func TestRunPoolWithSetup(t *testing.T) {
+	var setups, teardowns atomic.Int32
+	setup := func() func() {
+		setups.Add(1)
+		return func() { teardowns.Add(1) }
+	}
+	outputs := RunPoolWithSetup(feed(1, 2, 3, 4, 5, 6), 3, setup, func(n int) (int, error) {
+		return n, nil
+	})
+
+	count := 0
+	for range outputs {
+		count++
+	}
+	if count != 6 {
+		t.Errorf("Expected 6 outputs, got %d", count)
+	}
+	// Every worker is done, and has torn down, once the outputs are closed.
+	if setups.Load() != 3 || teardowns.Load() != 3 {
+		t.Errorf("Expected 3 setups and teardowns, got %d and %d", setups.Load(), teardowns.Load())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merged := Merge(context.Background(), feed(1, 2, 3), feed(4, 5), feed[int]())
+
+	var got []int
+	for value := range merged {
+		got = append(got, value)
+	}
+	slices.Sort(got)
+	if expected := []int{1, 2, 3, 4, 5}; !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestMergeCancel(t *testing.T) {
+	// Unbuffered producers that only close once every value was taken, so they'd block on a merge that stops reading.
+	var sent atomic.Int32
+	produce := func(n int) <-chan int {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := range n {
+				ch <- i
+				sent.Add(1)
+			}
+		}()
+		return ch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	merged := Merge(ctx, produce(100), produce(100), produce(100))
+
+	received := 0
+	for range merged {
+		received++
+		if received == 10 {
+			cancel() // Mid-stream, every producer still has values to send
+		}
+	}
+	// The merged channel is only closed once every producer is done, so nothing was left blocked.
+	if sent.Load() != 300 {
+		t.Errorf("Expected every producer to be drained, %d of 300 values were sent", sent.Load())
+	}
+	if received >= 300 {
+		t.Errorf("Expected the cancellation to stop forwarding, received %d values", received)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+func subcommandPrune(_ Config, pruneCmd *PruneCmd) {
+	// Create a local copy of pruneCmd to avoid unintended modifications.
+	_pruneCmd := *pruneCmd
+
+	// Ensure that the input directory path uses forward slashes consistently,
+	// regardless of the operating system's native path separator.
+	_pruneCmd.InputDir = filepath.ToSlash(_pruneCmd.InputDir)
+	_pruneCmd.PkgFiles = lo.Map(_pruneCmd.PkgFiles, func(path string, _ int) string {
+		return filepath.ToSlash(path)
+	})
+
+	// Check if the required input directory flag was provided.
+	if _pruneCmd.InputDir == "" {
+		log.Panic().Msg("Input directory is required") // If no input directory is given, log a fatal error and exit.
+	}
+	if err := checkPruneRoot(_pruneCmd.InputDir); err != nil {
+		log.Panic().Err(err).Msg("Refusing to prune")
+	}
+
+	// Unlike verify, any unreadable pkg file fails the prune, as the files it lists would otherwise be deleted.
+	pkgMap, err := readPkgFiles(_pruneCmd.InputDir, _pruneCmd.PkgFiles, _pruneCmd.CheckInputDirForPkg)
+	if err != nil {
+		log.Panic().Err(err).Msg("Error reading some pkg files")
+	}
+	if len(pkgMap) == 0 {
+		log.Panic().Msg("Refusing to prune against an empty manifest")
+	}
+
+	extras, err := pruneDir(_pruneCmd.InputDir, pkgMap, _pruneCmd.PkgFiles, _pruneCmd.CheckInputDirForPkg, _pruneCmd.Apply)
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to prune input directory")
+	}
+	if !_pruneCmd.Apply {
+		log.Info().Int("files", len(extras)).Msg("Dry run, pass --apply to delete these files")
+		return
+	}
+	log.Info().Int("files", len(extras)).Msg("Prune finished")
+}
+
+// checkPruneRoot refuses to prune a filesystem root or the root of a git repository.
+func checkPruneRoot(inputDir string) error {
+	absDir, err := filepath.Abs(inputDir)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(absDir) == absDir {
+		return fmt.Errorf("%s is a filesystem root", absDir)
+	}
+	if _, err := os.Stat(filepath.Join(absDir, ".git")); err == nil {
+		return fmt.Errorf("%s is a repository root", absDir)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// pruneDir walks inputDir and collects the files that aren't listed in pkgMap, deleting them when apply is set.
+// The pkg files themselves are never deleted. It returns the relative paths of the extra files.
+func pruneDir(inputDir string, pkgMap map[string]FileInfoOutput, pkgFiles []string, checkInputDirForPkg bool, apply bool) ([]string, error) {
+	// Protect the manifests, compared by absolute path as they may be given relative to another directory
+	protected := make(map[string]bool)
+	for _, pkgFile := range pkgFiles {
+		absPath, err := filepath.Abs(pkgFile)
+		if err != nil {
+			return nil, err
+		}
+		protected[absPath] = true
+	}
+
+	var extras []string
+	err := filepath.WalkDir(inputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(inputDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if _, ok := pkgMap[relPath]; ok {
+			return nil
+		}
+		// Pkg files picked up by --check-input live at the top of the input directory
+		if checkInputDirForPkg && !strings.Contains(relPath, "/") && isPkgFileName(relPath) {
+			return nil
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if protected[absPath] {
+			return nil
+		}
+
+		extras = append(extras, relPath)
+		if !apply {
+			log.Info().Str("file", relPath).Msg("Would delete")
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Info().Str("file", relPath).Msg("Deleted")
+		return nil
+	})
+	return extras, err
+}
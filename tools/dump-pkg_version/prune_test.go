@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPruneDir(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"keep.txt":       "keep",
+		"sub/keep.txt":   "keep",
+		"extra.txt":      "extra",
+		"sub/extra.txt":  "extra",
+		"package.jsonl":  "", // Manifest given with -f, stored inside the tree
+		"mypkg.jsonl":    "", // Manifest found by --check-input
+		"sub/mypkg.json": "", // Not at the top, so not a manifest
+	})
+	pkgMap := map[string]FileInfoOutput{
+		"keep.txt":     {FilePath: "keep.txt"},
+		"sub/keep.txt": {FilePath: "sub/keep.txt"},
+		"missing.txt":  {FilePath: "missing.txt"},
+	}
+	pkgFiles := []string{filepath.Join(root, "package.jsonl")}
+	expected := []string{"extra.txt", "sub/extra.txt", "sub/mypkg.json"}
+
+	// Dry run reports without deleting
+	extras, err := pruneDir(root, pkgMap, pkgFiles, true, false)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	slices.Sort(extras)
+	if !slices.Equal(extras, expected) {
+		t.Errorf("Expected extras %v, got %v", expected, extras)
+	}
+	if _, err := os.Stat(filepath.Join(root, "extra.txt")); err != nil {
+		t.Errorf("Dry run deleted a file: %v", err)
+	}
+
+	// Apply deletes only the extras
+	extras, err = pruneDir(root, pkgMap, pkgFiles, true, true)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	slices.Sort(extras)
+	if !slices.Equal(extras, expected) {
+		t.Errorf("Expected extras %v, got %v", expected, extras)
+	}
+	for _, name := range expected {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"keep.txt", "sub/keep.txt", "package.jsonl", "mypkg.jsonl"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+}
+
+func TestCheckPruneRoot(t *testing.T) {
+	if err := checkPruneRoot("/"); err == nil {
+		t.Errorf("Expected the filesystem root to be refused")
+	}
+
+	repo := t.TempDir()
+	writeTree(t, repo, map[string]string{".git/HEAD": "ref: refs/heads/main"})
+	if err := checkPruneRoot(repo); err == nil {
+		t.Errorf("Expected a repository root to be refused")
+	}
+
+	if err := checkPruneRoot(t.TempDir()); err != nil {
+		t.Errorf("Expected a plain directory to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// beforePublishVerify, when set, is called between the dump and the verification of a --verify-after dump.
+// It is a variable so tests can change the tree in between.
+var beforePublishVerify func()
+
+// subcommandDumpVerified dumps to a temporary manifest next to the output file, verifies every entry of it against
+// the input directory, and renames it over the output file only when everything matches. On any failure the
+// temporary manifest is removed and the existing output file is left untouched.
+func subcommandDumpVerified(config Config, dumpCmd *DumpCmd) DumpSummary {
+	start := time.Now()
+	_dumpCmd := *dumpCmd
+	_dumpCmd.VerifyAfter = false
+
+	if _dumpCmd.ShardByDir {
+		log.Panic().Msg("Verify after dump writes a single manifest and cannot be combined with shard by dir")
+	}
+	if len(_dumpCmd.MoreInputDirs) > 0 {
+		log.Panic().Msg("Verify after dump checks a single input directory and cannot be combined with several")
+	}
+
+	// The temporary manifest is created in the same directory, so the final rename never crosses filesystems.
+	outputFile := _dumpCmd.OutputFile
+	if err := createOutputDir(outputFile); err != nil {
+		log.Panic().Err(err).Msg("Failed to create output directory")
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(outputFile), filepath.Base(outputFile)+".tmp-*")
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to create temporary output file")
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name()) // Gone after a successful rename, removed on every failure, panics included
+	_dumpCmd.OutputFile = tmpFile.Name()
+
+	summary := subcommandDump(config, &_dumpCmd)
+
+	if beforePublishVerify != nil {
+		beforePublishVerify()
+	}
+	// Files are hashed the way the dump hashed them.
+	opts := compareOptions{NormalizeEOL: _dumpCmd.NormalizeEOL, TextExts: config.TextExts, XxhSeed: _dumpCmd.XxhSeed}
+	buckets, err := verifyManifest(config.Workers, _dumpCmd.InputDir, _dumpCmd.OutputFile, opts)
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to verify the new manifest")
+	}
+	if mismatches := buckets.Mismatches(); mismatches > 0 {
+		log.Panic().
+			Int64("mismatches", mismatches).
+			Str("output", outputFile).
+			Msg("New manifest doesn't match the input directory, the output file was not replaced")
+	}
+
+	if err := os.Rename(_dumpCmd.OutputFile, outputFile); err != nil {
+		log.Panic().Err(err).Msg("Failed to replace the output file")
+	}
+	log.Info().Int64("entries", buckets.Total()).Str("output", outputFile).Msg("Verified and published manifest")
+	summary.Duration = time.Since(start) // The verification is part of the dump
+	return summary
+}
+
+// verifyManifest compares every entry of a manifest against the files of inputDir by content, hashed as opts tells.
+func verifyManifest(threads int, inputDir string, pkgFile string, opts compareOptions) (*CompareResultBuckets, error) {
+	pkgMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(pkgFile, pkgMap); err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", pkgFile, err)
+	}
+	files := make(map[string]FileInfo, len(pkgMap))
+	for filePath, v := range pkgMap {
+		files[filePath] = FileInfo{
+			FilePath:   v.FilePath,
+			Md5Hash:    decodeHex(v.Md5Hash),
+			Xxh64Hash:  decodeHex(v.Xxh64Hash),
+			Size:       v.Size,
+			IsDir:      v.IsDir,
+			IsSymlink:  v.IsSymlink,
+			LinkTarget: v.LinkTarget,
+		}
+	}
+	return reportResults(verifyContent(threads, inputDir, files, opts)), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpVerifyAfter(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "a", "dir/b": "b"})
+	outputDir := t.TempDir()
+	outputFile := filepath.Join(outputDir, "package.jsonl")
+	const previous = `{"remoteName":"old","md5":"","hash":"","fileSize":0}` + "\n"
+	if err := os.WriteFile(outputFile, []byte(previous), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dumpCmd := &DumpCmd{InputDir: inputDir, OutputFile: outputFile, VerifyAfter: true}
+
+	// A file changing between the dump and the verification aborts the publish.
+	beforePublishVerify = func() {
+		writeTree(t, inputDir, map[string]string{"dir/b": "B"})
+	}
+	t.Cleanup(func() { beforePublishVerify = nil })
+	var recovered any
+	runWithTimeout(t, 10*time.Second, func() {
+		defer func() { recovered = recover() }()
+		subcommandDump(Config{Workers: 2}, dumpCmd)
+	})
+	if recovered == nil {
+		t.Fatal("Expected the verification failure to abort the dump")
+	}
+	if got, err := os.ReadFile(outputFile); err != nil || string(got) != previous {
+		t.Errorf("Expected the existing manifest to be left untouched, got %q (err: %v)", got, err)
+	}
+	if entries, _ := os.ReadDir(outputDir); len(entries) != 1 {
+		t.Errorf("Expected the temporary manifest to be removed, got %v", entries)
+	}
+
+	// Once the tree is stable, the verified manifest replaces the existing one.
+	beforePublishVerify = nil
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, dumpCmd)
+	})
+	entries := readManifest(t, outputFile)
+	if len(entries) != 2 {
+		t.Errorf("Expected the new manifest to be published, got %+v", entries)
+	}
+	if dirEntries, _ := os.ReadDir(outputDir); len(dirEntries) != 1 {
+		t.Errorf("Expected only the published manifest, got %v", dirEntries)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"example/hello/hyapi"
+	"github.com/rs/zerolog/log"
+	"resty.dev/v3"
+)
+
+// newPkgClient returns the HTTP client fetching package files, the caller must close it.
+// Failed requests are retried by fetchPkgURLs with a hyapi.RetryPolicy, not by the client.
+func newPkgClient(timeout time.Duration) *resty.Client {
+	return resty.New().
+		SetTimeout(timeout)
+}
+
+// fetchPkgURLs downloads every package file into dir with fetchPkgURL, retrying each as policy says, and returns
+// the local copies in the same order.
+func fetchPkgURLs(client *resty.Client, policy hyapi.RetryPolicy, urls []string, dir string) ([]string, error) {
+	var pkgFiles []string
+	for _, url := range urls {
+		var pkgFile string
+		err := hyapi.ExecuteWithRetry(policy, func(int) error {
+			var err error
+			pkgFile, err = fetchPkgURL(client, url, dir)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		pkgFiles = append(pkgFiles, pkgFile)
+	}
+	return pkgFiles, nil
+}
+
+// fetchPkgURL downloads the package file at url into dir and returns the path of the local copy.
+// Every line is parsed while it is downloaded, so a response that isn't a manifest fails early with a clear error.
+// A gzip content-encoding is decoded by the client.
+func fetchPkgURL(client *resty.Client, url string, dir string) (string, error) {
+	resp, err := client.R().
+		SetDoNotParseResponse(true). // Stream the body to disk instead of buffering it in memory
+		Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode() != 200 {
+		return "", &hyapi.StatusError{URL: url, Code: resp.StatusCode()}
+	}
+
+	out, err := os.CreateTemp(dir, "pkg-url-*.jsonl")
+	if err != nil {
+		return "", fmt.Errorf("failed to create local copy of %s: %w", url, err)
+	}
+	entries := 0
+	err = eachPkgLineReader(io.TeeReader(resp.Body, out), url, func(FileInfoOutput) error {
+		entries++
+		return nil
+	})
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	log.Debug().
+		Str("url", url).
+		Int("entries", entries).
+		Msg("Fetched pkg file")
+	return out.Name(), nil
+}
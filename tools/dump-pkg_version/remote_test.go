@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"example/hello/hyapi"
+)
+
+// roundTripFunc is an http.RoundTripper answering requests with a function instead of the network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFetchPkgURL(t *testing.T) {
+	const manifest = `{"remoteName":"a","md5":"00","hash":"01","fileSize":1}` + "\n" +
+		`{"remoteName":"dir/b","md5":"02","hash":"03","fileSize":2}` + "\n"
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(manifest))
+	zw.Close()
+
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+		switch req.URL.Path {
+		case "/package.jsonl":
+			resp.Body = io.NopCloser(strings.NewReader(manifest))
+		case "/package.jsonl.gz":
+			resp.Header.Set("Content-Encoding", "gzip")
+			resp.Body = io.NopCloser(bytes.NewReader(gzipped.Bytes()))
+		case "/index.html":
+			resp.Body = io.NopCloser(strings.NewReader("<html>not a manifest</html>\n"))
+		default:
+			resp.StatusCode = http.StatusNotFound
+			resp.Body = io.NopCloser(strings.NewReader("not found"))
+		}
+		return resp, nil
+	})
+	client := newPkgClient(0).SetTransport(transport)
+	defer client.Close()
+
+	for _, url := range []string{"http://example.test/package.jsonl", "http://example.test/package.jsonl.gz"} {
+		pkgFiles, err := fetchPkgURLs(client, hyapi.RetryPolicy{}, []string{url}, t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to fetch %s: %v", url, err)
+		}
+		pkgMap := make(map[string]FileInfoOutput)
+		if err := readPkgFile(pkgFiles[0], pkgMap); err != nil {
+			t.Fatalf("Failed to read the copy of %s: %v", url, err)
+		}
+		if len(pkgMap) != 2 || pkgMap["dir/b"].Size != 2 {
+			t.Errorf("Unexpected entries fetched from %s: %v", url, pkgMap)
+		}
+	}
+
+	dir := t.TempDir()
+	if _, err := fetchPkgURL(client, "http://example.test/missing.jsonl", dir); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a status code error, got %v", err)
+	}
+	if _, err := fetchPkgURL(client, "http://example.test/index.html", dir); err == nil {
+		t.Errorf("Expected an error for a response that isn't a manifest")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected failed downloads to leave no file behind, got %v", entries)
+	}
+}
+
+func TestFetchPkgURLsRetry(t *testing.T) {
+	const manifest = `{"remoteName":"a","md5":"00","hash":"01","fileSize":1}` + "\n"
+	requests := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+		if requests == 1 {
+			resp.StatusCode = http.StatusServiceUnavailable
+		}
+		resp.Body = io.NopCloser(strings.NewReader(manifest))
+		return resp, nil
+	})
+	client := newPkgClient(0).SetTransport(transport)
+	defer client.Close()
+
+	pkgFiles, err := fetchPkgURLs(client, hyapi.NewRetryPolicy(2, time.Millisecond), []string{"http://example.test/package.jsonl"}, t.TempDir())
+	if err != nil || len(pkgFiles) != 1 || requests != 2 {
+		t.Errorf("Expected the package file once retried, got %v (err: %v) after %d requests", pkgFiles, err, requests)
+	}
+}
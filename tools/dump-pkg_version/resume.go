@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// interruptContext returns the context cancelled on SIGINT while verifying file contents.
+// It is a variable so tests can interrupt a verify without signalling the test binary.
+var interruptContext = func() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// verifyContentContext is verifyContent stopping to dispatch files once ctx is done. Files already handed to a worker
+// are still compared and reported, the others never appear in the results.
+func verifyContentContext(ctx context.Context, threads int, basedir string, pkgMap map[string]FileInfo, opts compareOptions) <-chan FileCompareResult {
+	workQueue := make(chan FileInfo, threads) // Small queue so few files are dispatched but not started on interrupt
+
+	go func() {
+		defer close(workQueue)
+		for _, file := range pkgMap {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case workQueue <- file:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return compareFiles(threads, basedir, workQueue, opts)
+}
+
+// trackResults forwards results, recording the path of each into reached. reached may only be read once the
+// returned channel is closed.
+func trackResults(results <-chan FileCompareResult, reached map[string]struct{}) <-chan FileCompareResult {
+	out := make(chan FileCompareResult)
+	go func() {
+		defer close(out)
+		for res := range results {
+			reached[res.FilePath] = struct{}{}
+			out <- res
+		}
+	}()
+	return out
+}
+
+// unreachedEntries returns the sorted remote names of the pkgMap entries without a result in reached.
+func unreachedEntries(pkgMap map[string]FileInfo, reached map[string]struct{}) []string {
+	var pending []string
+	for name := range pkgMap {
+		if _, ok := reached[name]; !ok {
+			pending = append(pending, name)
+		}
+	}
+	slices.Sort(pending)
+	return pending
+}
+
+// writeNameList writes names to path one per line, as read back by readNameList.
+func writeNameList(path string, names []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create name list: %w", err)
+	}
+	out := bufio.NewWriter(file)
+	for _, name := range names {
+		out.WriteString(name)
+		out.WriteByte('\n')
+	}
+	if err := out.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write name list: %w", err)
+	}
+	return file.Close()
+}
+
+// readNameList reads the remote names listed in path one per line, skipping blank lines. A list holding a NUL byte,
+// e.g. written by list --print0, is split on NUL bytes instead, so names may contain newlines.
+func readNameList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read name list: %w", err)
+	}
+
+	var names []string
+	if bytes.IndexByte(data, 0) >= 0 {
+		for name := range strings.SplitSeq(string(data), "\x00") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+	for line := range strings.Lines(string(data)) {
+		if name := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// restrictPkgMap keeps only the pkgMap entries named in names, and returns the names without an entry.
+func restrictPkgMap(pkgMap map[string]FileInfo, names []string) (map[string]FileInfo, []string) {
+	restricted := make(map[string]FileInfo, len(names))
+	missing := lo.Filter(names, func(name string, _ int) bool {
+		file, ok := pkgMap[name]
+		if ok {
+			restricted[name] = file
+		}
+		return !ok
+	})
+	return restricted, missing
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestVerifyContentInterrupted(t *testing.T) {
+	inputDir := t.TempDir()
+	pkgMap := make(map[string]FileInfo)
+	for i := range 50 {
+		name := fmt.Sprintf("file%d", i)
+		writeTree(t, inputDir, map[string]string{name: "content"})
+		pkgMap[name] = FileInfo{FilePath: name, Size: 7}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reached := make(map[string]struct{})
+	runWithTimeout(t, 10*time.Second, func() {
+		results := trackResults(verifyContentContext(ctx, 1, inputDir, pkgMap, compareOptions{}), reached)
+		<-results
+		cancel()
+		for range results {
+		}
+	})
+
+	pending := unreachedEntries(pkgMap, reached)
+	if len(pending) == 0 {
+		t.Fatalf("Expected some entries not to be reached, all %d were", len(reached))
+	}
+	if len(pending)+len(reached) != len(pkgMap) {
+		t.Errorf("Expected %d reached and unreached entries, got %d and %d", len(pkgMap), len(reached), len(pending))
+	}
+	for _, name := range pending {
+		if _, ok := reached[name]; ok {
+			t.Errorf("%s is both reached and unreached", name)
+		}
+	}
+}
+
+func TestSubcommandVerifyResumeFile(t *testing.T) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 10 {
+		files[fmt.Sprintf("dir%d/file%d", i%2, i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, inputDir, files)
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+
+	previous := interruptContext
+	t.Cleanup(func() { interruptContext = previous })
+	// Interrupted before the first file is dispatched, every entry is pending.
+	interruptContext = func() (context.Context, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, cancel
+	}
+
+	resumeFile := filepath.Join(t.TempDir(), "resume.txt")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandVerify(Config{Workers: 2}, &VerifyCmd{InputDir: inputDir, PkgFiles: []string{manifest}, ResumeFile: resumeFile})
+	})
+	pending, err := readNameList(resumeFile)
+	if err != nil {
+		t.Fatalf("Failed to read resume file: %v", err)
+	}
+	expected := slices.Sorted(maps.Keys(files))
+	if !slices.Equal(pending, expected) {
+		t.Errorf("Expected pending entries %v, got %v", expected, pending)
+	}
+
+	// Resuming from the file without interruption leaves nothing to resume.
+	interruptContext = func() (context.Context, context.CancelFunc) {
+		return context.WithCancel(context.Background())
+	}
+	if err := os.WriteFile(resumeFile, []byte(pending[0]+"\r\n\n"+pending[1]+"\nnot/in/manifest\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write resume file: %v", err)
+	}
+	nextResumeFile := filepath.Join(t.TempDir(), "resume.txt")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandVerify(Config{Workers: 2}, &VerifyCmd{InputDir: inputDir, PkgFiles: []string{manifest}, Only: resumeFile, ResumeFile: nextResumeFile})
+	})
+	if _, err := os.Stat(nextResumeFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no resume file after a complete verify, got %v", err)
+	}
+}
+
+func TestRestrictPkgMap(t *testing.T) {
+	pkgMap := map[string]FileInfo{"a": {FilePath: "a"}, "b": {FilePath: "b"}, "c": {FilePath: "c"}}
+	restricted, missing := restrictPkgMap(pkgMap, []string{"c", "x", "a"})
+	if len(restricted) != 2 || restricted["a"].FilePath != "a" || restricted["c"].FilePath != "c" {
+		t.Errorf("Expected entries a and c, got %v", restricted)
+	}
+	if !slices.Equal(missing, []string{"x"}) {
+		t.Errorf("Expected x missing, got %v", missing)
+	}
+}
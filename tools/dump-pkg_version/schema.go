@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// manifestVersion is the version of the manifest format written by dump --embed-version. Lines without a version
+// are of version 1, readers reject the lines of a version they don't know rather than misread them.
+const manifestVersion = 1
+
+// jsonSchemaDraft is the JSON Schema dialect the generated schema declares.
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+func subcommandSchema(_ Config, _ *SchemaCmd) {
+	// Generate the schema from the Go type so it follows every field added to the manifest format.
+	schema := manifestSchema()
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to marshal JSON schema")
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// manifestSchema returns the JSON Schema describing one line of a manifest (FileInfoOutput).
+func manifestSchema() map[string]any {
+	schema := jsonSchemaFor(reflect.TypeFor[FileInfoOutput]())
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "FileInfoOutput"
+	schema["description"] = "One JSON line of a package manifest"
+	return schema
+}
+
+// jsonSchemaFor builds the JSON Schema of a Go type, following encoding/json naming and omitempty rules.
+func jsonSchemaFor(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[ManifestTime]() {
+		// Written as Unix nanoseconds or as an RFC 3339 string, see --time-format.
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "integer"},
+			map[string]any{"type": "string", "format": "date-time"},
+		}}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"} // []byte is encoded as base64
+		}
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		required := []string{}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchemaFor(field.Type)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]any{}
+	}
+}
+
+// schemaAliases names the keys of the newer manifest schema written along the pkg_version ones by dump --schema both.
+type schemaAliases struct {
+	FilePath  string `json:"path"`  // Alias of remoteName
+	Xxh64Hash string `json:"xxh64"` // Alias of hash
+	Size      int64  `json:"size"`  // Alias of fileSize
+}
+
+// MarshalJSON writes the entry with its pkg_version keys and, for an entry of a --schema both dump, the same values
+// under the keys of the newer schema after them, so consumers of either schema can read it.
+func (o FileInfoOutput) MarshalJSON() ([]byte, error) {
+	type plain FileInfoOutput // Same fields without this method, so marshaling doesn't recurse
+	data, err := json.Marshal(plain(o))
+	if err != nil || !o.aliases {
+		return data, err
+	}
+	aliases, err := json.Marshal(schemaAliases{FilePath: o.FilePath, Xxh64Hash: o.Xxh64Hash, Size: o.Size})
+	if err != nil {
+		return nil, err
+	}
+	// Both are objects with at least one key, splice the aliases in before the closing brace.
+	data = append(data[:len(data)-1], ',')
+	return append(data, aliases[1:]...), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestSchema(t *testing.T) {
+	schema := manifestSchema()
+
+	// The schema must survive a JSON round trip, as that is how it's published.
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("Failed to marshal schema: %v", err)
+	}
+	var decoded struct {
+		Type       string                    `json:"type"`
+		Properties map[string]map[string]any `json:"properties"`
+		Required   []string                  `json:"required"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal schema: %v", err)
+	}
+
+	if decoded.Type != "object" {
+		t.Errorf("Expected object schema, got %q", decoded.Type)
+	}
+	expectedTypes := map[string]string{
+		"remoteName": "string",
+		"md5":        "string",
+		"hash":       "string",
+		"fileSize":   "integer",
+	}
+	for name, typ := range expectedTypes {
+		if got := decoded.Properties[name]["type"]; got != typ {
+			t.Errorf("Property %s: got type %q, want %q", name, got, typ)
+		}
+		if !slices.Contains(decoded.Required, name) {
+			t.Errorf("Property %s should be required", name)
+		}
+	}
+}
+
+func TestJsonSchemaForOmitempty(t *testing.T) {
+	type sample struct {
+		Name     string            `json:"name"`
+		Optional *int64            `json:"optional,omitempty"`
+		Tags     []string          `json:"tags,omitempty"`
+		Extra    map[string]string `json:"extra,omitempty"`
+		Skipped  string            `json:"-"`
+		hidden   string
+	}
+	schema := jsonSchemaFor(reflect.TypeFor[sample]())
+
+	required := schema["required"].([]string)
+	if !slices.Equal(required, []string{"name"}) {
+		t.Errorf("Expected only name to be required, got %v", required)
+	}
+	properties := schema["properties"].(map[string]any)
+	if len(properties) != 4 {
+		t.Errorf("Expected 4 properties, got %v", properties)
+	}
+	if got := properties["optional"].(map[string]any)["type"]; got != "integer" {
+		t.Errorf("Expected pointer to int64 to be an integer, got %v", got)
+	}
+}
+
+func TestSubcommandDumpSchemaBoth(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "alpha", "dir/b": "bravo"})
+	outDir := t.TempDir()
+
+	// A reader of the newer schema, which only knows its own keys.
+	type newSchemaEntry struct {
+		FilePath  string `json:"path"`
+		Xxh64Hash string `json:"xxh64"`
+		Size      int64  `json:"size"`
+	}
+	for _, sort := range []bool{false, true} {
+		manifest := filepath.Join(outDir, fmt.Sprintf("sort-%v.jsonl", sort))
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, Schema: "both", Sort: sort, SortSpill: 1})
+		})
+
+		// The pkg_version reader ignores the aliases.
+		entries := readManifest(t, manifest)
+		lines := readLines(t, manifest)
+		if len(entries) != 2 || len(lines) != 2 {
+			t.Fatalf("Expected 2 entries, got %d", len(entries))
+		}
+		for i, line := range lines {
+			var entry newSchemaEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Failed to parse %s with the newer schema: %v", line, err)
+			}
+			expected := newSchemaEntry{FilePath: entries[i].FilePath, Xxh64Hash: entries[i].Xxh64Hash, Size: entries[i].Size}
+			if entry != expected || entry.Xxh64Hash == "" {
+				t.Errorf("Expected %+v under the newer schema, got %+v", expected, entry)
+			}
+		}
+	}
+
+	manifest := filepath.Join(outDir, "pkg.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+	for _, line := range readLines(t, manifest) {
+		if strings.Contains(line, `"xxh64"`) {
+			t.Errorf("Expected no aliases by default, got %s", line)
+		}
+	}
+}
+
+func TestManifestVersions(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "alpha"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, EmbedVersion: true})
+	})
+	versioned := readLines(t, manifest)[0]
+	if !strings.HasPrefix(versioned, `{"v":1,"remoteName":"a",`) {
+		t.Errorf("Expected the version first, got %s", versioned)
+	}
+
+	// Versioned and unversioned lines, e.g. of manifests concatenated together, are read alike.
+	mixed := filepath.Join(t.TempDir(), "mixed.jsonl")
+	writeLines := func(lines ...string) {
+		if err := os.WriteFile(mixed, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLines(versioned, `{"remoteName":"b","md5":"","hash":"","fileSize":0}`)
+	pkgMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(mixed, pkgMap); err != nil {
+		t.Fatalf("Failed to read mixed versions: %v", err)
+	}
+	if len(pkgMap) != 2 || pkgMap["a"].Size != 5 || pkgMap["a"].Version != 1 || pkgMap["b"].Version != 0 {
+		t.Errorf("Expected both entries, got %+v", pkgMap)
+	}
+
+	// A line of an unknown version is rejected rather than misread.
+	writeLines(versioned, `{"v":2,"path":"c"}`)
+	if err := readPkgFile(mixed, make(map[string]FileInfoOutput)); err == nil || !strings.Contains(err.Error(), "unsupported format version 2") {
+		t.Errorf("Expected an unsupported version error, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+)
+
+// selfTestFixture is a known byte pattern with its precomputed hashes.
+type selfTestFixture struct {
+	Name          string
+	Size          int    // Number of bytes generated
+	Pattern       []byte // Repeated to fill Size bytes
+	ExpectedMD5   string
+	ExpectedXXH64 string
+}
+
+// selfTestFixtures are hashed by the "selftest" subcommand, ranging from empty input to a few 64 MB patterns.
+var selfTestFixtures = []selfTestFixture{
+	{"empty", 0, []byte("A"), "d41d8cd98f00b204e9800998ecf8427e", "2d06800538d394c2"},
+	{"small 1 KB of A", 1024, []byte("A"), "d47b127bc2de2d687ddc82dac354c415", "4d35ad08e9d77c0d"},
+	{"small unaligned hex pattern", 4096 + 17, []byte("0123456789abcdef"), "fdce44873f2629860863f0520660c51d", "1e95d6bb9bd2414d"},
+	{"medium 64 MB of A", 64 * 1024 * 1024, []byte("A"), "b728279deaecafd2c74e0330f90ca9f5", "2a1301d19400a370"},
+	{"medium unaligned dder pattern", 64*1024*1024 + 3, []byte("dder"), "0d4c6acd759a649f6a7b3814086b367f", "78a2fde889b3a554"},
+}
+
+func subcommandSelfTest(_ Config, _ *SelfTestCmd) {
+	accelerated, impl := xxh3Acceleration()
+	log.Info().Bool("accelerated", accelerated).Str("xxh3", impl).Msg("Hashing implementation")
+
+	failed := 0
+	for _, fixture := range selfTestFixtures {
+		if err := fixture.check(); err != nil {
+			log.Error().Err(err).Str("fixture", fixture.Name).Msg("Self-test failed")
+			failed++
+			continue
+		}
+		log.Info().Str("fixture", fixture.Name).Int("size", fixture.Size).Msg("Self-test passed")
+	}
+	if failed > 0 {
+		log.Panic().Int("failed", failed).Msg("Hashing self-test failed, do not trust dumps made with this build")
+	}
+}
+
+// check hashes the fixture through processFileReader and compares against the expected values.
+func (fixture selfTestFixture) check() error {
+	md5Hash, xxh64Hash, size, err := processFileReader(&patternReader{Size: fixture.Size, Pattern: fixture.Pattern})
+	if err != nil {
+		return err
+	}
+	if size != int64(fixture.Size) {
+		return fmt.Errorf("size mismatch: got %d, want %d", size, fixture.Size)
+	}
+	if got := hex.EncodeToString(md5Hash); got != fixture.ExpectedMD5 {
+		return fmt.Errorf("MD5 mismatch: got %s, want %s", got, fixture.ExpectedMD5)
+	}
+	if got := hex.EncodeToString(xxh64Hash); got != fixture.ExpectedXXH64 {
+		return fmt.Errorf("XXH64 mismatch: got %s, want %s", got, fixture.ExpectedXXH64)
+	}
+	return nil
+}
+
+// patternReader generates Size bytes by repeating Pattern, like the MockReader used by the tests.
+type patternReader struct {
+	Size    int
+	Pattern []byte
+	offset  int // Position in the pattern where the next read starts
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.Size <= 0 {
+		return 0, io.EOF // Return EOF when all content is exhausted.
+	}
+
+	readSize := min(len(p), r.Size)
+	// Start the repetition at the current offset so the pattern continues across reads of any size.
+	r.offset = fillPattern(p[:readSize], r.Pattern, r.offset)
+	r.Size -= readSize
+	return readSize, nil
+}
+
+// fillPattern fills p with pattern repeated from offset in it, and returns the offset the next fill continues from.
+// It writes into p directly, doubling the copied span, so it never allocates however large p is.
+func fillPattern(p, pattern []byte, offset int) int {
+	n := copy(p, pattern[offset:])
+	if aligned := p[n:]; len(aligned) > 0 {
+		// The rest starts at the beginning of the pattern, so what was filled of it is whole repetitions to copy on.
+		filled := copy(aligned, pattern)
+		for filled < len(aligned) {
+			filled += copy(aligned[filled:], aligned[:filled])
+		}
+	}
+	return (offset + len(p)) % len(pattern)
+}
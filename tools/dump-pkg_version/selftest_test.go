@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSelfTestFixtures(t *testing.T) {
+	for _, fixture := range selfTestFixtures {
+		if err := fixture.check(); err != nil {
+			t.Errorf("%s: %v", fixture.Name, err)
+		}
+	}
+}
+
+func TestSelfTestDetectsMismatch(t *testing.T) {
+	fixture := selfTestFixtures[1]
+	fixture.Pattern = []byte("B")
+	if err := fixture.check(); err == nil {
+		t.Error("Expected a mismatch for altered content")
+	}
+}
+
+func TestPatternReader(t *testing.T) {
+	// Read with a buffer that doesn't divide the pattern length, the pattern must continue across reads.
+	reader := &patternReader{Size: 1000, Pattern: []byte("dder")}
+	var got bytes.Buffer
+	buf := make([]byte, 7)
+	for {
+		n, err := reader.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+	}
+
+	expected := bytes.Repeat([]byte("dder"), 250)
+	if !bytes.Equal(got.Bytes(), expected) {
+		t.Error("Pattern was not continued across reads")
+	}
+}
+
+func TestFillPattern(t *testing.T) {
+	pattern := []byte("dder")
+	for _, size := range []int{0, 1, 3, 4, 5, 17, 4096} {
+		for offset := range pattern {
+			p := make([]byte, size)
+			next := fillPattern(p, pattern, offset)
+			expected := bytes.Repeat(pattern, (offset+size)/len(pattern)+1)[offset : offset+size]
+			if !bytes.Equal(p, expected) || next != (offset+size)%len(pattern) {
+				t.Errorf("Size %d from %d: expected %q continuing at %d, got %q at %d", size, offset, expected, (offset+size)%len(pattern), p, next)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Shard selects the subset of files processed by one of Count cooperating dump runs.
+// A file belongs to shard Index when the XXH64 of its relative, forward-slashed path modulo Count equals Index,
+// so the assignment is stable across runs, machines and platforms.
+type Shard struct {
+	Index uint64 // Zero-based shard index (N)
+	Count uint64 // Total number of shards (M)
+}
+
+// UnmarshalText parses a shard written as "N/M", letting go-arg populate it from the command line.
+func (s *Shard) UnmarshalText(text []byte) error {
+	var index, count uint64
+	if _, err := fmt.Sscanf(string(text), "%d/%d", &index, &count); err != nil {
+		return fmt.Errorf("invalid shard %q, expected N/M: %w", text, err)
+	}
+	if count == 0 || index >= count {
+		return fmt.Errorf("invalid shard %q, expected 0 <= N < M", text)
+	}
+	s.Index, s.Count = index, count
+	return nil
+}
+
+// Contains reports whether the file at relPath belongs to this shard.
+func (s Shard) Contains(relPath string) bool {
+	return xxh3.HashString(filepath.ToSlash(relPath))%s.Count == s.Index
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// inSkippedDir tells whether relPath is below a directory matching one of the --skip-dir patterns, or is one itself
+// when isDir. Patterns are matched against each directory from the top down, so "saves" only skips the top-level
+// saves directory while "*/logs" skips the logs directories one level down.
+func inSkippedDir(relPath string, isDir bool, patterns []string) bool {
+	if len(patterns) == 0 || relPath == "." {
+		return false
+	}
+	for i := 0; i <= len(relPath); i++ {
+		if i < len(relPath) && relPath[i] != '/' || i == len(relPath) && !isDir {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, relPath[:i]); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipDirEntries removes the entries of pkgMap in the skipped directories, and returns how many were removed.
+func skipDirEntries(pkgMap map[string]FileInfo, patterns []string) int {
+	skipped := 0
+	for name, file := range pkgMap {
+		if inSkippedDir(name, file.IsDir, patterns) {
+			delete(pkgMap, name)
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// skipDirWalk wraps a filepath.WalkDir callback over basedir so the skipped directories are not descended into.
+func skipDirWalk(basedir string, patterns []string, fn fs.WalkDirFunc) fs.WalkDirFunc {
+	return func(walked string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && len(patterns) > 0 {
+			if relPath, relErr := filepath.Rel(basedir, walked); relErr == nil && inSkippedDir(filepath.ToSlash(relPath), true, patterns) {
+				return filepath.SkipDir
+			}
+		}
+		return fn(walked, d, err)
+	}
+}
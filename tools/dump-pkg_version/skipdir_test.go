@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInSkippedDir(t *testing.T) {
+	patterns := []string{"saves", "*/logs"}
+	tests := []struct {
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{"saves/slot1", false, true},
+		{"saves/deep/slot2", false, true},
+		{"saves", true, true},
+		{"saves", false, false}, // A file named like the directory
+		{"data/saves/slot1", false, false},
+		{"game/logs/today.log", false, true},
+		{"game/logs", true, true},
+		{"logs/today.log", false, false},
+		{"savestate", false, false},
+		{".", true, false},
+	}
+	for _, test := range tests {
+		if skipped := inSkippedDir(test.relPath, test.isDir, patterns); skipped != test.expected {
+			t.Errorf("%s (dir %v): expected %v, got %v", test.relPath, test.isDir, test.expected, skipped)
+		}
+	}
+}
+
+func TestVerifySkipDir(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"game.bin": "game", "saves/slot1": "save", "game/logs/today.log": "log"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+	// User data changes after the dump, and new files appear next to it.
+	writeTree(t, inputDir, map[string]string{"saves/slot1": "later save", "saves/slot2": "new save", "game/logs/new.log": "new log"})
+
+	pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	files := make(map[string]FileInfo)
+	for name, entry := range pkgMap {
+		files[name] = FileInfo{FilePath: entry.FilePath, Md5Hash: decodeHex(entry.Md5Hash), Xxh64Hash: decodeHex(entry.Xxh64Hash), Size: entry.Size}
+	}
+	skipDirs := []string{"saves", "*/logs"}
+	if skipped := skipDirEntries(files, skipDirs); skipped != 2 {
+		t.Errorf("Expected 2 skipped entries, got %d", skipped)
+	}
+
+	results, err := verifyModifiedSince(2, inputDir, files, time.Time{}, skipDirs, compareOptions{})
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	var verified []string
+	runWithTimeout(t, 10*time.Second, func() {
+		for res := range results {
+			verified = append(verified, res.FilePath)
+			if res.Result != CR_Same {
+				t.Errorf("%s: expected %s, got %s", res.FilePath, CR_Same, res.Result)
+			}
+		}
+	})
+	if !slices.Equal(verified, []string{"game.bin"}) {
+		t.Errorf("Expected only game.bin to be verified, got %v", verified)
+	}
+
+	// The walk never enters the skipped directories, so the files added there are not seen as extra either.
+	walked := make(map[string]struct{})
+	err = filepath.WalkDir(inputDir, skipDirWalk(inputDir, skipDirs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(inputDir, path)
+		walked[filepath.ToSlash(relPath)] = struct{}{}
+		return err
+	}))
+	if err != nil {
+		t.Fatalf("Failed to walk: %v", err)
+	}
+	expected := []string{".", "game", "game.bin"}
+	if names := slices.Sorted(maps.Keys(walked)); !slices.Equal(names, expected) {
+		t.Errorf("Expected to walk %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which copySparse looks for runs of zeros.
+const sparseBlockSize = 64 * 1024
+
+// copySparse copies src into dst, seeking over blocks made only of zeros instead of writing them,
+// so that filesystems supporting sparse files leave holes there. The final size of dst is set
+// explicitly, in case the content ends with a hole. It returns the number of bytes copied.
+func copySparse(dst *os.File, src io.Reader) (int64, error) {
+	buf := make([]byte, sparseBlockSize)
+	zeros := make([]byte, sparseBlockSize)
+	var written int64
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				// Leave a hole, the skipped range reads back as zeros
+				if _, err := dst.Seek(int64(n), io.SeekCurrent); err != nil {
+					return written, err
+				}
+			} else if _, err := dst.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+
+	// Seeking past the end doesn't extend the file, truncating does
+	return written, dst.Truncate(written)
+}
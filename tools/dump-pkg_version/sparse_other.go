@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+)
+
+// isSparse always reports false, as sparse files aren't detected on this platform.
+func isSparse(_ fs.FileInfo) bool {
+	return false
+}
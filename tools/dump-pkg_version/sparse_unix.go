@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// isSparse reports whether a file has fewer blocks allocated than its size requires.
+func isSparse(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	// st_blocks is always counted in 512-byte units
+	return int64(stat.Blocks)*512 < info.Size()
+}
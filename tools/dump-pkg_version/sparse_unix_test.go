@@ -0,0 +1,123 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopySparse(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "src.bin")
+
+	// 4 MiB file with data only at the start and in the middle
+	src, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 4 * 1024 * 1024
+	if err := src.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	src.WriteAt([]byte("header"), 0)
+	src.WriteAt([]byte("middle"), size/2)
+	src.Close()
+
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(srcInfo) {
+		t.Skip("Filesystem of the temp directory doesn't support sparse files")
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcFile.Close()
+	dstPath := filepath.Join(root, "dst.bin")
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := copySparse(dst, srcFile)
+	dst.Close()
+	if err != nil {
+		t.Fatalf("copySparse failed: %v", err)
+	}
+	if written != size {
+		t.Errorf("Expected %d bytes copied, got %d", size, written)
+	}
+
+	srcContent, _ := os.ReadFile(srcPath)
+	dstContent, _ := os.ReadFile(dstPath)
+	if !bytes.Equal(srcContent, dstContent) {
+		t.Errorf("Copied content differs from the source")
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(dstInfo) {
+		t.Errorf("Expected the copy to keep its holes")
+	}
+}
+
+func TestIsSparseDenseFile(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"dense.txt": string(bytes.Repeat([]byte("x"), 8192))})
+	info, err := os.Stat(filepath.Join(root, "dense.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isSparse(info) {
+		t.Errorf("Expected a fully written file not to be sparse")
+	}
+}
+
+func TestMirrorDownloadSparse(t *testing.T) {
+	inputDir := t.TempDir()
+	const size = 4 * 1024 * 1024
+	src, err := os.Create(filepath.Join(inputDir, "sparse.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Truncate(size)
+	src.WriteAt([]byte("header"), 0)
+	src.WriteAt([]byte("middle"), size/2)
+	src.Close()
+	writeTree(t, inputDir, map[string]string{"dense.bin": string(bytes.Repeat([]byte{0}, 2*sparseBlockSize))})
+	if info, err := os.Stat(filepath.Join(inputDir, "sparse.bin")); err != nil || !isSparse(info) {
+		t.Skip("Filesystem of the temp directory doesn't support sparse files")
+	}
+
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, Sparse: true})
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(inputDir)))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(Config{Workers: 2}, &MirrorCmd{OutputDir: outputDir, PkgFiles: []string{manifest}, Download: server.URL, Sparse: true})
+	})
+	for name, sparse := range map[string]bool{"sparse.bin": true, "dense.bin": false} {
+		expected, _ := os.ReadFile(filepath.Join(inputDir, name))
+		actual, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil || !bytes.Equal(actual, expected) {
+			t.Errorf("%s: expected the served content, got %d bytes (err: %v)", name, len(actual), err)
+		}
+		// Only the files recorded as sparse get holes, the zeros of the others are written.
+		if info, err := os.Stat(filepath.Join(outputDir, name)); err != nil || isSparse(info) != sparse {
+			t.Errorf("%s: expected sparse %t, got %v (err: %v)", name, sparse, info != nil && isSparse(info), err)
+		}
+	}
+}
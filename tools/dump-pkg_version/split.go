@@ -0,0 +1,107 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+func subcommandSplit(_ Config, splitCmd *SplitCmd) {
+	if splitCmd.Parts < 1 {
+		log.Panic().Int("parts", splitCmd.Parts).Msg("Number of parts must be at least 1")
+	}
+	pkgFile := filepath.ToSlash(splitCmd.PkgFile)
+	outputFile := pkgFile
+	if splitCmd.OutputFile != "" {
+		outputFile = filepath.ToSlash(splitCmd.OutputFile)
+	}
+
+	pkgMap := make(map[string]FileInfoOutput)
+	if err := readPkgFile(pkgFile, pkgMap); err != nil {
+		log.Panic().Err(err).Msg("Failed to read pkg file")
+	}
+
+	parts := splitManifest(pkgMap, splitCmd.Parts)
+	var maxBytes, totalBytes int64
+	for i, part := range parts {
+		partFile := splitPartFileName(outputFile, i, len(parts))
+		if err := writeManifestEntries(partFile, part.Entries); err != nil {
+			log.Panic().Err(err).Str("file", partFile).Msg("Failed to write part")
+		}
+		log.Info().Str("file", partFile).Int("files", len(part.Entries)).Int64("bytes", part.Bytes).Msg("Wrote part")
+		maxBytes = max(maxBytes, part.Bytes)
+		totalBytes += part.Bytes
+	}
+
+	// The balance is the largest part relative to a perfect split, 1 being perfectly balanced.
+	balance := 1.0
+	if totalBytes > 0 {
+		balance = float64(maxBytes) / (float64(totalBytes) / float64(len(parts)))
+	}
+	log.Info().
+		Int("parts", len(parts)).
+		Int64("bytes", totalBytes).
+		Int64("largest", maxBytes).
+		Float64("balance", balance).
+		Msg("Split finished")
+}
+
+// manifestPart is a group of manifest entries and their total size.
+type manifestPart struct {
+	Entries []FileInfoOutput
+	Bytes   int64
+}
+
+// splitManifest distributes the entries into n parts of roughly equal total size. Entries are placed from the
+// largest to the smallest, each into the part with the fewest bytes so far, then every part is sorted by path.
+func splitManifest(pkgMap map[string]FileInfoOutput, n int) []manifestPart {
+	entries := make([]FileInfoOutput, 0, len(pkgMap))
+	for _, entry := range pkgMap {
+		entries = append(entries, entry)
+	}
+	// Ties are broken by path so the same manifest always splits the same way.
+	slices.SortFunc(entries, func(a, b FileInfoOutput) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.FilePath, b.FilePath))
+	})
+
+	parts := make([]manifestPart, n)
+	for _, entry := range entries {
+		smallest := 0
+		for i := range parts {
+			if parts[i].Bytes < parts[smallest].Bytes {
+				smallest = i
+			}
+		}
+		parts[smallest].Entries = append(parts[smallest].Entries, entry)
+		parts[smallest].Bytes += entry.Size
+	}
+	for _, part := range parts {
+		slices.SortFunc(part.Entries, func(a, b FileInfoOutput) int {
+			return cmp.Compare(a.FilePath, b.FilePath)
+		})
+	}
+	return parts
+}
+
+// splitPartFileName returns the manifest name of the i-th of n parts, e.g. "package-part1of4.jsonl" for "package.jsonl".
+func splitPartFileName(outputFile string, i, n int) string {
+	return dirShardFileName(outputFile, fmt.Sprintf("part%dof%d", i+1, n))
+}
+
+// writeManifestEntries writes the entries to a new manifest file, in order.
+func writeManifestEntries(path string, entries []FileInfoOutput) error {
+	out, err := createPkgOutFile(path, false)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := out.enc.Encode(entry); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
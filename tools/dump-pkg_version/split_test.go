@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitManifest(t *testing.T) {
+	// Skewed sizes: a few large files and many small ones, which a split by line count would pile up unevenly.
+	pkgMap := make(map[string]FileInfoOutput)
+	var total int64
+	for i := range 100 {
+		size := int64(i%7 + 1)
+		if i%25 == 0 {
+			size = 500
+		}
+		name := fmt.Sprintf("dir%d/file%d", i%4, i)
+		pkgMap[name] = FileInfoOutput{FilePath: name, Size: size}
+		total += size
+	}
+
+	parts := splitManifest(pkgMap, 3)
+	if len(parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(parts))
+	}
+	var names []string
+	for _, part := range parts {
+		var bytes int64
+		for _, entry := range part.Entries {
+			names = append(names, entry.FilePath)
+			bytes += entry.Size
+		}
+		if bytes != part.Bytes {
+			t.Errorf("Part reports %d bytes, its entries add up to %d", part.Bytes, bytes)
+		}
+		if !slices.IsSortedFunc(part.Entries, func(a, b FileInfoOutput) int { return strings.Compare(a.FilePath, b.FilePath) }) {
+			t.Errorf("Expected every part to be sorted by path")
+		}
+		// Greedy placement never overshoots the mean by more than the largest entry.
+		if part.Bytes > total/3+500 {
+			t.Errorf("Part of %d bytes is unbalanced, total is %d", part.Bytes, total)
+		}
+	}
+	slices.Sort(names)
+	if len(names) != len(pkgMap) || len(slices.Compact(names)) != len(pkgMap) {
+		t.Errorf("Expected the parts to partition the %d entries, got %d names", len(pkgMap), len(names))
+	}
+}
+
+func TestSubcommandSplit(t *testing.T) {
+	manifest := writeManifest(t,
+		FileInfoOutput{FilePath: "big", Md5Hash: "01", Xxh64Hash: "01", Size: 100},
+		FileInfoOutput{FilePath: "a", Md5Hash: "02", Xxh64Hash: "02", Size: 40},
+		FileInfoOutput{FilePath: "b", Md5Hash: "03", Xxh64Hash: "03", Size: 30},
+		FileInfoOutput{FilePath: "c", Md5Hash: "04", Xxh64Hash: "04", Size: 30},
+	)
+	subcommandSplit(Config{}, &SplitCmd{PkgFile: manifest, Parts: 2})
+
+	dir := filepath.Dir(manifest)
+	expected := map[string][]string{
+		"package-part1of2.jsonl": {"big"},
+		"package-part2of2.jsonl": {"a", "b", "c"},
+	}
+	for name, files := range expected {
+		var got []string
+		for _, entry := range readManifest(t, filepath.Join(dir, name)) {
+			got = append(got, entry.FilePath)
+		}
+		if !slices.Equal(got, files) {
+			t.Errorf("%s: expected %v, got %v", name, files, got)
+		}
+	}
+}
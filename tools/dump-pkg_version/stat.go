@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statTotals counts files and their total size in bytes.
+type statTotals struct {
+	Files int64
+	Bytes int64
+}
+
+// add counts one more file of size bytes.
+func (st *statTotals) add(size int64) {
+	st.Files++
+	st.Bytes += size
+}
+
+// treeStats holds the totals of a tree, overall and broken down by top-level directory and by extension.
+// Files directly in the root are counted under ".", files without extension under "".
+type treeStats struct {
+	statTotals
+	ByDir map[string]*statTotals
+	ByExt map[string]*statTotals
+}
+
+func subcommandStat(_ Config, statCmd *StatCmd) {
+	if statCmd.InputDir == "" {
+		log.Panic().Msg("Input directory is required") // If no input directory is given, log a fatal error and exit.
+	}
+
+	stats, err := statTree(statCmd.InputDir)
+	if err != nil {
+		log.Panic().Err(err).Msg("Failed to walk input directory")
+	}
+	if err := writeTreeStats(os.Stdout, stats); err != nil {
+		log.Panic().Err(err).Msg("Failed to write the totals")
+	}
+}
+
+// statTree counts the regular files under inputDir and their sizes, without opening any of them: the size comes from
+// the directory entry, which only needs an extra stat on platforms whose listings don't carry it. Links are not
+// followed.
+func statTree(inputDir string) (treeStats, error) {
+	stats := treeStats{ByDir: make(map[string]*statTotals), ByExt: make(map[string]*statTotals)}
+	count := func(totals map[string]*statTotals, key string, size int64) {
+		if totals[key] == nil {
+			totals[key] = &statTotals{}
+		}
+		totals[key].add(size)
+	}
+	err := filepath.WalkDir(inputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(inputDir, path)
+		if err != nil {
+			return err
+		}
+		topDir, _, found := strings.Cut(filepath.ToSlash(relPath), "/")
+		if !found {
+			topDir = "."
+		}
+		stats.add(info.Size())
+		count(stats.ByDir, topDir, info.Size())
+		count(stats.ByExt, strings.ToLower(filepath.Ext(d.Name())), info.Size())
+		return nil
+	})
+	return stats, err
+}
+
+// writeTreeStats writes the totals of stats to w, then the breakdowns largest first.
+func writeTreeStats(w io.Writer, stats treeStats) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "%d files, %d bytes\n", stats.Files, stats.Bytes)
+	for _, breakdown := range []struct {
+		title  string
+		totals map[string]*statTotals
+	}{
+		{"By top-level directory", stats.ByDir},
+		{"By extension", stats.ByExt},
+	} {
+		fmt.Fprintf(out, "\n%s:\n", breakdown.title)
+		keys := slices.SortedFunc(maps.Keys(breakdown.totals), func(a, b string) int {
+			return cmp.Or(cmp.Compare(breakdown.totals[b].Bytes, breakdown.totals[a].Bytes), strings.Compare(a, b))
+		})
+		for _, key := range keys {
+			totals := breakdown.totals[key]
+			fmt.Fprintf(out, "  %s: %d files, %d bytes\n", cmp.Or(key, "(none)"), totals.Files, totals.Bytes)
+		}
+	}
+	return out.Flush() // The bufio.Writer keeps the first write error
+}
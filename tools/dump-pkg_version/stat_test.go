@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatTree(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"readme.TXT":        "12345",
+		"bin/game.exe":      strings.Repeat("x", 100),
+		"bin/lib/core.dll":  strings.Repeat("x", 50),
+		"data/a.pak":        strings.Repeat("x", 1000),
+		"data/b.pak":        strings.Repeat("x", 2000),
+		"data/sub/notes":    "abc",
+		"data/sub/more.txt": "",
+	})
+
+	stats, err := statTree(inputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 7 || stats.Bytes != 3158 {
+		t.Errorf("Expected 7 files and 3158 bytes, got %d files and %d bytes", stats.Files, stats.Bytes)
+	}
+	expectTotals := func(name string, totals map[string]*statTotals, expected map[string]statTotals) {
+		t.Helper()
+		if len(totals) != len(expected) {
+			t.Errorf("%s: expected %d keys, got %d", name, len(expected), len(totals))
+		}
+		for key, want := range expected {
+			if got := totals[key]; got == nil || *got != want {
+				t.Errorf("%s %q: expected %+v, got %+v", name, key, want, got)
+			}
+		}
+	}
+	expectTotals("By dir", stats.ByDir, map[string]statTotals{
+		".":    {Files: 1, Bytes: 5},
+		"bin":  {Files: 2, Bytes: 150},
+		"data": {Files: 4, Bytes: 3003},
+	})
+	expectTotals("By ext", stats.ByExt, map[string]statTotals{
+		".txt": {Files: 2, Bytes: 5},
+		".exe": {Files: 1, Bytes: 100},
+		".dll": {Files: 1, Bytes: 50},
+		".pak": {Files: 2, Bytes: 3000},
+		"":     {Files: 1, Bytes: 3},
+	})
+
+	var out bytes.Buffer
+	if err := writeTreeStats(&out, stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"7 files, 3158 bytes\n", "  data: 4 files, 3003 bytes\n  bin: 2 files", "  (none): 1 files, 3 bytes\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", line, out.String())
+		}
+	}
+}
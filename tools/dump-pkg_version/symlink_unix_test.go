@@ -0,0 +1,100 @@
+//go:build unix
+
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSymlinkAsLinkRoundTrip(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"data/target.txt": "content"})
+	links := map[string]string{
+		"data/link":     "target.txt",
+		"dangling":      "missing/file", // Never followed, so a dangling link is fine
+		"data/absolute": "/etc/hostname",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(inputDir, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	config := Config{Workers: 2}
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, SymlinkAsLink: true})
+	})
+
+	entries := make(map[string]FileInfoOutput)
+	for _, entry := range readManifest(t, outputFile) {
+		entries[entry.FilePath] = entry
+	}
+	for link, target := range links {
+		entry := entries[link]
+		if !entry.IsSymlink || entry.LinkTarget != target {
+			t.Errorf("Expected %s to be recorded as a link to %s, got %+v", link, target, entry)
+		}
+		if entry.Size != int64(len(target)) || entry.Md5Hash == "" {
+			t.Errorf("Expected %s to carry the size and hashes of its target string, got %+v", link, entry)
+		}
+	}
+	if entry := entries["data/target.txt"]; entry.IsSymlink || entry.Size != int64(len("content")) {
+		t.Errorf("Expected the regular file to be hashed as usual, got %+v", entry)
+	}
+
+	// Verify checks the link target, in both modes.
+	pkgMap, err := readPkgFiles("", []string{outputFile}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	toFileInfo := func(v FileInfoOutput) FileInfo {
+		return FileInfo{FilePath: v.FilePath, Md5Hash: decodeHex(v.Md5Hash), Xxh64Hash: decodeHex(v.Xxh64Hash), Size: v.Size, IsSymlink: v.IsSymlink, LinkTarget: v.LinkTarget}
+	}
+	files := make(map[string]FileInfo, len(pkgMap))
+	for k, v := range pkgMap {
+		files[k] = toFileInfo(v)
+	}
+	if err := os.Remove(filepath.Join(inputDir, "data", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("other.txt", filepath.Join(inputDir, "data", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(inputDir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, inputDir, map[string]string{"dangling": "now a file"})
+	expected := map[CompareResult]int{CR_Same: 2, CR_LinkTargetDif: 1, CR_NotLink: 1}
+	for _, statOnly := range []bool{false, true} {
+		var counts map[CompareResult]int
+		runWithTimeout(t, 10*time.Second, func() {
+			if statOnly {
+				counts = reportResults(verifyStatOnly(2, inputDir, files)).Counts()
+			} else {
+				counts = reportResults(verifyContent(2, inputDir, files, compareOptions{})).Counts()
+			}
+		})
+		if !maps.Equal(counts, expected) {
+			t.Errorf("Expected %v (stat-only: %v), got %v", expected, statOnly, counts)
+		}
+	}
+
+	// Mirror recreates the links as they were recorded.
+	outputDir := t.TempDir()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(config, &MirrorCmd{OutputDir: outputDir, PkgFiles: []string{outputFile}})
+	})
+	for link, target := range links {
+		got, err := os.Readlink(filepath.Join(outputDir, link))
+		if err != nil {
+			t.Errorf("Expected mirror to create the link %s: %v", link, err)
+		} else if got != target {
+			t.Errorf("Expected %s to point to %s, got %s", link, target, got)
+		}
+	}
+}
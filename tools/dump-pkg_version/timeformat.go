@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TimeFormat tells how the time fields of a manifest entry are written.
+type TimeFormat int
+
+const (
+	TF_UnixNano TimeFormat = iota // Compact integer Unix nanoseconds, the default
+	TF_RFC3339                    // Human-readable RFC 3339 string in UTC, with nanoseconds
+)
+
+// parseTimeFormat returns the TimeFormat named by --time-format.
+func parseTimeFormat(name string) (TimeFormat, error) {
+	switch name {
+	case "", "unixnano":
+		return TF_UnixNano, nil
+	case "rfc3339":
+		return TF_RFC3339, nil
+	default:
+		return 0, fmt.Errorf("unknown time format %q, expected rfc3339 or unixnano", name)
+	}
+}
+
+// ManifestTime is a time field of a manifest entry. It is written in its Format and read from either format,
+// which is then kept so rewriting an entry doesn't change it. The zero time is not recorded and omitted.
+type ManifestTime struct {
+	time.Time
+	Format TimeFormat
+}
+
+func (t ManifestTime) MarshalJSON() ([]byte, error) {
+	if t.Format == TF_RFC3339 {
+		return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+	}
+	return strconv.AppendInt(nil, t.UnixNano(), 10), nil
+}
+
+func (t *ManifestTime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid time: %w", err)
+		}
+		*t = ManifestTime{Time: parsed, Format: TF_RFC3339}
+		return nil
+	}
+	nanos, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid time: %w", err)
+	}
+	*t = ManifestTime{Time: time.Unix(0, nanos), Format: TF_UnixNano}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestTimeRoundTrip(t *testing.T) {
+	recorded := time.Date(2024, 5, 1, 12, 30, 45, 123456789, time.FixedZone("UTC+7", 7*60*60))
+	tests := []struct {
+		format   TimeFormat
+		expected string
+	}{
+		{TF_UnixNano, "1714541445123456789"},
+		{TF_RFC3339, `"2024-05-01T05:30:45.123456789Z"`},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(ManifestTime{recorded, test.format})
+		if err != nil {
+			t.Fatalf("Failed to marshal: %v", err)
+		}
+		if string(data) != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, data)
+		}
+
+		var decoded ManifestTime
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", data, err)
+		}
+		if !decoded.Equal(recorded) || decoded.Format != test.format {
+			t.Errorf("Expected %v in format %d, got %v in format %d", recorded, test.format, decoded.Time, decoded.Format)
+		}
+	}
+
+	// Plain RFC 3339 without fractional seconds is read too.
+	var decoded ManifestTime
+	if err := json.Unmarshal([]byte(`"2024-05-01T05:30:45Z"`), &decoded); err != nil || !decoded.Equal(recorded.Truncate(time.Second)) {
+		t.Errorf("Expected %v, got %v (%v)", recorded.Truncate(time.Second), decoded.Time, err)
+	}
+	if err := json.Unmarshal([]byte(`"yesterday"`), &decoded); err == nil {
+		t.Error("Expected an error for an invalid time")
+	}
+}
+
+func TestDumpTimeFormatRFC3339(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a": "alpha"})
+	modTime := time.Date(2024, 5, 1, 12, 30, 45, 123456789, time.UTC)
+	if err := os.Chtimes(filepath.Join(inputDir, "a"), modTime, modTime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+	stat, err := os.Stat(filepath.Join(inputDir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 1}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, ModTime: true, TimeFormat: "rfc3339"})
+	})
+	if line := readLines(t, manifest)[0]; !strings.Contains(line, `"mtime":"`+stat.ModTime().UTC().Format(time.RFC3339Nano)+`"`) {
+		t.Errorf("Expected an RFC 3339 mtime, got %s", line)
+	}
+
+	// The readable time is as precise as Unix nanoseconds, so smart mode still trusts the file.
+	entry := readManifest(t, manifest)[0]
+	if entry.ModTime.Format != TF_RFC3339 || !entry.ModTime.Equal(stat.ModTime()) {
+		t.Errorf("Expected %v read as RFC 3339, got %v in format %d", stat.ModTime(), entry.ModTime.Time, entry.ModTime.Format)
+	}
+	file := FileInfo{FilePath: "a", Md5Hash: decodeHex(entry.Md5Hash), Xxh64Hash: decodeHex(entry.Xxh64Hash), Size: entry.Size, ModTime: entry.ModTime.Time}
+	if result, err := compareFile(inputDir, file, compareOptions{}); result != CR_Trusted {
+		t.Errorf("Expected %v, got %v (%v)", CR_Trusted, result, err)
+	}
+}
@@ -3,15 +3,24 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
+	"example/hello/hyapi"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 )
@@ -19,23 +28,50 @@ import (
 type CompareResult int
 
 const (
-	CR_Same CompareResult = iota
-	CR_SizeDif
+	CR_Same    CompareResult = iota
+	CR_SizeDif               // No longer reported, split into CR_SizeLarger and CR_SizeSmaller, kept so the values after it don't change
 	CR_Md5Dif
 	CR_Xxh64Dif
 	CR_NotExist
 	CR_IsDir
 	CR_Error
+	CR_OwnerDif
+	CR_FingerprintDif
+	CR_NotDir
+	CR_Trusted  // Size and recorded mtime match, the content was not hashed
+	CR_Verified // Recorded mtime differs but the hashes match
+	CR_NotLink
+	CR_LinkTargetDif
+	CR_HeaderDif
+	CR_Unmodified  // Older than --modified-since with the recorded size, the content was not hashed
+	CR_Unchecked   // Not read as the --read-budget was exhausted
+	CR_HashDif     // A hash selected with --compare-hash, other than md5 and xxh64, differs
+	CR_Exists      // Present on disk, nothing else was checked with --missing-only
+	CR_SizeLarger  // The file grew past its recorded size, the more suspicious size difference
+	CR_SizeSmaller // The file shrank below its recorded size
+
+	crCount // Number of CompareResult values, must stay last
 )
 
+// sizeResult returns the result of a file whose actual size differs from the expected one, telling growth from
+// shrinkage as unexpected growth may be injected content.
+func sizeResult(actual, expected int64) CompareResult {
+	if actual > expected {
+		return CR_SizeLarger
+	}
+	return CR_SizeSmaller
+}
+
 type FileCompareResult struct {
 	FilePath string // Relative path of the file from the input directory
 	Result   CompareResult
+	Hashes   map[string]bool // Whether each hash compared matched, by name, nil when none was compared
 }
 
-func subcommandVerify(args *Args, verifyCmd *VerifyCmd) {
-	// Create local copies of args and verifyCmd to avoid unintended modifications.
-	_args := *args
+// subcommandVerify compares the input directory against the pkg files and returns the results by kind, as logged in
+// its summary.
+func subcommandVerify(config Config, verifyCmd *VerifyCmd) *CompareResultBuckets {
+	// Create a local copy of verifyCmd to avoid unintended modifications.
 	_verifyCmd := *verifyCmd
 
 	// Ensure that the input directory path uses forward slashes consistently,
@@ -51,99 +87,607 @@ func subcommandVerify(args *Args, verifyCmd *VerifyCmd) {
 		log.Panic().Msg("Input directory is required") // If no input directory is given, log a fatal error and exit.
 	}
 
-	// it is pretty fast to read already, doesn't need multi thread as map will require locking anyway.
-	_pkgMap, err := readPkgFiles(_verifyCmd.InputDir, _verifyCmd.PkgFiles, _verifyCmd.CheckInputDirForPkg)
-	pkgMap := lo.MapEntries(_pkgMap, func(k string, v FileInfoOutput) (string, FileInfo) {
-		// Convert FileInfoOutput to FileInfo
-		fileInfo := FileInfo{
-			FilePath:  v.FilePath,
-			Md5Hash:   decodeHex(v.Md5Hash),
-			Xxh64Hash: decodeHex(v.Xxh64Hash),
-			Size:      v.Size,
+	// Only the local manifests have an mtime telling when they were written, so they are checked before any download.
+	switch _verifyCmd.RequireFresh {
+	case "":
+	case "warn", "fail":
+		pkgFiles := _verifyCmd.PkgFiles
+		if _verifyCmd.CheckInputDirForPkg {
+			inputDirPkgFiles, err := pkgFilesInDir(_verifyCmd.InputDir)
+			if err != nil {
+				log.Panic().Err(err).Msg("Failed to scan input directory for pkg files")
+			}
+			pkgFiles = append(inputDirPkgFiles, pkgFiles...)
+		}
+		stale, err := staleFiles(_verifyCmd.InputDir, pkgFiles)
+		if err != nil {
+			log.Panic().Err(err).Msg("Failed to check the package files are fresh")
+		}
+		for _, file := range stale {
+			log.Info().Str("file", file).Msg("File is newer than the package files")
+		}
+		if len(stale) > 0 {
+			event := log.Warn()
+			if _verifyCmd.RequireFresh == "fail" {
+				event = log.Panic()
+			}
+			event.Int("files", len(stale)).Msg("Package files are older than the input directory, they may predate its changes")
+		}
+	default:
+		log.Panic().Str("require-fresh", _verifyCmd.RequireFresh).Msg("Require fresh must be warn or fail")
+	}
+
+	// Remote manifests are downloaded next to each other and read after the local ones, like extra pkg files.
+	if len(_verifyCmd.PkgURLs) > 0 {
+		dir, err := os.MkdirTemp("", "dump-pkg_version-pkg-url-*")
+		if err != nil {
+			log.Panic().Err(err).Msg("Failed to create download directory")
+		}
+		defer os.RemoveAll(dir)
+		client := newPkgClient(_verifyCmd.HTTPTimeout)
+		policy := hyapi.NewRetryPolicy(_verifyCmd.Retries, _verifyCmd.RetryDelay)
+		pkgFiles, err := fetchPkgURLs(client, policy, _verifyCmd.PkgURLs, dir)
+		client.Close()
+		if err != nil {
+			log.Panic().Err(err).Msg("Failed to fetch some pkg files")
 		}
-		return k, fileInfo
+		_verifyCmd.PkgFiles = append(_verifyCmd.PkgFiles, pkgFiles...)
+	}
+
+	if _verifyCmd.MaxReport < 0 {
+		log.Panic().Int("max-report", _verifyCmd.MaxReport).Msg("Max report must not be negative")
+	}
+	if _verifyCmd.Smart && _verifyCmd.StatOnly {
+		log.Panic().Msg("Smart mode hashes changed files and cannot be combined with stat-only mode")
+	}
+	if _verifyCmd.HeaderOnly && (_verifyCmd.StatOnly || _verifyCmd.NormalizeEOL) {
+		log.Panic().Msg("Header only mode reads raw file headers and cannot be combined with stat-only mode or normalized line endings")
+	}
+	if _verifyCmd.NormalizeEOL && (_verifyCmd.StatOnly || _verifyCmd.ModifiedSince != nil || _verifyCmd.CASRoot != "") {
+		log.Panic().Msg("Normalized text files have to be read to know their size and are told by the extension of their remote name, so they cannot be combined with stat-only mode, modified since or a content-addressed store")
+	}
+	if _verifyCmd.CASRoot != "" && (_verifyCmd.StatOnly || _verifyCmd.DiskIndex || _verifyCmd.ModifiedSince != nil || _verifyCmd.CaseInsensitive) {
+		log.Panic().Msg("A content-addressed store is looked up by hash and cannot be combined with stat-only mode, a disk index, modified since or case-insensitive matching")
+	}
+	if _verifyCmd.MissingOnly && (_verifyCmd.StatOnly || _verifyCmd.Smart || _verifyCmd.HeaderOnly || _verifyCmd.NormalizeEOL || _verifyCmd.Fingerprint ||
+		_verifyCmd.Owner || len(_verifyCmd.CompareHashes) > 0 || _verifyCmd.ModifiedSince != nil || _verifyCmd.CASRoot != "") {
+		log.Panic().Msg("Missing only mode only checks files exist and cannot be combined with modes or options comparing them")
+	}
+	if _verifyCmd.XxhSeed != nil && _verifyCmd.DiskIndex {
+		log.Panic().Msg("The xxh64 seed is checked against the loaded manifest and cannot be combined with a disk index")
+	}
+	if len(_verifyCmd.CompareHashes) > 0 && (_verifyCmd.StatOnly || _verifyCmd.HeaderOnly || _verifyCmd.DiskIndex || _verifyCmd.CASRoot != "") {
+		log.Panic().Msg("Compare hash selects the hashes of the loaded manifest and cannot be combined with stat-only mode, header only mode, a disk index or a content-addressed store")
+	}
+	for _, name := range _verifyCmd.CompareHashes {
+		if _, err := newHasher(name); err != nil {
+			log.Panic().Err(err).Msg("Invalid compare hash")
+		}
+	}
+	if (_verifyCmd.Only != "" || _verifyCmd.ResumeFile != "" || len(_verifyCmd.SkipDirs) > 0) && _verifyCmd.DiskIndex {
+		log.Panic().Msg("Only, resume file and skip dir select manifest entries by name and cannot be combined with a disk index")
+	}
+	// A trailing slash only tells the pattern names a directory.
+	_verifyCmd.SkipDirs = lo.Map(_verifyCmd.SkipDirs, func(pattern string, _ int) string {
+		return strings.TrimSuffix(filepath.ToSlash(pattern), "/")
 	})
-	_pkgMap = nil
-	if err != nil {
-		log.Panic().Err(err).Msg("Error reading some pkg files")
+	for _, pattern := range _verifyCmd.SkipDirs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Panic().Err(err).Str("pattern", pattern).Msg("Invalid skip dir pattern")
+		}
+	}
+	if _verifyCmd.ModifiedSince != nil && (_verifyCmd.StatOnly || _verifyCmd.DiskIndex) {
+		log.Panic().Msg("Modified since walks the input directory and cannot be combined with stat-only mode or a disk index")
 	}
 
-	var results []FileCompareResult
-	var resultsMutex sync.Mutex
-	workQueue := make(chan FileInfo, len(pkgMap)) // Work queue
+	// Files are only read while the budget lasts, it is shared by every entry.
+	budget := newReadBudget(_verifyCmd.ReadBudget)
+	// Options applying to every file compared, the entries only carry what the manifest records.
+	opts := compareOptions{
+		NormalizeEOL: _verifyCmd.NormalizeEOL,
+		TextExts:     config.TextExts,
+		ReadBudget:   budget,
+		MissingOnly:  _verifyCmd.MissingOnly,
+	}
+
+	// Convert FileInfoOutput to FileInfo
+	toFileInfo := func(v FileInfoOutput) FileInfo {
+		fileInfo := FileInfo{
+			FilePath:   v.FilePath,
+			Md5Hash:    decodeHex(v.Md5Hash),
+			Xxh64Hash:  decodeHex(v.Xxh64Hash),
+			Size:       v.Size,
+			IsDir:      v.IsDir,
+			IsSymlink:  v.IsSymlink,
+			LinkTarget: v.LinkTarget,
+		}
+		// Only the selected hashes are compared when requested, the additional ones included.
+		if len(_verifyCmd.CompareHashes) > 0 {
+			selectCompareHashes(&fileInfo, v, _verifyCmd.CompareHashes)
+		}
+		// Ownership is only compared when requested, entries without uid/gid are never checked.
+		if _verifyCmd.Owner {
+			fileInfo.Uid, fileInfo.Gid = v.Uid, v.Gid
+		}
+		// Recorded mtimes are only trusted in smart mode.
+		if _verifyCmd.Smart {
+			fileInfo.ModTime = v.ModTime.Time
+		}
+		// Header hashes replace the full hashes when requested, entries without one are still hashed whole.
+		if _verifyCmd.HeaderOnly && v.HeaderHash != "" {
+			fileInfo.HeaderHash, fileInfo.HeaderBytes = decodeHex(v.HeaderHash), v.HeaderBytes
+		}
+		// Fingerprints are only used as a first pass when requested.
+		if _verifyCmd.Fingerprint && v.Fingerprint != "" {
+			fileInfo.Fingerprint = decodeHex(v.Fingerprint)
+		}
+		return fileInfo
+	}
+
+	// Every result flows through a single channel consumed by the reporter, so differences are reported as soon as they're found.
+	var compared <-chan FileCompareResult
+	// Set when verifying contents, which can be interrupted, with the entries dispatched and those reached.
+	var interrupted context.Context
+	var pending map[string]FileInfo
+	reached := make(map[string]struct{})
+	if _verifyCmd.DiskIndex {
+		// The manifest is sorted into a temporary file and streamed from it, so it never has to fit in memory.
+		if _verifyCmd.CaseInsensitive {
+			log.Panic().Msg("Case-insensitive matching is not supported with a disk index")
+		}
+		index, err := buildDiskIndex(_verifyCmd.InputDir, _verifyCmd.PkgFiles, _verifyCmd.CheckInputDirForPkg)
+		if err != nil {
+			log.Panic().Err(err).Msg("Error reading some pkg files")
+		}
+		defer index.Close()
+		log.Debug().Int("entries", index.Len()).Msg("Built disk index")
+		// Every file is hashed with the seed the manifest was dumped with.
+		if !_verifyCmd.StatOnly {
+			if opts.XxhSeed, err = xxhSeedOf(index.Each); err != nil {
+				log.Panic().Err(err).Msg("Manifest xxh64 seeds differ")
+			}
+		}
+
+		compared = verifyDiskIndex(config.Workers, _verifyCmd.InputDir, index, _verifyCmd.StatOnly, toFileInfo, opts)
+	} else {
+		// it is pretty fast to read already, doesn't need multi thread as map will require locking anyway.
+		// Verifying against the pkg files that could be read still reports their differences, the others are named.
+		_pkgMap, err := readPkgFiles(_verifyCmd.InputDir, _verifyCmd.PkgFiles, _verifyCmd.CheckInputDirForPkg)
+		if _pkgMap == nil {
+			log.Panic().Err(err).Msg("Failed to read any pkg file")
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading some pkg files, verifying against the others")
+		}
+		// Hashes seeded differently than expected would make every file look changed.
+		if _verifyCmd.XxhSeed != nil {
+			if err := checkXxhSeed(_pkgMap, *_verifyCmd.XxhSeed); err != nil {
+				log.Panic().Err(err).Uint64("xxh-seed", *_verifyCmd.XxhSeed).Msg("Manifest xxh64 seed mismatch")
+			}
+		}
+		// Every file is hashed with the seed the manifest was dumped with.
+		if opts.XxhSeed, err = manifestXxhSeed(_pkgMap); err != nil {
+			log.Panic().Err(err).Msg("Manifest xxh64 seeds differ")
+		}
+		// A selected hash the manifest doesn't record would leave every file compared against nothing.
+		if err := checkCompareHashes(_pkgMap, _verifyCmd.CompareHashes); err != nil {
+			log.Panic().Err(err).Strs("compare-hash", _verifyCmd.CompareHashes).Msg("Manifest lacks a hash to compare")
+		}
+		pkgMap := lo.MapEntries(_pkgMap, func(k string, v FileInfoOutput) (string, FileInfo) {
+			return k, toFileInfo(v)
+		})
+		_pkgMap = nil
 
-	var workWg sync.WaitGroup
-	// Start a fixed number of worker goroutines
-	workWg.Add(_args.Threads)
-	for range _args.Threads {
-		go func() {
-			defer workWg.Done()
-			for file := range workQueue { // Workers pick tasks from the queue
-				result, _ := compareFile(_verifyCmd.InputDir, file)
-				if result != CR_Same {
-					resultsMutex.Lock()
-					results = append(results, FileCompareResult{FilePath: file.FilePath, Result: result})
-					resultsMutex.Unlock()
+		if _verifyCmd.Only != "" {
+			// Only the listed entries are verified, e.g. those an interrupted verify never reached.
+			names, err := readNameList(_verifyCmd.Only)
+			if err != nil {
+				log.Panic().Err(err).Msg("Failed to read the entries to verify")
+			}
+			var missing []string
+			pkgMap, missing = restrictPkgMap(pkgMap, names)
+			for _, name := range missing {
+				log.Warn().Str("file", name).Msg("Entry to verify is not in the pkg files")
+			}
+		}
+
+		if len(_verifyCmd.SkipDirs) > 0 {
+			// Directories of user data are neither verified nor walked.
+			skipped := skipDirEntries(pkgMap, _verifyCmd.SkipDirs)
+			log.Debug().Int("entries", skipped).Strs("skip-dir", _verifyCmd.SkipDirs).Msg("Skipped entries in skipped directories")
+		}
+
+		if _verifyCmd.CaseInsensitive {
+			// Match manifest paths against on-disk paths regardless of case.
+			folded, collisions := foldPkgMap(pkgMap)
+			if len(collisions) > 0 {
+				log.Warn().Int("count", len(collisions)).Msg("Manifest contains paths differing only by case")
+			}
+			pkgMap, err = resolveCaseInsensitive(_verifyCmd.InputDir, folded, _verifyCmd.SkipDirs)
+			if err != nil {
+				log.Panic().Err(err).Msg("Failed to walk input directory")
+			}
+		}
+
+		if _verifyCmd.CASRoot != "" {
+			// Blobs are found by hash in the store, the input directory is not read.
+			compared = verifyCAS(config.Workers, _verifyCmd.CASRoot, pkgMap, opts)
+		} else if _verifyCmd.StatOnly {
+			// Sizes only, taken from directory listings, no file is ever opened.
+			compared = verifyStatOnly(config.Workers, _verifyCmd.InputDir, pkgMap)
+		} else if _verifyCmd.ModifiedSince != nil {
+			// Only the files modified since the given time are hashed.
+			compared, err = verifyModifiedSince(config.Workers, _verifyCmd.InputDir, pkgMap, *_verifyCmd.ModifiedSince, _verifyCmd.SkipDirs, opts)
+			if err != nil {
+				log.Panic().Err(err).Msg("Failed to walk input directory")
+			}
+		} else {
+			// On SIGINT no new file is dispatched, the entries never reached are listed once the others are reported.
+			ctx, stop := interruptContext()
+			defer stop()
+			interrupted, pending = ctx, pkgMap
+			compared = trackResults(verifyContentContext(ctx, config.Workers, _verifyCmd.InputDir, pkgMap, opts), reached)
+		}
+		pkgMap = nil // don't need the map anymore
+	}
+
+	// The console only gets the first differences of each kind with --max-report, the JSON output gets every result.
+	var jsonFile *os.File
+	var jsonOut io.Writer
+	if _verifyCmd.JSONOut != "" {
+		var err error
+		jsonFile, err = os.Create(_verifyCmd.JSONOut)
+		if err != nil {
+			log.Panic().Err(err).Str("file", _verifyCmd.JSONOut).Msg("Failed to create the JSON output file")
+		}
+		defer jsonFile.Close()
+		jsonOut = jsonFile
+	}
+	buckets, err := reportResultsLimited(compared, int64(_verifyCmd.MaxReport), jsonOut)
+	if err == nil && jsonFile != nil {
+		err = jsonFile.Close()
+	}
+	if err != nil {
+		log.Panic().Err(err).Str("file", _verifyCmd.JSONOut).Msg("Failed to write the JSON output file")
+	}
+	if budget.Exhausted() {
+		log.Warn().
+			Int64("budget", int64(_verifyCmd.ReadBudget)).
+			Int64("read", budget.Claimed()).
+			Int64("unchecked", buckets.Count(CR_Unchecked)).
+			Msg("Read budget exhausted, the files left were not checked")
+	}
+	if interrupted != nil && interrupted.Err() != nil {
+		unreached := unreachedEntries(pending, reached)
+		for _, name := range unreached {
+			log.Debug().Str("file", name).Msg("Entry not reached")
+		}
+		log.Warn().Int("unreached", len(unreached)).Msg("Verify interrupted")
+		if _verifyCmd.ResumeFile != "" {
+			if err := writeNameList(_verifyCmd.ResumeFile, unreached); err != nil {
+				log.Panic().Err(err).Msg("Failed to write the resume file")
+			}
+			log.Info().Str("file", _verifyCmd.ResumeFile).Msg("Wrote the unreached entries, verify them with --only")
+		}
+	}
+	log.Info().
+		Func(func(e *zerolog.Event) {
+			for result := range crCount {
+				if count := buckets.Count(result); count > 0 {
+					e.Int64(result.String(), count)
 				}
 			}
-		}()
+		}).
+		Msg("Verify finished")
+	hashCounts := buckets.HashCounts()
+	for _, name := range slices.Sorted(maps.Keys(hashCounts)) {
+		log.Info().
+			Str("hash", name).
+			Int64("match", hashCounts[name].Match).
+			Int64("mismatch", hashCounts[name].Mismatch).
+			Msg("Hash results")
 	}
+	return buckets
+}
+
+// String returns the name of a CompareResult, as used in summaries.
+func (cr CompareResult) String() string {
+	switch cr {
+	case CR_Same:
+		return "same"
+	case CR_SizeDif:
+		return "size_differs"
+	case CR_SizeLarger:
+		return "size_larger"
+	case CR_SizeSmaller:
+		return "size_smaller"
+	case CR_Md5Dif:
+		return "md5_differs"
+	case CR_Xxh64Dif:
+		return "xxh64_differs"
+	case CR_NotExist:
+		return "not_exist"
+	case CR_IsDir:
+		return "is_dir"
+	case CR_Error:
+		return "error"
+	case CR_OwnerDif:
+		return "owner_differs"
+	case CR_FingerprintDif:
+		return "fingerprint_differs"
+	case CR_NotDir:
+		return "not_dir"
+	case CR_Trusted:
+		return "trusted"
+	case CR_Verified:
+		return "verified"
+	case CR_NotLink:
+		return "not_link"
+	case CR_LinkTargetDif:
+		return "link_target_differs"
+	case CR_HeaderDif:
+		return "header_differs"
+	case CR_Unmodified:
+		return "unmodified"
+	case CR_Unchecked:
+		return "unchecked"
+	case CR_HashDif:
+		return "hash_differs"
+	case CR_Exists:
+		return "exists"
+	default:
+		return fmt.Sprintf("unknown_%d", int(cr))
+	}
+}
+
+// IsMatch reports whether the result means the file matches the manifest.
+func (cr CompareResult) IsMatch() bool {
+	return cr == CR_Same || cr == CR_Trusted || cr == CR_Verified || cr == CR_Unmodified || cr == CR_Exists
+}
+
+// verifyContent compares every manifest entry against the file on disk using a pool of workers,
+// the returned channel is closed once every entry has been compared.
+func verifyContent(threads int, basedir string, pkgMap map[string]FileInfo, opts compareOptions) <-chan FileCompareResult {
+	workQueue := make(chan FileInfo, len(pkgMap)) // Work queue
 
 	// Send work to the queue (no goroutine per file)
 	for _, file := range pkgMap {
 		workQueue <- file
 	}
-	pkgMap = nil // don't need the map anymore
 	close(workQueue)
-	workWg.Wait() // Wait for the comparator goroutines to finish writing all the results.
-
-	for _, res := range results {
-		baseLog := log.With().
-			Str("file", res.FilePath).
-			Logger()
-
-		switch res.Result {
-		case CR_Same:
-			baseLog.Info().Msg("File is unchanged")
-		case CR_SizeDif:
-			baseLog.Info().Msg("File size differs")
-		case CR_Md5Dif:
-			baseLog.Info().Msg("MD5 hash differs")
-		case CR_Xxh64Dif:
-			baseLog.Info().Msg("XXH64 hash differs")
-		case CR_NotExist:
-			baseLog.Info().Msg("File does not exist")
-		case CR_IsDir:
-			baseLog.Warn().Msg("Path is a directory")
-		case CR_Error:
-			baseLog.Error().Msg("An error occurred while processing the file")
+
+	return compareFiles(threads, basedir, workQueue, opts)
+}
+
+// ErrNotInManifest is returned by VerifyOne for a path without a manifest entry.
+var ErrNotInManifest = errors.New("not in manifest")
+
+// VerifyOne compares the single file remoteName under root against its manifest entry, without any worker,
+// e.g. to re-check a file after repairing it. A path missing from the manifest returns CR_Error and ErrNotInManifest,
+// a file missing from disk returns CR_NotExist like any verify.
+func VerifyOne(manifest map[string]FileInfo, root, remoteName string) (CompareResult, error) {
+	remoteName = filepath.ToSlash(remoteName)
+	file, ok := manifest[remoteName]
+	if !ok {
+		return CR_Error, fmt.Errorf("%s: %w", remoteName, ErrNotInManifest)
+	}
+	return compareFile(root, file, compareOptions{})
+}
+
+// verifyModifiedSince walks basedir once, then compares by content only the manifest entries whose file was modified
+// at or after since. Older files of the recorded size are assumed unchanged and reported as CR_Unmodified, older
+// files of another size as CR_SizeLarger or CR_SizeSmaller, both without being opened. The returned channel is closed once every entry
+// has been reported.
+func verifyModifiedSince(threads int, basedir string, pkgMap map[string]FileInfo, since time.Time, skipDirs []string, opts compareOptions) (<-chan FileCompareResult, error) {
+	onDisk := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(basedir, skipDirWalk(basedir, skipDirs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(basedir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		onDisk[filepath.ToSlash(relPath)] = info
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []FileCompareResult
+	workQueue := make(chan FileInfo, len(pkgMap)) // Work queue, only the modified files
+	for _, file := range pkgMap {
+		info, ok := onDisk[file.FilePath]
+		switch {
+		case !ok || file.IsDir || file.IsSymlink || !info.ModTime().Before(since):
+			workQueue <- file // compareFile also reports the missing files
+		case info.Size() != file.Size:
+			skipped = append(skipped, FileCompareResult{FilePath: file.FilePath, Result: sizeResult(info.Size(), file.Size)})
 		default:
-			baseLog.Error().Msg("Unknown result type")
+			skipped = append(skipped, FileCompareResult{FilePath: file.FilePath, Result: CR_Unmodified})
+		}
+	}
+	close(workQueue)
+	log.Debug().Int("modified", len(workQueue)).Int("unmodified", len(skipped)).Msg("Filtered files by modification time")
+
+	compared := compareFiles(threads, basedir, workQueue, opts)
+	results := make(chan FileCompareResult, threads)
+	go func() {
+		defer close(results)
+		for _, res := range skipped {
+			results <- res
+		}
+		for res := range compared {
+			results <- res
+		}
+	}()
+	return results, nil
+}
+
+// compareOptions holds the options of a verify run that apply to every file compared, so manifest entries only carry
+// what the manifest records.
+type compareOptions struct {
+	NormalizeEOL bool        // Whether text files are hashed with CRLF collapsed to LF, as dumped with --normalize-eol
+	TextExts     []string    // Extensions of the text files, the default ones when empty
+	ReadBudget   *readBudget // Shared by every file of a verify with --read-budget, a file is only read if it allows, nil for no limit
+	MissingOnly  bool        // Whether only the existence of each entry is checked, set by --missing-only
+	XxhSeed      uint64      // Seed of the xxh64 hashes, the one the manifest was dumped with, 0 when unseeded
+}
+
+// normalizeEOL reports whether the file at filePath is hashed with normalized line endings.
+func (opts compareOptions) normalizeEOL(filePath string) bool {
+	return opts.NormalizeEOL && isTextFile(filePath, opts.TextExts)
+}
+
+// compareFiles runs compareFile over the files using a pool of workers,
+// the returned channel is closed once files is closed and every file has been compared.
+func compareFiles(threads int, basedir string, files <-chan FileInfo, opts compareOptions) <-chan FileCompareResult {
+	// Start a fixed number of worker goroutines, the pool closes its output once the work queue is drained.
+	return RunPool(files, threads, func(file FileInfo) (FileCompareResult, error) {
+		result, hashes, _ := compareFileHashes(basedir, file, opts)
+		return FileCompareResult{FilePath: file.FilePath, Result: result, Hashes: hashes}, nil
+	})
+}
+
+// reportResults logs every differing result as soon as it is received and accumulates the results into buckets per kind.
+// The returned buckets are complete once results is closed.
+func reportResults(results <-chan FileCompareResult) *CompareResultBuckets {
+	buckets, _ := reportResultsLimited(results, 0, nil)
+	return buckets
+}
+
+// compareResultRecord is a line of the --json-out file of verify.
+type compareResultRecord struct {
+	Path   string `json:"path"`
+	Result string `json:"result"`
+}
+
+// reportResultsLimited is reportResults logging only the first maxReport results of each kind that doesn't match,
+// then how many more there were, unless maxReport is 0. Every result, matching or not, is also written to jsonOut
+// when it isn't nil, so the full set is kept however many are logged. The error is that of writing jsonOut.
+func reportResultsLimited(results <-chan FileCompareResult, maxReport int64, jsonOut io.Writer) (*CompareResultBuckets, error) {
+	var enc *json.Encoder
+	var w *bufio.Writer
+	if jsonOut != nil {
+		w = bufio.NewWriter(jsonOut)
+		enc = json.NewEncoder(w)
+	}
+	buckets := NewCompareResultBuckets(compareResultSampleLimit)
+	for res := range results {
+		buckets.Add(res)
+		if !res.Result.IsMatch() && (maxReport == 0 || buckets.Count(res.Result) <= maxReport) {
+			logCompareResult(res)
+		}
+		if enc != nil {
+			enc.Encode(compareResultRecord{Path: res.FilePath, Result: res.Result.String()}) // A failed write is kept by w
 		}
 	}
+	if maxReport > 0 {
+		for cr := range crCount {
+			if more := buckets.Count(cr) - maxReport; !cr.IsMatch() && more > 0 {
+				log.Info().Str("result", cr.String()).Int64("more", more).Msgf("... and %d more", more)
+			}
+		}
+	}
+	if w != nil {
+		return buckets, w.Flush()
+	}
+	return buckets, nil
+}
+
+// logCompareResult logs a single compare result.
+func logCompareResult(res FileCompareResult) {
+	baseLog := log.With().
+		Str("file", res.FilePath).
+		Logger()
+
+	switch res.Result {
+	case CR_Same:
+		baseLog.Info().Msg("File is unchanged")
+	case CR_SizeLarger:
+		baseLog.Warn().Msg("File is larger than recorded")
+	case CR_SizeSmaller:
+		baseLog.Info().Msg("File is smaller than recorded")
+	case CR_Md5Dif:
+		baseLog.Info().Msg("MD5 hash differs")
+	case CR_Xxh64Dif:
+		baseLog.Info().Msg("XXH64 hash differs")
+	case CR_NotExist:
+		baseLog.Info().Msg("File does not exist")
+	case CR_IsDir:
+		baseLog.Warn().Msg("Path is a directory")
+	case CR_Error:
+		baseLog.Error().Msg("An error occurred while processing the file")
+	case CR_OwnerDif:
+		baseLog.Info().Msg("File owner differs")
+	case CR_FingerprintDif:
+		baseLog.Info().Msg("File fingerprint differs")
+	case CR_NotDir:
+		baseLog.Info().Msg("Path is not a directory")
+	case CR_Trusted:
+		baseLog.Info().Msg("File size and mtime match")
+	case CR_Verified:
+		baseLog.Info().Msg("File was touched but is unchanged")
+	case CR_NotLink:
+		baseLog.Info().Msg("Path is not a symbolic link")
+	case CR_LinkTargetDif:
+		baseLog.Info().Msg("Symbolic link target differs")
+	case CR_HeaderDif:
+		baseLog.Info().Msg("File header differs")
+	case CR_Unmodified:
+		baseLog.Info().Msg("File was not modified since the given time")
+	case CR_Unchecked:
+		baseLog.Debug().Msg("File was not checked, the read budget is exhausted")
+	case CR_HashDif:
+		baseLog.Info().Msg("Hash differs")
+	case CR_Exists:
+		baseLog.Info().Msg("File exists")
+	default:
+		baseLog.Error().Msg("Unknown result type")
+	}
 }
 
 func readPkgFile(pkgFilePath string, outMap map[string]FileInfoOutput) error {
+	return eachPkgLine(pkgFilePath, func(fileInfoOutput FileInfoOutput) error {
+		// Store in map using remoteName as key
+		outMap[fileInfoOutput.FilePath] = fileInfoOutput
+		return nil
+	})
+}
+
+// eachPkgLine parses a pkg file line by line, calling fn with every entry in order.
+func eachPkgLine(pkgFilePath string, fn func(FileInfoOutput) error) error {
 	file, err := os.Open(pkgFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to open pkg file %s: %w", pkgFilePath, err)
 	}
 	defer file.Close()
 
+	return eachPkgLineReader(file, pkgFilePath, fn)
+}
+
+// eachPkgLineReader parses pkg file content line by line from r, calling fn with every entry in order.
+// pkgFilePath only names the source in errors.
+func eachPkgLineReader(r io.Reader, pkgFilePath string, fn func(FileInfoOutput) error) error {
 	// Read and parse the file line by line
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 
 		// Parse JSON into FileInfoOutput
-		var fileInfoOutput FileInfoOutput
-		err := json.Unmarshal([]byte(line), &fileInfoOutput)
+		fileInfoOutput, err := parsePkgLine(line, pkgFilePath)
 		if err != nil {
-			return fmt.Errorf("failed to unmarshal line in pkg file %s: %w", pkgFilePath, err)
+			return err
 		}
 
-		// Store in map using remoteName as key
-		outMap[fileInfoOutput.FilePath] = fileInfoOutput
+		if err := fn(fileInfoOutput); err != nil {
+			return err
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -153,6 +697,31 @@ func readPkgFile(pkgFilePath string, outMap map[string]FileInfoOutput) error {
 	return nil
 }
 
+// parsePkgLine parses a single line of a pkg file.
+func parsePkgLine(line string, pkgFilePath string) (FileInfoOutput, error) {
+	var fileInfoOutput FileInfoOutput
+	err := json.Unmarshal([]byte(line), &fileInfoOutput)
+	if err != nil {
+		return FileInfoOutput{}, fmt.Errorf("failed to unmarshal line in pkg file %s: %w", pkgFilePath, err)
+	}
+	// Each line may carry the version of its format, a line without one is of version 1.
+	switch fileInfoOutput.Version {
+	case 0, 1:
+	default:
+		return FileInfoOutput{}, fmt.Errorf("entry %s in pkg file %s has unsupported format version %d, the newest known is %d",
+			fileInfoOutput.FilePath, pkgFilePath, fileInfoOutput.Version, manifestVersion)
+	}
+	// Directory entries have no content, a size or hash on one means the manifest is corrupt.
+	if fileInfoOutput.IsDir && (fileInfoOutput.Size != 0 || fileInfoOutput.Md5Hash != "" || fileInfoOutput.Xxh64Hash != "") {
+		return FileInfoOutput{}, fmt.Errorf("directory entry %s in pkg file %s has a size or hash", fileInfoOutput.FilePath, pkgFilePath)
+	}
+	// A symbolic link always has a target, and can't be a directory entry at the same time.
+	if fileInfoOutput.IsSymlink && (fileInfoOutput.LinkTarget == "" || fileInfoOutput.IsDir) {
+		return FileInfoOutput{}, fmt.Errorf("symbolic link entry %s in pkg file %s has no target or is a directory", fileInfoOutput.FilePath, pkgFilePath)
+	}
+	return fileInfoOutput, nil
+}
+
 // decodeHex converts a hex-encoded string into a byte slice
 func decodeHex(hexStr string) []byte {
 	decoded, err := hex.DecodeString(hexStr)
@@ -162,68 +731,121 @@ func decodeHex(hexStr string) []byte {
 	return decoded
 }
 
-func scanInputDirForPkg(inputDir string, outMap map[string]FileInfoOutput) error {
+// isPkgFileName reports whether a file name looks like a pkg file, i.e. contains "pkg".
+func isPkgFileName(name string) bool {
+	return strings.Contains(name, "pkg")
+}
+
+// pkgFilesInDir lists the pkg files at the top level of inputDir.
+func pkgFilesInDir(inputDir string) ([]string, error) {
 	entries, err := os.ReadDir(inputDir)
 	if err != nil {
-		return fmt.Errorf("failed to read input directory %s: %w", inputDir, err)
+		return nil, fmt.Errorf("failed to read input directory %s: %w", inputDir, err)
 	}
 
+	var pkgFiles []string
 	for _, entry := range entries {
 		// Check for file names containing "pkg"
-		if !entry.IsDir() && strings.Contains(entry.Name(), "pkg") {
-			fullPath := filepath.Join(inputDir, entry.Name())
-
-			// Read and process the pkg file
-			err := readPkgFile(fullPath, outMap)
-			if err != nil {
-				return fmt.Errorf("error processing pkg file %s: %w", fullPath, err)
-			}
+		if !entry.IsDir() && isPkgFileName(entry.Name()) {
+			pkgFiles = append(pkgFiles, filepath.Join(inputDir, entry.Name()))
 		}
 	}
-
-	return nil
+	return pkgFiles, nil
 }
 
+// readPkgFiles reads the pkg files of inputDir when checkInputDirForPkg is set, then every pkg file given, later
+// entries overriding earlier ones. Every file is attempted: the ones that fail to open or parse are left out whole,
+// and their errors are returned joined along with the entries of the others. The map is only nil when no file
+// could be read at all; reading no file because none was given is not an error.
 func readPkgFiles(inputDir string, pkgFiles []string, checkInputDirForPkg bool) (map[string]FileInfoOutput, error) {
-	// Initialize the map for storing FileInfo objects
-	pkgMap := make(map[string]FileInfoOutput)
-
+	var errs []error
 	if checkInputDirForPkg {
-		err := scanInputDirForPkg(inputDir, pkgMap)
+		inputDirPkgFiles, err := pkgFilesInDir(inputDir)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan input directory for pkg files: %w", err)
+			errs = append(errs, fmt.Errorf("failed to scan input directory for pkg files: %w", err))
 		}
+		pkgFiles = append(inputDirPkgFiles, pkgFiles...)
 	}
 
-	// Process additional package files provided as flags
+	// Initialize the map for storing FileInfo objects
+	pkgMap := make(map[string]FileInfoOutput)
+	loaded := 0
 	for _, pkgFile := range pkgFiles {
-		err := readPkgFile(pkgFile, pkgMap)
-		if err != nil {
-			return nil, fmt.Errorf("error processing pkg file %s: %w", pkgFile, err)
+		// Each file is read on its own first, so a parse error half-way doesn't leave some of its entries behind.
+		fileMap := make(map[string]FileInfoOutput)
+		if err := readPkgFile(pkgFile, fileMap); err != nil {
+			errs = append(errs, fmt.Errorf("error processing pkg file %s: %w", pkgFile, err))
+			continue
 		}
+		maps.Copy(pkgMap, fileMap)
+		loaded++
 	}
 
-	return pkgMap, nil
+	err := errors.Join(errs...)
+	if err != nil && loaded == 0 {
+		return nil, err
+	}
+	return pkgMap, err
+}
+
+// ClassifyStatError returns the result of a file that could not be opened or stat'ed because of err: CR_NotExist when
+// it doesn't exist, CR_IsDir when it is a directory where a file was expected, and CR_Error for any other error,
+// permission errors included. It relies on errors.Is, so it works alike on every platform and with wrapped errors.
+// A nil err returns CR_Same.
+func ClassifyStatError(err error) CompareResult {
+	switch {
+	case err == nil:
+		return CR_Same
+	case errors.Is(err, fs.ErrNotExist):
+		return CR_NotExist
+	case errors.Is(err, syscall.EISDIR):
+		return CR_IsDir
+	default:
+		return CR_Error
+	}
 }
 
 // compareFile reads the file and computes the MD5 and XXH64 hashes and file size.
-func compareFile(basedir string, file FileInfo) (CompareResult, error) {
+// How a file differs is only logged at trace level, its result is logged once by the reporter, within --max-report.
+func compareFile(basedir string, file FileInfo, opts compareOptions) (CompareResult, error) {
+	result, _, err := compareFileHashes(basedir, file, opts)
+	return result, err
+}
+
+// compareFileHashes is compareFile also returning whether each hash compared matched, by name. Every recorded hash is
+// compared even once one differs, so the summary tells which algorithms disagree, but the result is still that of the
+// first differing one: md5, then xxh64, then the additional hashes.
+func compareFileHashes(basedir string, file FileInfo, opts compareOptions) (CompareResult, map[string]bool, error) {
 	filePathAbs := filepath.Join(basedir, file.FilePath)
 	baseLog := log.With().Str("file", filePathAbs).Logger()
 	baseLog.Trace().Msg("Start compare")
+	if opts.MissingOnly {
+		result, err := compareExists(filePathAbs)
+		return result, nil, err
+	}
+	if file.IsDir {
+		result, err := compareDir(filePathAbs)
+		return result, nil, err
+	}
+	if file.IsSymlink {
+		result, err := compareSymlink(filePathAbs, file.LinkTarget)
+		return result, nil, err
+	}
+	if opts.ReadBudget.Exhausted() {
+		return CR_Unchecked, nil, nil // Not even opened once the budget ran out
+	}
 	f, err := os.Open(filePathAbs) // Open the file for reading.
 	if err != nil {                // If there's an error opening the file
-		errno := err.(*os.PathError).Err.(syscall.Errno)
-		switch errno {
-		case syscall.ERROR_FILE_NOT_FOUND:
-			baseLog.Info().Msg("File does not exist")
-			return CR_NotExist, nil
-		case syscall.EISDIR:
-			baseLog.Warn().Msg("Path is a directory")
-			return CR_IsDir, nil
+		switch result := ClassifyStatError(err); result {
+		case CR_NotExist:
+			baseLog.Trace().Msg("File does not exist")
+			return result, nil, nil
+		case CR_IsDir:
+			baseLog.Trace().Msg("Path is a directory")
+			return result, nil, nil
 		default:
 			baseLog.Warn().Err(err).Msg("Unknown error")
-			return CR_Error, err
+			return result, nil, err
 		}
 	}
 	defer f.Close() // Ensure the file is closed when this function returns.
@@ -231,42 +853,348 @@ func compareFile(basedir string, file FileInfo) (CompareResult, error) {
 	stat, err := f.Stat()
 	if err != nil {
 		baseLog.Warn().Err(err).Msg("Failed to retrieve file metadata")
-		return CR_Error, err
+		return CR_Error, nil, err
 	}
 	if stat.IsDir() {
-		baseLog.Warn().Err(err).Msg("Path is a directory")
-		return CR_IsDir, err
+		baseLog.Trace().Msg("Path is a directory")
+		return CR_IsDir, nil, err
 	}
 	actualSize := stat.Size()
+	hashNames := slices.Sorted(maps.Keys(file.Hashes)) // Additional hashes selected with --compare-hash, nil when none
+	var normalized *FileInfo
+	if opts.normalizeEOL(file.FilePath) {
+		if !opts.ReadBudget.Claim(actualSize) {
+			return CR_Unchecked, nil, nil
+		}
+		// The recorded size and hashes are those of the content with CRLF collapsed to LF, only known once read.
+		info, err := hashReaderSeed(&eolNormalizer{r: f}, hashNames, opts.XxhSeed)
+		if err != nil {
+			baseLog.Warn().Err(err).Msg("Error processing file hashes")
+			return CR_Error, nil, err
+		}
+		normalized = &info
+		actualSize = info.Size
+	}
 	if actualSize != file.Size {
-		baseLog.Info().
+		baseLog.Trace().
 			Int64("expected_size", file.Size).
 			Int64("actual_size", actualSize).
 			Msg("File size mismatch")
-		return CR_SizeDif, nil
+		return sizeResult(actualSize, file.Size), nil, nil
 	}
 
-	// Compute hashes and size using processFileReader.
-	md5Hash, xxh64Hash, _, err := processFileReader(f)
-	if err != nil {
-		baseLog.Warn().Err(err).Msg("Error processing file hashes")
-		return CR_Error, err // If there's an error during processing
+	// Smart mode: an untouched file of the right size is trusted without reading it.
+	if normalized == nil && !file.ModTime.IsZero() && stat.ModTime().Equal(file.ModTime) {
+		baseLog.Trace().Msg("File size and mtime match")
+		return CR_Trusted, nil, nil
 	}
-	if !bytes.Equal(md5Hash, file.Md5Hash) {
-		baseLog.Info().
-			Str("expected_md5", hex.EncodeToString(file.Md5Hash)).
-			Str("actual_md5", hex.EncodeToString(md5Hash)).
-			Msg("MD5 hash mismatch")
-		return CR_Md5Dif, nil
+
+	// Only the hashes recorded in the entry are compared, an absent one was never computed and can't differ.
+	hasMd5, hasXxh64, hasExtra := len(file.Md5Hash) > 0, len(file.Xxh64Hash) > 0, len(hashNames) > 0
+	var hashes map[string]bool
+	if normalized == nil {
+		// The file is only read if the budget allows all it reads: the fingerprint first, then the whole file or its header.
+		readSize := actualSize
+		switch {
+		case file.HeaderHash != nil:
+			readSize = min(actualSize, file.HeaderBytes)
+		case !hasMd5 && !hasXxh64 && !hasExtra:
+			readSize = 0
+		}
+		if file.Fingerprint != nil {
+			readSize += min(actualSize, 2*fingerprintChunkSize)
+		}
+		if !opts.ReadBudget.Claim(readSize) {
+			return CR_Unchecked, nil, nil
+		}
 	}
-	if !bytes.Equal(xxh64Hash, file.Xxh64Hash) {
-		baseLog.Info().
-			Str("expected_xxh64", hex.EncodeToString(file.Xxh64Hash)).
-			Str("actual_xxh64", hex.EncodeToString(xxh64Hash)).
-			Msg("XXH64 hash mismatch")
-		return CR_Xxh64Dif, nil
+
+	// Cheap first pass: a differing fingerprint proves a change without reading the whole file.
+	// A matching one doesn't prove anything, so the full hash still runs to confirm it.
+	if normalized == nil && file.Fingerprint != nil {
+		fingerprint, err := computeFingerprint(f, actualSize)
+		if err != nil {
+			baseLog.Warn().Err(err).Msg("Error computing file fingerprint")
+			return CR_Error, nil, err
+		}
+		if !bytes.Equal(fingerprint, file.Fingerprint) {
+			baseLog.Trace().
+				Str("expected_fingerprint", hex.EncodeToString(file.Fingerprint)).
+				Str("actual_fingerprint", hex.EncodeToString(fingerprint)).
+				Msg("Fingerprint mismatch")
+			return CR_FingerprintDif, nil, nil
+		}
 	}
 
+	if file.HeaderHash != nil {
+		// Header only mode: the first bytes decide, the rest of the file is never read.
+		headerHash, err := computeHeaderHash(f, file.HeaderBytes)
+		if err != nil {
+			baseLog.Warn().Err(err).Msg("Error computing header hash")
+			return CR_Error, nil, err
+		}
+		if !bytes.Equal(headerHash, file.HeaderHash) {
+			baseLog.Trace().
+				Str("expected_header", hex.EncodeToString(file.HeaderHash)).
+				Str("actual_header", hex.EncodeToString(headerHash)).
+				Msg("Header hash mismatch")
+			return CR_HeaderDif, nil, nil
+		}
+		hasMd5, hasXxh64, hasExtra = false, false, false // Not compared
+	} else if !hasMd5 && !hasXxh64 && !hasExtra {
+		baseLog.Warn().Msg("No hash recorded, compared size only")
+	} else {
+		// Compute hashes and size using hashReader, unless they were already computed normalized.
+		var md5Hash, xxh64Hash []byte
+		var extraHashes map[string][]byte
+		if normalized != nil {
+			md5Hash, xxh64Hash, extraHashes = normalized.Md5Hash, normalized.Xxh64Hash, normalized.Hashes
+		} else {
+			info, err := hashReaderSeed(f, hashNames, opts.XxhSeed)
+			if err != nil {
+				baseLog.Warn().Err(err).Msg("Error processing file hashes")
+				return CR_Error, nil, err // If there's an error during processing
+			}
+			md5Hash, xxh64Hash, extraHashes = info.Md5Hash, info.Xxh64Hash, info.Hashes
+		}
+		// Every hash is compared before returning, the first mismatch deciding the result.
+		hashes = make(map[string]bool, 2+len(hashNames))
+		mismatch := CR_Same
+		if hasMd5 {
+			if hashes[hasherMD5] = bytes.Equal(md5Hash, file.Md5Hash); !hashes[hasherMD5] {
+				baseLog.Trace().
+					Str("expected_md5", hex.EncodeToString(file.Md5Hash)).
+					Str("actual_md5", hex.EncodeToString(md5Hash)).
+					Msg("MD5 hash mismatch")
+				mismatch = CR_Md5Dif
+			}
+		}
+		if hasXxh64 {
+			if hashes[hasherXXH64] = bytes.Equal(xxh64Hash, file.Xxh64Hash); !hashes[hasherXXH64] {
+				baseLog.Trace().
+					Str("expected_xxh64", hex.EncodeToString(file.Xxh64Hash)).
+					Str("actual_xxh64", hex.EncodeToString(xxh64Hash)).
+					Msg("XXH64 hash mismatch")
+				if mismatch == CR_Same {
+					mismatch = CR_Xxh64Dif
+				}
+			}
+		}
+		for _, name := range hashNames {
+			if hashes[name] = bytes.Equal(extraHashes[name], file.Hashes[name]); !hashes[name] {
+				baseLog.Trace().
+					Str("hash", name).
+					Str("expected", hex.EncodeToString(file.Hashes[name])).
+					Str("actual", hex.EncodeToString(extraHashes[name])).
+					Msg("Hash mismatch")
+				if mismatch == CR_Same {
+					mismatch = CR_HashDif
+				}
+			}
+		}
+		if mismatch != CR_Same {
+			return mismatch, hashes, nil
+		}
+	}
+
+	if file.Uid != nil || file.Gid != nil {
+		uid, gid, ok := ownerFromFileInfo(stat)
+		if !ok {
+			baseLog.Warn().Msg("File owner is not available on this platform")
+		} else if (file.Uid != nil && *file.Uid != uid) || (file.Gid != nil && *file.Gid != gid) {
+			baseLog.Trace().
+				Any("expected_uid", file.Uid).
+				Any("expected_gid", file.Gid).
+				Uint32("actual_uid", uid).
+				Uint32("actual_gid", gid).
+				Msg("File owner mismatch")
+			return CR_OwnerDif, hashes, nil
+		}
+	}
+
+	if !file.ModTime.IsZero() && (hasMd5 || hasXxh64 || hasExtra) {
+		baseLog.Trace().Msg("File was touched but is unchanged")
+		return CR_Verified, hashes, nil
+	}
 	baseLog.Trace().Msg("File is unchanged")
+	return CR_Same, hashes, nil
+}
+
+// compareExists only checks that something exists at the path of a manifest entry, for --missing-only.
+// Links are not followed, so a dangling link still exists.
+func compareExists(pathAbs string) (CompareResult, error) {
+	_, err := os.Lstat(pathAbs)
+	switch result := ClassifyStatError(err); result {
+	case CR_Same:
+		return CR_Exists, nil
+	case CR_NotExist:
+		log.Trace().Str("file", pathAbs).Msg("File does not exist")
+		return result, nil
+	default:
+		log.Warn().Err(err).Str("file", pathAbs).Msg("Unknown error")
+		return result, err
+	}
+}
+
+// compareDir checks that a directory entry of the manifest exists as a directory.
+func compareDir(dirPathAbs string) (CompareResult, error) {
+	baseLog := log.With().Str("dir", dirPathAbs).Logger()
+	stat, err := os.Stat(dirPathAbs)
+	switch {
+	case ClassifyStatError(err) == CR_NotExist:
+		baseLog.Trace().Msg("Directory does not exist")
+		return CR_NotExist, nil
+	case err != nil:
+		baseLog.Warn().Err(err).Msg("Failed to retrieve directory metadata")
+		return CR_Error, err
+	case !stat.IsDir():
+		baseLog.Trace().Msg("Path is not a directory")
+		return CR_NotDir, nil
+	}
+	baseLog.Trace().Msg("Directory exists")
 	return CR_Same, nil
 }
+
+// compareSymlink checks that a path is a symbolic link to the recorded target, without following it.
+func compareSymlink(linkPathAbs string, target string) (CompareResult, error) {
+	baseLog := log.With().Str("file", linkPathAbs).Logger()
+	stat, err := os.Lstat(linkPathAbs)
+	switch {
+	case ClassifyStatError(err) == CR_NotExist:
+		baseLog.Trace().Msg("Symbolic link does not exist")
+		return CR_NotExist, nil
+	case err != nil:
+		baseLog.Warn().Err(err).Msg("Failed to retrieve symbolic link metadata")
+		return CR_Error, err
+	case stat.Mode()&fs.ModeSymlink == 0:
+		baseLog.Trace().Msg("Path is not a symbolic link")
+		return CR_NotLink, nil
+	}
+	actualTarget, err := os.Readlink(linkPathAbs)
+	if err != nil {
+		baseLog.Warn().Err(err).Msg("Failed to read symbolic link")
+		return CR_Error, err
+	}
+	if actualTarget != target {
+		baseLog.Trace().
+			Str("expected_target", target).
+			Str("actual_target", actualTarget).
+			Msg("Symbolic link target mismatch")
+		return CR_LinkTargetDif, nil
+	}
+	baseLog.Trace().Msg("Symbolic link is unchanged")
+	return CR_Same, nil
+}
+
+// verifyStatOnly compares only file sizes using one directory listing per directory,
+// so files are never opened. The returned channel is closed once every directory has been listed.
+func verifyStatOnly(threads int, basedir string, pkgMap map[string]FileInfo) <-chan FileCompareResult {
+	// Group the manifest entries by their parent directory so each directory is listed once.
+	dirMap := make(map[string][]FileInfo)
+	for _, file := range pkgMap {
+		dir := path.Dir(file.FilePath)
+		dirMap[dir] = append(dirMap[dir], file)
+	}
+
+	workQueue := make(chan []FileInfo, len(dirMap)) // Work queue of directories
+
+	// Send work to the queue (no goroutine per directory)
+	for _, files := range dirMap {
+		workQueue <- files
+	}
+	close(workQueue)
+
+	return compareDirGroups(threads, basedir, workQueue)
+}
+
+// compareDirGroups runs compareDirListing over groups of manifest entries sharing a parent directory,
+// using a pool of workers. The returned channel is closed once every group has been listed.
+func compareDirGroups(threads int, basedir string, groups <-chan []FileInfo) <-chan FileCompareResult {
+	// Start a fixed number of worker goroutines, the pool closes its output once the groups are drained.
+	compared := RunPool(groups, threads, func(files []FileInfo) ([]FileCompareResult, error) {
+		return compareDirListing(basedir, path.Dir(files[0].FilePath), files), nil
+	})
+
+	// Flatten the per-directory results into a single stream.
+	results := make(chan FileCompareResult, threads)
+	go func() {
+		defer close(results)
+		for dirResults := range compared {
+			for _, res := range dirResults {
+				results <- res
+			}
+		}
+	}()
+	return results
+}
+
+// compareDirListing lists a single directory and compares the sizes reported by the listing
+// against the manifest entries located in that directory.
+func compareDirListing(basedir string, dir string, files []FileInfo) []FileCompareResult {
+	var results []FileCompareResult
+	dirPathAbs := filepath.Join(basedir, dir)
+	baseLog := log.With().Str("dir", dirPathAbs).Logger()
+
+	entries, err := os.ReadDir(dirPathAbs)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		baseLog.Warn().Err(err).Msg("Failed to list directory")
+		for _, file := range files {
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: CR_Error})
+		}
+		return results
+	}
+	// A missing directory simply leaves entries empty, so every file in it is reported as not existing.
+	entryMap := make(map[string]os.DirEntry, len(entries))
+	for _, entry := range entries {
+		entryMap[entry.Name()] = entry
+	}
+
+	for _, file := range files {
+		fileLog := baseLog.With().Str("file", file.FilePath).Logger()
+		entry, ok := entryMap[path.Base(file.FilePath)]
+		if !ok {
+			fileLog.Trace().Msg("File does not exist")
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: CR_NotExist})
+			continue
+		}
+		if file.IsDir {
+			// Directory entries only need to exist as directories, their listing size means nothing.
+			result := CR_Same
+			if !entry.IsDir() {
+				fileLog.Trace().Msg("Path is not a directory")
+				result = CR_NotDir
+			}
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: result})
+			continue
+		}
+		if file.IsSymlink {
+			// Reading a link target doesn't open any file, so links are fully checked even here.
+			result, _ := compareSymlink(filepath.Join(basedir, file.FilePath), file.LinkTarget)
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: result})
+			continue
+		}
+		if entry.IsDir() {
+			fileLog.Trace().Msg("Path is a directory")
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: CR_IsDir})
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			fileLog.Warn().Err(err).Msg("Failed to retrieve file metadata")
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: CR_Error})
+			continue
+		}
+		if info.Size() != file.Size {
+			fileLog.Trace().
+				Int64("expected_size", file.Size).
+				Int64("actual_size", info.Size()).
+				Msg("File size mismatch")
+			results = append(results, FileCompareResult{FilePath: file.FilePath, Result: sizeResult(info.Size(), file.Size)})
+			continue
+		}
+		fileLog.Trace().Msg("File is unchanged")
+		results = append(results, FileCompareResult{FilePath: file.FilePath, Result: CR_Same})
+	}
+
+	return results
+}
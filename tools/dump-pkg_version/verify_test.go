@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// writeTree creates the given files (relative path -> content) under root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create parent of %s: %v", name, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyStatOnly(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt":     "abc",
+		"sub/b.txt": "hello",
+	})
+
+	pkgMap := map[string]FileInfo{
+		"a.txt":         {FilePath: "a.txt", Size: 3},
+		"sub/b.txt":     {FilePath: "sub/b.txt", Size: 4},     // Size differs on disk
+		"sub/c.txt":     {FilePath: "sub/c.txt", Size: 1},     // Missing from disk
+		"missing/d.txt": {FilePath: "missing/d.txt", Size: 1}, // Whole directory missing
+		"sub":           {FilePath: "sub", Size: 0},           // Is a directory
+	}
+
+	got := make(map[string]CompareResult)
+	for res := range verifyStatOnly(2, root, pkgMap) {
+		got[res.FilePath] = res.Result
+	}
+
+	expected := map[string]CompareResult{
+		"a.txt":         CR_Same,
+		"sub/b.txt":     CR_SizeLarger,
+		"sub/c.txt":     CR_NotExist,
+		"missing/d.txt": CR_NotExist,
+		"sub":           CR_IsDir,
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d results, got %d: %v", len(expected), len(got), got)
+	}
+	for file, want := range expected {
+		if got[file] != want {
+			t.Errorf("%s: got result %d, want %d", file, got[file], want)
+		}
+	}
+}
+
+func TestVerifyMissingOnly(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt":     "abc",
+		"sub/b.txt": "hello",
+	})
+
+	// Every entry on disk is recorded with the wrong size and hashes, which only show if the file is read or sized.
+	budget := newReadBudget(1)
+	pkgMap := make(map[string]FileInfo)
+	for name, info := range map[string]FileInfo{
+		"a.txt":         {Size: 1, Md5Hash: []byte{1}, Xxh64Hash: []byte{2}},
+		"sub/b.txt":     {Size: 2, Md5Hash: []byte{3}, Xxh64Hash: []byte{4}, Fingerprint: []byte{5}},
+		"sub":           {IsDir: true},
+		"sub/c.txt":     {Size: 1, Md5Hash: []byte{6}}, // Missing from disk
+		"missing/d.txt": {Size: 1, Md5Hash: []byte{7}}, // Whole directory missing
+	} {
+		info.FilePath = name
+		pkgMap[name] = info
+	}
+
+	buckets := reportResults(verifyContent(2, root, pkgMap, compareOptions{ReadBudget: budget, MissingOnly: true}))
+	expected := map[CompareResult]int{CR_Exists: 3, CR_NotExist: 2}
+	if counts := buckets.Counts(); !maps.Equal(counts, expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+	missing := buckets.Samples(CR_NotExist)
+	slices.Sort(missing)
+	if !slices.Equal(missing, []string{"missing/d.txt", "sub/c.txt"}) {
+		t.Errorf("Expected only the missing entries to be reported, got %v", missing)
+	}
+	if claimed := budget.Claimed(); claimed != 0 {
+		t.Errorf("Expected no file to be read, %d bytes were", claimed)
+	}
+}
+
+func TestCompareFileSizeDirection(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"grew.txt": "injected content", "shrank.txt": "cut"})
+	pkgMap := map[string]FileInfo{
+		"grew.txt":   {FilePath: "grew.txt", Size: 8},
+		"shrank.txt": {FilePath: "shrank.txt", Size: 8},
+	}
+	expected := map[string]CompareResult{"grew.txt": CR_SizeLarger, "shrank.txt": CR_SizeSmaller}
+
+	for name, file := range pkgMap {
+		if got, _ := compareFile(root, file, compareOptions{}); got != expected[name] {
+			t.Errorf("%s: expected %s, got %s", name, expected[name], got)
+		}
+	}
+	for res := range verifyStatOnly(2, root, pkgMap) {
+		if res.Result != expected[res.FilePath] {
+			t.Errorf("%s with --stat-only: expected %s, got %s", res.FilePath, expected[res.FilePath], res.Result)
+		}
+	}
+}
+
+func TestClassifyStatError(t *testing.T) {
+	dir := t.TempDir()
+	_, notExist := os.Open(filepath.Join(dir, "missing"))
+	type test struct {
+		name     string
+		err      error
+		expected CompareResult
+	}
+	tests := []test{
+		{"nil", nil, CR_Same},
+		{"not exist", notExist, CR_NotExist},
+		{"wrapped not exist", fmt.Errorf("opening: %w", notExist), CR_NotExist},
+		{"is dir", &fs.PathError{Op: "read", Path: dir, Err: syscall.EISDIR}, CR_IsDir},
+		{"permission", &fs.PathError{Op: "open", Path: dir, Err: fs.ErrPermission}, CR_Error},
+		{"other", errors.New("disk on fire"), CR_Error},
+	}
+	if runtime.GOOS != "windows" {
+		// Reading a directory as a file fails with EISDIR on Unix.
+		_, isDir := os.ReadFile(dir)
+		tests = append(tests, test{"read dir", isDir, CR_IsDir})
+	}
+	for _, test := range tests {
+		if result := ClassifyStatError(test.err); result != test.expected {
+			t.Errorf("%s (%v): expected %s, got %s", test.name, test.err, test.expected, result)
+		}
+	}
+}
+
+func TestVerifyContentStreamsResults(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"same.txt":    "same",
+		"changed.txt": "changed",
+	})
+
+	pkgMap := make(map[string]FileInfo)
+	for _, name := range []string{"same.txt", "changed.txt"} {
+		file, err := processFile(root, filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("Failed to process %s: %v", name, err)
+		}
+		pkgMap[name] = file
+	}
+	// Same size, different content
+	writeTree(t, root, map[string]string{"changed.txt": "CHANGED"})
+	pkgMap["missing.txt"] = FileInfo{FilePath: "missing.txt", Size: 1}
+
+	counts := reportResults(verifyContent(2, root, pkgMap, compareOptions{})).Counts()
+	expected := map[CompareResult]int{
+		CR_Same:     1,
+		CR_Md5Dif:   1,
+		CR_NotExist: 1,
+	}
+	if len(counts) != len(expected) {
+		t.Errorf("Expected counts %v, got %v", expected, counts)
+	}
+	for result, count := range expected {
+		if counts[result] != count {
+			t.Errorf("%s: got %d, want %d", result, counts[result], count)
+		}
+	}
+}
+
+func TestVerifyHashCounts(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "alpha", "b.txt": "bravo", "c.txt": "charlie"})
+
+	pkgMap := make(map[string]FileInfo)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file, err := processFile(root, filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("Failed to process %s: %v", name, err)
+		}
+		pkgMap[name] = file
+	}
+	// A wrongly recorded md5, the content and its xxh64 being unchanged.
+	file := pkgMap["b.txt"]
+	file.Md5Hash = make([]byte, len(file.Md5Hash))
+	pkgMap["b.txt"] = file
+
+	buckets := reportResults(verifyContent(2, root, pkgMap, compareOptions{}))
+	if counts := buckets.Counts(); counts[CR_Same] != 2 || counts[CR_Md5Dif] != 1 {
+		t.Errorf("Expected 2 same and 1 md5 differing file, got %v", counts)
+	}
+	expected := map[string]HashCount{
+		hasherMD5:   {Match: 2, Mismatch: 1},
+		hasherXXH64: {Match: 3},
+	}
+	if hashCounts := buckets.HashCounts(); !maps.Equal(hashCounts, expected) {
+		t.Errorf("Expected hash counts %v, got %v", expected, hashCounts)
+	}
+}
+
+func TestSubcommandVerifyBuckets(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"same": "same", "changed": "before", "gone": "gone"})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+	writeTree(t, inputDir, map[string]string{"changed": "after!"})
+	if err := os.Remove(filepath.Join(inputDir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buckets *CompareResultBuckets
+	runWithTimeout(t, 10*time.Second, func() {
+		buckets = subcommandVerify(Config{Workers: 2}, &VerifyCmd{InputDir: inputDir, PkgFiles: []string{manifest}})
+	})
+	if counts := buckets.Counts(); counts[CR_Same] != 1 || counts[CR_Md5Dif] != 1 || counts[CR_NotExist] != 1 {
+		t.Errorf("Expected 1 same, 1 md5 differing and 1 missing file, got %v", counts)
+	}
+	if samples := buckets.Samples(CR_NotExist); !slices.Equal(samples, []string{"gone"}) {
+		t.Errorf("Expected the missing file as sample, got %v", samples)
+	}
+}
+
+func TestVerifySmart(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"unchanged": "same content",
+		"touched":   "same content",
+		"changed":   "old content",
+		"resized":   "old content",
+	})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, ModTime: true})
+	})
+
+	// Rewrite or touch files, moving their mtime well away from the recorded one.
+	later := time.Now().Add(time.Hour)
+	writeTree(t, inputDir, map[string]string{"changed": "new content", "resized": "much newer content"})
+	for _, name := range []string{"touched", "changed", "resized"} {
+		if err := os.Chtimes(filepath.Join(inputDir, name), later, later); err != nil {
+			t.Fatalf("Failed to touch %s: %v", name, err)
+		}
+	}
+
+	pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	files := make(map[string]FileInfo)
+	for name, entry := range pkgMap {
+		if entry.ModTime.IsZero() {
+			t.Fatalf("Expected %s to have a recorded mtime", name)
+		}
+		files[name] = FileInfo{
+			FilePath:  entry.FilePath,
+			Md5Hash:   decodeHex(entry.Md5Hash),
+			Xxh64Hash: decodeHex(entry.Xxh64Hash),
+			Size:      entry.Size,
+			ModTime:   entry.ModTime.Time,
+		}
+	}
+
+	expected := map[string]CompareResult{
+		"unchanged": CR_Trusted,
+		"touched":   CR_Verified,
+		"changed":   CR_Md5Dif,
+		"resized":   CR_SizeLarger,
+	}
+	for res := range verifyContent(2, inputDir, files, compareOptions{}) {
+		if res.Result != expected[res.FilePath] {
+			t.Errorf("%s: expected %s, got %s", res.FilePath, expected[res.FilePath], res.Result)
+		}
+	}
+}
+
+func TestCompareFileAbsentHashes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "abc"})
+	md5Hash, xxh64Hash, _, err := processFileReader(strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := make([]byte, len(xxh64Hash))
+
+	testCases := []struct {
+		name     string
+		file     FileInfo
+		expected CompareResult
+	}{
+		{"xxh64 only", FileInfo{FilePath: "a.txt", Xxh64Hash: xxh64Hash, Size: 3}, CR_Same},
+		{"wrong xxh64 only", FileInfo{FilePath: "a.txt", Xxh64Hash: wrong, Size: 3}, CR_Xxh64Dif},
+		{"md5 only", FileInfo{FilePath: "a.txt", Md5Hash: md5Hash, Size: 3}, CR_Same},
+		{"no hash", FileInfo{FilePath: "a.txt", Md5Hash: decodeHex(""), Xxh64Hash: decodeHex(""), Size: 3}, CR_Same},
+		{"no hash, wrong size", FileInfo{FilePath: "a.txt", Size: 4}, CR_SizeSmaller},
+	}
+	for _, tc := range testCases {
+		if got, _ := compareFile(root, tc.file, compareOptions{}); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestVerifyOne(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"dir/same.txt": "abc", "dir/grown.txt": "abcd"})
+	md5Hash, xxh64Hash, _, err := processFileReader(strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := map[string]FileInfo{
+		"dir/same.txt":  {FilePath: "dir/same.txt", Md5Hash: md5Hash, Xxh64Hash: xxh64Hash, Size: 3},
+		"dir/grown.txt": {FilePath: "dir/grown.txt", Md5Hash: md5Hash, Xxh64Hash: xxh64Hash, Size: 3},
+		"dir/gone.txt":  {FilePath: "dir/gone.txt", Md5Hash: md5Hash, Xxh64Hash: xxh64Hash, Size: 3},
+	}
+
+	testCases := []struct {
+		remoteName string
+		expected   CompareResult
+	}{
+		{"dir/same.txt", CR_Same},
+		{filepath.Join("dir", "same.txt"), CR_Same}, // Native separators are accepted
+		{"dir/grown.txt", CR_SizeLarger},
+		{"dir/gone.txt", CR_NotExist},
+	}
+	for _, tc := range testCases {
+		if got, err := VerifyOne(manifest, root, tc.remoteName); got != tc.expected || err != nil {
+			t.Errorf("%s: expected %s, got %s (err: %v)", tc.remoteName, tc.expected, got, err)
+		}
+	}
+
+	if got, err := VerifyOne(manifest, root, "dir/unknown.txt"); got != CR_Error || !errors.Is(err, ErrNotInManifest) {
+		t.Errorf("Expected a path missing from the manifest to fail with ErrNotInManifest, got %s (err: %v)", got, err)
+	}
+}
+
+func TestVerifyModifiedSince(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{
+		"old/unchanged": "old content",
+		"old/edited":    "old content",
+		"old/resized":   "old content",
+		"new/changed":   "old content",
+		"missing":       "old content",
+	})
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+
+	// Edit every file, then move the mtime of the old ones before the threshold.
+	since := time.Now().Add(-time.Hour)
+	writeTree(t, inputDir, map[string]string{
+		"old/edited":  "OLD CONTENT",
+		"old/resized": "much older content",
+		"new/changed": "NEW CONTENT",
+	})
+	before := since.Add(-time.Minute)
+	for _, name := range []string{"old/unchanged", "old/edited", "old/resized"} {
+		if err := os.Chtimes(filepath.Join(inputDir, name), before, before); err != nil {
+			t.Fatalf("Failed to touch %s: %v", name, err)
+		}
+	}
+	if err := os.Remove(filepath.Join(inputDir, "missing")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	files := make(map[string]FileInfo)
+	for name, entry := range pkgMap {
+		files[name] = FileInfo{
+			FilePath:  entry.FilePath,
+			Md5Hash:   decodeHex(entry.Md5Hash),
+			Xxh64Hash: decodeHex(entry.Xxh64Hash),
+			Size:      entry.Size,
+		}
+	}
+
+	results, err := verifyModifiedSince(2, inputDir, files, since, nil, compareOptions{})
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	expected := map[string]CompareResult{
+		"old/unchanged": CR_Unmodified,
+		"old/edited":    CR_Unmodified, // Same size and older, so never hashed
+		"old/resized":   CR_SizeLarger,
+		"new/changed":   CR_Md5Dif,
+		"missing":       CR_NotExist,
+	}
+	seen := 0
+	runWithTimeout(t, 10*time.Second, func() {
+		for res := range results {
+			seen++
+			if res.Result != expected[res.FilePath] {
+				t.Errorf("%s: expected %s, got %s", res.FilePath, expected[res.FilePath], res.Result)
+			}
+		}
+	})
+	if seen != len(expected) {
+		t.Errorf("Expected %d results, got %d", len(expected), seen)
+	}
+}
+
+func TestReadPkgFilesPartialFailure(t *testing.T) {
+	first := writeManifest(t, FileInfoOutput{FilePath: "a", Md5Hash: "01", Xxh64Hash: "01", Size: 1})
+	second := writeManifest(t, FileInfoOutput{FilePath: "b", Md5Hash: "02", Xxh64Hash: "02", Size: 2})
+	missing := filepath.Join(t.TempDir(), "missing.jsonl")
+	broken := filepath.Join(t.TempDir(), "broken.jsonl")
+	content := `{"remoteName":"c","md5":"03","hash":"03","fileSize":3}` + "\nnot json\n"
+	if err := os.WriteFile(broken, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgMap, err := readPkgFiles("", []string{first, missing, second, broken}, false)
+	if err == nil || !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), broken) {
+		t.Errorf("Expected an error naming both failed files, got %v", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the joined error to keep the missing file error, got %v", err)
+	}
+	// The broken file is left out whole, even the entry before its bad line.
+	if len(pkgMap) != 2 || pkgMap["a"].Size != 1 || pkgMap["b"].Size != 2 {
+		t.Errorf("Expected the entries of the two good files, got %v", pkgMap)
+	}
+
+	pkgMap, err = readPkgFiles("", []string{missing}, false)
+	if pkgMap != nil || err == nil {
+		t.Errorf("Expected a hard failure when no file could be read, got %v and %v", pkgMap, err)
+	}
+}
+
+func TestSubcommandVerifyMaxReport(t *testing.T) {
+	inputDir := t.TempDir()
+	files := make(map[string]string)
+	for i := range 5 {
+		files[fmt.Sprintf("changed/%d", i)] = "before"
+	}
+	files["same"] = "same"
+	writeTree(t, inputDir, files)
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest})
+	})
+	for name := range files {
+		if name != "same" {
+			writeTree(t, inputDir, map[string]string{name: "after!"}) // Same size, the hashes differ
+		}
+	}
+
+	// Verified at the default level of the tool, the console only gets the first mismatch and a count of the others.
+	var console bytes.Buffer
+	previous, previousLevel := log.Logger, zerolog.GlobalLevel()
+	log.Logger = zerolog.New(&console)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	t.Cleanup(func() {
+		log.Logger = previous
+		zerolog.SetGlobalLevel(previousLevel)
+	})
+
+	var buckets *CompareResultBuckets
+	runWithTimeout(t, 10*time.Second, func() {
+		buckets = subcommandVerify(Config{Workers: 2}, &VerifyCmd{InputDir: inputDir, PkgFiles: []string{manifest}, MaxReport: 1})
+	})
+	if count := buckets.Count(CR_Md5Dif); count != 5 {
+		t.Errorf("Expected 5 md5 differences, got %d", count)
+	}
+	logged := console.String()
+	if count := strings.Count(logged, "changed/"); count != 1 {
+		t.Errorf("Expected a single changed file logged, got %d:\n%s", count, logged)
+	}
+	if !strings.Contains(logged, `"result":"md5_differs","more":4`) {
+		t.Errorf("Expected a line for 4 more md5 differences, got:\n%s", logged)
+	}
+}
+
+func TestReportResultsLimited(t *testing.T) {
+	var console bytes.Buffer
+	previous := log.Logger
+	log.Logger = zerolog.New(&console)
+	t.Cleanup(func() { log.Logger = previous })
+
+	results := make(chan FileCompareResult, 20)
+	for i := range 10 {
+		results <- FileCompareResult{FilePath: fmt.Sprintf("md5/%d", i), Result: CR_Md5Dif}
+	}
+	for i := range 2 {
+		results <- FileCompareResult{FilePath: fmt.Sprintf("missing/%d", i), Result: CR_NotExist}
+	}
+	results <- FileCompareResult{FilePath: "same", Result: CR_Same}
+	close(results)
+
+	var jsonOut bytes.Buffer
+	buckets, err := reportResultsLimited(results, 3, &jsonOut)
+	if err != nil {
+		t.Fatalf("Failed to report: %v", err)
+	}
+	if buckets.Count(CR_Md5Dif) != 10 {
+		t.Errorf("Expected every result counted, got %d", buckets.Count(CR_Md5Dif))
+	}
+
+	// The console gets 3 of the md5 differences then a line for the 7 others, and both missing files.
+	logged := console.String()
+	if count := strings.Count(logged, "MD5 hash differs"); count != 3 {
+		t.Errorf("Expected 3 md5 differences logged, got %d:\n%s", count, logged)
+	}
+	if !strings.Contains(logged, `"result":"md5_differs","more":7`) || strings.Count(logged, `"more":`) != 1 {
+		t.Errorf("Expected a single line for 7 more md5 differences, got:\n%s", logged)
+	}
+	if count := strings.Count(logged, "File does not exist"); count != 2 {
+		t.Errorf("Expected 2 missing files logged, got %d", count)
+	}
+
+	// The JSON output has every result.
+	var records []compareResultRecord
+	dec := json.NewDecoder(&jsonOut)
+	for dec.More() {
+		var record compareResultRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode JSON output: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 13 || records[0] != (compareResultRecord{Path: "md5/0", Result: "md5_differs"}) || records[12].Result != "same" {
+		t.Errorf("Expected the 13 results, got %v", records)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// encodeXattrs base64-encodes extended attribute values for the manifest.
+func encodeXattrs(attrs map[string][]byte) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	encoded := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		encoded[name] = base64.StdEncoding.EncodeToString(value)
+	}
+	return encoded
+}
+
+// decodeXattrs decodes extended attribute values read from a manifest.
+func decodeXattrs(encoded map[string]string) (map[string][]byte, error) {
+	attrs := make(map[string][]byte, len(encoded))
+	for name, value := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of extended attribute %s: %w", name, err)
+		}
+		attrs[name] = decoded
+	}
+	return attrs, nil
+}
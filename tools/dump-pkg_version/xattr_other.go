@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package main
+
+import (
+	"errors"
+)
+
+// xattrSupported reports whether extended attributes can be recorded on this platform.
+const xattrSupported = false
+
+// readXattrs always reports no extended attributes, as they are not supported on this platform.
+func readXattrs(_ string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs is not supported on this platform.
+func writeXattrs(_ string, _ map[string][]byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,98 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrSupported reports whether extended attributes can be recorded on this platform.
+const xattrSupported = true
+
+// readXattrs returns the extended attributes of a file, nil when it has none or the filesystem doesn't support them.
+func readXattrs(path string) (map[string][]byte, error) {
+	names, err := listXattrs(path)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	attrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+// writeXattrs sets the given extended attributes on a file, leaving its other attributes untouched.
+func writeXattrs(path string, attrs map[string][]byte) error {
+	for name, value := range attrs {
+		if err := unix.Setxattr(path, name, value, 0); err != nil {
+			return fmt.Errorf("failed to set extended attribute %s of %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of a file.
+func listXattrs(path string) ([]string, error) {
+	buf, err := readXattrBuffer(func(dest []byte) (int, error) {
+		return unix.Listxattr(path, dest)
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil // The filesystem has no extended attributes
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes of %s: %w", path, err)
+	}
+
+	// Names are NUL-terminated and concatenated.
+	var names []string
+	for name := range strings.SplitSeq(string(buf), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of a single extended attribute of a file.
+func getXattr(path string, name string) ([]byte, error) {
+	value, err := readXattrBuffer(func(dest []byte) (int, error) {
+		return unix.Getxattr(path, name, dest)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extended attribute %s of %s: %w", name, path, err)
+	}
+	return value, nil
+}
+
+// readXattrBuffer queries the needed size with an empty buffer then reads into one of that size,
+// retrying when the attributes grew in between.
+func readXattrBuffer(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
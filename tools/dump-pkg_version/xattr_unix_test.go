@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrsRoundTrip(t *testing.T) {
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"game.bin": "content", "plain.bin": "content"})
+	value := []byte("quarantine\x00\xff")
+	if err := unix.Setxattr(filepath.Join(inputDir, "game.bin"), "user.dder.test", value, 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			t.Skipf("User extended attributes are not supported here: %v", err)
+		}
+		t.Fatalf("Failed to set extended attribute: %v", err)
+	}
+	outputFile := filepath.Join(t.TempDir(), "package.jsonl")
+
+	config := Config{Workers: 2}
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(config, &DumpCmd{InputDir: inputDir, OutputFile: outputFile, Xattrs: true})
+	})
+
+	for _, entry := range readManifest(t, outputFile) {
+		switch entry.FilePath {
+		case "game.bin":
+			if got := entry.Xattrs["user.dder.test"]; got != base64.StdEncoding.EncodeToString(value) {
+				t.Errorf("Expected the base64 encoded attribute, got %q", got)
+			}
+		case "plain.bin":
+			if len(entry.Xattrs) != 0 {
+				t.Errorf("Expected no attributes, got %v", entry.Xattrs)
+			}
+		}
+	}
+
+	outputDir := t.TempDir()
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(config, &MirrorCmd{OutputDir: outputDir, PkgFiles: []string{outputFile}, Xattrs: true})
+	})
+	attrs, err := readXattrs(filepath.Join(outputDir, "game.bin.json"))
+	if err != nil {
+		t.Fatalf("Failed to read mirrored attributes: %v", err)
+	}
+	if !bytes.Equal(attrs["user.dder.test"], value) {
+		t.Errorf("Expected the attribute to be restored, got %q", attrs["user.dder.test"])
+	}
+}
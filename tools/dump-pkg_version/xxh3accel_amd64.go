@@ -0,0 +1,18 @@
+package main
+
+import "github.com/klauspost/cpuid/v2"
+
+// xxh3Acceleration reports whether the xxh3 package hashes with vector instructions and which ones, making the
+// same CPU feature detection it makes itself. SSE2 is part of amd64, so the generic code is never used here.
+func xxh3Acceleration() (accelerated bool, impl string) {
+	switch {
+	case cpuid.CPU.Has(cpuid.AVX512F):
+		return true, "avx512"
+	case cpuid.CPU.Has(cpuid.AVX2):
+		return true, "avx2"
+	case cpuid.CPU.Has(cpuid.SSE2):
+		return true, "sse2"
+	default:
+		return false, "generic"
+	}
+}
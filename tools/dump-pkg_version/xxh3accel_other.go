@@ -0,0 +1,9 @@
+//go:build !amd64
+
+package main
+
+// xxh3Acceleration reports whether the xxh3 package hashes with vector instructions and which ones. It only has
+// vector code for amd64, elsewhere the generic code is always used.
+func xxh3Acceleration() (accelerated bool, impl string) {
+	return false, "generic"
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestXXH3Acceleration(t *testing.T) {
+	accelerated, impl := xxh3Acceleration()
+	if impl == "" || accelerated == (impl == "generic") {
+		t.Errorf("Inconsistent acceleration report: accelerated %v with %q", accelerated, impl)
+	}
+	if runtime.GOARCH == "amd64" && !accelerated {
+		t.Errorf("Expected xxh3 to be accelerated on amd64")
+	}
+}
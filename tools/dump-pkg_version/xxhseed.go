@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// checkXxhSeed checks that every entry of pkgMap with an xxh64 hash was dumped with seed, so a manifest of another
+// project, or of the same one dumped without --xxh-seed, is rejected instead of every file looking changed.
+func checkXxhSeed(pkgMap map[string]FileInfoOutput, seed uint64) error {
+	for _, entry := range pkgMap {
+		if entry.Xxh64Hash != "" && entry.XxhSeed != seed {
+			return fmt.Errorf("xxh64 hash of %s is seeded with %d, not %d", entry.FilePath, entry.XxhSeed, seed)
+		}
+	}
+	return nil
+}
+
+// manifestXxhSeed returns the seed the xxh64 hashes of pkgMap were dumped with, see xxhSeedOf.
+func manifestXxhSeed(pkgMap map[string]FileInfoOutput) (uint64, error) {
+	return xxhSeedOf(func(fn func(FileInfoOutput) error) error {
+		for _, entry := range pkgMap {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// xxhSeedOf returns the seed the xxh64 hashes of the entries passed by each were dumped with, 0 when unseeded or
+// when no entry has one. A verify hashes every file with a single seed, so entries dumped with different seeds are
+// an error, the manifests they come from have to be verified separately.
+func xxhSeedOf(each func(fn func(FileInfoOutput) error) error) (uint64, error) {
+	var seed uint64
+	var seeded string // Path of the first entry with an xxh64 hash, "" until one is seen
+	err := each(func(entry FileInfoOutput) error {
+		switch {
+		case entry.Xxh64Hash == "":
+		case seeded == "":
+			seed, seeded = entry.XxhSeed, entry.FilePath
+		case entry.XxhSeed != seed:
+			return fmt.Errorf("xxh64 hashes of %s and %s are seeded with %d and %d", seeded, entry.FilePath, seed, entry.XxhSeed)
+		}
+		return nil
+	})
+	return seed, err
+}
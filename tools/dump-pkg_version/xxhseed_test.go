@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXxhSeed(t *testing.T) {
+	hash := func(seed uint64) FileInfo {
+		t.Helper()
+		info, err := hashReaderSeed(strings.NewReader("seeded content"), nil, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	unseeded, seeded1, seeded2 := hash(0), hash(1), hash(2)
+	if bytes.Equal(seeded1.Xxh64Hash, seeded2.Xxh64Hash) || bytes.Equal(seeded1.Xxh64Hash, unseeded.Xxh64Hash) {
+		t.Errorf("Expected different seeds to give different xxh64 hashes")
+	}
+	if !bytes.Equal(seeded1.Md5Hash, unseeded.Md5Hash) {
+		t.Errorf("Expected the seed to leave md5 alone")
+	}
+	// Pooled writers must not leak the seed of their last file.
+	if plain, _ := hashReader(strings.NewReader("seeded content"), nil); !bytes.Equal(plain.Xxh64Hash, unseeded.Xxh64Hash) {
+		t.Errorf("Expected an unseeded hash after a seeded one")
+	}
+
+	inputDir := t.TempDir()
+	writeTree(t, inputDir, map[string]string{"a.txt": "alpha", "dir/b.txt": "bravo"})
+	dump := func(seed uint64) map[string]FileInfoOutput {
+		t.Helper()
+		manifest := filepath.Join(t.TempDir(), "package.jsonl")
+		runWithTimeout(t, 10*time.Second, func() {
+			subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, XxhSeed: seed})
+		})
+		pkgMap, err := readPkgFiles(inputDir, []string{manifest}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkgMap
+	}
+	seededMap, plainMap := dump(42), dump(0)
+
+	// Verify rejects a manifest dumped with another seed, or without one.
+	if err := checkXxhSeed(seededMap, 42); err != nil {
+		t.Errorf("Expected the seed to match, got %v", err)
+	}
+	if err := checkXxhSeed(seededMap, 7); err == nil || !strings.Contains(err.Error(), "seeded with 42, not 7") {
+		t.Errorf("Expected a seed mismatch error, got %v", err)
+	}
+	if err := checkXxhSeed(plainMap, 42); err == nil || !strings.Contains(err.Error(), "seeded with 0, not 42") {
+		t.Errorf("Expected a seed mismatch error for the unseeded manifest, got %v", err)
+	}
+
+	// The manifest is verified with the seed it records, the seeded hash alone telling the file is unchanged.
+	entry := seededMap["dir/b.txt"]
+	if entry.XxhSeed != 42 {
+		t.Fatalf("Expected the entry to record seed 42, got %d", entry.XxhSeed)
+	}
+	seed, err := manifestXxhSeed(seededMap)
+	if err != nil || seed != 42 {
+		t.Fatalf("Expected the manifest seed to be 42, got %d (err: %v)", seed, err)
+	}
+	file := FileInfo{FilePath: entry.FilePath, Size: entry.Size, Xxh64Hash: decodeHex(entry.Xxh64Hash)}
+	if result, err := compareFile(inputDir, file, compareOptions{XxhSeed: seed}); result != CR_Same {
+		t.Errorf("Expected the file to be the same with its seed, got %s (err: %v)", result, err)
+	}
+	if result, _ := compareFile(inputDir, file, compareOptions{}); result != CR_Xxh64Dif {
+		t.Errorf("Expected the seeded hash to differ without its seed, got %s", result)
+	}
+
+	// Manifests dumped with different seeds are only compared on their other hashes.
+	if diffs := diffManifests(plainMap, seededMap, DiffOptions{}); len(diffs) != 0 {
+		t.Errorf("Expected no difference between the seeded and unseeded manifests, got %v", diffs)
+	}
+
+	// Entries dumped with different seeds can't be verified together.
+	mixed := maps.Clone(plainMap)
+	mixed["dir/b.txt"] = seededMap["dir/b.txt"]
+	if _, err := manifestXxhSeed(mixed); err == nil || !strings.Contains(err.Error(), "are seeded with") {
+		t.Errorf("Expected an error for entries seeded differently, got %v", err)
+	}
+
+	// A verified dump compares the files with the seed it dumped them with.
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: filepath.Join(t.TempDir(), "package.jsonl"), XxhSeed: 42, VerifyAfter: true})
+	})
+}
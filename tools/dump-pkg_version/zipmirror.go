@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// zipMirror writes the mirrored entries of mirror --zip into a zip archive, each named by its remote name.
+// Workers download and check files concurrently, each into its own temporary file, and only add them to the archive
+// one at a time once they match their entry, so the archive never holds a partial or corrupted file.
+type zipMirror struct {
+	mu     sync.Mutex // Guards zw, a zip.Writer writes one entry at a time
+	file   *os.File
+	zw     *zip.Writer
+	tmpDir string // Holds the files being downloaded, next to the archive so they don't cross filesystems
+}
+
+// createZipMirror creates the zip archive at path, replacing an existing file.
+func createZipMirror(path string) (*zipMirror, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".zip-mirror-*")
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create a temporary directory for %s: %w", path, err)
+	}
+	return &zipMirror{file: file, zw: zip.NewWriter(file), tmpDir: tmpDir}, nil
+}
+
+// add writes a manifest entry into the archive: a directory entry, a link holding its target, or a file downloaded
+// by downloader and checked against its recorded size and hashes first.
+func (z *zipMirror) add(downloader *mirrorDownloader, file FileInfoOutput) error {
+	header := &zip.FileHeader{Name: file.FilePath, Method: zip.Deflate}
+	if !file.ModTime.IsZero() {
+		header.Modified = file.ModTime.Time
+	}
+	switch {
+	case file.IsDir:
+		header.Name = strings.TrimSuffix(file.FilePath, "/") + "/"
+		header.Method = zip.Store
+		perm := fs.FileMode(file.Mode)
+		if perm == 0 {
+			perm = 0755 // Older or hand-written entries don't record a mode
+		}
+		header.SetMode(fs.ModeDir | perm)
+		return z.write(header, strings.NewReader(""))
+	case file.IsSymlink:
+		// Links are stored as their target, the way zip and unzip store them
+		header.SetMode(fs.ModeSymlink | 0777)
+		return z.write(header, strings.NewReader(file.LinkTarget))
+	}
+
+	tmp, err := os.CreateTemp(z.tmpDir, "*")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file for %s: %w", file.FilePath, err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+	if err := downloader.download(file, tmpPath); err != nil {
+		return err
+	}
+
+	content, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the download of %s: %w", file.FilePath, err)
+	}
+	defer content.Close()
+	header.SetMode(0644) // File modes are not recorded
+	return z.write(header, content)
+}
+
+// write adds an entry with the content of r to the archive.
+func (z *zipMirror) write(header *zip.FileHeader, r io.Reader) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to the archive: %w", header.Name, err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to write %s to the archive: %w", header.Name, err)
+	}
+	return nil
+}
+
+// Close finalizes the archive, writing its central directory, and removes the temporary directory.
+func (z *zipMirror) Close() error {
+	defer os.RemoveAll(z.tmpDir)
+	err := z.zw.Close()
+	if closeErr := z.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finalize %s: %w", z.file.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorZip(t *testing.T) {
+	inputDir := t.TempDir()
+	files := map[string]string{
+		"root.bin":           "root content",
+		"dir/sub/file.bin":   strings.Repeat("file content ", 1000),
+		"dir/50% off #1.txt": "escaped name",
+	}
+	writeTree(t, inputDir, files)
+	if err := os.Mkdir(filepath.Join(inputDir, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(t.TempDir(), "package.jsonl")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandDump(Config{Workers: 2}, &DumpCmd{InputDir: inputDir, OutputFile: manifest, IncludeDirs: true, ModTime: true})
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(inputDir)))
+	defer server.Close()
+
+	zipPath := filepath.Join(t.TempDir(), "mirror.zip")
+	runWithTimeout(t, 10*time.Second, func() {
+		subcommandMirror(Config{Workers: 2}, &MirrorCmd{Zip: zipPath, PkgFiles: []string{manifest}, Download: server.URL})
+	})
+
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("Expected a finalized zip archive, got %v", err)
+	}
+	defer archive.Close()
+	var names []string
+	for _, entry := range archive.File {
+		names = append(names, entry.Name)
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		r, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != files[entry.Name] {
+			t.Errorf("%s: expected the served content, got %q (err: %v)", entry.Name, data, err)
+		}
+	}
+	slices.Sort(names)
+	expected := []string{"dir/", "dir/50% off #1.txt", "dir/sub/", "dir/sub/file.bin", "empty/", "root.bin"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected entries %v, got %v", expected, names)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(zipPath)); len(entries) != 1 {
+		t.Errorf("Expected only the archive to be left, got %v", entries)
+	}
+
+	// A corrupted file is left out of the archive, which is still finalized, and fails the mirror.
+	corrupted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/root.bin" {
+			w.Write([]byte("ROOT CONTENT"))
+			return
+		}
+		http.FileServer(http.Dir(inputDir)).ServeHTTP(w, r)
+	}))
+	defer corrupted.Close()
+	corruptedPath := filepath.Join(t.TempDir(), "corrupted.zip")
+	var recovered any
+	runWithTimeout(t, 10*time.Second, func() {
+		defer func() { recovered = recover() }()
+		subcommandMirror(Config{Workers: 2}, &MirrorCmd{Zip: corruptedPath, PkgFiles: []string{manifest}, Download: corrupted.URL})
+	})
+	if recovered == nil {
+		t.Error("Expected the corrupted file to fail the mirror")
+	}
+	corruptedArchive, err := zip.OpenReader(corruptedPath)
+	if err != nil {
+		t.Fatalf("Expected a finalized zip archive, got %v", err)
+	}
+	defer corruptedArchive.Close()
+	for _, entry := range corruptedArchive.File {
+		if entry.Name == "root.bin" {
+			t.Error("Expected the corrupted file to be left out of the archive")
+		}
+	}
+	if len(corruptedArchive.File) != len(expected)-1 {
+		t.Errorf("Expected %d entries, got %d", len(expected)-1, len(corruptedArchive.File))
+	}
+}
+
+func TestMirrorZipNeedsDownload(t *testing.T) {
+	for _, mirrorCmd := range []MirrorCmd{
+		{Zip: "mirror.zip"},
+		{Zip: "mirror.zip", Download: "http://localhost", OutputDir: "out"},
+		{Zip: "mirror.zip", Download: "http://localhost", Owner: true},
+	} {
+		var recovered any
+		func() {
+			defer func() { recovered = recover() }()
+			subcommandMirror(Config{Workers: 2}, &mirrorCmd)
+		}()
+		if recovered == nil {
+			t.Errorf("%+v: expected the arguments to be rejected", mirrorCmd)
+		}
+	}
+}